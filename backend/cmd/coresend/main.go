@@ -0,0 +1,375 @@
+// Command coresend is a command-line client for the CoreSend API.
+//
+// The mnemonic is read from CORESEND_MNEMONIC or from the file named by
+// CORESEND_MNEMONIC_FILE (or -mnemonic-file). Every request is signed with
+// the identity derived at -index, exactly like the web app does.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/api"
+	"github.com/fn-jakubkarp/coresend/internal/client"
+	"github.com/fn-jakubkarp/coresend/internal/identity"
+)
+
+const usage = `Usage: coresend <command> [flags]
+
+Commands:
+  identity new              Generate a new mnemonic and print its first address
+  identity derive           Print the address derived at -index
+  register [-domain D]      Register the address to receive mail
+  inbox ls                  List emails in the inbox
+  inbox show <id>           Print a single email
+  inbox rm <id>             Delete a single email
+  inbox clear               Delete every email in the inbox
+  wait                      Block until a matching email arrives
+  raw <id>                  Print the source of an email as received
+
+Common flags:
+  -server URL               API base URL (env CORESEND_SERVER, default http://localhost:8080)
+  -index N                  Identity derivation index (env CORESEND_INDEX, default 0)
+  -mnemonic-file PATH       File holding the mnemonic (env CORESEND_MNEMONIC_FILE)
+
+The mnemonic itself may also be passed via CORESEND_MNEMONIC.
+`
+
+var errUsage = errors.New("invalid usage")
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+type options struct {
+	server       string
+	index        uint
+	mnemonicFile string
+	json         bool
+}
+
+func newFlagSet(name string, stderr io.Writer) (*flag.FlagSet, *options) {
+	opts := &options{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	defaultIndex, _ := strconv.ParseUint(getEnv("CORESEND_INDEX", "0"), 10, 32)
+
+	fs.StringVar(&opts.server, "server", getEnv("CORESEND_SERVER", "http://localhost:8080"), "API base URL")
+	fs.UintVar(&opts.index, "index", uint(defaultIndex), "identity derivation index")
+	fs.StringVar(&opts.mnemonicFile, "mnemonic-file", os.Getenv("CORESEND_MNEMONIC_FILE"), "file holding the mnemonic")
+	fs.BoolVar(&opts.json, "json", false, "print JSON output")
+	return fs, opts
+}
+
+// loadMnemonic prefers CORESEND_MNEMONIC and falls back to the mnemonic file.
+func loadMnemonic(path string) (string, error) {
+	if m := os.Getenv("CORESEND_MNEMONIC"); m != "" {
+		return identity.NormalizeMnemonic(m), nil
+	}
+	if path == "" {
+		return "", errors.New("no mnemonic configured: set CORESEND_MNEMONIC or CORESEND_MNEMONIC_FILE")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read mnemonic file: %w", err)
+	}
+	return identity.NormalizeMnemonic(string(data)), nil
+}
+
+func (o *options) identity() (*identity.Identity, error) {
+	mnemonic, err := loadMnemonic(o.mnemonicFile)
+	if err != nil {
+		return nil, err
+	}
+	return identity.Derive(mnemonic, uint32(o.index))
+}
+
+func (o *options) client() (*client.Client, error) {
+	id, err := o.identity()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(o.server, id), nil
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "identity":
+		err = runIdentity(args[1:], stdout, stderr)
+	case "register":
+		err = runRegister(ctx, args[1:], stdout, stderr)
+	case "inbox":
+		err = runInbox(ctx, args[1:], stdout, stderr)
+	case "wait":
+		err = runWait(ctx, args[1:], stdout, stderr)
+	case "raw":
+		err = runRaw(ctx, args[1:], stdout, stderr)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+		fmt.Fprint(stderr, usage)
+		return 2
+	default:
+		fmt.Fprintf(stderr, "coresend: %v\n", err)
+		return 1
+	}
+}
+
+func runIdentity(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	fs, opts := newFlagSet("identity "+args[0], stderr)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var (
+		id       *identity.Identity
+		mnemonic string
+		err      error
+	)
+	switch args[0] {
+	case "new":
+		mnemonic, err = identity.GenerateMnemonic()
+		if err != nil {
+			return err
+		}
+		id, err = identity.Derive(mnemonic, uint32(opts.index))
+	case "derive":
+		id, err = opts.identity()
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	out := identityOutput{
+		Mnemonic:  mnemonic,
+		Index:     id.Index,
+		Address:   id.Address,
+		PublicKey: fmt.Sprintf("%x", []byte(id.PublicKey)),
+	}
+	if opts.json {
+		return writeJSON(stdout, out)
+	}
+	if mnemonic != "" {
+		fmt.Fprintf(stdout, "mnemonic:   %s\n", mnemonic)
+	}
+	fmt.Fprintf(stdout, "index:      %d\naddress:    %s\npublic key: %s\n", out.Index, out.Address, out.PublicKey)
+	return nil
+}
+
+type identityOutput struct {
+	Mnemonic  string `json:"mnemonic,omitempty"`
+	Index     uint32 `json:"index"`
+	Address   string `json:"address"`
+	PublicKey string `json:"public_key"`
+}
+
+func runRegister(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs, opts := newFlagSet("register", stderr)
+	domain := fs.String("domain", "", "domain to register on (default: server's primary domain)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Register(ctx, *domain)
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		return writeJSON(stdout, resp)
+	}
+	fmt.Fprintf(stdout, "registered %s for %s\n", resp.Email, time.Duration(resp.ExpiresIn)*time.Second)
+	return nil
+}
+
+func runInbox(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	fs, opts := newFlagSet("inbox "+args[0], stderr)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	wantArgs := map[string]int{"ls": 0, "show": 1, "rm": 1, "clear": 0}
+	if n, ok := wantArgs[args[0]]; !ok || fs.NArg() != n {
+		return errUsage
+	}
+
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "ls":
+		inbox, err := c.Inbox(ctx)
+		if err != nil {
+			return err
+		}
+		if opts.json {
+			return writeJSON(stdout, inbox)
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tRECEIVED\tFROM\tSUBJECT")
+		for _, email := range inbox.Emails {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", email.ID, email.ReceivedAt, email.From, email.Subject)
+		}
+		return tw.Flush()
+
+	case "show":
+		email, err := c.Email(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if opts.json {
+			return writeJSON(stdout, email)
+		}
+		printEmail(stdout, email)
+		return nil
+
+	case "rm":
+		resp, err := c.DeleteEmail(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if opts.json {
+			return writeJSON(stdout, resp)
+		}
+		fmt.Fprintf(stdout, "deleted %s\n", resp.ID)
+		return nil
+
+	case "clear":
+		resp, err := c.ClearInbox(ctx)
+		if err != nil {
+			return err
+		}
+		if opts.json {
+			return writeJSON(stdout, resp)
+		}
+		fmt.Fprintln(stdout, "inbox cleared")
+		return nil
+	}
+
+	return errUsage
+}
+
+func runWait(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs, opts := newFlagSet("wait", stderr)
+	subject := fs.String("subject", "", "match emails whose subject contains this text")
+	from := fs.String("from", "", "match emails whose sender contains this text")
+	timeout := fs.Duration("timeout", time.Minute, "how long to wait before giving up")
+	interval := fs.Duration("interval", 2*time.Second, "polling interval")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	email, err := c.WaitForEmail(ctx, *interval, func(e api.EmailResponse) bool {
+		return strings.Contains(e.Subject, *subject) && strings.Contains(e.From, *from)
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("no matching email within %s", *timeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return writeJSON(stdout, email)
+	}
+	printEmail(stdout, email)
+	return nil
+}
+
+func runRaw(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs, opts := newFlagSet("raw", stderr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	source, err := c.Source(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(source)
+	return err
+}
+
+func printEmail(w io.Writer, email *api.EmailResponse) {
+	fmt.Fprintf(w, "ID:       %s\n", email.ID)
+	fmt.Fprintf(w, "From:     %s\n", email.From)
+	fmt.Fprintf(w, "To:       %s\n", strings.Join(email.To, ", "))
+	if len(email.Cc) > 0 {
+		fmt.Fprintf(w, "Cc:       %s\n", strings.Join(email.Cc, ", "))
+	}
+	fmt.Fprintf(w, "Subject:  %s\n", email.Subject)
+	fmt.Fprintf(w, "Received: %s\n\n", email.ReceivedAt)
+	fmt.Fprintln(w, email.Body)
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
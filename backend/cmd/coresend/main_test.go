@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fn-jakubkarp/coresend/internal/api"
+	"github.com/fn-jakubkarp/coresend/internal/domains"
+	"github.com/fn-jakubkarp/coresend/internal/identity"
+	"github.com/fn-jakubkarp/coresend/internal/store"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func newTestServer(t *testing.T) (*store.Store, string) {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	registry, err := domains.Parse("coresend.test,alt.test")
+	if err != nil {
+		t.Fatalf("domains.Parse() error = %v", err)
+	}
+
+	s := store.NewStore(mr.Addr(), "")
+	srv := httptest.NewServer(api.NewRouter(s, registry, api.RouterConfig{StaticDir: t.TempDir()}))
+	t.Cleanup(srv.Close)
+
+	return s, srv.URL
+}
+
+func runCLI(t *testing.T, args ...string) (string, string, int) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+func TestLoadMnemonic(t *testing.T) {
+	t.Run("env takes precedence", func(t *testing.T) {
+		t.Setenv("CORESEND_MNEMONIC", "  Abandon  about ")
+
+		got, err := loadMnemonic("/does/not/exist")
+		if err != nil {
+			t.Fatalf("loadMnemonic() error = %v", err)
+		}
+		if got != "abandon about" {
+			t.Fatalf("mnemonic = %q, want %q", got, "abandon about")
+		}
+	})
+
+	t.Run("reads file", func(t *testing.T) {
+		t.Setenv("CORESEND_MNEMONIC", "")
+		path := filepath.Join(t.TempDir(), "mnemonic")
+		if err := os.WriteFile(path, []byte(testMnemonic+"\n"), 0o600); err != nil {
+			t.Fatalf("failed to write mnemonic file: %v", err)
+		}
+
+		got, err := loadMnemonic(path)
+		if err != nil {
+			t.Fatalf("loadMnemonic() error = %v", err)
+		}
+		if got != testMnemonic {
+			t.Fatalf("mnemonic = %q, want %q", got, testMnemonic)
+		}
+	})
+
+	t.Run("missing configuration", func(t *testing.T) {
+		t.Setenv("CORESEND_MNEMONIC", "")
+
+		if _, err := loadMnemonic(""); err == nil {
+			t.Fatalf("loadMnemonic() expected error")
+		}
+	})
+}
+
+func TestRun_Usage(t *testing.T) {
+	t.Parallel()
+
+	if _, _, code := runCLI(t); code != 2 {
+		t.Fatalf("exit code = %d, want 2", code)
+	}
+	if _, stderr, code := runCLI(t, "bogus"); code != 2 || !strings.Contains(stderr, "unknown command") {
+		t.Fatalf("exit code = %d stderr = %q", code, stderr)
+	}
+	if _, _, code := runCLI(t, "inbox", "show"); code != 2 {
+		t.Fatalf("inbox show without id: exit code = %d, want 2", code)
+	}
+}
+
+func TestRun_IdentityDerive(t *testing.T) {
+	t.Setenv("CORESEND_MNEMONIC", testMnemonic)
+
+	stdout, stderr, code := runCLI(t, "identity", "derive", "-index", "1", "-json")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr = %s", code, stderr)
+	}
+
+	var out identityOutput
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("failed to decode output: %v", err)
+	}
+	if out.Index != 1 || out.Address != "b8aece28623ce188040068c43558930c6fe4eee8" {
+		t.Fatalf("output = %+v", out)
+	}
+}
+
+func TestRun_IdentityNew(t *testing.T) {
+	t.Parallel()
+
+	stdout, stderr, code := runCLI(t, "identity", "new", "-json")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr = %s", code, stderr)
+	}
+
+	var out identityOutput
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("failed to decode output: %v", err)
+	}
+	id, err := identity.Derive(out.Mnemonic, 0)
+	if err != nil {
+		t.Fatalf("printed mnemonic does not derive: %v", err)
+	}
+	if id.Address != out.Address {
+		t.Fatalf("address = %s, want %s", out.Address, id.Address)
+	}
+}
+
+func TestRun_InboxLifecycle(t *testing.T) {
+	s, serverURL := newTestServer(t)
+	t.Setenv("CORESEND_MNEMONIC", testMnemonic)
+	t.Setenv("CORESEND_SERVER", serverURL)
+
+	id, err := identity.Derive(testMnemonic, 0)
+	if err != nil {
+		t.Fatalf("Derive() error = %v", err)
+	}
+
+	stdout, stderr, code := runCLI(t, "register", "-domain", "alt.test")
+	if code != 0 {
+		t.Fatalf("register exit code = %d, stderr = %s", code, stderr)
+	}
+	if !strings.Contains(stdout, id.Address+"@alt.test") {
+		t.Fatalf("register output = %q, want address on alt.test", stdout)
+	}
+	active, err := s.IsAddressActive(context.Background(), id.Address, "alt.test")
+	if err != nil || !active {
+		t.Fatalf("address active = %v, err = %v", active, err)
+	}
+
+	source := "From: noreply@example.com\r\nSubject: Your code is 424242\r\n\r\n424242\r\n"
+	email := store.Email{ID: "email-1", From: "noreply@example.com", Subject: "Your code is 424242", Body: "424242", ReceivedAt: time.Now(), Source: []byte(source)}
+	if err := s.SaveEmail(context.Background(), id.Address, email, 0); err != nil {
+		t.Fatalf("SaveEmail() error = %v", err)
+	}
+
+	stdout, stderr, code = runCLI(t, "inbox", "ls")
+	if code != 0 || !strings.Contains(stdout, "email-1") {
+		t.Fatalf("inbox ls exit code = %d stdout = %q stderr = %q", code, stdout, stderr)
+	}
+
+	stdout, stderr, code = runCLI(t, "wait", "-subject", "Your code", "-timeout", "5s", "-interval", "10ms", "-json")
+	if code != 0 {
+		t.Fatalf("wait exit code = %d, stderr = %s", code, stderr)
+	}
+	var got api.EmailResponse
+	if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+		t.Fatalf("failed to decode wait output: %v", err)
+	}
+	if got.ID != "email-1" {
+		t.Fatalf("wait email id = %q, want %q", got.ID, "email-1")
+	}
+
+	stdout, _, code = runCLI(t, "raw", "email-1")
+	if code != 0 || stdout != source {
+		t.Fatalf("raw exit code = %d stdout = %q, want %q", code, stdout, source)
+	}
+
+	if _, stderr, code := runCLI(t, "inbox", "rm", "email-1"); code != 0 {
+		t.Fatalf("inbox rm exit code = %d, stderr = %s", code, stderr)
+	}
+	if _, stderr, code := runCLI(t, "inbox", "show", "email-1"); code != 1 || !strings.Contains(stderr, "404") {
+		t.Fatalf("inbox show after rm exit code = %d, stderr = %s", code, stderr)
+	}
+	if _, stderr, code := runCLI(t, "inbox", "clear"); code != 0 {
+		t.Fatalf("inbox clear exit code = %d, stderr = %s", code, stderr)
+	}
+
+	if _, stderr, code := runCLI(t, "wait", "-subject", "never", "-timeout", "30ms", "-interval", "10ms"); code != 1 || !strings.Contains(stderr, "no matching email") {
+		t.Fatalf("wait timeout exit code = %d, stderr = %s", code, stderr)
+	}
+}
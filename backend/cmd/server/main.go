@@ -10,18 +10,37 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
-	"log"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	gosmtp "github.com/emersion/go-smtp"
 	"github.com/fn-jakubkarp/coresend/internal/api"
+	"github.com/fn-jakubkarp/coresend/internal/blob"
+	"github.com/fn-jakubkarp/coresend/internal/buildinfo"
+	"github.com/fn-jakubkarp/coresend/internal/certs"
+	"github.com/fn-jakubkarp/coresend/internal/config"
+	"github.com/fn-jakubkarp/coresend/internal/dkim"
+	"github.com/fn-jakubkarp/coresend/internal/filter"
+	"github.com/fn-jakubkarp/coresend/internal/forward"
+	"github.com/fn-jakubkarp/coresend/internal/health"
+	"github.com/fn-jakubkarp/coresend/internal/imageproxy"
+	"github.com/fn-jakubkarp/coresend/internal/logging"
+	"github.com/fn-jakubkarp/coresend/internal/send"
 	"github.com/fn-jakubkarp/coresend/internal/smtp"
+	"github.com/fn-jakubkarp/coresend/internal/spool"
 	"github.com/fn-jakubkarp/coresend/internal/store"
+	"github.com/fn-jakubkarp/coresend/internal/tracing"
+	"github.com/fn-jakubkarp/coresend/internal/webhook"
 )
 
 func getEnv(key, fallback string) string {
@@ -31,90 +50,417 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// fatal logs at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// signingKey returns key, or a random key when it is not configured, in
+// which case URLs signed with it change on restart.
+func signingKey(key config.Secret, setting string) []byte {
+	if key != "" {
+		return []byte(key)
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		fatal("Failed to generate signing key", "setting", setting, "error", err)
+	}
+	slog.Info("Signing key generated, signed URLs change on restart", "setting", setting)
+	return random
+}
+
 func main() {
-	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
-	redisPassword := os.Getenv("REDIS_PASSWORD")
-	domain := getEnv("DOMAIN_NAME", "localhost")
-	smtpListenAddr := getEnv("SMTP_LISTEN_ADDR", ":1025")
-	httpListenAddr := getEnv("HTTP_LISTEN_ADDR", ":8080")
-	staticDir := getEnv("STATIC_DIR", "./app/dist")
-	certPath := os.Getenv("SMTP_CERT_PATH")
-	keyPath := os.Getenv("SMTP_KEY_PATH")
-
-	emailStore := store.NewStore(redisAddr, redisPassword)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	configPath := fs.String("config", getEnv("CONFIG_FILE", ""), "path to a YAML config file (env CONFIG_FILE)")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration and exit")
+	overrides := config.RegisterFlags(fs)
+	_ = fs.Parse(os.Args[1:])
+
+	cfg, err := config.Load(*configPath, os.LookupEnv, overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	if *printConfig {
+		if err := cfg.Write(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to print configuration: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	logConfig := cfg.Logging()
+	logging.Setup(os.Stderr, logConfig)
+	if !logConfig.Redacts() {
+		slog.Warn("Debug logging enabled, addresses and email contents are not redacted")
+	}
+	build := buildinfo.Get()
+	slog.Info("Starting coresend", "version", build.Version, "commit", build.Commit, "go_version", build.GoVersion)
+	if *configPath != "" {
+		slog.Info("Loaded config file", "path", *configPath)
+	}
+
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	traceExporter := cfg.TraceExporter()
+	shutdownTracing, err := tracing.Setup(rootCtx, traceExporter)
+	if err != nil {
+		fatal("Failed to set up tracing", "error", err)
+	}
+	if traceExporter != tracing.ExporterNone {
+		slog.Info("Tracing enabled", "exporter", traceExporter)
+	}
+
+	registry, err := cfg.Registry()
+	if err != nil {
+		fatal("Invalid domains", "error", err)
+	}
+	domain := registry.Default().Name
+
+	redisAddr := cfg.Redis.Addr
+	emailStore := store.NewStore(redisAddr, string(cfg.Redis.Password))
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Redis.ConnectTimeout)
 	defer cancel()
 	if err := emailStore.Ping(ctx); err != nil {
-		log.Fatalf("Failed to connect to Redis at %s: %v", redisAddr, err)
+		fatal("Failed to connect to Redis", "addr", redisAddr, "error", err)
+	}
+	slog.Info("Connected to Redis", "addr", redisAddr)
+
+	if cfg.Redis.Compression {
+		if err := emailStore.UseCompression(ctx, registry.MaxRetention()); err != nil {
+			fatal("Failed to load compression dictionaries", "error", err)
+		}
+	}
+
+	if cfg.Blobs.Enabled() {
+		blobs, err := openBlobs(cfg.Blobs)
+		if err != nil {
+			fatal("Failed to open blob store", "error", err)
+		}
+		emailStore.UseBlobs(blobs)
+		go emailStore.RunBlobGC(rootCtx, cfg.Blobs.GCInterval, cfg.Blobs.GCGrace)
+		slog.Info("Blob store enabled", "dir", cfg.Blobs.Dir, "s3_endpoint", cfg.Blobs.S3.Endpoint, "s3_bucket", cfg.Blobs.S3.Bucket)
+	}
+
+	tracedStore := store.WithTracing(emailStore)
+
+	webhooks := &webhook.Dispatcher{
+		Store:         store.WithWebhookTracing(emailStore),
+		MaxAttempts:   cfg.Webhooks.MaxAttempts,
+		Backoff:       cfg.Webhooks.Backoff,
+		MaxBackoff:    cfg.Webhooks.MaxBackoff,
+		Timeout:       cfg.Webhooks.Timeout,
+		MaxPerAddress: cfg.Webhooks.MaxPerAddress,
+		AllowPrivate:  cfg.Webhooks.AllowPrivate,
+	}
+	go webhooks.Run(rootCtx, cfg.Webhooks.PollInterval)
+	if cfg.Webhooks.AllowPrivate {
+		slog.Warn("Webhooks may target private networks")
+	}
+
+	var forwarder *forward.Forwarder
+	if cfg.Forward.Enabled() {
+		srsDomain := cfg.Forward.SRSDomain
+		if srsDomain == "" {
+			srsDomain = domain
+		}
+		forwarder = &forward.Forwarder{
+			Store: store.WithForwardTracing(emailStore),
+			Relay: &forward.Relay{
+				Addr:     cfg.Forward.Relay.Addr,
+				Username: cfg.Forward.Relay.Username,
+				Password: string(cfg.Forward.Relay.Password),
+				TLS:      cfg.Forward.Relay.TLS,
+				Timeout:  cfg.Forward.Relay.Timeout,
+			},
+			SRS: &forward.SRS{
+				Domain: srsDomain,
+				Secret: []byte(cfg.Forward.SRSSecret),
+				MaxAge: cfg.Forward.SRSMaxAge,
+			},
+			Domains:       registry,
+			MaxAttempts:   cfg.Forward.MaxAttempts,
+			Backoff:       cfg.Forward.Backoff,
+			MaxBackoff:    cfg.Forward.MaxBackoff,
+			MaxPerAddress: cfg.Forward.MaxPerAddress,
+			Blobs:         emailStore.Blobs(),
+		}
+		go forwarder.Run(rootCtx, cfg.Forward.PollInterval)
+		slog.Info("Forwarding enabled", "relay", cfg.Forward.Relay.Addr, "tls", cfg.Forward.Relay.TLS, "srs_domain", srsDomain)
+	}
+
+	var sender *send.Sender
+	if cfg.Send.Enabled() {
+		signers := make(map[string]*dkim.Signer, len(cfg.Send.DKIMKeys))
+		for _, key := range cfg.Send.DKIMKeys {
+			signer, err := dkim.Load(key)
+			if err != nil {
+				fatal("Failed to load DKIM key", "domain", key.Domain, "error", err)
+			}
+			signers[key.Domain] = signer
+		}
+		sender = &send.Sender{
+			Store: struct {
+				store.SentStore
+				store.EmailStore
+			}{store.WithSentTracing(emailStore), tracedStore},
+			Queue:         forwarder.Store,
+			Signers:       signers,
+			MaxRecipients: cfg.Send.MaxRecipients,
+			HourlyQuota:   cfg.Send.HourlyQuota,
+			DailyQuota:    cfg.Send.DailyQuota,
+			MaxBodyBytes:  int(cfg.Send.MaxBodySize),
+		}
+		slog.Info("Sending enabled", "domains", len(signers), "hourly_quota", cfg.Send.HourlyQuota, "daily_quota", cfg.Send.DailyQuota)
+	}
+
+	var images *imageproxy.Proxy
+	if cfg.Images.Proxy {
+		images = &imageproxy.Proxy{
+			Key:          signingKey(cfg.Images.ProxyKey, "images.proxy_key"),
+			MaxBytes:     int64(cfg.Images.MaxSize),
+			CacheBytes:   int64(cfg.Images.CacheSize),
+			CacheTTL:     cfg.Images.CacheTTL,
+			Timeout:      cfg.Images.Timeout,
+			AllowPrivate: cfg.Images.AllowPrivate,
+		}
+		if cfg.Images.AllowPrivate {
+			slog.Warn("Image proxy may fetch from private networks")
+		}
 	}
-	log.Printf("Connected to Redis at %s", redisAddr)
 
+	filters := &filter.Filters{
+		Store:         store.WithFilterTracing(emailStore),
+		MaxPerAddress: cfg.Filters.MaxPerAddress,
+	}
+
+	requireTLS := cfg.SMTP.RequireTLS
 	be := &smtp.Backend{
-		Store: emailStore,
+		Store:        tracedStore,
+		Domains:      registry,
+		RequireTLS:   requireTLS,
+		StoreTimeout: cfg.SMTP.StoreTimeout,
+		Dedup:        cfg.SMTP.Dedup,
+		KeepSource:   cfg.SMTP.KeepSource,
+		MaxPartSize:  int64(cfg.SMTP.MaxPartSize),
+		Webhooks:     webhooks,
+		Forwarder:    forwarder,
+		Filters:      filters,
 	}
 
-	s := gosmtp.NewServer(be)
-	s.Addr = smtpListenAddr
-	s.Domain = domain
-	s.ReadTimeout = 10 * time.Second
-	s.WriteTimeout = 10 * time.Second
-	s.MaxMessageBytes = 1024 * 1024
-	s.MaxRecipients = 50
-	s.AllowInsecureAuth = true
-
-	if certPath != "" && keyPath != "" {
-		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	be.Blobs = emailStore.Blobs()
+
+	if cfg.SMTP.Spool.Dir != "" {
+		sp, err := spool.Open(cfg.SMTP.Spool.Dir, int64(cfg.SMTP.Spool.MaxSize), tracedStore)
 		if err != nil {
-			log.Printf("Warning: TLS certificate failed to load (STARTTLS disabled): %v", err)
-		} else {
-			s.TLSConfig = &tls.Config{
-				Certificates: []tls.Certificate{cert},
-				MinVersion:   tls.VersionTLS12,
+			fatal("Failed to open spool", "dir", cfg.SMTP.Spool.Dir, "error", err)
+		}
+		sp.OnReplay = func(ctx context.Context, e spool.Entry) {
+			if _, err := webhooks.Enqueue(ctx, e.Address, e.Retention, e.Email); err != nil {
+				slog.WarnContext(ctx, "Failed to queue webhook deliveries", logging.KeyAddress, e.Address, "error", err)
+			}
+			if forwarder != nil && (e.Raw != nil || e.RawSHA256 != "") {
+				raw := forward.Raw{Data: e.Raw, SHA256: e.RawSHA256}
+				if _, err := forwarder.Enqueue(ctx, e.Address, e.Email, raw.Source()); err != nil {
+					slog.WarnContext(ctx, "Failed to queue forwarded mail", logging.KeyAddress, e.Address, "error", err)
+				}
 			}
-			log.Println("TLS certificates loaded successfully")
+		}
+		be.Spool = sp
+		go sp.Run(rootCtx, cfg.SMTP.Spool.ReplayInterval)
+		slog.Info("Spool enabled", "dir", cfg.SMTP.Spool.Dir, "max_size", int64(cfg.SMTP.Spool.MaxSize), "pending", sp.Len())
+	}
+
+	s := newSMTPServer(be, cfg.SMTP, domain, registry.MaxMessageBytes())
+	s.AllowInsecureAuth = !requireTLS
+
+	checker := &health.Checker{
+		Store:   emailStore,
+		SMTP:    []health.SMTPProbe{{Name: "smtp", Addr: cfg.SMTP.ListenAddr}},
+		Timeout: cfg.HTTP.HealthTimeout,
+	}
+
+	certPairs := cfg.SMTP.TLS.Pairs()
+	certReloadInterval := cfg.SMTP.TLS.ReloadInterval
+
+	if len(certPairs) > 0 {
+		certManager, err := certs.NewManager(certPairs...)
+		if err != nil {
+			slog.Warn("TLS certificate failed to load, STARTTLS disabled", "error", err)
+		} else {
+			s.TLSConfig = certManager.TLSConfig()
+			checker.Certificates = certManager.Certificates
+			go certManager.Watch(rootCtx, certReloadInterval)
+			slog.Info("TLS certificates loaded", "count", len(certPairs), "reload_interval", certReloadInterval)
 		}
 	} else {
-		log.Println("TLS certificates not configured, running without STARTTLS")
+		slog.Info("TLS certificates not configured, running without STARTTLS")
+	}
+
+	if requireTLS && s.TLSConfig == nil {
+		fatal("smtp.require_tls is set but no TLS certificate is loaded")
+	}
+	if s.TLSConfig != nil {
+		// Honour senders that ask for TLS to be required end to end (RFC 8689)
+		s.EnableREQUIRETLS = true
+	}
+
+	// Implicit TLS (port 465 style) shares the backend and certificates
+	var smtps *gosmtp.Server
+	smtpsListenAddr := cfg.SMTP.SMTPSListenAddr
+	if smtpsListenAddr != "" {
+		if s.TLSConfig == nil {
+			fatal("smtp.smtps_listen_addr is set but no TLS certificate is loaded")
+		}
+		smtps = newSMTPServer(be, cfg.SMTP, domain, registry.MaxMessageBytes())
+		smtps.TLSConfig = s.TLSConfig
+		smtps.EnableREQUIRETLS = true
+		checker.SMTP = append(checker.SMTP, health.SMTPProbe{Name: "smtps", Addr: smtpsListenAddr, TLS: true})
 	}
 
-	apiRouter := api.NewRouter(emailStore, domain, staticDir)
+	apiRouter := api.NewRouter(tracedStore, registry, api.RouterConfig{
+		StaticDir:   cfg.HTTP.StaticDir,
+		InboxLimit:  api.RateLimitConfig{Limit: cfg.HTTP.RateLimits.Inbox.Limit, Window: cfg.HTTP.RateLimits.Inbox.Window},
+		DeleteLimit: api.RateLimitConfig{Limit: cfg.HTTP.RateLimits.Delete.Limit, Window: cfg.HTTP.RateLimits.Delete.Window},
+		AuthMaxSkew: cfg.HTTP.AuthMaxSkew,
+		Health:      checker,
+		Webhooks:    webhooks,
+		Forwarder:   forwarder,
+		Sender:      sender,
+		Filters:     filters,
+		Images:      images,
+		URLKey:      signingKey(cfg.HTTP.URLKey, "http.url_key"),
+	})
+	httpListenAddr := cfg.HTTP.ListenAddr
 	httpServer := &http.Server{
 		Addr:         httpListenAddr,
 		Handler:      apiRouter,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.HTTP.ReadTimeout,
+		WriteTimeout: cfg.HTTP.WriteTimeout,
+		IdleTimeout:  cfg.HTTP.IdleTimeout,
+		ErrorLog:     slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+
+	smtpListener := listenSMTP(cfg.SMTP.ListenAddr)
+	var smtpsListener *smtp.Listener
+	if smtps != nil {
+		smtpsListener = listenSMTP(smtpsListenAddr)
 	}
 
 	// Graceful shutdown on SIGINT/SIGTERM
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
+
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
 
-		log.Println("Shutting down servers...")
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer shutdownCancel()
+		// Fail readiness first so load balancers stop sending traffic
+		checker.Drain()
+		slog.Info("Draining before shutdown", "delay", cfg.HTTP.DrainDelay)
+		time.Sleep(cfg.HTTP.DrainDelay)
+
+		slog.Info("Shutting down servers")
+		rootCancel()
+
+		var wg sync.WaitGroup
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+			defer cancel()
+			if err := httpServer.Shutdown(ctx); err != nil {
+				slog.Error("HTTP server shutdown failed", "error", err)
+			}
+		})
 
-		if err := httpServer.Shutdown(shutdownCtx); err != nil {
-			log.Printf("HTTP server shutdown error: %v", err)
+		// Messages being received get the drain timeout to finish saving
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), cfg.SMTP.DrainTimeout)
+		defer drainCancel()
+		wg.Go(func() {
+			if err := smtp.Shutdown(drainCtx, s, smtpListener); err != nil {
+				slog.Error("SMTP server shutdown failed", "error", err)
+			}
+		})
+		if smtps != nil {
+			wg.Go(func() {
+				if err := smtp.Shutdown(drainCtx, smtps, smtpsListener); err != nil {
+					slog.Error("SMTPS server shutdown failed", "error", err)
+				}
+			})
 		}
+		wg.Wait()
 
-		if err := s.Shutdown(shutdownCtx); err != nil {
-			log.Printf("SMTP server shutdown error: %v", err)
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Tracing shutdown failed", "error", err)
 		}
+		slog.Info("Shutdown complete")
 	}()
 
 	go func() {
-		log.Printf("HTTP API server starting on %s", httpListenAddr)
+		slog.Info("HTTP API server starting", "addr", httpListenAddr)
 		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("HTTP server error: %v", err)
+			slog.Error("HTTP server failed", "error", err)
 		}
 	}()
 
-	log.Printf("SMTP server starting on %s for domain %s", smtpListenAddr, domain)
-	if err := s.ListenAndServe(); err != nil {
-		log.Fatalf("SMTP server error: %v", err)
+	if smtps != nil {
+		go func() {
+			slog.Info("SMTPS server (implicit TLS) starting", "addr", smtpsListenAddr)
+			if err := smtps.Serve(tls.NewListener(smtpsListener, smtps.TLSConfig)); err != nil && err != gosmtp.ErrServerClosed {
+				slog.Error("SMTPS server failed", "error", err)
+			}
+		}()
+	}
+
+	for _, p := range registry.Policies() {
+		slog.Info("Accepting mail", "domain", p.Name, "retention", p.Retention, "max_size", p.MaxMessageBytes)
+	}
+	if requireTLS {
+		slog.Info("Refusing MAIL on unencrypted SMTP connections")
+	}
+	slog.Info("SMTP server starting", "addr", cfg.SMTP.ListenAddr)
+	if err := s.Serve(smtpListener); err != nil {
+		fatal("SMTP server failed", "error", err)
+	}
+	// Serve returns once shutdown closes the listener; wait for the drain
+	<-shutdownDone
+}
+
+// listenSMTP binds addr for a server that can be drained at shutdown.
+func listenSMTP(addr string) *smtp.Listener {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("Failed to listen for SMTP", "addr", addr, "error", err)
+	}
+	return smtp.NewListener(ln)
+}
+
+func newSMTPServer(be gosmtp.Backend, cfg config.SMTPConfig, domain string, maxMessageBytes int64) *gosmtp.Server {
+	s := gosmtp.NewServer(be)
+	s.Domain = domain
+	s.ReadTimeout = cfg.ReadTimeout
+	s.WriteTimeout = cfg.WriteTimeout
+	s.MaxMessageBytes = maxMessageBytes
+	s.MaxRecipients = cfg.MaxRecipients
+	s.ErrorLog = slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn)
+	return s
+}
+
+// openBlobs opens the configured blob store: a directory, or an S3 bucket.
+func openBlobs(cfg config.BlobConfig) (blob.Store, error) {
+	if cfg.Dir != "" {
+		return blob.NewFS(cfg.Dir)
 	}
+	return blob.NewS3(blob.S3Config{
+		Endpoint:  cfg.S3.Endpoint,
+		Region:    cfg.S3.Region,
+		Bucket:    cfg.S3.Bucket,
+		AccessKey: cfg.S3.AccessKey,
+		SecretKey: string(cfg.S3.SecretKey),
+	})
 }
@@ -16,53 +16,47 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/api/health": {
+        "/api/domains": {
             "get": {
-                "description": "Check API and services health status",
+                "description": "List the domains this server accepts mail for, with their retention and size limits",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "health"
+                    "inbox"
                 ],
-                "summary": "Health check",
-                "operationId": "healthCheck",
+                "summary": "List receiving domains",
+                "operationId": "listDomains",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.HealthResponse"
-                        }
-                    },
-                    "503": {
-                        "description": "Service unavailable - Redis disconnected",
-                        "schema": {
-                            "$ref": "#/definitions/api.ErrorResponse"
+                            "$ref": "#/definitions/api.DomainsResponse"
                         }
                     }
                 }
             }
         },
-        "/api/inbox/{address}": {
+        "/api/filters/{address}": {
             "get": {
                 "security": [
                     {
                         "SignatureAuth": []
                     }
                 ],
-                "description": "Retrieve all emails for a specific address",
+                "description": "List the filtering rules of an address in the order they apply",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "inbox"
+                    "filtering"
                 ],
-                "summary": "Get inbox emails",
-                "operationId": "getInbox",
+                "summary": "List filtering rules",
+                "operationId": "listFilterRules",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Address to retrieve emails for",
+                        "description": "Address",
                         "name": "address",
                         "in": "path",
                         "required": true
@@ -72,11 +66,74 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.InboxResponse"
+                            "$ref": "#/definitions/api.FilterRulesResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Act on mail received by the address that matches every condition given: a sender glob, a subject regular expression, a header and a minimum size.\nThe action drops the message, rejects it at SMTP time, labels it or makes it expire after expire_minutes. Rules apply in the order they were added; the first drop or reject ends evaluation. Rules are kept with the address's registration: registering again keeps them as long, and they end with it. Addresses that are not registered return 404.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "filtering"
+                ],
+                "summary": "Add a filtering rule",
+                "operationId": "createFilterRule",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Conditions and action",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.FilterRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.FilterRuleResponse"
                         }
                     },
                     "400": {
-                        "description": "Bad Request",
+                        "description": "Invalid address, body or rule",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "The address is not registered",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The address already has the maximum number of rules",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
@@ -88,29 +145,38 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
+            }
+        },
+        "/api/filters/{address}/{filterId}": {
             "delete": {
                 "security": [
                     {
                         "SignatureAuth": []
                     }
                 ],
-                "description": "Delete all emails for a specific address",
+                "description": "Remove a filtering rule. Mail already labelled or set to expire keeps that.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "inbox"
+                    "filtering"
                 ],
-                "summary": "Clear entire inbox",
-                "operationId": "clearInbox",
+                "summary": "Delete a filtering rule",
+                "operationId": "deleteFilterRule",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Address to clear inbox for",
+                        "description": "Address",
                         "name": "address",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Rule ID",
+                        "name": "filterId",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
@@ -120,8 +186,8 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.DeleteResponse"
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "404": {
+                        "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
@@ -135,22 +201,22 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/inbox/{address}/{emailId}": {
+        "/api/forwards/{address}": {
             "get": {
                 "security": [
                     {
                         "SignatureAuth": []
                     }
                 ],
-                "description": "Retrieve a specific email by ID for an address",
+                "description": "List the forwarding rules of an address",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "inbox"
+                    "forwarding"
                 ],
-                "summary": "Get single email",
-                "operationId": "getEmail",
+                "summary": "List forwarding rules",
+                "operationId": "listForwardRules",
                 "parameters": [
                     {
                         "type": "string",
@@ -158,30 +224,74 @@ const docTemplate = `{
                         "name": "address",
                         "in": "path",
                         "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ForwardRulesResponse"
+                        }
                     },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Relay mail received by the address to another mailbox, optionally only mail whose sender or subject contains the given text.\nDestinations on domains served here are refused. The rules of an address are kept for the domain's retention after the latest was added.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "forwarding"
+                ],
+                "summary": "Add a forwarding rule",
+                "operationId": "createForwardRule",
+                "parameters": [
                     {
                         "type": "string",
-                        "description": "Email ID",
-                        "name": "emailId",
+                        "description": "Address to forward from",
+                        "name": "address",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "Destination and filters",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.ForwardRequest"
+                        }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/api.EmailResponse"
+                            "$ref": "#/definitions/api.ForwardRuleResponse"
                         }
                     },
                     "400": {
-                        "description": "Bad Request",
+                        "description": "Invalid address, body, destination or filter",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "409": {
+                        "description": "The address already has the maximum number of rules",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
@@ -193,22 +303,24 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
+            }
+        },
+        "/api/forwards/{address}/{ruleId}": {
             "delete": {
                 "security": [
                     {
                         "SignatureAuth": []
                     }
                 ],
-                "description": "Delete a specific email by ID for an address",
+                "description": "Remove a forwarding rule. Messages still queued for it are dropped.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "inbox"
+                    "forwarding"
                 ],
-                "summary": "Delete single email",
-                "operationId": "deleteEmail",
+                "summary": "Delete a forwarding rule",
+                "operationId": "deleteForwardRule",
                 "parameters": [
                     {
                         "type": "string",
@@ -219,8 +331,8 @@ const docTemplate = `{
                     },
                     {
                         "type": "string",
-                        "description": "Email ID",
-                        "name": "emailId",
+                        "description": "Rule ID",
+                        "name": "ruleId",
                         "in": "path",
                         "required": true
                     }
@@ -232,8 +344,8 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.DeleteResponse"
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "404": {
+                        "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
@@ -247,26 +359,153 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/register/{address}": {
-            "post": {
+        "/api/health": {
+            "get": {
+                "description": "Legacy summary of the readiness check. Use /api/health/ready instead.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Health check",
+                "operationId": "healthCheck",
+                "deprecated": true,
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.HealthResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "A probe failed or the server is draining",
+                        "schema": {
+                            "$ref": "#/definitions/api.HealthResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/health/live": {
+            "get": {
+                "description": "Reports that the process is serving HTTP. It does not check dependencies.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Liveness check",
+                "operationId": "livenessCheck",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.LivenessResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/health/ready": {
+            "get": {
+                "description": "Probes the store and SMTP listeners and reports TLS certificate validity.\nFails with 503 while the server is shutting down so load balancers drain first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness check",
+                "operationId": "readinessCheck",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ReadinessResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "A probe failed or the server is draining",
+                        "schema": {
+                            "$ref": "#/definitions/api.ReadinessResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/images": {
+            "get": {
+                "description": "Fetch a remote image from a sanitized email so the viewer's address is not revealed to its server. Only URLs signed by the server, as found in sanitized bodies, are fetched. PNG, GIF, JPEG and WebP images up to the configured size are served.",
+                "produces": [
+                    "image/png",
+                    "image/gif",
+                    "image/jpeg"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Proxy remote image",
+                "operationId": "proxyImage",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Image URL",
+                        "name": "url",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Signature of the URL",
+                        "name": "sig",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/inbox/{address}": {
+            "get": {
                 "security": [
                     {
                         "SignatureAuth": []
                     }
                 ],
-                "description": "Register a derived hex address to receive emails for the next 24 hours",
+                "description": "Retrieve all emails for a specific address",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "inbox"
                 ],
-                "summary": "Register address for inbound mail",
-                "operationId": "registerAddress",
+                "summary": "Get inbox emails",
+                "operationId": "getInbox",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Hex-encoded address to register",
+                        "description": "Address to retrieve emails for",
                         "name": "address",
                         "in": "path",
                         "required": true
@@ -276,68 +515,1823 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.RegisterResponse"
+                            "$ref": "#/definitions/api.InboxResponse"
                         }
                     },
                     "400": {
-                        "description": "Invalid address format or missing address",
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "500": {
-                        "description": "Internal server error",
+                        "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     }
                 }
-            }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Delete all emails for a specific address",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Clear entire inbox",
+                "operationId": "clearInbox",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address to clear inbox for",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.DeleteResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/inbox/{address}/latest-code": {
+            "get": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Return the most likely one-time code of the newest email that has one, for test scripts waiting on a verification email. Pass since to ignore earlier emails.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Get latest one-time code",
+                "operationId": "getLatestCode",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only consider emails received at or after this RFC 3339 time",
+                        "name": "since",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.LatestCodeResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/inbox/{address}/reply/{emailId}": {
+            "post": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Reply to a received email. The reply is threaded with In-Reply-To and References, and goes to the original's Reply-To or sender unless recipients are given.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sending"
+                ],
+                "summary": "Reply to an email",
+                "operationId": "replyToEmail",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID of the email replied to",
+                        "name": "emailId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Reply",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.ReplyRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/api.SentEmailResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/inbox/{address}/send": {
+            "post": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Send a DKIM-signed email from an address through the outbound relay. Each address may only send a few messages per hour and per day; a copy is kept in its sent folder.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sending"
+                ],
+                "summary": "Send an email",
+                "operationId": "sendEmail",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Message",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.SendRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/api.SentEmailResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/inbox/{address}/sent": {
+            "get": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "List the emails an address has sent, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sending"
+                ],
+                "summary": "List sent emails",
+                "operationId": "getSentEmails",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SentResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/inbox/{address}/{emailId}": {
+            "get": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Retrieve a specific email by ID for an address",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Get single email",
+                "operationId": "getEmail",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Email ID",
+                        "name": "emailId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.EmailResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Delete a specific email by ID for an address",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Delete single email",
+                "operationId": "deleteEmail",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Email ID",
+                        "name": "emailId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.DeleteResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/inbox/{address}/{emailId}/inline/{contentId}": {
+            "get": {
+                "description": "Return an image embedded in an email, as referenced by cid: URLs in its HTML body. Images load from img tags, which cannot sign requests, so this route takes the signed, expiring URL given in the email's inline list and sanitized body instead.",
+                "produces": [
+                    "image/png",
+                    "image/gif",
+                    "image/jpeg"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Get inline image",
+                "operationId": "getInlinePart",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Email ID",
+                        "name": "emailId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Content-ID of the part",
+                        "name": "contentId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Unix time the URL expires",
+                        "name": "expires",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Signature of the URL",
+                        "name": "sig",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/inbox/{address}/{emailId}/render": {
+            "get": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Return the email as a standalone HTML document for display in a sandboxed frame. HTML bodies are sanitized, with tracking pixels removed and remote images loaded through the image proxy when it is enabled, and plain text is escaped. The document's Content-Security-Policy blocks scripts and other remote resources; pass remote_images=true to load images directly over HTTPS.",
+                "produces": [
+                    "text/html"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Render email",
+                "operationId": "renderEmail",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Email ID",
+                        "name": "emailId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Allow images from HTTPS URLs",
+                        "name": "remote_images",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "HTML document",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/inbox/{address}/{emailId}/source": {
+            "get": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Return an email as it was received, its RFC 5322 headers and body undecoded. Sources are kept only while the server's SMTP_KEEP_SOURCE is enabled; other emails return 404.",
+                "produces": [
+                    "message/rfc822"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Get email source",
+                "operationId": "getEmailSource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Email ID",
+                        "name": "emailId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/inbox/{address}/{emailId}/structure": {
+            "get": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Return the MIME tree of an email as it was received: each part's path, content type, size, disposition and file name. Messages attached as message/rfc822 parts are described as the part's only child. Emails received before structures were recorded return 404.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Get email structure",
+                "operationId": "getEmailStructure",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Email ID",
+                        "name": "emailId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.MIMEPartResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/register/{address}": {
+            "post": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Register a derived hex address to receive emails on one of the served domains.\nThe registration lasts for the domain's retention period (24 hours by default).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Register address for inbound mail",
+                "operationId": "registerAddress",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Hex-encoded address to register",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Domain to register on; defaults to the primary domain",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/api.RegisterRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.RegisterResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid address format, missing address or unknown domain",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/webhooks/{address}": {
+            "get": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "List the webhooks registered on an address, without their secrets",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "List webhooks",
+                "operationId": "listWebhooks",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.WebhooksResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Register a URL to be notified with a signed POST whenever the address receives an email.\nThe secret for verifying signatures is only returned here. The webhooks of an address are kept for the domain's retention after the latest registration.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Register a webhook",
+                "operationId": "createWebhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address to watch",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Webhook URL and options",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.WebhookRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.WebhookCreatedResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid address, body or URL",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The address already has the maximum number of webhooks",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/webhooks/{address}/dead-letters": {
+            "get": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "List deliveries that failed every attempt, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Webhook dead letters",
+                "operationId": "listWebhookDeadLetters",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.DeadLettersResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/webhooks/{address}/deliveries": {
+            "get": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "List the most recent delivery attempts for the address's webhooks, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Webhook delivery log",
+                "operationId": "listWebhookDeliveries",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.WebhookDeliveriesResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/webhooks/{address}/{webhookId}": {
+            "delete": {
+                "security": [
+                    {
+                        "SignatureAuth": []
+                    }
+                ],
+                "description": "Remove a webhook. Deliveries still queued for it are dropped.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Delete a webhook",
+                "operationId": "deleteWebhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Webhook ID",
+                        "name": "webhookId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.DeleteResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
         }
     },
     "definitions": {
+        "api.BuildInfoResponse": {
+            "type": "object",
+            "properties": {
+                "commit": {
+                    "type": "string",
+                    "example": "0f87b1e"
+                },
+                "go_version": {
+                    "type": "string",
+                    "example": "go1.25.6"
+                },
+                "version": {
+                    "type": "string",
+                    "example": "v1.2.0"
+                }
+            }
+        },
+        "api.CertificateResponse": {
+            "type": "object",
+            "properties": {
+                "dns_names": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "mx.coresend.io"
+                    ]
+                },
+                "not_after": {
+                    "type": "string",
+                    "example": "2026-01-01T00:00:00Z"
+                },
+                "subject": {
+                    "type": "string",
+                    "example": "mx.coresend.io"
+                },
+                "valid": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "api.CheckResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "dial tcp :1025: connect: connection refused"
+                },
+                "latency_ms": {
+                    "type": "number",
+                    "example": 0.42
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "ok",
+                        "failing"
+                    ],
+                    "example": "ok"
+                }
+            }
+        },
+        "api.CodeResponse": {
+            "type": "object",
+            "properties": {
+                "confidence": {
+                    "type": "number",
+                    "example": 0.9
+                },
+                "value": {
+                    "type": "string",
+                    "example": "482913"
+                }
+            }
+        },
+        "api.DeadLetterResponse": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer",
+                    "example": 8
+                },
+                "created_at": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00Z"
+                },
+                "email_id": {
+                    "type": "string",
+                    "example": "550e8400-e29b-41d4-a716-446655440000"
+                },
+                "job_id": {
+                    "type": "string",
+                    "example": "0b6a6f8e-8d1f-4a43-9f0e-5c1d2b3a4e5f"
+                },
+                "last_error": {
+                    "type": "string",
+                    "example": "receiver answered 503 Service Unavailable"
+                },
+                "webhook_id": {
+                    "type": "string",
+                    "example": "6f1c2a9e-3b7d-4c1e-9a57-2d8e4f0b1c3a"
+                }
+            }
+        },
+        "api.DeadLettersResponse": {
+            "type": "object",
+            "properties": {
+                "dead_letters": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.DeadLetterResponse"
+                    }
+                }
+            }
+        },
         "api.DeleteResponse": {
             "type": "object",
             "properties": {
-                "count": {
+                "count": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "deleted": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "id": {
+                    "type": "string",
+                    "example": "550e8400-e29b-41d4-a716-446655440000"
+                }
+            }
+        },
+        "api.DomainResponse": {
+            "type": "object",
+            "properties": {
+                "default": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "max_message_bytes": {
+                    "type": "integer",
+                    "example": 1048576
+                },
+                "name": {
+                    "type": "string",
+                    "example": "coresend.io"
+                },
+                "retention_seconds": {
+                    "type": "integer",
+                    "example": 86400
+                }
+            }
+        },
+        "api.DomainsResponse": {
+            "type": "object",
+            "properties": {
+                "domains": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.DomainResponse"
+                    }
+                }
+            }
+        },
+        "api.EmailResponse": {
+            "type": "object",
+            "required": [
+                "id"
+            ],
+            "properties": {
+                "body": {
+                    "description": "Body is sanitized when it is HTML.",
+                    "type": "string",
+                    "example": "This is the email body content"
+                },
+                "cc": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "team@example.com"
+                    ]
+                },
+                "charset": {
+                    "description": "Charset is what the body was declared in; it is always returned as\nUTF-8.",
+                    "type": "string",
+                    "example": "iso-2022-jp"
+                },
+                "content_type": {
+                    "type": "string",
+                    "enum": [
+                        "text/html",
+                        "text/plain"
+                    ],
+                    "example": "text/html"
+                },
+                "duplicates": {
+                    "description": "Duplicates counts copies of the message that were received again and\nnot saved.",
+                    "type": "integer",
+                    "example": 1
+                },
+                "envelope": {
+                    "description": "Envelope is omitted for emails received before it was recorded.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.EnvelopeResponse"
+                        }
+                    ]
+                },
+                "expires_at": {
+                    "description": "ExpiresAt is set when a filtering rule removes the email early.",
+                    "type": "string",
+                    "example": "2024-01-01T12:30:00Z"
+                },
+                "extracted": {
+                    "description": "Extracted is omitted when nothing was found in the email.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.ExtractedResponse"
+                        }
+                    ]
+                },
+                "from": {
+                    "description": "From is the envelope sender.",
+                    "type": "string",
+                    "example": "sender@example.com"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "550e8400-e29b-41d4-a716-446655440000"
+                },
+                "inline": {
+                    "description": "Inline are the images embedded in the message for its HTML body.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.InlinePartResponse"
+                    }
+                },
+                "labels": {
+                    "description": "Labels are added by the address's filtering rules.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "marketing"
+                    ]
+                },
+                "received_at": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00Z"
+                },
+                "subject": {
+                    "type": "string",
+                    "example": "Hello World"
+                },
+                "tls": {
+                    "$ref": "#/definitions/api.TLSResponse"
+                },
+                "to": {
+                    "description": "To and Cc are the recipients in the message header.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "Alice \u003ca1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2@coresend.io\u003e"
+                    ]
+                },
+                "trackers": {
+                    "description": "Trackers lists what sanitizing the body removed, if anything.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.TrackersResponse"
+                        }
+                    ]
+                }
+            }
+        },
+        "api.EnvelopeResponse": {
+            "type": "object",
+            "properties": {
+                "client_ip": {
+                    "type": "string",
+                    "example": "192.0.2.10"
+                },
+                "helo": {
+                    "type": "string",
+                    "example": "mail.example.com"
+                },
+                "mail_from": {
+                    "type": "string",
+                    "example": "bounces@example.com"
+                },
+                "recipient": {
+                    "type": "string",
+                    "example": "a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2@coresend.io"
+                }
+            }
+        },
+        "api.ErrorDetails": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "INVALID_ADDRESS"
+                },
+                "details": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Address is required"
+                }
+            }
+        },
+        "api.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "$ref": "#/definitions/api.ErrorDetails"
+                }
+            }
+        },
+        "api.ExtractedResponse": {
+            "type": "object",
+            "properties": {
+                "codes": {
+                    "description": "Codes are one-time code candidates, most likely first.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.CodeResponse"
+                    }
+                },
+                "links": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.LinkResponse"
+                    }
+                },
+                "unsubscribe": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "mailto:unsubscribe@example.com"
+                    ]
+                }
+            }
+        },
+        "api.FilterRequest": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string",
+                    "enum": [
+                        "drop",
+                        "reject",
+                        "label",
+                        "expire"
+                    ],
+                    "example": "label"
+                },
+                "expire_minutes": {
+                    "type": "integer",
+                    "example": 30
+                },
+                "from_glob": {
+                    "type": "string",
+                    "example": "*@news.example.com"
+                },
+                "header": {
+                    "type": "string",
+                    "example": "List-Unsubscribe"
+                },
+                "header_contains": {
+                    "type": "string",
+                    "example": "example.com"
+                },
+                "label": {
+                    "type": "string",
+                    "example": "marketing"
+                },
+                "min_size": {
+                    "type": "integer",
+                    "example": 1048576
+                },
+                "subject_regex": {
+                    "type": "string",
+                    "example": "(?i)sale|% off"
+                }
+            }
+        },
+        "api.FilterRuleResponse": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string",
+                    "example": "label"
+                },
+                "created_at": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00Z"
+                },
+                "expire_minutes": {
+                    "type": "integer",
+                    "example": 30
+                },
+                "from_glob": {
+                    "type": "string",
+                    "example": "*@news.example.com"
+                },
+                "header": {
+                    "type": "string",
+                    "example": "List-Unsubscribe"
+                },
+                "header_contains": {
+                    "type": "string",
+                    "example": "example.com"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "8b0f3e2a-6c1d-4f7e-9a2b-5d4c3e2f1a0b"
+                },
+                "label": {
+                    "type": "string",
+                    "example": "marketing"
+                },
+                "min_size": {
+                    "type": "integer",
+                    "example": 1048576
+                },
+                "subject_regex": {
+                    "type": "string",
+                    "example": "(?i)sale|% off"
+                }
+            }
+        },
+        "api.FilterRulesResponse": {
+            "type": "object",
+            "properties": {
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.FilterRuleResponse"
+                    }
+                }
+            }
+        },
+        "api.ForwardRequest": {
+            "type": "object",
+            "properties": {
+                "destination": {
+                    "type": "string",
+                    "example": "tester@example.com"
+                },
+                "from_contains": {
+                    "description": "FromContains and SubjectContains limit the rule to matching mail.",
+                    "type": "string",
+                    "example": "ci@example.org"
+                },
+                "subject_contains": {
+                    "type": "string",
+                    "example": "verification"
+                }
+            }
+        },
+        "api.ForwardRuleResponse": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00Z"
+                },
+                "destination": {
+                    "type": "string",
+                    "example": "tester@example.com"
+                },
+                "from_contains": {
+                    "type": "string",
+                    "example": "ci@example.org"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "3c9e1d7a-5b2f-4e8a-9c6d-1f0a2b3c4d5e"
+                },
+                "subject_contains": {
+                    "type": "string",
+                    "example": "verification"
+                }
+            }
+        },
+        "api.ForwardRulesResponse": {
+            "type": "object",
+            "properties": {
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.ForwardRuleResponse"
+                    }
+                }
+            }
+        },
+        "api.HealthResponse": {
+            "type": "object",
+            "properties": {
+                "services": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "connected"
+                }
+            }
+        },
+        "api.InboxResponse": {
+            "type": "object",
+            "properties": {
+                "address": {
+                    "type": "string",
+                    "example": "a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2"
+                },
+                "count": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "email": {
+                    "type": "string",
+                    "example": "a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2@coresend.io"
+                },
+                "emails": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.EmailResponse"
+                    }
+                }
+            }
+        },
+        "api.InlinePartResponse": {
+            "type": "object",
+            "properties": {
+                "content_id": {
+                    "type": "string",
+                    "example": "logo@example.com"
+                },
+                "content_type": {
+                    "type": "string",
+                    "example": "image/png"
+                },
+                "filename": {
+                    "type": "string",
+                    "example": "logo.png"
+                },
+                "size": {
+                    "type": "integer",
+                    "example": 2048
+                },
+                "url": {
+                    "type": "string",
+                    "example": "/api/inbox/a1b2c3/550e8400-e29b-41d4-a716-446655440000/inline/logo@example.com?expires=1704114000\u0026sig=..."
+                }
+            }
+        },
+        "api.LatestCodeResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "482913"
+                },
+                "confidence": {
+                    "type": "number",
+                    "example": 0.9
+                },
+                "email_id": {
+                    "type": "string",
+                    "example": "550e8400-e29b-41d4-a716-446655440000"
+                },
+                "links": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.LinkResponse"
+                    }
+                },
+                "received_at": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00Z"
+                },
+                "subject": {
+                    "type": "string",
+                    "example": "Your verification code"
+                }
+            }
+        },
+        "api.LinkResponse": {
+            "type": "object",
+            "properties": {
+                "kind": {
+                    "type": "string",
+                    "enum": [
+                        "verify",
+                        "reset",
+                        "login"
+                    ],
+                    "example": "verify"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://example.com/verify?token=abc"
+                }
+            }
+        },
+        "api.LivenessResponse": {
+            "type": "object",
+            "properties": {
+                "build": {
+                    "$ref": "#/definitions/api.BuildInfoResponse"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "alive"
+                }
+            }
+        },
+        "api.MIMEPartResponse": {
+            "type": "object",
+            "properties": {
+                "charset": {
+                    "type": "string",
+                    "example": "utf-8"
+                },
+                "content_id": {
+                    "type": "string",
+                    "example": "logo@example.com"
+                },
+                "content_type": {
+                    "type": "string",
+                    "example": "application/pdf"
+                },
+                "disposition": {
+                    "type": "string",
+                    "enum": [
+                        "inline",
+                        "attachment"
+                    ],
+                    "example": "attachment"
+                },
+                "encoding": {
+                    "type": "string",
+                    "example": "base64"
+                },
+                "filename": {
+                    "type": "string",
+                    "example": "invoice.pdf"
+                },
+                "parts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.MIMEPartResponse"
+                    }
+                },
+                "path": {
+                    "type": "string",
+                    "example": "1.2"
+                },
+                "sha256": {
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+                },
+                "size": {
                     "type": "integer",
-                    "example": 5
+                    "example": 48213
+                }
+            }
+        },
+        "api.ReadinessResponse": {
+            "type": "object",
+            "properties": {
+                "build": {
+                    "$ref": "#/definitions/api.BuildInfoResponse"
                 },
-                "deleted": {
-                    "type": "boolean",
-                    "example": true
+                "certificates": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.CertificateResponse"
+                    }
                 },
-                "id": {
+                "checks": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/api.CheckResponse"
+                    }
+                },
+                "status": {
                     "type": "string",
-                    "example": "550e8400-e29b-41d4-a716-446655440000"
+                    "enum": [
+                        "ready",
+                        "not_ready",
+                        "draining"
+                    ],
+                    "example": "ready"
                 }
             }
         },
-        "api.EmailResponse": {
+        "api.RegisterRequest": {
+            "type": "object",
+            "properties": {
+                "domain": {
+                    "type": "string",
+                    "example": "coresend.io"
+                }
+            }
+        },
+        "api.RegisterResponse": {
             "type": "object",
             "required": [
-                "id"
+                "address",
+                "expires_in",
+                "registered"
             ],
+            "properties": {
+                "address": {
+                    "type": "string",
+                    "example": "a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2"
+                },
+                "domain": {
+                    "type": "string",
+                    "example": "coresend.io"
+                },
+                "email": {
+                    "type": "string",
+                    "example": "a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2@coresend.io"
+                },
+                "expires_in": {
+                    "type": "integer",
+                    "example": 86400
+                },
+                "registered": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "api.ReplyRequest": {
+            "type": "object",
+            "properties": {
+                "html": {
+                    "type": "string",
+                    "example": "\u003cp\u003eThanks\u003c/p\u003e"
+                },
+                "subject": {
+                    "description": "Subject defaults to the original's with a \"Re:\" prefix.",
+                    "type": "string",
+                    "example": "Re: Hello"
+                },
+                "text": {
+                    "type": "string",
+                    "example": "Thanks"
+                },
+                "to": {
+                    "description": "To defaults to the original's Reply-To or sender.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "tester@example.com"
+                    ]
+                }
+            }
+        },
+        "api.SendRequest": {
+            "type": "object",
+            "properties": {
+                "html": {
+                    "type": "string",
+                    "example": "\u003cp\u003eHi there\u003c/p\u003e"
+                },
+                "subject": {
+                    "type": "string",
+                    "example": "Hello"
+                },
+                "text": {
+                    "description": "Text and HTML are the body; at least one should be set. When both are\nset the message offers them as alternatives.",
+                    "type": "string",
+                    "example": "Hi there"
+                },
+                "to": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "tester@example.com"
+                    ]
+                }
+            }
+        },
+        "api.SentEmailResponse": {
+            "type": "object",
             "properties": {
                 "body": {
                     "type": "string",
-                    "example": "This is the email body content"
+                    "example": "Hi there"
                 },
                 "from": {
                     "type": "string",
-                    "example": "sender@example.com"
+                    "example": "a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2@coresend.io"
                 },
                 "id": {
                     "type": "string",
                     "example": "550e8400-e29b-41d4-a716-446655440000"
                 },
-                "received_at": {
+                "message_id": {
+                    "type": "string",
+                    "example": "550e8400-e29b-41d4-a716-446655440000@coresend.io"
+                },
+                "references": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "CAF1234@mail.example.com"
+                    ]
+                },
+                "sent_at": {
                     "type": "string",
                     "example": "2024-01-01T12:00:00Z"
                 },
                 "subject": {
                     "type": "string",
-                    "example": "Hello World"
+                    "example": "Hello"
                 },
                 "to": {
                     "type": "array",
@@ -345,93 +2339,197 @@ const docTemplate = `{
                         "type": "string"
                     },
                     "example": [
-                        "a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2@coresend.io"
+                        "tester@example.com"
                     ]
                 }
             }
         },
-        "api.ErrorDetails": {
+        "api.SentResponse": {
             "type": "object",
             "properties": {
-                "code": {
-                    "type": "string",
-                    "example": "INVALID_ADDRESS"
-                },
-                "details": {
-                    "type": "object",
-                    "additionalProperties": true
+                "count": {
+                    "type": "integer",
+                    "example": 1
                 },
-                "message": {
-                    "type": "string",
-                    "example": "Address is required"
+                "emails": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.SentEmailResponse"
+                    }
                 }
             }
         },
-        "api.ErrorResponse": {
+        "api.TLSResponse": {
             "type": "object",
             "properties": {
-                "error": {
-                    "$ref": "#/definitions/api.ErrorDetails"
+                "cipher_suite": {
+                    "type": "string",
+                    "example": "TLS_AES_128_GCM_SHA256"
+                },
+                "encrypted": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "version": {
+                    "type": "string",
+                    "example": "TLS 1.3"
                 }
             }
         },
-        "api.HealthResponse": {
+        "api.TrackersResponse": {
             "type": "object",
             "properties": {
-                "services": {
-                    "type": "object",
-                    "additionalProperties": {
+                "links": {
+                    "description": "Links are click-tracking redirects replaced by their destination.",
+                    "type": "array",
+                    "items": {
                         "type": "string"
-                    }
+                    },
+                    "example": [
+                        "https://www.google.com/url?q=https://example.com/"
+                    ]
                 },
-                "status": {
-                    "type": "string",
-                    "example": "connected"
+                "pixels": {
+                    "description": "Pixels are the sources of removed tracking images.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "https://u1.ct.sendgrid.net/wf/open?upn=abc"
+                    ]
                 }
             }
         },
-        "api.InboxResponse": {
+        "api.WebhookCreatedResponse": {
             "type": "object",
             "properties": {
-                "address": {
+                "created_at": {
                     "type": "string",
-                    "example": "a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2"
+                    "example": "2024-01-01T12:00:00Z"
                 },
-                "count": {
+                "expires_in": {
                     "type": "integer",
-                    "example": 5
+                    "example": 86400
                 },
-                "email": {
+                "id": {
                     "type": "string",
-                    "example": "a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2@coresend.io"
+                    "example": "6f1c2a9e-3b7d-4c1e-9a57-2d8e4f0b1c3a"
                 },
-                "emails": {
+                "include_body": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "secret": {
+                    "type": "string",
+                    "example": "whsec_3f9a..."
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://ci.example.com/hooks/coresend"
+                }
+            }
+        },
+        "api.WebhookDeliveriesResponse": {
+            "type": "object",
+            "properties": {
+                "deliveries": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/api.EmailResponse"
+                        "$ref": "#/definitions/api.WebhookDeliveryResponse"
                     }
                 }
             }
         },
-        "api.RegisterResponse": {
+        "api.WebhookDeliveryResponse": {
             "type": "object",
-            "required": [
-                "address",
-                "expires_in",
-                "registered"
-            ],
             "properties": {
-                "address": {
+                "at": {
                     "type": "string",
-                    "example": "a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2"
+                    "example": "2024-01-01T12:00:01Z"
                 },
-                "expires_in": {
+                "attempt": {
                     "type": "integer",
-                    "example": 86400
+                    "example": 1
                 },
-                "registered": {
+                "duration_ms": {
+                    "type": "integer",
+                    "example": 42
+                },
+                "email_id": {
+                    "type": "string",
+                    "example": "550e8400-e29b-41d4-a716-446655440000"
+                },
+                "error": {
+                    "type": "string",
+                    "example": "receiver answered 503 Service Unavailable"
+                },
+                "job_id": {
+                    "type": "string",
+                    "example": "0b6a6f8e-8d1f-4a43-9f0e-5c1d2b3a4e5f"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "delivered",
+                        "retrying",
+                        "dead"
+                    ],
+                    "example": "delivered"
+                },
+                "status_code": {
+                    "type": "integer",
+                    "example": 200
+                },
+                "webhook_id": {
+                    "type": "string",
+                    "example": "6f1c2a9e-3b7d-4c1e-9a57-2d8e4f0b1c3a"
+                }
+            }
+        },
+        "api.WebhookRequest": {
+            "type": "object",
+            "properties": {
+                "include_body": {
+                    "description": "IncludeBody adds the email body to each delivery.",
                     "type": "boolean",
-                    "example": true
+                    "example": false
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://ci.example.com/hooks/coresend"
+                }
+            }
+        },
+        "api.WebhookResponse": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00Z"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "6f1c2a9e-3b7d-4c1e-9a57-2d8e4f0b1c3a"
+                },
+                "include_body": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://ci.example.com/hooks/coresend"
+                }
+            }
+        },
+        "api.WebhooksResponse": {
+            "type": "object",
+            "properties": {
+                "webhooks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.WebhookResponse"
+                    }
                 }
             }
         }
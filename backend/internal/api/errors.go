@@ -13,6 +13,19 @@ const (
 	ErrCodeServiceUnavailable = "SERVICE_UNAVAILABLE"
 	ErrCodeRateLimitExceeded  = "RATE_LIMIT_EXCEEDED"
 	ErrCodeUnauthorized       = "UNAUTHORIZED"
+	ErrCodeInvalidDomain      = "INVALID_DOMAIN"
+	ErrCodeInvalidRequest     = "INVALID_REQUEST"
+	ErrCodeInvalidWebhookURL  = "INVALID_WEBHOOK_URL"
+	ErrCodeWebhookLimit       = "WEBHOOK_LIMIT_EXCEEDED"
+	ErrCodeInvalidForward     = "INVALID_FORWARD_RULE"
+	ErrCodeForwardLimit       = "FORWARD_LIMIT_EXCEEDED"
+	ErrCodeInvalidMessage     = "INVALID_MESSAGE"
+	ErrCodeSendQuota          = "SEND_QUOTA_EXCEEDED"
+	ErrCodeSendingDisabled    = "SENDING_DISABLED"
+	ErrCodeInvalidFilter      = "INVALID_FILTER_RULE"
+	ErrCodeFilterLimit        = "FILTER_LIMIT_EXCEEDED"
+	ErrCodeInvalidSignature   = "INVALID_SIGNATURE"
+	ErrCodeImageUnavailable   = "IMAGE_UNAVAILABLE"
 )
 
 func writeError(w http.ResponseWriter, code string, message string, httpStatus int) {
@@ -1,36 +1,81 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/fn-jakubkarp/coresend/docs"
+	"github.com/fn-jakubkarp/coresend/internal/buildinfo"
+	"github.com/fn-jakubkarp/coresend/internal/domains"
+	"github.com/fn-jakubkarp/coresend/internal/filter"
+	"github.com/fn-jakubkarp/coresend/internal/forward"
+	"github.com/fn-jakubkarp/coresend/internal/health"
+	"github.com/fn-jakubkarp/coresend/internal/imageproxy"
+	"github.com/fn-jakubkarp/coresend/internal/sanitize"
+	"github.com/fn-jakubkarp/coresend/internal/send"
 	"github.com/fn-jakubkarp/coresend/internal/store"
+	"github.com/fn-jakubkarp/coresend/internal/tracing"
 	"github.com/fn-jakubkarp/coresend/internal/validator"
+	"github.com/fn-jakubkarp/coresend/internal/webhook"
 )
 
 type APIHandler struct {
-	Store  store.EmailStore
-	Domain string
+	Store   store.EmailStore
+	Domains *domains.Registry
+	// Health runs the readiness probes. When nil only the store is checked.
+	Health *health.Checker
+	// Webhooks registers and reports on webhooks. When nil the webhook
+	// routes are not served.
+	Webhooks *webhook.Dispatcher
+	// Forwarder manages forwarding rules. When nil the forwarding routes are
+	// not served.
+	Forwarder *forward.Forwarder
+	// Sender sends mail from addresses. When nil the sending routes are not
+	// served.
+	Sender *send.Sender
+	// Images proxies remote images in sanitized HTML. When nil, image
+	// sources are kept and the proxy route is not served.
+	Images *imageproxy.Proxy
+	// Filters manages filtering rules. When nil the filter routes are not
+	// served.
+	Filters *filter.Filters
+	// URLKey signs the URLs of inline images. When empty, cid: image sources
+	// are kept and the inline route is not served.
+	URLKey []byte
 }
 
-func NewAPIHandler(s store.EmailStore, domain string) *APIHandler {
+func NewAPIHandler(s store.EmailStore, registry *domains.Registry) *APIHandler {
 	return &APIHandler{
-		Store:  s,
-		Domain: domain,
+		Store:   s,
+		Domains: registry,
 	}
 }
 
 // @ID registerAddress
 // @Summary Register address for inbound mail
-// @Description Register a derived hex address to receive emails for the next 24 hours
+// @Description Register a derived hex address to receive emails on one of the served domains.
+// @Description The registration lasts for the domain's retention period (24 hours by default).
 // @Tags inbox
 // @Param address path string true "Hex-encoded address to register"
+// @Param request body RegisterRequest false "Domain to register on; defaults to the primary domain"
+// @Accept json
 // @Produce json
 // @Success 200 {object} RegisterResponse
-// @Failure 400 {object} ErrorResponse "Invalid address format or missing address"
+// @Failure 400 {object} ErrorResponse "Invalid address format, missing address or unknown domain"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Security SignatureAuth
 // @Router /api/register/{address} [post]
@@ -46,11 +91,35 @@ func (h *APIHandler) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ttl := 24 * time.Hour
+	var req RegisterRequest
+	if r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, ErrCodeInternalError, "Failed to read request body", http.StatusInternalServerError)
+			return
+		}
+		if len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, &req); err != nil {
+				writeError(w, ErrCodeInvalidRequest, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	policy := h.Domains.Default()
+	if req.Domain != "" {
+		var ok bool
+		if policy, ok = h.Domains.Lookup(req.Domain); !ok {
+			writeError(w, ErrCodeInvalidDomain, "Unknown domain", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ttl := policy.Retention
 
-	err := h.Store.RegisterAddress(r.Context(), address, ttl)
+	err := h.Store.RegisterAddress(r.Context(), address, policy.Name, ttl)
 	if err != nil {
-		log.Printf("Error registering address: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to register address", "address", address, "error", err)
 		writeError(w, ErrCodeInternalError, "Failed to register address", http.StatusInternalServerError)
 		return
 	}
@@ -58,6 +127,8 @@ func (h *APIHandler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	resp := RegisterResponse{
 		Registered: true,
 		Address:    address,
+		Domain:     policy.Name,
+		Email:      address + "@" + policy.Name,
 		ExpiresIn:  int(ttl.Seconds()),
 	}
 
@@ -85,26 +156,30 @@ func (h *APIHandler) handleGetInbox(w http.ResponseWriter, r *http.Request) {
 
 	emails, err := h.Store.GetEmails(r.Context(), address)
 	if err != nil {
-		log.Printf("Error getting emails: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to get emails", "address", address, "error", err)
 		writeError(w, ErrCodeInternalError, "Failed to retrieve emails", http.StatusInternalServerError)
 		return
 	}
 
 	emailResponses := make([]EmailResponse, 0, len(emails))
 	for _, email := range emails {
-		emailResponses = append(emailResponses, EmailResponse{
-			ID:         email.ID,
-			From:       email.From,
-			To:         email.To,
-			Subject:    email.Subject,
-			Body:       email.Body,
-			ReceivedAt: email.ReceivedAt.Format("2006-01-02T15:04:05Z"),
-		})
+		tracing.AddLink(r.Context(), email.TraceParent)
+		emailResponses = append(emailResponses, h.emailResponse(address, email))
+	}
+
+	domain, err := h.Store.AddressDomain(r.Context(), address)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get address domain", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to retrieve emails", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := h.Domains.Lookup(domain); !ok {
+		domain = h.Domains.Default().Name
 	}
 
 	resp := InboxResponse{
 		Address: address,
-		Email:   address + "@" + h.Domain,
+		Email:   address + "@" + domain,
 		Count:   len(emailResponses),
 		Emails:  emailResponses,
 	}
@@ -138,7 +213,7 @@ func (h *APIHandler) handleGetEmail(w http.ResponseWriter, r *http.Request) {
 
 	email, err := h.Store.GetEmail(r.Context(), address, emailID)
 	if err != nil {
-		log.Printf("Error getting email: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to get email", "address", address, "email_id", emailID, "error", err)
 		writeError(w, ErrCodeInternalError, "Failed to retrieve email", http.StatusInternalServerError)
 		return
 	}
@@ -148,19 +223,294 @@ func (h *APIHandler) handleGetEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := EmailResponse{
-		ID:         email.ID,
-		From:       email.From,
-		To:         email.To,
-		Subject:    email.Subject,
-		Body:       email.Body,
-		ReceivedAt: email.ReceivedAt.Format("2006-01-02T15:04:05Z"),
-	}
+	tracing.AddLink(r.Context(), email.TraceParent)
+	resp := h.emailResponse(address, *email)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// @ID renderEmail
+// @Summary Render email
+// @Description Return the email as a standalone HTML document for display in a sandboxed frame. HTML bodies are sanitized, with tracking pixels removed and remote images loaded through the image proxy when it is enabled, and plain text is escaped. The document's Content-Security-Policy blocks scripts and other remote resources; pass remote_images=true to load images directly over HTTPS.
+// @Tags inbox
+// @Produce html
+// @Param address path string true "Address"
+// @Param emailId path string true "Email ID"
+// @Param remote_images query bool false "Allow images from HTTPS URLs"
+// @Success 200 {string} string "HTML document"
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/inbox/{address}/{emailId}/render [get]
+func (h *APIHandler) handleRenderEmail(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	emailID := r.PathValue("emailId")
+
+	email, err := h.Store.GetEmail(r.Context(), address, emailID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get email", "address", address, "email_id", emailID, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to retrieve email", http.StatusInternalServerError)
+		return
+	}
+	if email == nil {
+		writeError(w, ErrCodeNotFound, "Email not found", http.StatusNotFound)
+		return
+	}
+	tracing.AddLink(r.Context(), email.TraceParent)
+
+	content := "<pre>" + html.EscapeString(email.Body) + "</pre>"
+	if isHTML(*email) {
+		content, _ = sanitize.Sanitize(email.Body, h.sanitizePolicy(address, *email))
+	}
+
+	setRenderHeaders(w, r.URL.Query().Get("remote_images") == "true")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, renderDocument, html.EscapeString(email.Subject), content)
+}
+
+// renderDocument wraps a rendered email; its arguments are the escaped
+// subject and the sanitized body.
+const renderDocument = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="referrer" content="no-referrer">
+<title>%s</title>
+<style>body{margin:16px;font-family:sans-serif;overflow-wrap:break-word}pre{white-space:pre-wrap}img{max-width:100%%;height:auto}</style>
+</head>
+<body>%s</body>
+</html>
+`
+
+// @ID getEmailStructure
+// @Summary Get email structure
+// @Description Return the MIME tree of an email as it was received: each part's path, content type, size, disposition and file name. Messages attached as message/rfc822 parts are described as the part's only child. Emails received before structures were recorded return 404.
+// @Tags inbox
+// @Produce json
+// @Param address path string true "Address"
+// @Param emailId path string true "Email ID"
+// @Success 200 {object} MIMEPartResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/inbox/{address}/{emailId}/structure [get]
+func (h *APIHandler) handleGetStructure(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	emailID := r.PathValue("emailId")
+
+	email, err := h.Store.GetEmail(r.Context(), address, emailID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get email", "address", address, "email_id", emailID, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to retrieve email", http.StatusInternalServerError)
+		return
+	}
+	if email == nil {
+		writeError(w, ErrCodeNotFound, "Email not found", http.StatusNotFound)
+		return
+	}
+	if email.Structure == nil {
+		writeError(w, ErrCodeNotFound, "Structure was not recorded for this email", http.StatusNotFound)
+		return
+	}
+	tracing.AddLink(r.Context(), email.TraceParent)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mimePartResponse(*email.Structure))
+}
+
+// @ID getEmailSource
+// @Summary Get email source
+// @Description Return an email as it was received, its RFC 5322 headers and body undecoded. Sources are kept only while the server's SMTP_KEEP_SOURCE is enabled; other emails return 404.
+// @Tags inbox
+// @Produce message/rfc822
+// @Param address path string true "Address"
+// @Param emailId path string true "Email ID"
+// @Success 200 {file} binary
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/inbox/{address}/{emailId}/source [get]
+func (h *APIHandler) handleGetSource(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	emailID := r.PathValue("emailId")
+
+	source, err := h.Store.GetSource(r.Context(), address, emailID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get email source", "address", address, "email_id", emailID, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to retrieve email source", http.StatusInternalServerError)
+		return
+	}
+	if source == nil {
+		writeError(w, ErrCodeNotFound, "Email source not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Length", strconv.Itoa(len(source)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(source)
+}
+
+func mimePartResponse(part store.MIMEPart) MIMEPartResponse {
+	resp := MIMEPartResponse{
+		Path:        part.Path,
+		ContentType: part.ContentType,
+		Charset:     part.Charset,
+		Encoding:    part.Encoding,
+		Disposition: part.Disposition,
+		Filename:    part.Filename,
+		ContentID:   part.ContentID,
+		SHA256:      part.SHA256,
+		Size:        part.Size,
+	}
+	for _, child := range part.Parts {
+		resp.Parts = append(resp.Parts, mimePartResponse(child))
+	}
+	return resp
+}
+
+// @ID proxyImage
+// @Summary Proxy remote image
+// @Description Fetch a remote image from a sanitized email so the viewer's address is not revealed to its server. Only URLs signed by the server, as found in sanitized bodies, are fetched. PNG, GIF, JPEG and WebP images up to the configured size are served.
+// @Tags inbox
+// @Produce png,gif,jpeg
+// @Param url query string true "Image URL"
+// @Param sig query string true "Signature of the URL"
+// @Success 200 {file} binary
+// @Failure 403 {object} ErrorResponse
+// @Failure 502 {object} ErrorResponse
+// @Router /api/images [get]
+func (h *APIHandler) handleProxyImage(w http.ResponseWriter, r *http.Request) {
+	src := r.URL.Query().Get("url")
+	if !h.Images.Verify(src, r.URL.Query().Get("sig")) {
+		writeError(w, ErrCodeInvalidSignature, "Image URL signature is invalid", http.StatusForbidden)
+		return
+	}
+
+	img, err := h.Images.Fetch(r.Context(), src)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed to proxy image", "url", src, "error", err)
+		writeError(w, ErrCodeImageUnavailable, "Image could not be loaded", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(img.Data)))
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(img.Data)
+}
+
+// @ID getInlinePart
+// @Summary Get inline image
+// @Description Return an image embedded in an email, as referenced by cid: URLs in its HTML body. Images load from img tags, which cannot sign requests, so this route takes the signed, expiring URL given in the email's inline list and sanitized body instead.
+// @Tags inbox
+// @Produce png,gif,jpeg
+// @Param address path string true "Address"
+// @Param emailId path string true "Email ID"
+// @Param contentId path string true "Content-ID of the part"
+// @Param expires query int true "Unix time the URL expires"
+// @Param sig query string true "Signature of the URL"
+// @Success 200 {file} binary
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/inbox/{address}/{emailId}/inline/{contentId} [get]
+func (h *APIHandler) handleInlinePart(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	emailID := r.PathValue("emailId")
+	contentID := r.PathValue("contentId")
+
+	expires := r.URL.Query().Get("expires")
+	sig := h.inlineSignature(address, emailID, contentID, expires)
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() >= expiresAt || !hmac.Equal([]byte(r.URL.Query().Get("sig")), []byte(sig)) {
+		writeError(w, ErrCodeInvalidSignature, "Inline image URL is invalid or expired", http.StatusForbidden)
+		return
+	}
+
+	part, err := h.Store.GetInlinePart(r.Context(), address, emailID, contentID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get inline part", "address", address, "email_id", emailID, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to retrieve inline image", http.StatusInternalServerError)
+		return
+	}
+	if part == nil {
+		writeError(w, ErrCodeNotFound, "Inline image not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", part.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(part.Data)))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	// Images are served as the sender labelled them; the sandbox keeps a
+	// scripted SVG opened directly from running
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(part.Data)
+}
+
+// @ID getLatestCode
+// @Summary Get latest one-time code
+// @Description Return the most likely one-time code of the newest email that has one, for test scripts waiting on a verification email. Pass since to ignore earlier emails.
+// @Tags inbox
+// @Produce json
+// @Param address path string true "Address"
+// @Param since query string false "Only consider emails received at or after this RFC 3339 time"
+// @Success 200 {object} LatestCodeResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/inbox/{address}/latest-code [get]
+func (h *APIHandler) handleLatestCode(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, ErrCodeInvalidRequest, "since must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	emails, err := h.Store.GetEmails(r.Context(), address)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get emails", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to retrieve emails", http.StatusInternalServerError)
+		return
+	}
+
+	// Emails are listed newest first
+	for _, email := range emails {
+		if email.ReceivedAt.Before(since) {
+			break
+		}
+		if email.Extracted == nil || len(email.Extracted.Codes) == 0 {
+			continue
+		}
+		tracing.AddLink(r.Context(), email.TraceParent)
+		code := email.Extracted.Codes[0]
+		resp := LatestCodeResponse{
+			Code:       code.Value,
+			Confidence: code.Confidence,
+			EmailID:    email.ID,
+			Subject:    email.Subject,
+			ReceivedAt: email.ReceivedAt.Format("2006-01-02T15:04:05Z"),
+			Links:      linkResponses(email.Extracted.Links),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	writeError(w, ErrCodeNotFound, "No email with a code found", http.StatusNotFound)
+}
+
 // @ID deleteEmail
 // @Summary Delete single email
 // @Description Delete a specific email by ID for an address
@@ -184,7 +534,7 @@ func (h *APIHandler) handleDeleteEmail(w http.ResponseWriter, r *http.Request) {
 
 	err := h.Store.DeleteEmail(r.Context(), address, emailID)
 	if err != nil {
-		log.Printf("Error deleting email: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to delete email", "address", address, "email_id", emailID, "error", err)
 		writeError(w, ErrCodeInternalError, "Failed to delete email", http.StatusInternalServerError)
 		return
 	}
@@ -219,7 +569,7 @@ func (h *APIHandler) handleClearInbox(w http.ResponseWriter, r *http.Request) {
 
 	count, err := h.Store.ClearInbox(r.Context(), address)
 	if err != nil {
-		log.Printf("Error clearing inbox: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to clear inbox", "address", address, "error", err)
 		writeError(w, ErrCodeInternalError, "Failed to clear inbox", http.StatusInternalServerError)
 		return
 	}
@@ -233,35 +583,900 @@ func (h *APIHandler) handleClearInbox(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-// @ID healthCheck
-// @Summary Health check
-// @Description Check API and services health status
-// @Tags health
+// @ID listDomains
+// @Summary List receiving domains
+// @Description List the domains this server accepts mail for, with their retention and size limits
+// @Tags inbox
 // @Produce json
-// @Success 200 {object} HealthResponse
-// @Failure 503 {object} ErrorResponse "Service unavailable - Redis disconnected"
-// @Router /api/health [get]
-func (h *APIHandler) handleHealth(w http.ResponseWriter, r *http.Request) {
-	redisStatus := "connected"
-	if err := h.Store.Ping(r.Context()); err != nil {
-		redisStatus = "disconnected"
+// @Success 200 {object} DomainsResponse
+// @Router /api/domains [get]
+func (h *APIHandler) handleListDomains(w http.ResponseWriter, r *http.Request) {
+	defaultName := h.Domains.Default().Name
+
+	policies := h.Domains.Policies()
+	resp := DomainsResponse{Domains: make([]DomainResponse, 0, len(policies))}
+	for _, p := range policies {
+		resp.Domains = append(resp.Domains, DomainResponse{
+			Name:             p.Name,
+			Default:          p.Name == defaultName,
+			RetentionSeconds: int(p.Retention.Seconds()),
+			MaxMessageBytes:  p.MaxMessageBytes,
+		})
 	}
 
-	resp := HealthResponse{
-		Status: redisStatus,
-		Services: map[string]string{
-			"redis": redisStatus,
-			"smtp":  "running",
-		},
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// @ID createWebhook
+// @Summary Register a webhook
+// @Description Register a URL to be notified with a signed POST whenever the address receives an email.
+// @Description The secret for verifying signatures is only returned here. The webhooks of an address are kept for the domain's retention after the latest registration.
+// @Tags webhooks
+// @Param address path string true "Address to watch"
+// @Param request body WebhookRequest true "Webhook URL and options"
+// @Accept json
+// @Produce json
+// @Success 201 {object} WebhookCreatedResponse
+// @Failure 400 {object} ErrorResponse "Invalid address, body or URL"
+// @Failure 409 {object} ErrorResponse "The address already has the maximum number of webhooks"
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/webhooks/{address} [post]
+func (h *APIHandler) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !validator.IsValidHexAddress(address) {
+		writeError(w, ErrCodeInvalidAddress, "Invalid address format", http.StatusBadRequest)
+		return
+	}
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ErrCodeInvalidRequest, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	policy, err := h.addressPolicy(r.Context(), address)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get address domain", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to register webhook", http.StatusInternalServerError)
+		return
+	}
+
+	hook, err := h.Webhooks.Register(r.Context(), address, req.URL, req.IncludeBody, policy.Retention)
+	switch {
+	case errors.Is(err, webhook.ErrInvalidURL), errors.Is(err, webhook.ErrPrivateURL):
+		writeError(w, ErrCodeInvalidWebhookURL, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, webhook.ErrTooMany):
+		writeError(w, ErrCodeWebhookLimit, "Too many webhooks for this address", http.StatusConflict)
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to register webhook", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to register webhook", http.StatusInternalServerError)
+		return
+	}
+
+	resp := WebhookCreatedResponse{
+		ID:          hook.ID,
+		URL:         hook.URL,
+		IncludeBody: hook.IncludeBody,
+		CreatedAt:   hook.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		Secret:      hook.Secret,
+		ExpiresIn:   int(policy.Retention.Seconds()),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
 
-	if redisStatus != "connected" {
-		w.WriteHeader(http.StatusServiceUnavailable)
-	} else {
-		w.WriteHeader(http.StatusOK)
+// @ID listWebhooks
+// @Summary List webhooks
+// @Description List the webhooks registered on an address, without their secrets
+// @Tags webhooks
+// @Produce json
+// @Param address path string true "Address"
+// @Success 200 {object} WebhooksResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/webhooks/{address} [get]
+func (h *APIHandler) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+
+	hooks, err := h.Webhooks.Store.ListWebhooks(r.Context(), address)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list webhooks", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to list webhooks", http.StatusInternalServerError)
+		return
 	}
 
+	resp := WebhooksResponse{Webhooks: make([]WebhookResponse, 0, len(hooks))}
+	for _, hook := range hooks {
+		resp.Webhooks = append(resp.Webhooks, WebhookResponse{
+			ID:          hook.ID,
+			URL:         hook.URL,
+			IncludeBody: hook.IncludeBody,
+			CreatedAt:   hook.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// @ID deleteWebhook
+// @Summary Delete a webhook
+// @Description Remove a webhook. Deliveries still queued for it are dropped.
+// @Tags webhooks
+// @Produce json
+// @Param address path string true "Address"
+// @Param webhookId path string true "Webhook ID"
+// @Success 200 {object} DeleteResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/webhooks/{address}/{webhookId} [delete]
+func (h *APIHandler) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	webhookID := r.PathValue("webhookId")
+
+	deleted, err := h.Webhooks.Store.DeleteWebhook(r.Context(), address, webhookID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete webhook", "address", address, "webhook_id", webhookID, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		writeError(w, ErrCodeNotFound, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeleteResponse{Deleted: true, ID: webhookID})
+}
+
+// @ID listWebhookDeliveries
+// @Summary Webhook delivery log
+// @Description List the most recent delivery attempts for the address's webhooks, newest first
+// @Tags webhooks
+// @Produce json
+// @Param address path string true "Address"
+// @Success 200 {object} WebhookDeliveriesResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/webhooks/{address}/deliveries [get]
+func (h *APIHandler) handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+
+	deliveries, err := h.Webhooks.Store.WebhookDeliveries(r.Context(), address)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get webhook deliveries", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to retrieve webhook deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	resp := WebhookDeliveriesResponse{Deliveries: make([]WebhookDeliveryResponse, 0, len(deliveries))}
+	for _, d := range deliveries {
+		resp.Deliveries = append(resp.Deliveries, WebhookDeliveryResponse{
+			JobID:      d.JobID,
+			WebhookID:  d.WebhookID,
+			EmailID:    d.EmailID,
+			Attempt:    d.Attempt,
+			Status:     d.Status,
+			StatusCode: d.StatusCode,
+			Error:      d.Error,
+			DurationMS: d.Duration,
+			At:         d.At.Format("2006-01-02T15:04:05Z"),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// @ID listWebhookDeadLetters
+// @Summary Webhook dead letters
+// @Description List deliveries that failed every attempt, newest first
+// @Tags webhooks
+// @Produce json
+// @Param address path string true "Address"
+// @Success 200 {object} DeadLettersResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/webhooks/{address}/dead-letters [get]
+func (h *APIHandler) handleWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+
+	jobs, err := h.Webhooks.Store.DeadWebhookJobs(r.Context(), address)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get webhook dead letters", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to retrieve webhook dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	resp := DeadLettersResponse{DeadLetters: make([]DeadLetterResponse, 0, len(jobs))}
+	for _, job := range jobs {
+		resp.DeadLetters = append(resp.DeadLetters, DeadLetterResponse{
+			JobID:     job.ID,
+			WebhookID: job.WebhookID,
+			EmailID:   job.EmailID,
+			Attempts:  job.Attempt,
+			LastError: job.LastError,
+			CreatedAt: job.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// @ID createForwardRule
+// @Summary Add a forwarding rule
+// @Description Relay mail received by the address to another mailbox, optionally only mail whose sender or subject contains the given text.
+// @Description Destinations on domains served here are refused. The rules of an address are kept for the domain's retention after the latest was added.
+// @Tags forwarding
+// @Param address path string true "Address to forward from"
+// @Param request body ForwardRequest true "Destination and filters"
+// @Accept json
+// @Produce json
+// @Success 201 {object} ForwardRuleResponse
+// @Failure 400 {object} ErrorResponse "Invalid address, body, destination or filter"
+// @Failure 409 {object} ErrorResponse "The address already has the maximum number of rules"
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/forwards/{address} [post]
+func (h *APIHandler) handleCreateForward(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !validator.IsValidHexAddress(address) {
+		writeError(w, ErrCodeInvalidAddress, "Invalid address format", http.StatusBadRequest)
+		return
+	}
+
+	var req ForwardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ErrCodeInvalidRequest, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	policy, err := h.addressPolicy(r.Context(), address)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get address domain", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to add forwarding rule", http.StatusInternalServerError)
+		return
+	}
+
+	rule, err := h.Forwarder.Register(r.Context(), address, store.ForwardRule{
+		Destination:     req.Destination,
+		FromContains:    req.FromContains,
+		SubjectContains: req.SubjectContains,
+	}, policy.Retention)
+	switch {
+	case errors.Is(err, forward.ErrInvalidDestination), errors.Is(err, forward.ErrLocalDestination), errors.Is(err, forward.ErrInvalidFilter):
+		writeError(w, ErrCodeInvalidForward, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, forward.ErrTooMany):
+		writeError(w, ErrCodeForwardLimit, "Too many forwarding rules for this address", http.StatusConflict)
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to add forwarding rule", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to add forwarding rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(forwardRuleResponse(rule))
+}
+
+// @ID listForwardRules
+// @Summary List forwarding rules
+// @Description List the forwarding rules of an address
+// @Tags forwarding
+// @Produce json
+// @Param address path string true "Address"
+// @Success 200 {object} ForwardRulesResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/forwards/{address} [get]
+func (h *APIHandler) handleListForwards(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+
+	rules, err := h.Forwarder.Store.ListForwardRules(r.Context(), address)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list forwarding rules", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to list forwarding rules", http.StatusInternalServerError)
+		return
+	}
+
+	resp := ForwardRulesResponse{Rules: make([]ForwardRuleResponse, 0, len(rules))}
+	for _, rule := range rules {
+		resp.Rules = append(resp.Rules, forwardRuleResponse(rule))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// @ID deleteForwardRule
+// @Summary Delete a forwarding rule
+// @Description Remove a forwarding rule. Messages still queued for it are dropped.
+// @Tags forwarding
+// @Produce json
+// @Param address path string true "Address"
+// @Param ruleId path string true "Rule ID"
+// @Success 200 {object} DeleteResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/forwards/{address}/{ruleId} [delete]
+func (h *APIHandler) handleDeleteForward(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	ruleID := r.PathValue("ruleId")
+
+	deleted, err := h.Forwarder.Store.DeleteForwardRule(r.Context(), address, ruleID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete forwarding rule", "address", address, "rule_id", ruleID, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to delete forwarding rule", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		writeError(w, ErrCodeNotFound, "Forwarding rule not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeleteResponse{Deleted: true, ID: ruleID})
+}
+
+func forwardRuleResponse(rule store.ForwardRule) ForwardRuleResponse {
+	return ForwardRuleResponse{
+		ID:              rule.ID,
+		Destination:     rule.Destination,
+		FromContains:    rule.FromContains,
+		SubjectContains: rule.SubjectContains,
+		CreatedAt:       rule.CreatedAt.Format("2006-01-02T15:04:05Z"),
+	}
+}
+
+// @ID createFilterRule
+// @Summary Add a filtering rule
+// @Description Act on mail received by the address that matches every condition given: a sender glob, a subject regular expression, a header and a minimum size.
+// @Description The action drops the message, rejects it at SMTP time, labels it or makes it expire after expire_minutes. Rules apply in the order they were added; the first drop or reject ends evaluation. Rules are kept with the address's registration: registering again keeps them as long, and they end with it. Addresses that are not registered return 404.
+// @Tags filtering
+// @Param address path string true "Address"
+// @Param request body FilterRequest true "Conditions and action"
+// @Accept json
+// @Produce json
+// @Success 201 {object} FilterRuleResponse
+// @Failure 400 {object} ErrorResponse "Invalid address, body or rule"
+// @Failure 404 {object} ErrorResponse "The address is not registered"
+// @Failure 409 {object} ErrorResponse "The address already has the maximum number of rules"
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/filters/{address} [post]
+func (h *APIHandler) handleCreateFilter(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !validator.IsValidHexAddress(address) {
+		writeError(w, ErrCodeInvalidAddress, "Invalid address format", http.StatusBadRequest)
+		return
+	}
+
+	var req FilterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ErrCodeInvalidRequest, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := h.Filters.Register(r.Context(), address, store.FilterRule{
+		FromGlob:       req.FromGlob,
+		SubjectRegex:   req.SubjectRegex,
+		Header:         req.Header,
+		HeaderContains: req.HeaderContains,
+		MinSize:        req.MinSize,
+		Action:         req.Action,
+		Label:          req.Label,
+		ExpireMinutes:  req.ExpireMinutes,
+	})
+	switch {
+	case errors.Is(err, filter.ErrTooMany):
+		writeError(w, ErrCodeFilterLimit, "Too many filtering rules for this address", http.StatusConflict)
+		return
+	case errors.Is(err, filter.ErrNotRegistered):
+		writeError(w, ErrCodeNotFound, "Address is not registered", http.StatusNotFound)
+		return
+	case errors.Is(err, filter.ErrInvalidRule):
+		writeError(w, ErrCodeInvalidFilter, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to add filtering rule", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to add filtering rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(filterRuleResponse(rule))
+}
+
+// @ID listFilterRules
+// @Summary List filtering rules
+// @Description List the filtering rules of an address in the order they apply
+// @Tags filtering
+// @Produce json
+// @Param address path string true "Address"
+// @Success 200 {object} FilterRulesResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/filters/{address} [get]
+func (h *APIHandler) handleListFilters(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+
+	rules, err := h.Filters.Store.ListFilterRules(r.Context(), address)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list filtering rules", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to list filtering rules", http.StatusInternalServerError)
+		return
+	}
+
+	resp := FilterRulesResponse{Rules: make([]FilterRuleResponse, 0, len(rules))}
+	for _, rule := range rules {
+		resp.Rules = append(resp.Rules, filterRuleResponse(rule))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// @ID deleteFilterRule
+// @Summary Delete a filtering rule
+// @Description Remove a filtering rule. Mail already labelled or set to expire keeps that.
+// @Tags filtering
+// @Produce json
+// @Param address path string true "Address"
+// @Param filterId path string true "Rule ID"
+// @Success 200 {object} DeleteResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/filters/{address}/{filterId} [delete]
+func (h *APIHandler) handleDeleteFilter(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	ruleID := r.PathValue("filterId")
+
+	deleted, err := h.Filters.Store.DeleteFilterRule(r.Context(), address, ruleID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete filtering rule", "address", address, "rule_id", ruleID, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to delete filtering rule", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		writeError(w, ErrCodeNotFound, "Filtering rule not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeleteResponse{Deleted: true, ID: ruleID})
+}
+
+func filterRuleResponse(rule store.FilterRule) FilterRuleResponse {
+	return FilterRuleResponse{
+		ID:             rule.ID,
+		FromGlob:       rule.FromGlob,
+		SubjectRegex:   rule.SubjectRegex,
+		Header:         rule.Header,
+		HeaderContains: rule.HeaderContains,
+		MinSize:        rule.MinSize,
+		Action:         rule.Action,
+		Label:          rule.Label,
+		ExpireMinutes:  rule.ExpireMinutes,
+		CreatedAt:      rule.CreatedAt.Format("2006-01-02T15:04:05Z"),
+	}
+}
+
+// @ID sendEmail
+// @Summary Send an email
+// @Description Send a DKIM-signed email from an address through the outbound relay. Each address may only send a few messages per hour and per day; a copy is kept in its sent folder.
+// @Tags sending
+// @Accept json
+// @Produce json
+// @Param address path string true "Address"
+// @Param request body SendRequest true "Message"
+// @Success 202 {object} SentEmailResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/inbox/{address}/send [post]
+func (h *APIHandler) handleSend(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !validator.IsValidHexAddress(address) {
+		writeError(w, ErrCodeInvalidAddress, "Invalid address format", http.StatusBadRequest)
+		return
+	}
+
+	var req SendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ErrCodeInvalidRequest, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	h.send(w, r, address, send.Message{
+		To:      req.To,
+		Subject: req.Subject,
+		Text:    req.Text,
+		HTML:    req.HTML,
+	})
+}
+
+// @ID replyToEmail
+// @Summary Reply to an email
+// @Description Reply to a received email. The reply is threaded with In-Reply-To and References, and goes to the original's Reply-To or sender unless recipients are given.
+// @Tags sending
+// @Accept json
+// @Produce json
+// @Param address path string true "Address"
+// @Param emailId path string true "ID of the email replied to"
+// @Param request body ReplyRequest true "Reply"
+// @Success 202 {object} SentEmailResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/inbox/{address}/reply/{emailId} [post]
+func (h *APIHandler) handleReply(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	emailID := r.PathValue("emailId")
+	if !validator.IsValidHexAddress(address) {
+		writeError(w, ErrCodeInvalidAddress, "Invalid address format", http.StatusBadRequest)
+		return
+	}
+
+	var req ReplyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ErrCodeInvalidRequest, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	original, err := h.Store.GetEmail(r.Context(), address, emailID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get email", "address", address, "email_id", emailID, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to send email", http.StatusInternalServerError)
+		return
+	}
+	if original == nil {
+		writeError(w, ErrCodeNotFound, "Email not found", http.StatusNotFound)
+		return
+	}
+	tracing.AddLink(r.Context(), original.TraceParent)
+
+	h.send(w, r, address, send.Reply(*original, send.Message{
+		To:      req.To,
+		Subject: req.Subject,
+		Text:    req.Text,
+		HTML:    req.HTML,
+	}))
+}
+
+// send sends msg from address and writes the sent copy or the error.
+func (h *APIHandler) send(w http.ResponseWriter, r *http.Request, address string, msg send.Message) {
+	policy, err := h.addressPolicy(r.Context(), address)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get address domain", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to send email", http.StatusInternalServerError)
+		return
+	}
+
+	email, err := h.Sender.Send(r.Context(), address, policy, msg)
+	switch {
+	case errors.Is(err, send.ErrNoRecipients), errors.Is(err, send.ErrTooManyRecipients), errors.Is(err, send.ErrInvalidRecipient),
+		errors.Is(err, send.ErrInvalidSubject), errors.Is(err, send.ErrBodyTooLarge):
+		writeError(w, ErrCodeInvalidMessage, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, send.ErrDomainDisabled):
+		writeError(w, ErrCodeSendingDisabled, "Sending is not enabled for this address's domain", http.StatusForbidden)
+		return
+	case errors.Is(err, send.ErrQuotaExceeded):
+		writeError(w, ErrCodeSendQuota, "Send quota exceeded for this address", http.StatusTooManyRequests)
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to send email", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to send email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(sentEmailResponse(email))
+}
+
+// @ID getSentEmails
+// @Summary List sent emails
+// @Description List the emails an address has sent, newest first
+// @Tags sending
+// @Produce json
+// @Param address path string true "Address"
+// @Success 200 {object} SentResponse
+// @Failure 500 {object} ErrorResponse
+// @Security SignatureAuth
+// @Router /api/inbox/{address}/sent [get]
+func (h *APIHandler) handleGetSent(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+
+	emails, err := h.Sender.Store.GetSent(r.Context(), address)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get sent emails", "address", address, "error", err)
+		writeError(w, ErrCodeInternalError, "Failed to retrieve sent emails", http.StatusInternalServerError)
+		return
+	}
+
+	resp := SentResponse{Count: len(emails), Emails: make([]SentEmailResponse, 0, len(emails))}
+	for _, email := range emails {
+		resp.Emails = append(resp.Emails, sentEmailResponse(email))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func sentEmailResponse(email store.Email) SentEmailResponse {
+	return SentEmailResponse{
+		ID:         email.ID,
+		MessageID:  email.MessageID,
+		From:       email.From,
+		To:         email.To,
+		Subject:    email.Subject,
+		Body:       email.Body,
+		References: email.References,
+		SentAt:     email.ReceivedAt.Format("2006-01-02T15:04:05Z"),
+	}
+}
+
+// addressPolicy returns the policy of the domain address is registered on,
+// or the default domain's for unknown and legacy registrations.
+func (h *APIHandler) addressPolicy(ctx context.Context, address string) (domains.Policy, error) {
+	domain, err := h.Store.AddressDomain(ctx, address)
+	if err != nil {
+		return domains.Policy{}, err
+	}
+	if policy, ok := h.Domains.Lookup(domain); ok {
+		return policy, nil
+	}
+	return h.Domains.Default(), nil
+}
+
+// @ID livenessCheck
+// @Summary Liveness check
+// @Description Reports that the process is serving HTTP. It does not check dependencies.
+// @Tags health
+// @Produce json
+// @Success 200 {object} LivenessResponse
+// @Router /api/health/live [get]
+func (h *APIHandler) handleLive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LivenessResponse{
+		Status: "alive",
+		Build:  buildInfoResponse(),
+	})
+}
+
+// @ID readinessCheck
+// @Summary Readiness check
+// @Description Probes the store and SMTP listeners and reports TLS certificate validity.
+// @Description Fails with 503 while the server is shutting down so load balancers drain first.
+// @Tags health
+// @Produce json
+// @Success 200 {object} ReadinessResponse
+// @Failure 503 {object} ReadinessResponse "A probe failed or the server is draining"
+// @Router /api/health/ready [get]
+func (h *APIHandler) handleReady(w http.ResponseWriter, r *http.Request) {
+	report := h.health().Ready(r.Context())
+
+	resp := ReadinessResponse{
+		Status: "ready",
+		Checks: make(map[string]CheckResponse, len(report.Checks)),
+		Build:  buildInfoResponse(),
+	}
+	switch {
+	case report.Draining:
+		resp.Status = "draining"
+	case !report.Ready:
+		resp.Status = "not_ready"
+	}
+	for _, c := range report.Checks {
+		check := CheckResponse{Status: "ok", LatencyMS: float64(c.Latency.Microseconds()) / 1000}
+		if !c.OK() {
+			check.Status = "failing"
+			check.Error = c.Err.Error()
+		}
+		resp.Checks[c.Name] = check
+	}
+	for _, c := range report.Certificates {
+		resp.Certificates = append(resp.Certificates, CertificateResponse{
+			Subject:  c.Subject,
+			DNSNames: c.DNSNames,
+			NotAfter: c.NotAfter.UTC().Format(time.RFC3339),
+			Valid:    c.Valid,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// @ID healthCheck
+// @Summary Health check
+// @Description Legacy summary of the readiness check. Use /api/health/ready instead.
+// @Tags health
+// @Produce json
+// @Deprecated
+// @Success 200 {object} HealthResponse
+// @Failure 503 {object} HealthResponse "A probe failed or the server is draining"
+// @Router /api/health [get]
+func (h *APIHandler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	report := h.health().Ready(r.Context())
+
+	resp := HealthResponse{
+		Status:   "connected",
+		Services: make(map[string]string, len(report.Checks)),
+	}
+	for _, c := range report.Checks {
+		switch {
+		case c.Name == "store" && c.OK():
+			resp.Services["redis"] = "connected"
+		case c.Name == "store":
+			resp.Services["redis"] = "disconnected"
+			resp.Status = "disconnected"
+		case c.OK():
+			resp.Services[c.Name] = "running"
+		default:
+			resp.Services[c.Name] = "unreachable"
+		}
+	}
+	if report.Draining {
+		resp.Status = "draining"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// health returns the configured checker, or one that only pings the store.
+func (h *APIHandler) health() *health.Checker {
+	if h.Health != nil {
+		return h.Health
+	}
+	return &health.Checker{Store: h.Store}
+}
+
+func buildInfoResponse() BuildInfoResponse {
+	info := buildinfo.Get()
+	return BuildInfoResponse{Version: info.Version, Commit: info.Commit, GoVersion: info.GoVersion}
+}
+
+func (h *APIHandler) emailResponse(address string, email store.Email) EmailResponse {
+	resp := EmailResponse{
+		ID:          email.ID,
+		From:        email.From,
+		To:          email.To,
+		Cc:          email.Cc,
+		Subject:     email.Subject,
+		Body:        email.Body,
+		ContentType: email.ContentType,
+		Charset:     email.Charset,
+		ReceivedAt:  email.ReceivedAt.Format("2006-01-02T15:04:05Z"),
+		TLS:         tlsResponse(email.TLS),
+		Labels:      email.Labels,
+		Duplicates:  email.Duplicates,
+	}
+	if env := email.Envelope; env != nil {
+		resp.Envelope = &EnvelopeResponse{MailFrom: env.MailFrom, Recipient: env.Recipient, ClientIP: env.ClientIP, Helo: env.Helo}
+	}
+	if isHTML(email) {
+		var report sanitize.Report
+		resp.Body, report = sanitize.Sanitize(email.Body, h.sanitizePolicy(address, email))
+		if len(report.TrackingPixels) > 0 || len(report.TrackingLinks) > 0 {
+			resp.Trackers = &TrackersResponse{Pixels: report.TrackingPixels, Links: report.TrackingLinks}
+		}
+	}
+	if !email.ExpiresAt.IsZero() {
+		resp.ExpiresAt = email.ExpiresAt.UTC().Format("2006-01-02T15:04:05Z")
+	}
+	if ex := email.Extracted; ex != nil {
+		resp.Extracted = &ExtractedResponse{Links: linkResponses(ex.Links), Unsubscribe: ex.Unsubscribe}
+		for _, c := range ex.Codes {
+			resp.Extracted.Codes = append(resp.Extracted.Codes, CodeResponse{Value: c.Value, Confidence: c.Confidence})
+		}
+	}
+	for _, part := range email.Inline {
+		resp.Inline = append(resp.Inline, InlinePartResponse{
+			ContentID:   part.ContentID,
+			ContentType: part.ContentType,
+			Filename:    part.Filename,
+			Size:        part.Size,
+			URL:         h.inlineURL(address, email.ID, part.ContentID),
+		})
+	}
+	return resp
+}
+
+// sanitizePolicy routes remote images through the image proxy when there is
+// one, and points cid: images at the inline parts of email.
+func (h *APIHandler) sanitizePolicy(address string, email store.Email) sanitize.Policy {
+	var policy sanitize.Policy
+	if h.Images != nil {
+		policy.ImageURL = h.Images.URL
+	}
+	if len(h.URLKey) > 0 {
+		policy.InlineURL = func(contentID string) string {
+			for _, part := range email.Inline {
+				if part.ContentID == contentID {
+					return h.inlineURL(address, email.ID, contentID)
+				}
+			}
+			return ""
+		}
+	}
+	return policy
+}
+
+// inlineURLTTL is how long the URL of an inline image works.
+const inlineURLTTL = time.Hour
+
+// inlineURL returns the signed URL of an inline part, or "" without a key.
+func (h *APIHandler) inlineURL(address, emailID, contentID string) string {
+	if len(h.URLKey) == 0 {
+		return ""
+	}
+	expires := strconv.FormatInt(time.Now().Add(inlineURLTTL).Unix(), 10)
+	q := url.Values{"expires": {expires}, "sig": {h.inlineSignature(address, emailID, contentID, expires)}}
+	return "/api/inbox/" + url.PathEscape(address) + "/" + url.PathEscape(emailID) + "/inline/" + url.PathEscape(contentID) + "?" + q.Encode()
+}
+
+func (h *APIHandler) inlineSignature(address, emailID, contentID, expires string) string {
+	mac := hmac.New(sha256.New, h.URLKey)
+	mac.Write([]byte(address + "\n" + emailID + "\n" + contentID + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// isHTML reports whether email's body is HTML. Emails stored before the
+// content type was recorded count as HTML when they contain markup, so they
+// are sanitized too.
+func isHTML(email store.Email) bool {
+	if email.ContentType == "" {
+		return strings.Contains(email.Body, "<")
+	}
+	return email.ContentType == "text/html"
+}
+
+func linkResponses(links []store.Link) []LinkResponse {
+	var resp []LinkResponse
+	for _, l := range links {
+		resp = append(resp, LinkResponse{URL: l.URL, Kind: l.Kind})
+	}
+	return resp
+}
+
+func tlsResponse(info *store.TLSInfo) *TLSResponse {
+	if info == nil {
+		return nil
+	}
+	return &TLSResponse{
+		Encrypted:   info.Encrypted,
+		Version:     info.Version,
+		CipherSuite: info.CipherSuite,
+	}
+}
@@ -1,15 +1,31 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
+	"html"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fn-jakubkarp/coresend/internal/dkim"
+	"github.com/fn-jakubkarp/coresend/internal/domains"
+	"github.com/fn-jakubkarp/coresend/internal/filter"
+	"github.com/fn-jakubkarp/coresend/internal/forward"
+	"github.com/fn-jakubkarp/coresend/internal/health"
+	"github.com/fn-jakubkarp/coresend/internal/imageproxy"
+	"github.com/fn-jakubkarp/coresend/internal/send"
 	"github.com/fn-jakubkarp/coresend/internal/store"
+	"github.com/fn-jakubkarp/coresend/internal/webhook"
 )
 
 const testValidAddress = "0123456789abcdef0123456789abcdef01234567"
@@ -28,15 +44,15 @@ func TestNewAPIHandler(t *testing.T) {
 	t.Parallel()
 
 	s := &fakeEmailStore{}
-	h := NewAPIHandler(s, "coresend.io")
+	h := NewAPIHandler(s, newTestDomains(t, "coresend.io"))
 	if h == nil {
 		t.Fatalf("NewAPIHandler returned nil")
 	}
 	if h.Store != s {
 		t.Fatalf("handler store was not assigned")
 	}
-	if h.Domain != "coresend.io" {
-		t.Fatalf("handler domain = %q, want %q", h.Domain, "coresend.io")
+	if got := h.Domains.Default().Name; got != "coresend.io" {
+		t.Fatalf("handler domain = %q, want %q", got, "coresend.io")
 	}
 }
 
@@ -91,7 +107,7 @@ func TestHandleRegister(t *testing.T) {
 				},
 			}
 
-			h := NewAPIHandler(s, "coresend.io")
+			h := NewAPIHandler(s, newTestDomains(t, "coresend.io"))
 			req := httptest.NewRequest(http.MethodPost, "/api/register/"+tc.address, nil)
 			if tc.address != "" {
 				req.SetPathValue("address", tc.address)
@@ -141,6 +157,159 @@ func TestHandleRegister(t *testing.T) {
 	}
 }
 
+func TestHandleRegister_Domain(t *testing.T) {
+	t.Parallel()
+
+	registry, err := domains.NewRegistry(
+		domains.Policy{Name: "coresend.io"},
+		domains.Policy{Name: "short.example", Retention: time.Hour},
+	)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		body          string
+		wantStatus    int
+		wantErrorCode string
+		wantDomain    string
+		wantTTL       time.Duration
+	}{
+		{
+			name:       "empty body uses default domain",
+			body:       "",
+			wantStatus: http.StatusOK,
+			wantDomain: "coresend.io",
+			wantTTL:    24 * time.Hour,
+		},
+		{
+			name:       "selected domain uses its retention",
+			body:       `{"domain":"Short.Example"}`,
+			wantStatus: http.StatusOK,
+			wantDomain: "short.example",
+			wantTTL:    time.Hour,
+		},
+		{
+			name:          "unknown domain",
+			body:          `{"domain":"elsewhere.org"}`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidDomain,
+		},
+		{
+			name:          "malformed body",
+			body:          `{"domain":`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &fakeEmailStore{}
+			h := NewAPIHandler(s, registry)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/register/"+testValidAddress, strings.NewReader(tc.body))
+			req.SetPathValue("address", testValidAddress)
+			rr := httptest.NewRecorder()
+
+			h.handleRegister(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			if tc.wantErrorCode != "" {
+				if s.registerCallCount != 0 {
+					t.Fatalf("register call count = %d, want 0", s.registerCallCount)
+				}
+				gotErr := decodeErrorResponse(t, rr)
+				if gotErr.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", gotErr.Error.Code, tc.wantErrorCode)
+				}
+				return
+			}
+
+			if s.lastRegisterDomain != tc.wantDomain {
+				t.Fatalf("register domain = %q, want %q", s.lastRegisterDomain, tc.wantDomain)
+			}
+			if s.lastRegisterDuration != tc.wantTTL {
+				t.Fatalf("register ttl = %s, want %s", s.lastRegisterDuration, tc.wantTTL)
+			}
+
+			resp := decodeJSONResponse[RegisterResponse](t, rr)
+			if resp.Domain != tc.wantDomain {
+				t.Fatalf("response domain = %q, want %q", resp.Domain, tc.wantDomain)
+			}
+			if want := testValidAddress + "@" + tc.wantDomain; resp.Email != want {
+				t.Fatalf("response email = %q, want %q", resp.Email, want)
+			}
+			if resp.ExpiresIn != int(tc.wantTTL.Seconds()) {
+				t.Fatalf("expires_in = %d, want %d", resp.ExpiresIn, int(tc.wantTTL.Seconds()))
+			}
+		})
+	}
+}
+
+func TestHandleGetInbox_RegisteredDomain(t *testing.T) {
+	t.Parallel()
+
+	s := &fakeEmailStore{
+		addressDomainFn: func(ctx context.Context, addressBox string) (string, error) {
+			return "second.example", nil
+		},
+	}
+	h := NewAPIHandler(s, newTestDomains(t, "coresend.io", "second.example"))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/inbox/"+testValidAddress, nil)
+	req.SetPathValue("address", testValidAddress)
+	rr := httptest.NewRecorder()
+
+	h.handleGetInbox(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	resp := decodeJSONResponse[InboxResponse](t, rr)
+	if want := testValidAddress + "@second.example"; resp.Email != want {
+		t.Fatalf("email = %q, want %q", resp.Email, want)
+	}
+}
+
+func TestHandleListDomains(t *testing.T) {
+	t.Parallel()
+
+	registry, err := domains.NewRegistry(
+		domains.Policy{Name: "coresend.io"},
+		domains.Policy{Name: "short.example", Retention: time.Hour, MaxMessageBytes: 4096},
+	)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	h := NewAPIHandler(&fakeEmailStore{}, registry)
+
+	rr := httptest.NewRecorder()
+	h.handleListDomains(rr, httptest.NewRequest(http.MethodGet, "/api/domains", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	resp := decodeJSONResponse[DomainsResponse](t, rr)
+	want := []DomainResponse{
+		{Name: "coresend.io", Default: true, RetentionSeconds: 86400, MaxMessageBytes: domains.DefaultMaxMessageBytes},
+		{Name: "short.example", RetentionSeconds: 3600, MaxMessageBytes: 4096},
+	}
+	if len(resp.Domains) != len(want) {
+		t.Fatalf("domains length = %d, want %d", len(resp.Domains), len(want))
+	}
+	for i := range want {
+		if resp.Domains[i] != want[i] {
+			t.Fatalf("domains[%d] = %+v, want %+v", i, resp.Domains[i], want[i])
+		}
+	}
+}
+
 func TestHandleGetInbox(t *testing.T) {
 	t.Parallel()
 
@@ -213,7 +382,7 @@ func TestHandleGetInbox(t *testing.T) {
 					return tc.storeEmails, tc.storeErr
 				},
 			}
-			h := NewAPIHandler(s, "coresend.io")
+			h := NewAPIHandler(s, newTestDomains(t, "coresend.io"))
 
 			req := httptest.NewRequest(http.MethodGet, "/api/inbox/"+tc.address, nil)
 			if tc.address != "" {
@@ -328,6 +497,61 @@ func TestHandleGetEmail(t *testing.T) {
 			wantStatus:      http.StatusOK,
 			wantGetEmailRun: 1,
 		},
+		{
+			name:    "success with TLS metadata",
+			address: testValidAddress,
+			emailID: "email-2",
+			storeEmail: &store.Email{
+				ID:         "email-2",
+				ReceivedAt: mailTime,
+				TLS:        &store.TLSInfo{Encrypted: true, Version: "TLS 1.3", CipherSuite: "TLS_AES_128_GCM_SHA256"},
+			},
+			wantStatus:      http.StatusOK,
+			wantGetEmailRun: 1,
+		},
+		{
+			name:    "success with labels and expiry",
+			address: testValidAddress,
+			emailID: "email-3",
+			storeEmail: &store.Email{
+				ID:         "email-3",
+				ReceivedAt: mailTime,
+				Labels:     []string{"marketing"},
+				ExpiresAt:  mailTime.Add(30 * time.Minute),
+			},
+			wantStatus:      http.StatusOK,
+			wantGetEmailRun: 1,
+		},
+		{
+			name:    "success with extracted codes and links",
+			address: testValidAddress,
+			emailID: "email-4",
+			storeEmail: &store.Email{
+				ID:         "email-4",
+				ReceivedAt: mailTime,
+				Extracted: &store.Extracted{
+					Codes:       []store.Code{{Value: "482913", Confidence: 0.9}},
+					Links:       []store.Link{{URL: "https://example.com/verify", Kind: "verify"}},
+					Unsubscribe: []string{"mailto:leave@example.com"},
+				},
+			},
+			wantStatus:      http.StatusOK,
+			wantGetEmailRun: 1,
+		},
+		{
+			name:    "success with header recipients and envelope",
+			address: testValidAddress,
+			emailID: "email-5",
+			storeEmail: &store.Email{
+				ID:         "email-5",
+				ReceivedAt: mailTime,
+				To:         []string{"Team <team@example.com>"},
+				Cc:         []string{"ops@example.com"},
+				Envelope:   &store.Envelope{MailFrom: "bounces@example.com", Recipient: testValidAddress + "@coresend.io", ClientIP: "192.0.2.10", Helo: "mail.example.com"},
+			},
+			wantStatus:      http.StatusOK,
+			wantGetEmailRun: 1,
+		},
 	}
 
 	for _, tc := range tests {
@@ -339,7 +563,7 @@ func TestHandleGetEmail(t *testing.T) {
 					return tc.storeEmail, tc.storeErr
 				},
 			}
-			h := NewAPIHandler(s, "coresend.io")
+			h := NewAPIHandler(s, newTestDomains(t, "coresend.io"))
 
 			req := httptest.NewRequest(http.MethodGet, "/api/inbox/"+tc.address+"/"+tc.emailID, nil)
 			if tc.address != "" {
@@ -383,45 +607,73 @@ func TestHandleGetEmail(t *testing.T) {
 			if resp.ReceivedAt != wantTs {
 				t.Fatalf("received_at = %q, want %q", resp.ReceivedAt, wantTs)
 			}
+			if want := tc.storeEmail.TLS; want == nil {
+				if resp.TLS != nil {
+					t.Fatalf("tls = %+v, want nil", resp.TLS)
+				}
+			} else if resp.TLS == nil || resp.TLS.Version != want.Version || resp.TLS.CipherSuite != want.CipherSuite || !resp.TLS.Encrypted {
+				t.Fatalf("tls = %+v, want %+v", resp.TLS, want)
+			}
+			if strings.Join(resp.Labels, ",") != strings.Join(tc.storeEmail.Labels, ",") {
+				t.Fatalf("labels = %v, want %v", resp.Labels, tc.storeEmail.Labels)
+			}
+			wantExpiry := ""
+			if !tc.storeEmail.ExpiresAt.IsZero() {
+				wantExpiry = tc.storeEmail.ExpiresAt.Format("2006-01-02T15:04:05Z")
+			}
+			if resp.ExpiresAt != wantExpiry {
+				t.Fatalf("expires_at = %q, want %q", resp.ExpiresAt, wantExpiry)
+			}
+			if want := tc.storeEmail.Extracted; want == nil {
+				if resp.Extracted != nil {
+					t.Fatalf("extracted = %+v, want nil", resp.Extracted)
+				}
+			} else if resp.Extracted == nil || len(resp.Extracted.Codes) != 1 || resp.Extracted.Codes[0].Value != want.Codes[0].Value ||
+				len(resp.Extracted.Links) != 1 || resp.Extracted.Links[0].Kind != want.Links[0].Kind ||
+				strings.Join(resp.Extracted.Unsubscribe, ",") != strings.Join(want.Unsubscribe, ",") {
+				t.Fatalf("extracted = %+v, want %+v", resp.Extracted, want)
+			}
+			if strings.Join(resp.To, ",") != strings.Join(tc.storeEmail.To, ",") || strings.Join(resp.Cc, ",") != strings.Join(tc.storeEmail.Cc, ",") {
+				t.Fatalf("to, cc = %v, %v, want %v, %v", resp.To, resp.Cc, tc.storeEmail.To, tc.storeEmail.Cc)
+			}
+			if want := tc.storeEmail.Envelope; want == nil {
+				if resp.Envelope != nil {
+					t.Fatalf("envelope = %+v, want nil", resp.Envelope)
+				}
+			} else if resp.Envelope == nil || *resp.Envelope != (EnvelopeResponse{MailFrom: want.MailFrom, Recipient: want.Recipient, ClientIP: want.ClientIP, Helo: want.Helo}) {
+				t.Fatalf("envelope = %+v, want %+v", resp.Envelope, want)
+			}
 		})
 	}
 }
 
-func TestHandleDeleteEmail(t *testing.T) {
+func TestEmailResponse_SanitizesHTML(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
-		name             string
-		address          string
-		emailID          string
-		deleteErr        error
-		wantStatus       int
-		wantErrorCode    string
-		wantDeleteCalled int
+		name     string
+		email    store.Email
+		wantBody string
 	}{
 		{
-			name:             "missing params",
-			address:          "",
-			emailID:          "",
-			wantStatus:       http.StatusBadRequest,
-			wantErrorCode:    ErrCodeInvalidAddress,
-			wantDeleteCalled: 0,
+			name:     "HTML",
+			email:    store.Email{Body: `<p onclick="x()">Hi</p><script>alert(1)</script>`, ContentType: "text/html"},
+			wantBody: `<p>Hi</p>`,
 		},
 		{
-			name:             "store error",
-			address:          testValidAddress,
-			emailID:          "email-1",
-			deleteErr:        errors.New("redis down"),
-			wantStatus:       http.StatusInternalServerError,
-			wantErrorCode:    ErrCodeInternalError,
-			wantDeleteCalled: 1,
+			name:     "plain text is left alone",
+			email:    store.Email{Body: "if a <b then", ContentType: "text/plain"},
+			wantBody: "if a <b then",
 		},
 		{
-			name:             "success",
-			address:          testValidAddress,
-			emailID:          "email-1",
-			wantStatus:       http.StatusOK,
-			wantDeleteCalled: 1,
+			name:     "legacy email with markup",
+			email:    store.Email{Body: `<a href="javascript:alert(1)">x</a>`},
+			wantBody: `<a target="_blank" rel="noopener noreferrer nofollow">x</a>`,
+		},
+		{
+			name:     "legacy plain text",
+			email:    store.Email{Body: "Hello & welcome"},
+			wantBody: "Hello & welcome",
 		},
 	}
 
@@ -429,162 +681,225 @@ func TestHandleDeleteEmail(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			s := &fakeEmailStore{
-				deleteEmailFn: func(ctx context.Context, addressBox string, emailID string) error {
-					return tc.deleteErr
-				},
-			}
-			h := NewAPIHandler(s, "coresend.io")
-
-			req := httptest.NewRequest(http.MethodDelete, "/api/inbox/"+tc.address+"/"+tc.emailID, nil)
-			if tc.address != "" {
-				req.SetPathValue("address", tc.address)
-			}
-			if tc.emailID != "" {
-				req.SetPathValue("emailId", tc.emailID)
+			if got := (&APIHandler{}).emailResponse("inbox", tc.email); got.Body != tc.wantBody || got.ContentType != tc.email.ContentType {
+				t.Fatalf("emailResponse() body = %q, content type = %q, want %q", got.Body, got.ContentType, tc.wantBody)
 			}
-			rr := httptest.NewRecorder()
+		})
+	}
+}
 
-			h.handleDeleteEmail(rr, req)
+func TestEmailResponse_ProxiesImagesAndReportsTrackers(t *testing.T) {
+	t.Parallel()
 
-			if rr.Code != tc.wantStatus {
-				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
-			}
-			if s.deleteEmailCallCount != tc.wantDeleteCalled {
-				t.Fatalf("deleteEmail call count = %d, want %d", s.deleteEmailCallCount, tc.wantDeleteCalled)
-			}
-			if tc.wantDeleteCalled > 0 {
-				if s.lastDeleteAddressBox != tc.address {
-					t.Fatalf("deleteEmail address = %q, want %q", s.lastDeleteAddressBox, tc.address)
-				}
-				if s.lastDeleteEmailID != tc.emailID {
-					t.Fatalf("deleteEmail id = %q, want %q", s.lastDeleteEmailID, tc.emailID)
-				}
-			}
+	images := &imageproxy.Proxy{Key: []byte("0123456789abcdef")}
+	h := &APIHandler{Images: images}
+	email := store.Email{
+		ContentType: "text/html",
+		Body: `<img src="https://cdn.example.com/logo.png">` +
+			`<img src="https://news.example.com/o.gif" width="1" height="1">` +
+			`<a href="https://www.google.com/url?q=https://example.com/">Open</a>`,
+	}
 
-			if tc.wantErrorCode != "" {
-				gotErr := decodeErrorResponse(t, rr)
-				if gotErr.Error.Code != tc.wantErrorCode {
-					t.Fatalf("error.code = %q, want %q", gotErr.Error.Code, tc.wantErrorCode)
-				}
-				return
-			}
+	resp := h.emailResponse("inbox", email)
+	wantSrc := html.EscapeString(images.URL("https://cdn.example.com/logo.png"))
+	if !strings.Contains(resp.Body, `<img src="`+wantSrc+`"/>`) || strings.Contains(resp.Body, "o.gif") {
+		t.Fatalf("body = %q, want the logo proxied and the pixel removed", resp.Body)
+	}
+	if resp.Trackers == nil ||
+		strings.Join(resp.Trackers.Pixels, " ") != "https://news.example.com/o.gif" ||
+		strings.Join(resp.Trackers.Links, " ") != "https://www.google.com/url?q=https://example.com/" {
+		t.Fatalf("trackers = %+v", resp.Trackers)
+	}
 
-			resp := decodeJSONResponse[DeleteResponse](t, rr)
-			if !resp.Deleted {
-				t.Fatalf("deleted = %v, want true", resp.Deleted)
-			}
-			if resp.ID != tc.emailID {
-				t.Fatalf("id = %q, want %q", resp.ID, tc.emailID)
-			}
-		})
+	if resp := h.emailResponse("inbox", store.Email{ContentType: "text/html", Body: "<p>Hi</p>"}); resp.Trackers != nil {
+		t.Fatalf("trackers = %+v, want nil without trackers", resp.Trackers)
 	}
 }
 
-func TestHandleClearInbox(t *testing.T) {
+func TestHandleProxyImage(t *testing.T) {
 	t.Parallel()
 
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 24)...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/logo.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(png)
+	}))
+	t.Cleanup(server.Close)
+
+	images := &imageproxy.Proxy{Key: []byte("0123456789abcdef"), AllowPrivate: true}
+
 	tests := []struct {
-		name            string
-		address         string
-		clearCount      int64
-		clearErr        error
-		wantStatus      int
-		wantErrorCode   string
-		wantClearCalled int
+		name          string
+		target        string
+		wantStatus    int
+		wantErrorCode string
 	}{
-		{
-			name:            "missing address",
-			address:         "",
-			wantStatus:      http.StatusBadRequest,
-			wantErrorCode:   ErrCodeInvalidAddress,
-			wantClearCalled: 0,
-		},
-		{
-			name:            "store error",
-			address:         testValidAddress,
-			clearErr:        errors.New("redis down"),
-			wantStatus:      http.StatusInternalServerError,
-			wantErrorCode:   ErrCodeInternalError,
-			wantClearCalled: 1,
-		},
-		{
-			name:            "success",
-			address:         testValidAddress,
-			clearCount:      2,
-			wantStatus:      http.StatusOK,
-			wantClearCalled: 1,
-		},
+		{name: "signed", target: images.URL(server.URL + "/logo.png"), wantStatus: http.StatusOK},
+		{name: "unsigned", target: imageproxy.Path + "?url=" + url.QueryEscape(server.URL+"/logo.png"), wantStatus: http.StatusForbidden, wantErrorCode: ErrCodeInvalidSignature},
+		{name: "signed for another URL", target: strings.Replace(images.URL(server.URL+"/logo.png"), "logo", "other", 1), wantStatus: http.StatusForbidden, wantErrorCode: ErrCodeInvalidSignature},
+		{name: "upstream error", target: images.URL(server.URL + "/missing.png"), wantStatus: http.StatusBadGateway, wantErrorCode: ErrCodeImageUnavailable},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			s := &fakeEmailStore{
-				clearInboxFn: func(ctx context.Context, addressBox string) (int64, error) {
-					return tc.clearCount, tc.clearErr
-				},
-			}
-			h := NewAPIHandler(s, "coresend.io")
-
-			req := httptest.NewRequest(http.MethodDelete, "/api/inbox/"+tc.address, nil)
-			if tc.address != "" {
-				req.SetPathValue("address", tc.address)
-			}
+			h := &APIHandler{Images: images}
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
 			rr := httptest.NewRecorder()
 
-			h.handleClearInbox(rr, req)
+			h.handleProxyImage(rr, req)
 
 			if rr.Code != tc.wantStatus {
 				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
 			}
-			if s.clearInboxCallCount != tc.wantClearCalled {
-				t.Fatalf("clearInbox call count = %d, want %d", s.clearInboxCallCount, tc.wantClearCalled)
-			}
-			if tc.wantClearCalled > 0 && s.lastClearInboxAddress != tc.address {
-				t.Fatalf("clearInbox address = %q, want %q", s.lastClearInboxAddress, tc.address)
-			}
-
 			if tc.wantErrorCode != "" {
-				gotErr := decodeErrorResponse(t, rr)
-				if gotErr.Error.Code != tc.wantErrorCode {
-					t.Fatalf("error.code = %q, want %q", gotErr.Error.Code, tc.wantErrorCode)
+				if got := decodeErrorResponse(t, rr); got.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", got.Error.Code, tc.wantErrorCode)
 				}
 				return
 			}
-
-			resp := decodeJSONResponse[DeleteResponse](t, rr)
-			if !resp.Deleted {
-				t.Fatalf("deleted = %v, want true", resp.Deleted)
+			if rr.Header().Get("Content-Type") != "image/png" || !bytes.Equal(rr.Body.Bytes(), png) {
+				t.Fatalf("response = %q, %d bytes", rr.Header().Get("Content-Type"), rr.Body.Len())
 			}
-			if resp.Count != tc.clearCount {
-				t.Fatalf("count = %d, want %d", resp.Count, tc.clearCount)
+			if rr.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Fatalf("headers = %v", rr.Header())
 			}
 		})
 	}
 }
 
-func TestHandleHealth(t *testing.T) {
+func TestEmailResponse_InlineImages(t *testing.T) {
 	t.Parallel()
 
-	tests := []struct {
-		name       string
-		pingErr    error
-		wantStatus int
-		wantState  string
+	email := store.Email{
+		ID:          "email-1",
+		ContentType: "text/html",
+		Body:        `<img src="cid:logo@example.com"><img src="cid:missing">`,
+		Inline:      []store.InlinePart{{ContentID: "logo@example.com", ContentType: "image/png", Filename: "logo.png", Size: 3}},
+	}
+
+	resp := (&APIHandler{URLKey: []byte("0123456789abcdef")}).emailResponse(testValidAddress, email)
+	if len(resp.Inline) != 1 || resp.Inline[0].ContentID != "logo@example.com" || resp.Inline[0].Size != 3 {
+		t.Fatalf("inline = %+v", resp.Inline)
+	}
+	u, err := url.Parse(resp.Inline[0].URL)
+	if err != nil || u.Path != "/api/inbox/"+testValidAddress+"/email-1/inline/logo@example.com" || u.Query().Get("sig") == "" {
+		t.Fatalf("inline URL = %q", resp.Inline[0].URL)
+	}
+	if !strings.HasPrefix(resp.Body, `<img src="`+html.EscapeString(u.Path)) || strings.Count(resp.Body, "src=") != 1 {
+		t.Fatalf("body = %q, want the cid: image resolved and the missing one dropped", resp.Body)
+	}
+
+	// Without a key cid: sources are left alone
+	resp = (&APIHandler{}).emailResponse(testValidAddress, email)
+	if resp.Inline[0].URL != "" || !strings.Contains(resp.Body, `src="cid:logo@example.com"`) {
+		t.Fatalf("without key: inline = %+v, body = %q", resp.Inline, resp.Body)
+	}
+}
+
+func TestHandleInlinePart(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("0123456789abcdef")
+	signer := &APIHandler{URLKey: key}
+	signed := signer.inlineURL(testValidAddress, "email-1", "logo/1@example.com")
+	expired := "/api/inbox/" + testValidAddress + "/email-1/inline/logo%2F1@example.com?expires=1&sig=" +
+		signer.inlineSignature(testValidAddress, "email-1", "logo/1@example.com", "1")
+
+	tests := []struct {
+		name          string
+		target        string
+		part          *store.InlinePart
+		wantStatus    int
+		wantErrorCode string
+	}{
+		{name: "signed", target: signed, part: &store.InlinePart{ContentType: "image/png", Data: []byte("png")}, wantStatus: http.StatusOK},
+		{name: "unsigned", target: strings.Split(signed, "?")[0], wantStatus: http.StatusForbidden, wantErrorCode: ErrCodeInvalidSignature},
+		{name: "signed for another part", target: strings.Replace(signed, "logo", "other", 1), wantStatus: http.StatusForbidden, wantErrorCode: ErrCodeInvalidSignature},
+		{name: "expired", target: expired, wantStatus: http.StatusForbidden, wantErrorCode: ErrCodeInvalidSignature},
+		{name: "missing part", target: signed, wantStatus: http.StatusNotFound, wantErrorCode: ErrCodeNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var gotEmailID, gotContentID string
+			fakeStore := &fakeEmailStore{
+				getInlinePartFn: func(ctx context.Context, addressBox, emailID, contentID string) (*store.InlinePart, error) {
+					gotEmailID, gotContentID = emailID, contentID
+					return tc.part, nil
+				},
+			}
+			router := NewRouter(fakeStore, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t), URLKey: key})
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			if tc.wantErrorCode != "" {
+				if got := decodeErrorResponse(t, rr); got.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", got.Error.Code, tc.wantErrorCode)
+				}
+				return
+			}
+			if gotEmailID != "email-1" || gotContentID != "logo/1@example.com" {
+				t.Fatalf("store called with %q, %q", gotEmailID, gotContentID)
+			}
+			if rr.Header().Get("Content-Type") != "image/png" || rr.Body.String() != "png" {
+				t.Fatalf("response = %q, %q", rr.Header().Get("Content-Type"), rr.Body.String())
+			}
+			if rr.Header().Get("X-Content-Type-Options") != "nosniff" || !strings.Contains(rr.Header().Get("Content-Security-Policy"), "sandbox") {
+				t.Fatalf("headers = %v", rr.Header())
+			}
+		})
+	}
+}
+
+func TestHandleRenderEmail(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		query         string
+		storeEmail    *store.Email
+		storeErr      error
+		wantStatus    int
+		wantErrorCode string
+		wantContains  []string
+		wantMissing   []string
+		wantImgSrc    string
 	}{
 		{
-			name:       "redis connected",
-			wantStatus: http.StatusOK,
-			wantState:  "connected",
+			name:         "sanitized HTML",
+			storeEmail:   &store.Email{ID: "email-1", Subject: "<Welcome>", ContentType: "text/html", Body: `<p>Hi</p><script>alert(1)</script><img src="https://track.test/p.gif" onload="x()">`},
+			wantStatus:   http.StatusOK,
+			wantContains: []string{"<title>&lt;Welcome&gt;</title>", `<body><p>Hi</p><img src="https://track.test/p.gif"/></body>`},
+			wantMissing:  []string{"<script>", "onload"},
+			wantImgSrc:   "img-src 'self' data: cid:;",
 		},
 		{
-			name:       "redis disconnected",
-			pingErr:    errors.New("redis down"),
-			wantStatus: http.StatusServiceUnavailable,
-			wantState:  "disconnected",
+			name:         "plain text is escaped",
+			storeEmail:   &store.Email{ID: "email-2", ContentType: "text/plain", Body: "a <b> c"},
+			wantStatus:   http.StatusOK,
+			wantContains: []string{"<pre>a &lt;b&gt; c</pre>"},
+			wantImgSrc:   "img-src 'self' data: cid:;",
+		},
+		{
+			name:       "remote images allowed",
+			query:      "?remote_images=true",
+			storeEmail: &store.Email{ID: "email-3", ContentType: "text/html", Body: "<p>Hi</p>"},
+			wantStatus: http.StatusOK,
+			wantImgSrc: "img-src 'self' data: cid: https:;",
 		},
+		{name: "not found", wantStatus: http.StatusNotFound, wantErrorCode: ErrCodeNotFound},
+		{name: "store error", storeErr: errors.New("redis down"), wantStatus: http.StatusInternalServerError, wantErrorCode: ErrCodeInternalError},
 	}
 
 	for _, tc := range tests {
@@ -592,37 +907,1366 @@ func TestHandleHealth(t *testing.T) {
 			t.Parallel()
 
 			s := &fakeEmailStore{
-				pingFn: func(ctx context.Context) error {
-					return tc.pingErr
+				getEmailFn: func(ctx context.Context, addressBox string, emailID string) (*store.Email, error) {
+					return tc.storeEmail, tc.storeErr
 				},
 			}
-			h := NewAPIHandler(s, "coresend.io")
+			h := NewAPIHandler(s, newTestDomains(t, "coresend.io"))
 
-			req := httptest.NewRequest(http.MethodGet, "/api/health", nil)
+			req := httptest.NewRequest(http.MethodGet, "/api/inbox/"+testValidAddress+"/email-1/render"+tc.query, nil)
+			req.SetPathValue("address", testValidAddress)
+			req.SetPathValue("emailId", "email-1")
 			rr := httptest.NewRecorder()
 
-			h.handleHealth(rr, req)
+			h.handleRenderEmail(rr, req)
 
 			if rr.Code != tc.wantStatus {
 				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
 			}
-			if s.pingCallCount != 1 {
-				t.Fatalf("ping call count = %d, want 1", s.pingCallCount)
+			if tc.wantErrorCode != "" {
+				if got := decodeErrorResponse(t, rr); got.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", got.Error.Code, tc.wantErrorCode)
+				}
+				return
 			}
-			if got := rr.Header().Get("Content-Type"); got != "application/json" {
-				t.Fatalf("Content-Type = %q, want %q", got, "application/json")
+
+			if ct := rr.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+				t.Fatalf("Content-Type = %q", ct)
+			}
+			csp := rr.Header().Get("Content-Security-Policy")
+			for _, want := range []string{"default-src 'none';", tc.wantImgSrc, "sandbox allow-popups"} {
+				if !strings.Contains(csp, want) {
+					t.Fatalf("Content-Security-Policy = %q, want it to contain %q", csp, want)
+				}
+			}
+			if strings.Contains(csp, "script-src") {
+				t.Fatalf("Content-Security-Policy = %q, want no script sources", csp)
+			}
+			if rr.Header().Get("X-Content-Type-Options") != "nosniff" || rr.Header().Get("Referrer-Policy") != "no-referrer" {
+				t.Fatalf("headers = %v", rr.Header())
 			}
 
-			resp := decodeJSONResponse[HealthResponse](t, rr)
-			if resp.Status != tc.wantState {
-				t.Fatalf("status = %q, want %q", resp.Status, tc.wantState)
+			body := rr.Body.String()
+			if !strings.HasPrefix(body, "<!DOCTYPE html>") {
+				t.Fatalf("body = %q, want a standalone document", body)
+			}
+			for _, want := range tc.wantContains {
+				if !strings.Contains(body, want) {
+					t.Fatalf("body = %q, want it to contain %q", body, want)
+				}
+			}
+			for _, unwanted := range tc.wantMissing {
+				if strings.Contains(body, unwanted) {
+					t.Fatalf("body = %q, want no %q", body, unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleGetStructure(t *testing.T) {
+	t.Parallel()
+
+	structure := &store.MIMEPart{
+		ContentType: "multipart/mixed",
+		Size:        120,
+		Parts: []store.MIMEPart{
+			{Path: "1", ContentType: "text/plain", Charset: "utf-8", Size: 20},
+			{
+				Path:        "2",
+				ContentType: "message/rfc822",
+				Disposition: "attachment",
+				Filename:    "forwarded.eml",
+				Size:        100,
+				Parts:       []store.MIMEPart{{Path: "2.1", ContentType: "text/html", Size: 40}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		storeEmail    *store.Email
+		storeErr      error
+		wantStatus    int
+		wantErrorCode string
+	}{
+		{name: "structure", storeEmail: &store.Email{ID: "email-1", Structure: structure}, wantStatus: http.StatusOK},
+		{name: "not recorded", storeEmail: &store.Email{ID: "email-1"}, wantStatus: http.StatusNotFound, wantErrorCode: ErrCodeNotFound},
+		{name: "not found", wantStatus: http.StatusNotFound, wantErrorCode: ErrCodeNotFound},
+		{name: "store error", storeErr: errors.New("redis down"), wantStatus: http.StatusInternalServerError, wantErrorCode: ErrCodeInternalError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &fakeEmailStore{
+				getEmailFn: func(ctx context.Context, addressBox string, emailID string) (*store.Email, error) {
+					return tc.storeEmail, tc.storeErr
+				},
+			}
+			h := NewAPIHandler(s, newTestDomains(t, "coresend.io"))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/inbox/"+testValidAddress+"/email-1/structure", nil)
+			req.SetPathValue("address", testValidAddress)
+			req.SetPathValue("emailId", "email-1")
+			rr := httptest.NewRecorder()
+
+			h.handleGetStructure(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			if tc.wantErrorCode != "" {
+				if got := decodeErrorResponse(t, rr); got.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", got.Error.Code, tc.wantErrorCode)
+				}
+				return
+			}
+
+			var got MIMEPartResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if got.ContentType != "multipart/mixed" || got.Size != 120 || len(got.Parts) != 2 {
+				t.Fatalf("root = %+v", got)
+			}
+			attached := got.Parts[1]
+			if attached.Path != "2" || attached.Filename != "forwarded.eml" || attached.Disposition != "attachment" {
+				t.Fatalf("attached part = %+v", attached)
+			}
+			if len(attached.Parts) != 1 || attached.Parts[0].Path != "2.1" || attached.Parts[0].ContentType != "text/html" {
+				t.Fatalf("attached message parts = %+v", attached.Parts)
+			}
+		})
+	}
+}
+
+func TestHandleGetSource(t *testing.T) {
+	t.Parallel()
+
+	source := "From: a@example.com\r\nSubject: Hi\r\n\r\nHello\r\n"
+	tests := []struct {
+		name          string
+		storeSource   []byte
+		storeErr      error
+		wantStatus    int
+		wantErrorCode string
+	}{
+		{name: "source", storeSource: []byte(source), wantStatus: http.StatusOK},
+		{name: "not kept", wantStatus: http.StatusNotFound, wantErrorCode: ErrCodeNotFound},
+		{name: "store error", storeErr: errors.New("redis down"), wantStatus: http.StatusInternalServerError, wantErrorCode: ErrCodeInternalError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &fakeEmailStore{
+				getSourceFn: func(ctx context.Context, addressBox string, emailID string) ([]byte, error) {
+					return tc.storeSource, tc.storeErr
+				},
+			}
+			h := NewAPIHandler(s, newTestDomains(t, "coresend.io"))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/inbox/"+testValidAddress+"/email-1/source", nil)
+			req.SetPathValue("address", testValidAddress)
+			req.SetPathValue("emailId", "email-1")
+			rr := httptest.NewRecorder()
+
+			h.handleGetSource(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			if tc.wantErrorCode != "" {
+				if got := decodeErrorResponse(t, rr); got.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", got.Error.Code, tc.wantErrorCode)
+				}
+				return
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "message/rfc822" {
+				t.Fatalf("Content-Type = %q, want message/rfc822", ct)
+			}
+			if rr.Body.String() != source {
+				t.Fatalf("body = %q, want %q", rr.Body.String(), source)
+			}
+		})
+	}
+}
+
+func TestHandleLatestCode(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	withCode := func(id, code string, at time.Time) store.Email {
+		return store.Email{
+			ID:         id,
+			Subject:    "Your code",
+			ReceivedAt: at,
+			Extracted: &store.Extracted{
+				Codes: []store.Code{{Value: code, Confidence: 0.9}, {Value: "1111", Confidence: 0.3}},
+				Links: []store.Link{{URL: "https://example.com/verify", Kind: "verify"}},
+			},
+		}
+	}
+	// Newest first, as the store lists them
+	inbox := []store.Email{
+		{ID: "newest", ReceivedAt: now},
+		withCode("middle", "482913", now.Add(-time.Minute)),
+		withCode("oldest", "731055", now.Add(-time.Hour)),
+	}
+
+	tests := []struct {
+		name          string
+		query         string
+		emails        []store.Email
+		storeErr      error
+		wantStatus    int
+		wantErrorCode string
+		wantCode      string
+		wantEmailID   string
+	}{
+		{name: "newest email with a code", emails: inbox, wantStatus: http.StatusOK, wantCode: "482913", wantEmailID: "middle"},
+		{name: "since includes the email", query: "?since=" + now.Add(-time.Minute).Format(time.RFC3339), emails: inbox, wantStatus: http.StatusOK, wantCode: "482913", wantEmailID: "middle"},
+		{name: "since excludes older codes", query: "?since=" + now.Add(-30*time.Second).Format(time.RFC3339), emails: inbox, wantStatus: http.StatusNotFound, wantErrorCode: ErrCodeNotFound},
+		{name: "no codes", emails: inbox[:1], wantStatus: http.StatusNotFound, wantErrorCode: ErrCodeNotFound},
+		{name: "invalid since", query: "?since=yesterday", wantStatus: http.StatusBadRequest, wantErrorCode: ErrCodeInvalidRequest},
+		{name: "store error", storeErr: errors.New("redis down"), wantStatus: http.StatusInternalServerError, wantErrorCode: ErrCodeInternalError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &fakeEmailStore{
+				getEmailsFn: func(ctx context.Context, addressBox string) ([]store.Email, error) {
+					return tc.emails, tc.storeErr
+				},
+			}
+			h := NewAPIHandler(s, newTestDomains(t, "coresend.io"))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/inbox/"+testValidAddress+"/latest-code"+tc.query, nil)
+			req.SetPathValue("address", testValidAddress)
+			rr := httptest.NewRecorder()
+
+			h.handleLatestCode(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			if tc.wantErrorCode != "" {
+				if got := decodeErrorResponse(t, rr); got.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", got.Error.Code, tc.wantErrorCode)
+				}
+				return
 			}
-			if resp.Services["redis"] != tc.wantState {
-				t.Fatalf("services.redis = %q, want %q", resp.Services["redis"], tc.wantState)
+
+			resp := decodeJSONResponse[LatestCodeResponse](t, rr)
+			if resp.Code != tc.wantCode || resp.EmailID != tc.wantEmailID || resp.Confidence != 0.9 {
+				t.Fatalf("response = %+v, want code %q from %q", resp, tc.wantCode, tc.wantEmailID)
 			}
-			if resp.Services["smtp"] != "running" {
-				t.Fatalf("services.smtp = %q, want %q", resp.Services["smtp"], "running")
+			if len(resp.Links) != 1 || resp.Links[0].Kind != "verify" {
+				t.Fatalf("links = %+v", resp.Links)
 			}
 		})
 	}
 }
+
+func TestHandleDeleteEmail(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		address          string
+		emailID          string
+		deleteErr        error
+		wantStatus       int
+		wantErrorCode    string
+		wantDeleteCalled int
+	}{
+		{
+			name:             "missing params",
+			address:          "",
+			emailID:          "",
+			wantStatus:       http.StatusBadRequest,
+			wantErrorCode:    ErrCodeInvalidAddress,
+			wantDeleteCalled: 0,
+		},
+		{
+			name:             "store error",
+			address:          testValidAddress,
+			emailID:          "email-1",
+			deleteErr:        errors.New("redis down"),
+			wantStatus:       http.StatusInternalServerError,
+			wantErrorCode:    ErrCodeInternalError,
+			wantDeleteCalled: 1,
+		},
+		{
+			name:             "success",
+			address:          testValidAddress,
+			emailID:          "email-1",
+			wantStatus:       http.StatusOK,
+			wantDeleteCalled: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &fakeEmailStore{
+				deleteEmailFn: func(ctx context.Context, addressBox string, emailID string) error {
+					return tc.deleteErr
+				},
+			}
+			h := NewAPIHandler(s, newTestDomains(t, "coresend.io"))
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/inbox/"+tc.address+"/"+tc.emailID, nil)
+			if tc.address != "" {
+				req.SetPathValue("address", tc.address)
+			}
+			if tc.emailID != "" {
+				req.SetPathValue("emailId", tc.emailID)
+			}
+			rr := httptest.NewRecorder()
+
+			h.handleDeleteEmail(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			if s.deleteEmailCallCount != tc.wantDeleteCalled {
+				t.Fatalf("deleteEmail call count = %d, want %d", s.deleteEmailCallCount, tc.wantDeleteCalled)
+			}
+			if tc.wantDeleteCalled > 0 {
+				if s.lastDeleteAddressBox != tc.address {
+					t.Fatalf("deleteEmail address = %q, want %q", s.lastDeleteAddressBox, tc.address)
+				}
+				if s.lastDeleteEmailID != tc.emailID {
+					t.Fatalf("deleteEmail id = %q, want %q", s.lastDeleteEmailID, tc.emailID)
+				}
+			}
+
+			if tc.wantErrorCode != "" {
+				gotErr := decodeErrorResponse(t, rr)
+				if gotErr.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", gotErr.Error.Code, tc.wantErrorCode)
+				}
+				return
+			}
+
+			resp := decodeJSONResponse[DeleteResponse](t, rr)
+			if !resp.Deleted {
+				t.Fatalf("deleted = %v, want true", resp.Deleted)
+			}
+			if resp.ID != tc.emailID {
+				t.Fatalf("id = %q, want %q", resp.ID, tc.emailID)
+			}
+		})
+	}
+}
+
+func TestHandleClearInbox(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		address         string
+		clearCount      int64
+		clearErr        error
+		wantStatus      int
+		wantErrorCode   string
+		wantClearCalled int
+	}{
+		{
+			name:            "missing address",
+			address:         "",
+			wantStatus:      http.StatusBadRequest,
+			wantErrorCode:   ErrCodeInvalidAddress,
+			wantClearCalled: 0,
+		},
+		{
+			name:            "store error",
+			address:         testValidAddress,
+			clearErr:        errors.New("redis down"),
+			wantStatus:      http.StatusInternalServerError,
+			wantErrorCode:   ErrCodeInternalError,
+			wantClearCalled: 1,
+		},
+		{
+			name:            "success",
+			address:         testValidAddress,
+			clearCount:      2,
+			wantStatus:      http.StatusOK,
+			wantClearCalled: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &fakeEmailStore{
+				clearInboxFn: func(ctx context.Context, addressBox string) (int64, error) {
+					return tc.clearCount, tc.clearErr
+				},
+			}
+			h := NewAPIHandler(s, newTestDomains(t, "coresend.io"))
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/inbox/"+tc.address, nil)
+			if tc.address != "" {
+				req.SetPathValue("address", tc.address)
+			}
+			rr := httptest.NewRecorder()
+
+			h.handleClearInbox(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			if s.clearInboxCallCount != tc.wantClearCalled {
+				t.Fatalf("clearInbox call count = %d, want %d", s.clearInboxCallCount, tc.wantClearCalled)
+			}
+			if tc.wantClearCalled > 0 && s.lastClearInboxAddress != tc.address {
+				t.Fatalf("clearInbox address = %q, want %q", s.lastClearInboxAddress, tc.address)
+			}
+
+			if tc.wantErrorCode != "" {
+				gotErr := decodeErrorResponse(t, rr)
+				if gotErr.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", gotErr.Error.Code, tc.wantErrorCode)
+				}
+				return
+			}
+
+			resp := decodeJSONResponse[DeleteResponse](t, rr)
+			if !resp.Deleted {
+				t.Fatalf("deleted = %v, want true", resp.Deleted)
+			}
+			if resp.Count != tc.clearCount {
+				t.Fatalf("count = %d, want %d", resp.Count, tc.clearCount)
+			}
+		})
+	}
+}
+
+func TestHandleHealth(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		pingErr    error
+		drain      bool
+		wantStatus int
+		wantState  string
+		wantRedis  string
+	}{
+		{
+			name:       "redis connected",
+			wantStatus: http.StatusOK,
+			wantState:  "connected",
+			wantRedis:  "connected",
+		},
+		{
+			name:       "redis disconnected",
+			pingErr:    errors.New("redis down"),
+			wantStatus: http.StatusServiceUnavailable,
+			wantState:  "disconnected",
+			wantRedis:  "disconnected",
+		},
+		{
+			name:       "draining",
+			drain:      true,
+			wantStatus: http.StatusServiceUnavailable,
+			wantState:  "draining",
+			wantRedis:  "connected",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &fakeEmailStore{
+				pingFn: func(ctx context.Context) error {
+					return tc.pingErr
+				},
+			}
+			h := NewAPIHandler(s, newTestDomains(t, "coresend.io"))
+			h.Health = &health.Checker{Store: s}
+			if tc.drain {
+				h.Health.Drain()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/health", nil)
+			rr := httptest.NewRecorder()
+
+			h.handleHealth(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			if s.pingCallCount != 1 {
+				t.Fatalf("ping call count = %d, want 1", s.pingCallCount)
+			}
+			if got := rr.Header().Get("Content-Type"); got != "application/json" {
+				t.Fatalf("Content-Type = %q, want %q", got, "application/json")
+			}
+
+			resp := decodeJSONResponse[HealthResponse](t, rr)
+			if resp.Status != tc.wantState {
+				t.Fatalf("status = %q, want %q", resp.Status, tc.wantState)
+			}
+			if resp.Services["redis"] != tc.wantRedis {
+				t.Fatalf("services.redis = %q, want %q", resp.Services["redis"], tc.wantRedis)
+			}
+		})
+	}
+}
+
+func TestHandleLive(t *testing.T) {
+	t.Parallel()
+
+	s := &fakeEmailStore{
+		pingFn: func(ctx context.Context) error {
+			return errors.New("redis down")
+		},
+	}
+	h := NewAPIHandler(s, newTestDomains(t, "coresend.io"))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/health/live", nil)
+	rr := httptest.NewRecorder()
+
+	h.handleLive(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if s.pingCallCount != 0 {
+		t.Fatalf("ping call count = %d, liveness must not touch the store", s.pingCallCount)
+	}
+	resp := decodeJSONResponse[LivenessResponse](t, rr)
+	if resp.Status != "alive" || resp.Build.Version == "" || resp.Build.GoVersion == "" {
+		t.Fatalf("response = %+v, want alive with build info", resp)
+	}
+}
+
+func TestHandleReady(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+
+	tests := []struct {
+		name       string
+		pingErr    error
+		smtp       []health.SMTPProbe
+		drain      bool
+		wantStatus int
+		wantState  string
+		wantChecks map[string]string
+	}{
+		{
+			name:       "ready",
+			wantStatus: http.StatusOK,
+			wantState:  "ready",
+			wantChecks: map[string]string{"store": "ok"},
+		},
+		{
+			name:       "store down",
+			pingErr:    errors.New("redis down"),
+			wantStatus: http.StatusServiceUnavailable,
+			wantState:  "not_ready",
+			wantChecks: map[string]string{"store": "failing"},
+		},
+		{
+			name:       "smtp unreachable",
+			smtp:       []health.SMTPProbe{{Name: "smtp", Addr: closedAddr}},
+			wantStatus: http.StatusServiceUnavailable,
+			wantState:  "not_ready",
+			wantChecks: map[string]string{"store": "ok", "smtp": "failing"},
+		},
+		{
+			name:       "draining",
+			drain:      true,
+			wantStatus: http.StatusServiceUnavailable,
+			wantState:  "draining",
+			wantChecks: map[string]string{"store": "ok"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &fakeEmailStore{
+				pingFn: func(ctx context.Context) error {
+					return tc.pingErr
+				},
+			}
+			h := NewAPIHandler(s, newTestDomains(t, "coresend.io"))
+			h.Health = &health.Checker{Store: s, SMTP: tc.smtp}
+			if tc.drain {
+				h.Health.Drain()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/health/ready", nil)
+			rr := httptest.NewRecorder()
+
+			h.handleReady(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			resp := decodeJSONResponse[ReadinessResponse](t, rr)
+			if resp.Status != tc.wantState {
+				t.Fatalf("status = %q, want %q", resp.Status, tc.wantState)
+			}
+			if len(resp.Checks) != len(tc.wantChecks) {
+				t.Fatalf("checks = %+v, want %v", resp.Checks, tc.wantChecks)
+			}
+			for name, want := range tc.wantChecks {
+				got := resp.Checks[name]
+				if got.Status != want {
+					t.Fatalf("checks.%s = %+v, want status %q", name, got, want)
+				}
+				if (want == "failing") != (got.Error != "") {
+					t.Fatalf("checks.%s error = %q, want error only when failing", name, got.Error)
+				}
+			}
+			if resp.Build.Version == "" {
+				t.Fatal("build.version is empty")
+			}
+		})
+	}
+}
+
+func newTestWebhooks(t *testing.T) *webhook.Dispatcher {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	return &webhook.Dispatcher{Store: store.NewStore(mr.Addr(), ""), MaxPerAddress: 1}
+}
+
+func TestHandleCreateWebhook(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		address       string
+		body          string
+		existing      bool
+		wantStatus    int
+		wantErrorCode string
+	}{
+		{
+			name:          "invalid address",
+			address:       "not-hex",
+			body:          `{"url":"https://ci.example.com/hook"}`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidAddress,
+		},
+		{
+			name:          "invalid body",
+			address:       testValidAddress,
+			body:          `{`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidRequest,
+		},
+		{
+			name:          "invalid url",
+			address:       testValidAddress,
+			body:          `{"url":"ftp://ci.example.com/hook"}`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidWebhookURL,
+		},
+		{
+			name:          "private url",
+			address:       testValidAddress,
+			body:          `{"url":"http://169.254.169.254/latest"}`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidWebhookURL,
+		},
+		{
+			name:          "limit reached",
+			address:       testValidAddress,
+			body:          `{"url":"https://ci.example.com/hook"}`,
+			existing:      true,
+			wantStatus:    http.StatusConflict,
+			wantErrorCode: ErrCodeWebhookLimit,
+		},
+		{
+			name:       "success",
+			address:    testValidAddress,
+			body:       `{"url":"https://ci.example.com/hook","include_body":true}`,
+			wantStatus: http.StatusCreated,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := NewAPIHandler(&fakeEmailStore{}, newTestDomains(t, "coresend.io"))
+			h.Webhooks = newTestWebhooks(t)
+			if tc.existing {
+				if _, err := h.Webhooks.Register(context.Background(), tc.address, "https://other.example.com", false, time.Hour); err != nil {
+					t.Fatalf("Register() error = %v", err)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/webhooks/"+tc.address, strings.NewReader(tc.body))
+			req.SetPathValue("address", tc.address)
+			rr := httptest.NewRecorder()
+
+			h.handleCreateWebhook(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			if tc.wantErrorCode != "" {
+				gotErr := decodeErrorResponse(t, rr)
+				if gotErr.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", gotErr.Error.Code, tc.wantErrorCode)
+				}
+				return
+			}
+
+			resp := decodeJSONResponse[WebhookCreatedResponse](t, rr)
+			if resp.ID == "" || !strings.HasPrefix(resp.Secret, "whsec_") {
+				t.Fatalf("response = %+v, want an ID and a secret", resp)
+			}
+			if resp.URL != "https://ci.example.com/hook" || !resp.IncludeBody {
+				t.Fatalf("response = %+v", resp)
+			}
+			if resp.ExpiresIn <= 0 {
+				t.Fatalf("expires_in = %d, want > 0", resp.ExpiresIn)
+			}
+		})
+	}
+}
+
+func TestHandleListAndDeleteWebhooks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	h := NewAPIHandler(&fakeEmailStore{}, newTestDomains(t, "coresend.io"))
+	h.Webhooks = newTestWebhooks(t)
+
+	hook, err := h.Webhooks.Register(ctx, testValidAddress, "https://ci.example.com/hook", false, time.Hour)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/webhooks/"+testValidAddress, nil)
+	req.SetPathValue("address", testValidAddress)
+	rr := httptest.NewRecorder()
+	h.handleListWebhooks(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if strings.Contains(rr.Body.String(), hook.Secret) {
+		t.Fatal("list response must not include the secret")
+	}
+	list := decodeJSONResponse[WebhooksResponse](t, rr)
+	if len(list.Webhooks) != 1 || list.Webhooks[0].ID != hook.ID {
+		t.Fatalf("webhooks = %+v, want %s", list.Webhooks, hook.ID)
+	}
+
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		req := httptest.NewRequest(http.MethodDelete, "/api/webhooks/"+testValidAddress+"/"+hook.ID, nil)
+		req.SetPathValue("address", testValidAddress)
+		req.SetPathValue("webhookId", hook.ID)
+		rr := httptest.NewRecorder()
+		h.handleDeleteWebhook(rr, req)
+
+		if rr.Code != want {
+			t.Fatalf("delete status = %d, want %d", rr.Code, want)
+		}
+	}
+}
+
+func TestHandleWebhookDeliveriesAndDeadLetters(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	h := NewAPIHandler(&fakeEmailStore{}, newTestDomains(t, "coresend.io"))
+	h.Webhooks = newTestWebhooks(t)
+
+	delivery := store.WebhookDelivery{JobID: "job-1", WebhookID: "hook-1", EmailID: "email-1", Attempt: 2, Status: webhook.StatusDead, StatusCode: 503, Error: "receiver answered 503 Service Unavailable"}
+	if err := h.Webhooks.Store.LogWebhookDelivery(ctx, testValidAddress, delivery, time.Hour); err != nil {
+		t.Fatalf("LogWebhookDelivery() error = %v", err)
+	}
+	job := store.WebhookJob{ID: "job-1", Address: testValidAddress, WebhookID: "hook-1", EmailID: "email-1", Attempt: 2, LastError: delivery.Error}
+	if err := h.Webhooks.Store.DeadLetterWebhookJob(ctx, job); err != nil {
+		t.Fatalf("DeadLetterWebhookJob() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/webhooks/"+testValidAddress+"/deliveries", nil)
+	req.SetPathValue("address", testValidAddress)
+	rr := httptest.NewRecorder()
+	h.handleWebhookDeliveries(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("deliveries status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	deliveries := decodeJSONResponse[WebhookDeliveriesResponse](t, rr)
+	if len(deliveries.Deliveries) != 1 || deliveries.Deliveries[0].Status != webhook.StatusDead || deliveries.Deliveries[0].StatusCode != 503 {
+		t.Fatalf("deliveries = %+v", deliveries.Deliveries)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/webhooks/"+testValidAddress+"/dead-letters", nil)
+	req.SetPathValue("address", testValidAddress)
+	rr = httptest.NewRecorder()
+	h.handleWebhookDeadLetters(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("dead letters status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	dead := decodeJSONResponse[DeadLettersResponse](t, rr)
+	if len(dead.DeadLetters) != 1 || dead.DeadLetters[0].JobID != "job-1" || dead.DeadLetters[0].Attempts != 2 {
+		t.Fatalf("dead letters = %+v", dead.DeadLetters)
+	}
+}
+
+func newTestForwarder(t *testing.T, registry *domains.Registry) *forward.Forwarder {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	return &forward.Forwarder{
+		Store:         store.NewStore(mr.Addr(), ""),
+		Relay:         &forward.Relay{},
+		SRS:           &forward.SRS{Domain: "coresend.io", Secret: []byte("0123456789abcdef")},
+		Domains:       registry,
+		MaxPerAddress: 1,
+	}
+}
+
+func TestHandleCreateForward(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		address       string
+		body          string
+		existing      bool
+		wantStatus    int
+		wantErrorCode string
+	}{
+		{
+			name:          "invalid address",
+			address:       "not-hex",
+			body:          `{"destination":"tester@example.com"}`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidAddress,
+		},
+		{
+			name:          "invalid body",
+			address:       testValidAddress,
+			body:          `{`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidRequest,
+		},
+		{
+			name:          "invalid destination",
+			address:       testValidAddress,
+			body:          `{"destination":"Tester <tester@example.com>"}`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidForward,
+		},
+		{
+			name:          "local destination",
+			address:       testValidAddress,
+			body:          `{"destination":"` + testValidAddress + `@coresend.io"}`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidForward,
+		},
+		{
+			name:          "limit reached",
+			address:       testValidAddress,
+			body:          `{"destination":"tester@example.com"}`,
+			existing:      true,
+			wantStatus:    http.StatusConflict,
+			wantErrorCode: ErrCodeForwardLimit,
+		},
+		{
+			name:       "success",
+			address:    testValidAddress,
+			body:       `{"destination":"tester@example.com","subject_contains":"verification"}`,
+			wantStatus: http.StatusCreated,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			registry := newTestDomains(t, "coresend.io")
+			h := NewAPIHandler(&fakeEmailStore{}, registry)
+			h.Forwarder = newTestForwarder(t, registry)
+			if tc.existing {
+				if _, err := h.Forwarder.Register(context.Background(), tc.address, store.ForwardRule{Destination: "other@example.com"}, time.Hour); err != nil {
+					t.Fatalf("Register() error = %v", err)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/forwards/"+tc.address, strings.NewReader(tc.body))
+			req.SetPathValue("address", tc.address)
+			rr := httptest.NewRecorder()
+
+			h.handleCreateForward(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			if tc.wantErrorCode != "" {
+				gotErr := decodeErrorResponse(t, rr)
+				if gotErr.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", gotErr.Error.Code, tc.wantErrorCode)
+				}
+				return
+			}
+
+			resp := decodeJSONResponse[ForwardRuleResponse](t, rr)
+			if resp.ID == "" || resp.Destination != "tester@example.com" || resp.SubjectContains != "verification" {
+				t.Fatalf("response = %+v", resp)
+			}
+		})
+	}
+}
+
+func TestHandleListAndDeleteForwards(t *testing.T) {
+	t.Parallel()
+
+	registry := newTestDomains(t, "coresend.io")
+	h := NewAPIHandler(&fakeEmailStore{}, registry)
+	h.Forwarder = newTestForwarder(t, registry)
+
+	rule, err := h.Forwarder.Register(context.Background(), testValidAddress, store.ForwardRule{Destination: "tester@example.com"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/forwards/"+testValidAddress, nil)
+	req.SetPathValue("address", testValidAddress)
+	rr := httptest.NewRecorder()
+	h.handleListForwards(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	list := decodeJSONResponse[ForwardRulesResponse](t, rr)
+	if len(list.Rules) != 1 || list.Rules[0].ID != rule.ID || list.Rules[0].Destination != "tester@example.com" {
+		t.Fatalf("rules = %+v, want %s", list.Rules, rule.ID)
+	}
+
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		req := httptest.NewRequest(http.MethodDelete, "/api/forwards/"+testValidAddress+"/"+rule.ID, nil)
+		req.SetPathValue("address", testValidAddress)
+		req.SetPathValue("ruleId", rule.ID)
+		rr := httptest.NewRecorder()
+		h.handleDeleteForward(rr, req)
+
+		if rr.Code != want {
+			t.Fatalf("delete status = %d, want %d", rr.Code, want)
+		}
+	}
+}
+
+func newTestFilters(t *testing.T) *filter.Filters {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	s := store.NewStore(mr.Addr(), "")
+	if err := s.RegisterAddress(context.Background(), testValidAddress, "coresend.io", time.Hour); err != nil {
+		t.Fatalf("RegisterAddress() error = %v", err)
+	}
+	return &filter.Filters{Store: s, MaxPerAddress: 1}
+}
+
+func TestHandleCreateFilter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		address       string
+		body          string
+		existing      bool
+		wantStatus    int
+		wantErrorCode string
+	}{
+		{
+			name:          "invalid address",
+			address:       "not-hex",
+			body:          `{"from_glob":"*@news.example.com","action":"drop"}`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidAddress,
+		},
+		{
+			name:          "invalid body",
+			address:       testValidAddress,
+			body:          `{`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidRequest,
+		},
+		{
+			name:          "no condition",
+			address:       testValidAddress,
+			body:          `{"action":"drop"}`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidFilter,
+		},
+		{
+			name:          "invalid regex",
+			address:       testValidAddress,
+			body:          `{"subject_regex":"(","action":"drop"}`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidFilter,
+		},
+		{
+			name:          "not registered",
+			address:       "fedcba9876543210fedcba9876543210fedcba98",
+			body:          `{"from_glob":"*@news.example.com","action":"drop"}`,
+			wantStatus:    http.StatusNotFound,
+			wantErrorCode: ErrCodeNotFound,
+		},
+		{
+			name:          "limit reached",
+			address:       testValidAddress,
+			body:          `{"from_glob":"*@news.example.com","action":"drop"}`,
+			existing:      true,
+			wantStatus:    http.StatusConflict,
+			wantErrorCode: ErrCodeFilterLimit,
+		},
+		{
+			name:       "success",
+			address:    testValidAddress,
+			body:       `{"header":"List-Unsubscribe","action":"label","label":"marketing"}`,
+			wantStatus: http.StatusCreated,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := NewAPIHandler(&fakeEmailStore{}, newTestDomains(t, "coresend.io"))
+			h.Filters = newTestFilters(t)
+			if tc.existing {
+				if _, err := h.Filters.Register(context.Background(), tc.address, store.FilterRule{FromGlob: "*", Action: filter.ActionDrop}); err != nil {
+					t.Fatalf("Register() error = %v", err)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/filters/"+tc.address, strings.NewReader(tc.body))
+			req.SetPathValue("address", tc.address)
+			rr := httptest.NewRecorder()
+
+			h.handleCreateFilter(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			if tc.wantErrorCode != "" {
+				gotErr := decodeErrorResponse(t, rr)
+				if gotErr.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", gotErr.Error.Code, tc.wantErrorCode)
+				}
+				return
+			}
+
+			resp := decodeJSONResponse[FilterRuleResponse](t, rr)
+			if resp.ID == "" || resp.Header != "List-Unsubscribe" || resp.Action != filter.ActionLabel || resp.Label != "marketing" {
+				t.Fatalf("response = %+v", resp)
+			}
+		})
+	}
+}
+
+func TestHandleListAndDeleteFilters(t *testing.T) {
+	t.Parallel()
+
+	h := NewAPIHandler(&fakeEmailStore{}, newTestDomains(t, "coresend.io"))
+	h.Filters = newTestFilters(t)
+
+	rule, err := h.Filters.Register(context.Background(), testValidAddress, store.FilterRule{FromGlob: "*@news.example.com", Action: filter.ActionReject})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/filters/"+testValidAddress, nil)
+	req.SetPathValue("address", testValidAddress)
+	rr := httptest.NewRecorder()
+	h.handleListFilters(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	list := decodeJSONResponse[FilterRulesResponse](t, rr)
+	if len(list.Rules) != 1 || list.Rules[0].ID != rule.ID || list.Rules[0].FromGlob != "*@news.example.com" {
+		t.Fatalf("rules = %+v, want %s", list.Rules, rule.ID)
+	}
+
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		req := httptest.NewRequest(http.MethodDelete, "/api/filters/"+testValidAddress+"/"+rule.ID, nil)
+		req.SetPathValue("address", testValidAddress)
+		req.SetPathValue("filterId", rule.ID)
+		rr := httptest.NewRecorder()
+		h.handleDeleteFilter(rr, req)
+
+		if rr.Code != want {
+			t.Fatalf("delete status = %d, want %d", rr.Code, want)
+		}
+	}
+}
+
+func newTestSender(t *testing.T, domain string) *send.Sender {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	s := store.NewStore(mr.Addr(), "")
+	return &send.Sender{
+		Store:       s,
+		Queue:       s,
+		Signers:     map[string]*dkim.Signer{domain: {Domain: domain, Selector: "mail", Key: key}},
+		HourlyQuota: 1,
+	}
+}
+
+func TestHandleSend(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		address       string
+		body          string
+		signerDomain  string
+		sentBefore    bool
+		wantStatus    int
+		wantErrorCode string
+	}{
+		{
+			name:          "invalid address",
+			address:       "not-hex",
+			body:          `{"to":["tester@example.com"]}`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidAddress,
+		},
+		{
+			name:          "invalid body",
+			address:       testValidAddress,
+			body:          `{`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidRequest,
+		},
+		{
+			name:          "invalid recipient",
+			address:       testValidAddress,
+			body:          `{"to":["Tester <tester@example.com>"]}`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidMessage,
+		},
+		{
+			name:          "domain without key",
+			address:       testValidAddress,
+			body:          `{"to":["tester@example.com"]}`,
+			signerDomain:  "other.test",
+			wantStatus:    http.StatusForbidden,
+			wantErrorCode: ErrCodeSendingDisabled,
+		},
+		{
+			name:          "quota exceeded",
+			address:       testValidAddress,
+			body:          `{"to":["tester@example.com"]}`,
+			sentBefore:    true,
+			wantStatus:    http.StatusTooManyRequests,
+			wantErrorCode: ErrCodeSendQuota,
+		},
+		{
+			name:       "success",
+			address:    testValidAddress,
+			body:       `{"to":["tester@example.com"],"subject":"Hello","text":"Hi there"}`,
+			wantStatus: http.StatusAccepted,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			signerDomain := tc.signerDomain
+			if signerDomain == "" {
+				signerDomain = "coresend.io"
+			}
+			registry := newTestDomains(t, "coresend.io")
+			h := NewAPIHandler(&fakeEmailStore{}, registry)
+			h.Sender = newTestSender(t, signerDomain)
+			if tc.sentBefore {
+				if _, err := h.Sender.Send(context.Background(), tc.address, registry.Default(), send.Message{To: []string{"other@example.com"}}); err != nil {
+					t.Fatalf("Send() error = %v", err)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/inbox/"+tc.address+"/send", strings.NewReader(tc.body))
+			req.SetPathValue("address", tc.address)
+			rr := httptest.NewRecorder()
+
+			h.handleSend(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			if tc.wantErrorCode != "" {
+				gotErr := decodeErrorResponse(t, rr)
+				if gotErr.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", gotErr.Error.Code, tc.wantErrorCode)
+				}
+				return
+			}
+
+			resp := decodeJSONResponse[SentEmailResponse](t, rr)
+			if resp.ID == "" || resp.From != testValidAddress+"@coresend.io" || resp.Subject != "Hello" || resp.MessageID != resp.ID+"@coresend.io" {
+				t.Fatalf("response = %+v", resp)
+			}
+		})
+	}
+}
+
+func TestHandleReply(t *testing.T) {
+	t.Parallel()
+
+	original := &store.Email{
+		ID:         "email-1",
+		From:       "ci@example.com",
+		Subject:    "Build failed",
+		MessageID:  "2@example.com",
+		References: []string{"1@example.com"},
+	}
+
+	tests := []struct {
+		name          string
+		emailID       string
+		body          string
+		wantStatus    int
+		wantErrorCode string
+	}{
+		{
+			name:          "unknown email",
+			emailID:       "missing",
+			body:          `{}`,
+			wantStatus:    http.StatusNotFound,
+			wantErrorCode: ErrCodeNotFound,
+		},
+		{
+			name:          "invalid body",
+			emailID:       "email-1",
+			body:          `{`,
+			wantStatus:    http.StatusBadRequest,
+			wantErrorCode: ErrCodeInvalidRequest,
+		},
+		{
+			name:       "success",
+			emailID:    "email-1",
+			body:       `{"text":"Fixed"}`,
+			wantStatus: http.StatusAccepted,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeStore := &fakeEmailStore{
+				getEmailFn: func(_ context.Context, _ string, emailID string) (*store.Email, error) {
+					if emailID == original.ID {
+						return original, nil
+					}
+					return nil, nil
+				},
+			}
+			h := NewAPIHandler(fakeStore, newTestDomains(t, "coresend.io"))
+			h.Sender = newTestSender(t, "coresend.io")
+
+			req := httptest.NewRequest(http.MethodPost, "/api/inbox/"+testValidAddress+"/reply/"+tc.emailID, strings.NewReader(tc.body))
+			req.SetPathValue("address", testValidAddress)
+			req.SetPathValue("emailId", tc.emailID)
+			rr := httptest.NewRecorder()
+
+			h.handleReply(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+			if tc.wantErrorCode != "" {
+				gotErr := decodeErrorResponse(t, rr)
+				if gotErr.Error.Code != tc.wantErrorCode {
+					t.Fatalf("error.code = %q, want %q", gotErr.Error.Code, tc.wantErrorCode)
+				}
+				return
+			}
+
+			resp := decodeJSONResponse[SentEmailResponse](t, rr)
+			if len(resp.To) != 1 || resp.To[0] != "ci@example.com" || resp.Subject != "Re: Build failed" {
+				t.Fatalf("response = %+v", resp)
+			}
+			if strings.Join(resp.References, " ") != "1@example.com 2@example.com" {
+				t.Fatalf("references = %v", resp.References)
+			}
+		})
+	}
+}
+
+func TestHandleGetSent(t *testing.T) {
+	t.Parallel()
+
+	h := NewAPIHandler(&fakeEmailStore{}, newTestDomains(t, "coresend.io"))
+	h.Sender = newTestSender(t, "coresend.io")
+
+	sent, err := h.Sender.Send(context.Background(), testValidAddress, h.Domains.Default(), send.Message{To: []string{"tester@example.com"}, Text: "Hi"})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/inbox/"+testValidAddress+"/sent", nil)
+	req.SetPathValue("address", testValidAddress)
+	rr := httptest.NewRecorder()
+	h.handleGetSent(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	resp := decodeJSONResponse[SentResponse](t, rr)
+	if resp.Count != 1 || len(resp.Emails) != 1 || resp.Emails[0].ID != sent.ID || resp.Emails[0].Body != "Hi" {
+		t.Fatalf("response = %+v", resp)
+	}
+}
@@ -10,16 +10,23 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/fn-jakubkarp/coresend/internal/identity"
+	"github.com/fn-jakubkarp/coresend/internal/logging"
 	"github.com/fn-jakubkarp/coresend/internal/metrics"
 	"github.com/fn-jakubkarp/coresend/internal/store"
+	"github.com/fn-jakubkarp/coresend/internal/tracing"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RateLimitConfig struct {
@@ -36,7 +43,7 @@ func rateLimitMiddleware(s store.EmailStore, config RateLimitConfig) func(http.H
 
 			allowed, _, err := s.CheckRateLimit(r.Context(), key, config.Limit, config.Window)
 			if err != nil {
-				log.Printf("Rate limit check error: %v", err)
+				slog.ErrorContext(r.Context(), "Rate limit check failed", "error", err)
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -70,7 +77,7 @@ func securityHeadersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nonce, err := generateNonce()
 		if err != nil {
-			log.Printf("Failed to generate CSP nonce: %v", err)
+			slog.ErrorContext(r.Context(), "Failed to generate CSP nonce", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
@@ -100,11 +107,94 @@ func securityHeadersMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// setRenderHeaders locks down a rendered email. The document runs no
+// scripts, loads images only inline, from the image proxy or, with
+// remoteImages, over HTTPS, and is sandboxed so links can only open in a new
+// window.
+func setRenderHeaders(w http.ResponseWriter, remoteImages bool) {
+	imgSrc := "'self' data: cid:"
+	if remoteImages {
+		imgSrc += " https:"
+	}
+	csp := fmt.Sprintf(
+		"default-src 'none'; "+
+			"img-src %s; "+
+			"style-src 'unsafe-inline'; "+
+			"base-uri 'none'; "+
+			"form-action 'none'; "+
+			"frame-ancestors 'self'; "+
+			"sandbox allow-popups allow-popups-to-escape-sandbox",
+		imgSrc,
+	)
+	w.Header().Set("Content-Security-Policy", csp)
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// requestIDMiddleware reuses a well-formed X-Request-ID from the client or
+// generates one, echoes it in the response and stores it in the context.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !isValidRequestID(id) {
+			id = logging.NewID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// tracingMiddleware continues a W3C trace from the request headers, or
+// starts a new one, and records a server span for the request. The span is
+// named after the normalized endpoint so addresses never reach the tracer.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.Propagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		endpoint := normalizeEndpoint(r.URL.Path)
+
+		ctx, span := tracing.Tracer().Start(ctx, r.Method+" "+endpoint,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", endpoint),
+				attribute.String("coresend.request_id", logging.RequestID(r.Context())),
+			),
+		)
+		defer span.End()
+
+		wrapped := newResponseWriter(w)
+		next.ServeHTTP(wrapped, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", wrapped.statusCode))
+		if wrapped.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(wrapped.statusCode))
+		}
+	})
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Public-Key, X-Signature, X-Timestamp, X-Nonce")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Public-Key, X-Signature, X-Timestamp, X-Nonce, X-Request-ID, traceparent, tracestate")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 		// TODO: restrict to actual domain in production
 
 		if r.Method == http.MethodOptions {
@@ -155,7 +245,12 @@ func loggingMiddleware(next http.Handler) http.Handler {
 		metrics.HTTPRequestsTotal.WithLabelValues(r.Method, endpoint, statusStr).Inc()
 		metrics.HTTPRequestDuration.WithLabelValues(r.Method, endpoint).Observe(duration)
 
-		log.Printf("%s %s %d %s", r.Method, r.URL.Path, wrapped.statusCode, time.Since(start))
+		slog.InfoContext(r.Context(), "HTTP request",
+			"method", r.Method,
+			logging.KeyPath, r.URL.Path,
+			"status", wrapped.statusCode,
+			"duration", time.Since(start),
+		)
 	})
 }
 
@@ -188,7 +283,11 @@ func isHexOrAlphanumeric(s string) bool {
 	return true
 }
 
-func signatureAuthMiddleware(s store.EmailStore) func(http.Handler) http.Handler {
+// signatureAuthMiddleware rejects requests whose timestamp is more than
+// maxSkew away from the server clock. A request first used when its
+// timestamp was maxSkew ahead is accepted for 2*maxSkew, so nonces are
+// remembered that long and a replayed request is refused.
+func signatureAuthMiddleware(s store.EmailStore, maxSkew time.Duration) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			pubKeyHex := r.Header.Get("X-Public-Key")
@@ -217,7 +316,7 @@ func signatureAuthMiddleware(s store.EmailStore) func(http.Handler) http.Handler
 
 			clientTime := time.Unix(ts, 0)
 			timeDiff := time.Since(clientTime)
-			if timeDiff > 5*time.Minute || timeDiff < -5*time.Minute {
+			if timeDiff > maxSkew || timeDiff < -maxSkew {
 				metrics.AuthFailuresTotal.WithLabelValues("expired_timestamp").Inc()
 				writeError(w, ErrCodeUnauthorized, "Request expired or invalid timestamp", http.StatusUnauthorized)
 				return
@@ -237,8 +336,7 @@ func signatureAuthMiddleware(s store.EmailStore) func(http.Handler) http.Handler
 				return
 			}
 
-			hash := sha256.Sum256(pubKeyBytes)
-			derivedAddress := hex.EncodeToString(hash[:])[:40]
+			derivedAddress := identity.AddressFromPublicKey(pubKeyBytes)
 
 			address := r.PathValue("address")
 			if address == "" {
@@ -262,20 +360,21 @@ func signatureAuthMiddleware(s store.EmailStore) func(http.Handler) http.Handler
 			bodyHash := sha256.Sum256(bodyBytes)
 			bodyHashHex := hex.EncodeToString(bodyHash[:])
 
-			payload := fmt.Sprintf("%s:%s:%s:%s:%s", r.Method, r.URL.Path, tsStr, bodyHashHex, nonce)
-
-			log.Printf("[VERIFY DEBUG] method=%s path=%s ts=%s bodyLen=%d bodyHash=%s nonce=%s payload=%s",
-				r.Method, r.URL.Path, tsStr, len(bodyBytes), bodyHashHex, nonce, payload)
+			payload := identity.SigningPayload(r.Method, r.URL.Path, tsStr, bodyHashHex, nonce)
 
 			if !ed25519.Verify(pubKeyBytes, []byte(payload), sigBytes) {
 				metrics.AuthFailuresTotal.WithLabelValues("signature_verification_failed").Inc()
+				slog.DebugContext(r.Context(), "Signature verification failed",
+					logging.KeyPublicKey, pubKeyHex,
+					logging.KeyPayload, payload,
+				)
 				writeError(w, ErrCodeUnauthorized, "Invalid cryptographic signature", http.StatusUnauthorized)
 				return
 			}
 
-			unique, err := s.CheckAndStoreNonce(r.Context(), nonce, 5*time.Minute)
+			unique, err := s.CheckAndStoreNonce(r.Context(), nonce, 2*maxSkew)
 			if err != nil {
-				log.Printf("Nonce check error: %v", err)
+				slog.ErrorContext(r.Context(), "Nonce check failed", "error", err)
 				writeError(w, ErrCodeInternalError, "Failed to verify nonce", http.StatusInternalServerError)
 				return
 			}
@@ -293,7 +392,7 @@ func signatureAuthMiddleware(s store.EmailStore) func(http.Handler) http.Handler
 func serveIndexWithNonce(w http.ResponseWriter, r *http.Request, staticDir string) {
 	nonce, ok := r.Context().Value(NonceContextKey).(string)
 	if !ok {
-		log.Println("Missing nonce in request context")
+		slog.ErrorContext(r.Context(), "Missing CSP nonce in request context")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -303,7 +402,7 @@ func serveIndexWithNonce(w http.ResponseWriter, r *http.Request, staticDir strin
 	filePath := staticDir + "/index.html"
 	content, err := os.ReadFile(filePath)
 	if err != nil {
-		log.Printf("Failed to read index.html: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to read index.html", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
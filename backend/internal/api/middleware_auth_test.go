@@ -226,7 +226,7 @@ func TestSignatureAuthMiddleware_Failures(t *testing.T) {
 				w.WriteHeader(http.StatusNoContent)
 			})
 
-			handler := signatureAuthMiddleware(store)(next)
+			handler := signatureAuthMiddleware(store, 5*time.Minute)(next)
 			rr := httptest.NewRecorder()
 			handler.ServeHTTP(rr, fixture.req)
 
@@ -270,7 +270,7 @@ func TestSignatureAuthMiddleware_Success(t *testing.T) {
 		w.WriteHeader(http.StatusNoContent)
 	})
 
-	handler := signatureAuthMiddleware(store)(next)
+	handler := signatureAuthMiddleware(store, 5*time.Minute)(next)
 	rr := httptest.NewRecorder()
 	handler.ServeHTTP(rr, fixture.req)
 
@@ -287,8 +287,8 @@ func TestSignatureAuthMiddleware_Success(t *testing.T) {
 	if store.lastNonce != fixture.nonce {
 		t.Fatalf("nonce passed to store = %q, want %q", store.lastNonce, fixture.nonce)
 	}
-	if store.lastNonceTTL != 5*time.Minute {
-		t.Fatalf("nonce ttl = %s, want %s", store.lastNonceTTL, 5*time.Minute)
+	if store.lastNonceTTL != 10*time.Minute {
+		t.Fatalf("nonce ttl = %s, want %s", store.lastNonceTTL, 10*time.Minute)
 	}
 	if store.nonceCallCount != 1 {
 		t.Fatalf("nonce store call count = %d, want %d", store.nonceCallCount, 1)
@@ -9,6 +9,9 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/fn-jakubkarp/coresend/internal/logging"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func newRequestWithNonceContext(t *testing.T, method, path, nonce string) *http.Request {
@@ -170,6 +173,98 @@ func TestCORSMiddleware(t *testing.T) {
 	})
 }
 
+func TestRequestIDMiddleware(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		incoming string
+		wantSame bool
+	}{
+		{name: "generates when missing"},
+		{name: "reuses well-formed id", incoming: "trace-abc_123.4", wantSame: true},
+		{name: "replaces malformed id", incoming: "bad id\nwith newline"},
+		{name: "replaces oversized id", incoming: strings.Repeat("a", 65)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var ctxID string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxID = logging.RequestID(r.Context())
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/health", nil)
+			if tc.incoming != "" {
+				req.Header.Set("X-Request-ID", tc.incoming)
+			}
+			rr := httptest.NewRecorder()
+			requestIDMiddleware(next).ServeHTTP(rr, req)
+
+			got := rr.Header().Get("X-Request-ID")
+			if got == "" {
+				t.Fatalf("missing X-Request-ID response header")
+			}
+			if got != ctxID {
+				t.Fatalf("context request ID = %q, want %q", ctxID, got)
+			}
+			if tc.wantSame != (got == tc.incoming) {
+				t.Fatalf("X-Request-ID = %q, incoming %q, wantSame %v", got, tc.incoming, tc.wantSame)
+			}
+		})
+	}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	t.Parallel()
+
+	const (
+		traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+		traceparent = "00-" + traceID + "-00f067aa0ba902b7-01"
+	)
+
+	tests := []struct {
+		name        string
+		traceparent string
+		wantTraceID string
+	}{
+		{name: "continues incoming trace", traceparent: traceparent, wantTraceID: traceID},
+		{name: "ignores malformed header", traceparent: "garbage"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got trace.SpanContext
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = trace.SpanContextFromContext(r.Context())
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/health", nil)
+			req.Header.Set("traceparent", tc.traceparent)
+			rr := httptest.NewRecorder()
+			tracingMiddleware(next).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want %d", rr.Code, http.StatusNoContent)
+			}
+			if tc.wantTraceID == "" {
+				if got.IsValid() {
+					t.Fatalf("span context = %s, want none from malformed header", got.TraceID())
+				}
+				return
+			}
+			if got.TraceID().String() != tc.wantTraceID {
+				t.Fatalf("trace ID = %s, want %s", got.TraceID(), tc.wantTraceID)
+			}
+		})
+	}
+}
+
 func TestResponseWriter(t *testing.T) {
 	t.Parallel()
 
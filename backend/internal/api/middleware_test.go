@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/fn-jakubkarp/coresend/internal/domains"
 	"github.com/fn-jakubkarp/coresend/internal/store"
 )
 
@@ -20,6 +21,9 @@ type fakeEmailStore struct {
 	clearInboxFn      func(ctx context.Context, addressBox string) (int64, error)
 	registerAddressFn func(ctx context.Context, addressBox string, duration time.Duration) error
 	isAddressActiveFn func(ctx context.Context, addressBox string) (bool, error)
+	addressDomainFn   func(ctx context.Context, addressBox string) (string, error)
+	getInlinePartFn   func(ctx context.Context, addressBox, emailID, contentID string) (*store.InlinePart, error)
+	getSourceFn       func(ctx context.Context, addressBox, emailID string) ([]byte, error)
 	pingFn            func(ctx context.Context) error
 
 	checkRateLimitFn func(ctx context.Context, key string, limit int, window time.Duration) (bool, int, error)
@@ -27,6 +31,7 @@ type fakeEmailStore struct {
 
 	lastSaveAddressBox string
 	lastSavedEmail     store.Email
+	lastSaveRetention  time.Duration
 	saveEmailCallCount int
 
 	lastGetEmailsAddress string
@@ -44,12 +49,16 @@ type fakeEmailStore struct {
 	clearInboxCallCount   int
 
 	lastRegisterAddress  string
+	lastRegisterDomain   string
 	lastRegisterDuration time.Duration
 	registerCallCount    int
 
 	lastIsAddressActiveAddress string
+	lastIsAddressActiveDomain  string
 	isAddressActiveCallCount   int
 
+	addressDomainCallCount int
+
 	pingCallCount int
 
 	lastRateLimitKey    string
@@ -61,9 +70,10 @@ type fakeEmailStore struct {
 	nonceCallCount int
 }
 
-func (f *fakeEmailStore) SaveEmail(ctx context.Context, addressBox string, email store.Email) error {
+func (f *fakeEmailStore) SaveEmail(ctx context.Context, addressBox string, email store.Email, retention time.Duration) error {
 	f.lastSaveAddressBox = addressBox
 	f.lastSavedEmail = email
+	f.lastSaveRetention = retention
 	f.saveEmailCallCount++
 	if f.saveEmailFn != nil {
 		return f.saveEmailFn(ctx, addressBox, email)
@@ -120,8 +130,9 @@ func (f *fakeEmailStore) CheckRateLimit(ctx context.Context, key string, limit i
 	return f.checkRateLimitFn(ctx, key, limit, window)
 }
 
-func (f *fakeEmailStore) RegisterAddress(ctx context.Context, addressBox string, duration time.Duration) error {
+func (f *fakeEmailStore) RegisterAddress(ctx context.Context, addressBox string, domain string, duration time.Duration) error {
 	f.lastRegisterAddress = addressBox
+	f.lastRegisterDomain = domain
 	f.lastRegisterDuration = duration
 	f.registerCallCount++
 	if f.registerAddressFn != nil {
@@ -130,8 +141,9 @@ func (f *fakeEmailStore) RegisterAddress(ctx context.Context, addressBox string,
 	return nil
 }
 
-func (f *fakeEmailStore) IsAddressActive(ctx context.Context, addressBox string) (bool, error) {
+func (f *fakeEmailStore) IsAddressActive(ctx context.Context, addressBox string, domain string) (bool, error) {
 	f.lastIsAddressActiveAddress = addressBox
+	f.lastIsAddressActiveDomain = domain
 	f.isAddressActiveCallCount++
 	if f.isAddressActiveFn != nil {
 		return f.isAddressActiveFn(ctx, addressBox)
@@ -139,6 +151,28 @@ func (f *fakeEmailStore) IsAddressActive(ctx context.Context, addressBox string)
 	return true, nil
 }
 
+func (f *fakeEmailStore) AddressDomain(ctx context.Context, addressBox string) (string, error) {
+	f.addressDomainCallCount++
+	if f.addressDomainFn != nil {
+		return f.addressDomainFn(ctx, addressBox)
+	}
+	return "", nil
+}
+
+func (f *fakeEmailStore) GetInlinePart(ctx context.Context, addressBox string, emailID string, contentID string) (*store.InlinePart, error) {
+	if f.getInlinePartFn != nil {
+		return f.getInlinePartFn(ctx, addressBox, emailID, contentID)
+	}
+	return nil, nil
+}
+
+func (f *fakeEmailStore) GetSource(ctx context.Context, addressBox string, emailID string) ([]byte, error) {
+	if f.getSourceFn != nil {
+		return f.getSourceFn(ctx, addressBox, emailID)
+	}
+	return nil, nil
+}
+
 func (f *fakeEmailStore) Ping(ctx context.Context) error {
 	f.pingCallCount++
 	if f.pingFn != nil {
@@ -157,6 +191,20 @@ func (f *fakeEmailStore) CheckAndStoreNonce(ctx context.Context, nonce string, t
 	return f.checkNonceFn(ctx, nonce, ttl)
 }
 
+func newTestDomains(t *testing.T, names ...string) *domains.Registry {
+	t.Helper()
+
+	policies := make([]domains.Policy, 0, len(names))
+	for _, name := range names {
+		policies = append(policies, domains.Policy{Name: name})
+	}
+	registry, err := domains.NewRegistry(policies...)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	return registry
+}
+
 func decodeErrorResponse(t *testing.T, rr *httptest.ResponseRecorder) ErrorResponse {
 	t.Helper()
 
@@ -4,31 +4,136 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/fn-jakubkarp/coresend/internal/domains"
+	"github.com/fn-jakubkarp/coresend/internal/filter"
+	"github.com/fn-jakubkarp/coresend/internal/forward"
+	"github.com/fn-jakubkarp/coresend/internal/health"
+	"github.com/fn-jakubkarp/coresend/internal/imageproxy"
+	"github.com/fn-jakubkarp/coresend/internal/send"
 	"github.com/fn-jakubkarp/coresend/internal/store"
+	"github.com/fn-jakubkarp/coresend/internal/webhook"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
-func NewRouter(s store.EmailStore, domain string, staticDir string) http.Handler {
-	handler := NewAPIHandler(s, domain)
+// RouterConfig holds the tunable parts of the API. Zero values select the
+// defaults.
+type RouterConfig struct {
+	StaticDir string
+	// InboxLimit applies to inbox reads, DeleteLimit to deletions. Their
+	// key prefixes are set by the router.
+	InboxLimit  RateLimitConfig
+	DeleteLimit RateLimitConfig
+	// AuthMaxSkew is the allowed clock drift of signed requests.
+	AuthMaxSkew time.Duration
+	// Health runs the readiness probes. When nil only the store is checked.
+	Health *health.Checker
+	// Webhooks enables the webhook routes when set.
+	Webhooks *webhook.Dispatcher
+	// Forwarder enables the forwarding routes when set.
+	Forwarder *forward.Forwarder
+	// Sender enables the sending routes when set.
+	Sender *send.Sender
+	// Filters enables the filtering routes when set.
+	Filters *filter.Filters
+	// Images enables the image proxy when set.
+	Images *imageproxy.Proxy
+	// URLKey enables inline images when set.
+	URLKey []byte
+}
+
+const defaultAuthMaxSkew = 5 * time.Minute
+
+func (c RouterConfig) withDefaults() RouterConfig {
+	if c.InboxLimit.Limit == 0 || c.InboxLimit.Window == 0 {
+		c.InboxLimit = RateLimitConfig{Limit: 60, Window: time.Minute}
+	}
+	if c.DeleteLimit.Limit == 0 || c.DeleteLimit.Window == 0 {
+		c.DeleteLimit = RateLimitConfig{Limit: 30, Window: time.Minute}
+	}
+	c.InboxLimit.KeyPrefix = "inbox"
+	c.DeleteLimit.KeyPrefix = "delete"
+	if c.AuthMaxSkew == 0 {
+		c.AuthMaxSkew = defaultAuthMaxSkew
+	}
+	return c
+}
+
+func NewRouter(s store.EmailStore, registry *domains.Registry, cfg RouterConfig) http.Handler {
+	cfg = cfg.withDefaults()
+	handler := NewAPIHandler(s, registry)
+	handler.Health = cfg.Health
+	handler.Webhooks = cfg.Webhooks
+	handler.Forwarder = cfg.Forwarder
+	handler.Sender = cfg.Sender
+	handler.Filters = cfg.Filters
+	handler.Images = cfg.Images
+	handler.URLKey = cfg.URLKey
 	mux := http.NewServeMux()
 
-	inboxLimit := RateLimitConfig{Limit: 60, Window: time.Minute, KeyPrefix: "inbox"}
-	deleteLimit := RateLimitConfig{Limit: 30, Window: time.Minute, KeyPrefix: "delete"}
+	staticDir := cfg.StaticDir
+	inboxLimit := cfg.InboxLimit
+	deleteLimit := cfg.DeleteLimit
+	auth := signatureAuthMiddleware(s, cfg.AuthMaxSkew)
 
 	mux.HandleFunc("GET /", wrap(serveStatic(staticDir), securityHeadersMiddleware, loggingMiddleware))
-	mux.HandleFunc("POST /api/register/{address}", wrap(handler.handleRegister, loggingMiddleware, corsMiddleware, signatureAuthMiddleware(s)))
+	mux.HandleFunc("POST /api/register/{address}", wrap(handler.handleRegister, loggingMiddleware, corsMiddleware, auth))
+
+	mux.HandleFunc("GET /api/inbox/{address}", wrap(handler.handleGetInbox, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+	mux.HandleFunc("GET /api/inbox/{address}/latest-code", wrap(handler.handleLatestCode, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+	mux.HandleFunc("GET /api/inbox/{address}/{emailId}", wrap(handler.handleGetEmail, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+	mux.HandleFunc("GET /api/inbox/{address}/{emailId}/render", wrap(handler.handleRenderEmail, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+	mux.HandleFunc("GET /api/inbox/{address}/{emailId}/structure", wrap(handler.handleGetStructure, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+	mux.HandleFunc("GET /api/inbox/{address}/{emailId}/source", wrap(handler.handleGetSource, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+	mux.HandleFunc("DELETE /api/inbox/{address}/{emailId}", wrap(handler.handleDeleteEmail, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, deleteLimit)))
+	mux.HandleFunc("DELETE /api/inbox/{address}", wrap(handler.handleClearInbox, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, deleteLimit)))
+
+	if handler.Webhooks != nil {
+		mux.HandleFunc("POST /api/webhooks/{address}", wrap(handler.handleCreateWebhook, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+		mux.HandleFunc("GET /api/webhooks/{address}", wrap(handler.handleListWebhooks, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+		mux.HandleFunc("DELETE /api/webhooks/{address}/{webhookId}", wrap(handler.handleDeleteWebhook, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, deleteLimit)))
+		mux.HandleFunc("GET /api/webhooks/{address}/deliveries", wrap(handler.handleWebhookDeliveries, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+		mux.HandleFunc("GET /api/webhooks/{address}/dead-letters", wrap(handler.handleWebhookDeadLetters, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+	}
+
+	if handler.Forwarder != nil {
+		mux.HandleFunc("POST /api/forwards/{address}", wrap(handler.handleCreateForward, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+		mux.HandleFunc("GET /api/forwards/{address}", wrap(handler.handleListForwards, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+		mux.HandleFunc("DELETE /api/forwards/{address}/{ruleId}", wrap(handler.handleDeleteForward, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, deleteLimit)))
+	}
+
+	if handler.Filters != nil {
+		mux.HandleFunc("POST /api/filters/{address}", wrap(handler.handleCreateFilter, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+		mux.HandleFunc("GET /api/filters/{address}", wrap(handler.handleListFilters, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+		mux.HandleFunc("DELETE /api/filters/{address}/{filterId}", wrap(handler.handleDeleteFilter, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, deleteLimit)))
+	}
 
-	mux.HandleFunc("GET /api/inbox/{address}", wrap(handler.handleGetInbox, loggingMiddleware, corsMiddleware, signatureAuthMiddleware(s), rateLimitMiddleware(s, inboxLimit)))
-	mux.HandleFunc("GET /api/inbox/{address}/{emailId}", wrap(handler.handleGetEmail, loggingMiddleware, corsMiddleware, signatureAuthMiddleware(s), rateLimitMiddleware(s, inboxLimit)))
-	mux.HandleFunc("DELETE /api/inbox/{address}/{emailId}", wrap(handler.handleDeleteEmail, loggingMiddleware, corsMiddleware, signatureAuthMiddleware(s), rateLimitMiddleware(s, deleteLimit)))
-	mux.HandleFunc("DELETE /api/inbox/{address}", wrap(handler.handleClearInbox, loggingMiddleware, corsMiddleware, signatureAuthMiddleware(s), rateLimitMiddleware(s, deleteLimit)))
+	if handler.Images != nil {
+		// Images load from <img> tags, which cannot sign requests; the URL
+		// signature stands in for authentication, and repeated loads are
+		// served from the cache
+		mux.HandleFunc("GET "+imageproxy.Path, wrap(handler.handleProxyImage, loggingMiddleware))
+	}
+
+	if len(handler.URLKey) > 0 {
+		// Like proxied images, inline images are authorized by their URL
+		mux.HandleFunc("GET /api/inbox/{address}/{emailId}/inline/{contentId}", wrap(handler.handleInlinePart, loggingMiddleware))
+	}
+
+	if handler.Sender != nil {
+		mux.HandleFunc("POST /api/inbox/{address}/send", wrap(handler.handleSend, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+		mux.HandleFunc("POST /api/inbox/{address}/reply/{emailId}", wrap(handler.handleReply, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+		mux.HandleFunc("GET /api/inbox/{address}/sent", wrap(handler.handleGetSent, loggingMiddleware, corsMiddleware, auth, rateLimitMiddleware(s, inboxLimit)))
+	}
 
+	mux.HandleFunc("GET /api/domains", wrap(handler.handleListDomains, loggingMiddleware, corsMiddleware))
 	mux.HandleFunc("GET /api/health", wrap(handler.handleHealth, loggingMiddleware, corsMiddleware))
+	mux.HandleFunc("GET /api/health/live", wrap(handler.handleLive, loggingMiddleware, corsMiddleware))
+	mux.HandleFunc("GET /api/health/ready", wrap(handler.handleReady, loggingMiddleware, corsMiddleware))
 	mux.Handle("GET /metrics", promhttp.Handler())
 	mux.HandleFunc("GET /docs/", httpSwagger.WrapHandler)
 
-	return mux
+	return requestIDMiddleware(tracingMiddleware(mux))
 }
 
 func wrap(handler http.HandlerFunc, middlewares ...func(http.Handler) http.Handler) http.HandlerFunc {
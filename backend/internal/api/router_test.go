@@ -16,6 +16,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/fn-jakubkarp/coresend/internal/health"
+	"github.com/fn-jakubkarp/coresend/internal/imageproxy"
 	"github.com/fn-jakubkarp/coresend/internal/store"
 	"github.com/google/uuid"
 )
@@ -99,7 +101,7 @@ func TestNewRouter_RegisterRoute_WithValidAuth(t *testing.T) {
 	t.Parallel()
 
 	fakeStore := &fakeEmailStore{}
-	router := NewRouter(fakeStore, "coresend.dev", writeStaticFixture(t))
+	router := NewRouter(fakeStore, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t)})
 
 	req, address := newSignedRouteRequest(t, http.MethodPost, "/api/register/{address}", nil, time.Now())
 	rr := httptest.NewRecorder()
@@ -131,7 +133,7 @@ func TestNewRouter_ProtectedRoutes_RejectMissingAuth(t *testing.T) {
 	t.Parallel()
 
 	fakeStore := &fakeEmailStore{}
-	router := NewRouter(fakeStore, "coresend.dev", writeStaticFixture(t))
+	router := NewRouter(fakeStore, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t)})
 
 	tests := []struct {
 		name   string
@@ -148,6 +150,26 @@ func TestNewRouter_ProtectedRoutes_RejectMissingAuth(t *testing.T) {
 			method: http.MethodDelete,
 			path:   "/api/inbox/" + testValidAddress + "/email-1",
 		},
+		{
+			name:   "render route",
+			method: http.MethodGet,
+			path:   "/api/inbox/" + testValidAddress + "/email-1/render",
+		},
+		{
+			name:   "structure route",
+			method: http.MethodGet,
+			path:   "/api/inbox/" + testValidAddress + "/email-1/structure",
+		},
+		{
+			name:   "source route",
+			method: http.MethodGet,
+			path:   "/api/inbox/" + testValidAddress + "/email-1/source",
+		},
+		{
+			name:   "latest code route",
+			method: http.MethodGet,
+			path:   "/api/inbox/" + testValidAddress + "/latest-code",
+		},
 	}
 
 	for _, tc := range tests {
@@ -169,6 +191,282 @@ func TestNewRouter_ProtectedRoutes_RejectMissingAuth(t *testing.T) {
 	}
 }
 
+func TestNewRouter_ImageRoute(t *testing.T) {
+	t.Parallel()
+
+	router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{
+		StaticDir: writeStaticFixture(t),
+		Images:    &imageproxy.Proxy{Key: []byte("0123456789abcdef")},
+	})
+
+	// The signature in the URL replaces request signing
+	req := httptest.NewRequest(http.MethodGet, "/api/images?url=https%3A%2F%2Fexample.com%2Fa.png&sig=bad", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+	if errResp := decodeErrorResponse(t, rr); errResp.Error.Code != ErrCodeInvalidSignature {
+		t.Fatalf("error.code = %q, want %q", errResp.Error.Code, ErrCodeInvalidSignature)
+	}
+}
+
+func TestNewRouter_WebhookRoutes(t *testing.T) {
+	t.Parallel()
+
+	paths := []struct {
+		method string
+		path   string
+	}{
+		{method: http.MethodPost, path: "/api/webhooks/" + testValidAddress},
+		{method: http.MethodGet, path: "/api/webhooks/" + testValidAddress},
+		{method: http.MethodDelete, path: "/api/webhooks/" + testValidAddress + "/hook-1"},
+		{method: http.MethodGet, path: "/api/webhooks/" + testValidAddress + "/deliveries"},
+		{method: http.MethodGet, path: "/api/webhooks/" + testValidAddress + "/dead-letters"},
+	}
+
+	t.Run("disabled without dispatcher", func(t *testing.T) {
+		t.Parallel()
+
+		router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t)})
+		for _, p := range paths {
+			req := httptest.NewRequest(p.method, p.path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code == http.StatusUnauthorized {
+				t.Fatalf("%s %s status = %d, want the route unregistered", p.method, p.path, rr.Code)
+			}
+		}
+	})
+
+	t.Run("require auth", func(t *testing.T) {
+		t.Parallel()
+
+		router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{
+			StaticDir: writeStaticFixture(t),
+			Webhooks:  newTestWebhooks(t),
+		})
+		for _, p := range paths {
+			req := httptest.NewRequest(p.method, p.path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("%s %s status = %d, want %d", p.method, p.path, rr.Code, http.StatusUnauthorized)
+			}
+		}
+	})
+
+	t.Run("list with valid auth", func(t *testing.T) {
+		t.Parallel()
+
+		router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{
+			StaticDir: writeStaticFixture(t),
+			Webhooks:  newTestWebhooks(t),
+		})
+		req, _ := newSignedRouteRequest(t, http.MethodGet, "/api/webhooks/{address}", nil, time.Now())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+		}
+	})
+}
+
+func TestNewRouter_ForwardRoutes(t *testing.T) {
+	t.Parallel()
+
+	paths := []struct {
+		method string
+		path   string
+	}{
+		{method: http.MethodPost, path: "/api/forwards/" + testValidAddress},
+		{method: http.MethodGet, path: "/api/forwards/" + testValidAddress},
+		{method: http.MethodDelete, path: "/api/forwards/" + testValidAddress + "/rule-1"},
+	}
+
+	t.Run("disabled without forwarder", func(t *testing.T) {
+		t.Parallel()
+
+		router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t)})
+		for _, p := range paths {
+			req := httptest.NewRequest(p.method, p.path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code == http.StatusUnauthorized {
+				t.Fatalf("%s %s status = %d, want the route unregistered", p.method, p.path, rr.Code)
+			}
+		}
+	})
+
+	t.Run("require auth", func(t *testing.T) {
+		t.Parallel()
+
+		registry := newTestDomains(t, "coresend.dev")
+		router := NewRouter(&fakeEmailStore{}, registry, RouterConfig{
+			StaticDir: writeStaticFixture(t),
+			Forwarder: newTestForwarder(t, registry),
+		})
+		for _, p := range paths {
+			req := httptest.NewRequest(p.method, p.path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("%s %s status = %d, want %d", p.method, p.path, rr.Code, http.StatusUnauthorized)
+			}
+		}
+	})
+
+	t.Run("list with valid auth", func(t *testing.T) {
+		t.Parallel()
+
+		registry := newTestDomains(t, "coresend.dev")
+		router := NewRouter(&fakeEmailStore{}, registry, RouterConfig{
+			StaticDir: writeStaticFixture(t),
+			Forwarder: newTestForwarder(t, registry),
+		})
+		req, _ := newSignedRouteRequest(t, http.MethodGet, "/api/forwards/{address}", nil, time.Now())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+		}
+	})
+}
+
+func TestNewRouter_FilterRoutes(t *testing.T) {
+	t.Parallel()
+
+	paths := []struct {
+		method string
+		path   string
+	}{
+		{method: http.MethodPost, path: "/api/filters/" + testValidAddress},
+		{method: http.MethodGet, path: "/api/filters/" + testValidAddress},
+		{method: http.MethodDelete, path: "/api/filters/" + testValidAddress + "/rule-1"},
+	}
+
+	t.Run("disabled without filters", func(t *testing.T) {
+		t.Parallel()
+
+		router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t)})
+		for _, p := range paths {
+			req := httptest.NewRequest(p.method, p.path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code == http.StatusUnauthorized {
+				t.Fatalf("%s %s status = %d, want the route unregistered", p.method, p.path, rr.Code)
+			}
+		}
+	})
+
+	t.Run("require auth", func(t *testing.T) {
+		t.Parallel()
+
+		router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{
+			StaticDir: writeStaticFixture(t),
+			Filters:   newTestFilters(t),
+		})
+		for _, p := range paths {
+			req := httptest.NewRequest(p.method, p.path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("%s %s status = %d, want %d", p.method, p.path, rr.Code, http.StatusUnauthorized)
+			}
+		}
+	})
+
+	t.Run("list with valid auth", func(t *testing.T) {
+		t.Parallel()
+
+		router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{
+			StaticDir: writeStaticFixture(t),
+			Filters:   newTestFilters(t),
+		})
+		req, _ := newSignedRouteRequest(t, http.MethodGet, "/api/filters/{address}", nil, time.Now())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+		}
+	})
+}
+
+func TestNewRouter_SendRoutes(t *testing.T) {
+	t.Parallel()
+
+	paths := []struct {
+		method string
+		path   string
+	}{
+		{method: http.MethodPost, path: "/api/inbox/" + testValidAddress + "/send"},
+		{method: http.MethodPost, path: "/api/inbox/" + testValidAddress + "/reply/email-1"},
+		{method: http.MethodGet, path: "/api/inbox/" + testValidAddress + "/sent"},
+	}
+
+	t.Run("disabled without sender", func(t *testing.T) {
+		t.Parallel()
+
+		router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t)})
+		for _, p := range paths[:2] {
+			req := httptest.NewRequest(p.method, p.path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusMethodNotAllowed && rr.Code != http.StatusNotFound {
+				t.Fatalf("%s %s status = %d, want the route unregistered", p.method, p.path, rr.Code)
+			}
+		}
+	})
+
+	t.Run("require auth", func(t *testing.T) {
+		t.Parallel()
+
+		router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{
+			StaticDir: writeStaticFixture(t),
+			Sender:    newTestSender(t, "coresend.dev"),
+		})
+		for _, p := range paths {
+			req := httptest.NewRequest(p.method, p.path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("%s %s status = %d, want %d", p.method, p.path, rr.Code, http.StatusUnauthorized)
+			}
+		}
+	})
+
+	t.Run("sent folder with valid auth", func(t *testing.T) {
+		t.Parallel()
+
+		router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{
+			StaticDir: writeStaticFixture(t),
+			Sender:    newTestSender(t, "coresend.dev"),
+		})
+		req, _ := newSignedRouteRequest(t, http.MethodGet, "/api/inbox/{address}/sent", nil, time.Now())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+		}
+		if resp := decodeJSONResponse[SentResponse](t, rr); resp.Count != 0 {
+			t.Fatalf("response = %+v, want an empty sent folder", resp)
+		}
+	})
+}
+
 func TestNewRouter_ProtectedRoutes_PassWithValidAuth(t *testing.T) {
 	t.Parallel()
 
@@ -194,7 +492,7 @@ func TestNewRouter_ProtectedRoutes_PassWithValidAuth(t *testing.T) {
 				return nil
 			},
 		}
-		router := NewRouter(fakeStore, "coresend.dev", writeStaticFixture(t))
+		router := NewRouter(fakeStore, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t)})
 
 		req, address := newSignedRouteRequest(t, http.MethodGet, "/api/inbox/{address}", nil, time.Now())
 		rr := httptest.NewRecorder()
@@ -231,7 +529,7 @@ func TestNewRouter_ProtectedRoutes_PassWithValidAuth(t *testing.T) {
 				return nil
 			},
 		}
-		router := NewRouter(fakeStore, "coresend.dev", writeStaticFixture(t))
+		router := NewRouter(fakeStore, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t)})
 
 		req, address := newSignedRouteRequest(t, http.MethodDelete, "/api/inbox/{address}/email-1", nil, time.Now())
 		rr := httptest.NewRecorder()
@@ -292,7 +590,7 @@ func TestNewRouter_RateLimitEnforced_OnInboxAndDelete(t *testing.T) {
 					return nil
 				},
 			}
-			router := NewRouter(fakeStore, "coresend.dev", writeStaticFixture(t))
+			router := NewRouter(fakeStore, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t)})
 
 			req, _ := newSignedRouteRequest(t, tc.method, tc.pathTmpl, nil, time.Now())
 			req.RemoteAddr = "192.0.2.10:7777"
@@ -319,7 +617,7 @@ func TestNewRouter_HealthRoute_NoAuthRequired(t *testing.T) {
 	t.Run("redis connected", func(t *testing.T) {
 		t.Parallel()
 
-		router := NewRouter(&fakeEmailStore{}, "coresend.dev", writeStaticFixture(t))
+		router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t)})
 		req := httptest.NewRequest(http.MethodGet, "/api/health", nil)
 		rr := httptest.NewRecorder()
 		router.ServeHTTP(rr, req)
@@ -337,7 +635,7 @@ func TestNewRouter_HealthRoute_NoAuthRequired(t *testing.T) {
 				return fmt.Errorf("redis down")
 			},
 		}
-		router := NewRouter(fakeStore, "coresend.dev", writeStaticFixture(t))
+		router := NewRouter(fakeStore, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t)})
 		req := httptest.NewRequest(http.MethodGet, "/api/health", nil)
 		rr := httptest.NewRecorder()
 		router.ServeHTTP(rr, req)
@@ -346,12 +644,33 @@ func TestNewRouter_HealthRoute_NoAuthRequired(t *testing.T) {
 			t.Fatalf("status = %d, want %d", rr.Code, http.StatusServiceUnavailable)
 		}
 	})
+
+	t.Run("live and ready routes", func(t *testing.T) {
+		t.Parallel()
+
+		checker := &health.Checker{Store: &fakeEmailStore{}}
+		router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t), Health: checker})
+		checker.Drain()
+
+		for path, want := range map[string]int{
+			"/api/health/live":  http.StatusOK,
+			"/api/health/ready": http.StatusServiceUnavailable,
+		} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != want {
+				t.Fatalf("GET %s status = %d, want %d", path, rr.Code, want)
+			}
+		}
+	})
 }
 
 func TestNewRouter_DocsAndMetricsReachable(t *testing.T) {
 	t.Parallel()
 
-	router := NewRouter(&fakeEmailStore{}, "coresend.dev", writeStaticFixture(t))
+	router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t)})
 
 	reqMetrics := httptest.NewRequest(http.MethodGet, "/metrics", nil)
 	rrMetrics := httptest.NewRecorder()
@@ -375,7 +694,7 @@ func TestNewRouter_DocsAndMetricsReachable(t *testing.T) {
 func TestNewRouter_MethodMismatchAndUnknownPath(t *testing.T) {
 	t.Parallel()
 
-	router := NewRouter(&fakeEmailStore{}, "coresend.dev", writeStaticFixture(t))
+	router := NewRouter(&fakeEmailStore{}, newTestDomains(t, "coresend.dev"), RouterConfig{StaticDir: writeStaticFixture(t)})
 
 	reqMismatch := httptest.NewRequest(http.MethodPost, "/api/health", nil)
 	rrMismatch := httptest.NewRecorder()
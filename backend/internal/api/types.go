@@ -1,17 +1,139 @@
 package api
 
+type RegisterRequest struct {
+	Domain string `json:"domain,omitempty" example:"coresend.io"`
+}
+
 type RegisterResponse struct {
 	Registered bool   `json:"registered" example:"true" validate:"required"`
 	Address    string `json:"address" example:"a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2" validate:"required,hexadecimal"`
+	Domain     string `json:"domain" example:"coresend.io"`
+	Email      string `json:"email" example:"a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2@coresend.io"`
 	ExpiresIn  int    `json:"expires_in" example:"86400" validate:"required,gt=0"`
 }
+
+type DomainResponse struct {
+	Name             string `json:"name" example:"coresend.io"`
+	Default          bool   `json:"default" example:"true"`
+	RetentionSeconds int    `json:"retention_seconds" example:"86400"`
+	MaxMessageBytes  int64  `json:"max_message_bytes" example:"1048576"`
+}
+
+type DomainsResponse struct {
+	Domains []DomainResponse `json:"domains"`
+}
 type EmailResponse struct {
-	ID         string   `json:"id" example:"550e8400-e29b-41d4-a716-446655440000" binding:"required"`
-	From       string   `json:"from" example:"sender@example.com"`
-	To         []string `json:"to" example:"a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2@coresend.io"`
-	Subject    string   `json:"subject" example:"Hello World"`
-	Body       string   `json:"body" example:"This is the email body content"`
-	ReceivedAt string   `json:"received_at" example:"2024-01-01T12:00:00Z"`
+	ID string `json:"id" example:"550e8400-e29b-41d4-a716-446655440000" binding:"required"`
+	// From is the envelope sender.
+	From string `json:"from" example:"sender@example.com"`
+	// To and Cc are the recipients in the message header.
+	To      []string `json:"to" example:"Alice <a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2@coresend.io>"`
+	Cc      []string `json:"cc,omitempty" example:"team@example.com"`
+	Subject string   `json:"subject" example:"Hello World"`
+	// Body is sanitized when it is HTML.
+	Body        string `json:"body" example:"This is the email body content"`
+	ContentType string `json:"content_type,omitempty" example:"text/html" enums:"text/html,text/plain"`
+	// Charset is what the body was declared in; it is always returned as
+	// UTF-8.
+	Charset    string       `json:"charset,omitempty" example:"iso-2022-jp"`
+	ReceivedAt string       `json:"received_at" example:"2024-01-01T12:00:00Z"`
+	TLS        *TLSResponse `json:"tls,omitempty"`
+	// Labels are added by the address's filtering rules.
+	Labels []string `json:"labels,omitempty" example:"marketing"`
+	// Duplicates counts copies of the message that were received again and
+	// not saved.
+	Duplicates int `json:"duplicates,omitempty" example:"1"`
+	// ExpiresAt is set when a filtering rule removes the email early.
+	ExpiresAt string `json:"expires_at,omitempty" example:"2024-01-01T12:30:00Z"`
+	// Extracted is omitted when nothing was found in the email.
+	Extracted *ExtractedResponse `json:"extracted,omitempty"`
+	// Trackers lists what sanitizing the body removed, if anything.
+	Trackers *TrackersResponse `json:"trackers,omitempty"`
+	// Inline are the images embedded in the message for its HTML body.
+	Inline []InlinePartResponse `json:"inline,omitempty"`
+	// Envelope is omitted for emails received before it was recorded.
+	Envelope *EnvelopeResponse `json:"envelope,omitempty"`
+}
+
+// EnvelopeResponse is the SMTP transaction that delivered an email to the
+// inbox. Only the inbox's own recipient is shown.
+type EnvelopeResponse struct {
+	MailFrom  string `json:"mail_from" example:"bounces@example.com"`
+	Recipient string `json:"recipient" example:"a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2@coresend.io"`
+	ClientIP  string `json:"client_ip,omitempty" example:"192.0.2.10"`
+	Helo      string `json:"helo,omitempty" example:"mail.example.com"`
+}
+
+// InlinePartResponse is an embedded image. URL loads it without request
+// signing until it expires; sanitized bodies already point at it.
+type InlinePartResponse struct {
+	ContentID   string `json:"content_id" example:"logo@example.com"`
+	ContentType string `json:"content_type" example:"image/png"`
+	Filename    string `json:"filename,omitempty" example:"logo.png"`
+	Size        int    `json:"size" example:"2048"`
+	URL         string `json:"url,omitempty" example:"/api/inbox/a1b2c3/550e8400-e29b-41d4-a716-446655440000/inline/logo@example.com?expires=1704114000&sig=..."`
+}
+
+// MIMEPartResponse is a part of a received message. Path numbers parts from
+// 1 within their parent, joined with dots; the message itself has an empty
+// path. Size is the decoded size, or the sum of the parts for multiparts.
+type MIMEPartResponse struct {
+	Path        string             `json:"path" example:"1.2"`
+	ContentType string             `json:"content_type" example:"application/pdf"`
+	Charset     string             `json:"charset,omitempty" example:"utf-8"`
+	Encoding    string             `json:"encoding,omitempty" example:"base64"`
+	Disposition string             `json:"disposition,omitempty" example:"attachment" enums:"inline,attachment"`
+	Filename    string             `json:"filename,omitempty" example:"invoice.pdf"`
+	ContentID   string             `json:"content_id,omitempty" example:"logo@example.com"`
+	SHA256      string             `json:"sha256,omitempty" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	Size        int                `json:"size" example:"48213"`
+	Parts       []MIMEPartResponse `json:"parts,omitempty"`
+}
+
+// TrackersResponse lists the trackers removed from an HTML body.
+type TrackersResponse struct {
+	// Pixels are the sources of removed tracking images.
+	Pixels []string `json:"pixels,omitempty" example:"https://u1.ct.sendgrid.net/wf/open?upn=abc"`
+	// Links are click-tracking redirects replaced by their destination.
+	Links []string `json:"links,omitempty" example:"https://www.google.com/url?q=https://example.com/"`
+}
+
+// ExtractedResponse holds the codes and links found in an email when it was
+// received.
+type ExtractedResponse struct {
+	// Codes are one-time code candidates, most likely first.
+	Codes       []CodeResponse `json:"codes,omitempty"`
+	Links       []LinkResponse `json:"links,omitempty"`
+	Unsubscribe []string       `json:"unsubscribe,omitempty" example:"mailto:unsubscribe@example.com"`
+}
+
+type CodeResponse struct {
+	Value      string  `json:"value" example:"482913"`
+	Confidence float64 `json:"confidence" example:"0.9"`
+}
+
+type LinkResponse struct {
+	URL  string `json:"url" example:"https://example.com/verify?token=abc"`
+	Kind string `json:"kind" example:"verify" enums:"verify,reset,login"`
+}
+
+// LatestCodeResponse is the most likely code of the newest email that has
+// one.
+type LatestCodeResponse struct {
+	Code       string         `json:"code" example:"482913"`
+	Confidence float64        `json:"confidence" example:"0.9"`
+	EmailID    string         `json:"email_id" example:"550e8400-e29b-41d4-a716-446655440000"`
+	Subject    string         `json:"subject" example:"Your verification code"`
+	ReceivedAt string         `json:"received_at" example:"2024-01-01T12:00:00Z"`
+	Links      []LinkResponse `json:"links,omitempty"`
+}
+
+// TLSResponse describes how the delivering SMTP connection was secured. It is
+// omitted for emails received before this was recorded.
+type TLSResponse struct {
+	Encrypted   bool   `json:"encrypted" example:"true"`
+	Version     string `json:"version,omitempty" example:"TLS 1.3"`
+	CipherSuite string `json:"cipher_suite,omitempty" example:"TLS_AES_128_GCM_SHA256"`
 }
 
 type InboxResponse struct {
@@ -37,7 +159,179 @@ type ErrorDetails struct {
 	Details map[string]interface{} `json:"details,omitempty"`
 }
 
+// HealthResponse is the legacy /api/health shape, derived from readiness.
 type HealthResponse struct {
 	Status   string            `json:"status" example:"connected"`
 	Services map[string]string `json:"services"`
 }
+
+type BuildInfoResponse struct {
+	Version   string `json:"version" example:"v1.2.0"`
+	Commit    string `json:"commit,omitempty" example:"0f87b1e"`
+	GoVersion string `json:"go_version" example:"go1.25.6"`
+}
+
+type LivenessResponse struct {
+	Status string            `json:"status" example:"alive"`
+	Build  BuildInfoResponse `json:"build"`
+}
+
+type ReadinessResponse struct {
+	Status       string                   `json:"status" example:"ready" enums:"ready,not_ready,draining"`
+	Checks       map[string]CheckResponse `json:"checks"`
+	Certificates []CertificateResponse    `json:"certificates,omitempty"`
+	Build        BuildInfoResponse        `json:"build"`
+}
+
+type CheckResponse struct {
+	Status    string  `json:"status" example:"ok" enums:"ok,failing"`
+	LatencyMS float64 `json:"latency_ms" example:"0.42"`
+	Error     string  `json:"error,omitempty" example:"dial tcp :1025: connect: connection refused"`
+}
+
+type CertificateResponse struct {
+	Subject  string   `json:"subject" example:"mx.coresend.io"`
+	DNSNames []string `json:"dns_names,omitempty" example:"mx.coresend.io"`
+	NotAfter string   `json:"not_after" example:"2026-01-01T00:00:00Z"`
+	Valid    bool     `json:"valid" example:"true"`
+}
+
+type WebhookRequest struct {
+	URL string `json:"url" example:"https://ci.example.com/hooks/coresend"`
+	// IncludeBody adds the email body to each delivery.
+	IncludeBody bool `json:"include_body,omitempty" example:"false"`
+}
+
+type ForwardRequest struct {
+	Destination string `json:"destination" example:"tester@example.com"`
+	// FromContains and SubjectContains limit the rule to matching mail.
+	FromContains    string `json:"from_contains,omitempty" example:"ci@example.org"`
+	SubjectContains string `json:"subject_contains,omitempty" example:"verification"`
+}
+
+type ForwardRuleResponse struct {
+	ID              string `json:"id" example:"3c9e1d7a-5b2f-4e8a-9c6d-1f0a2b3c4d5e"`
+	Destination     string `json:"destination" example:"tester@example.com"`
+	FromContains    string `json:"from_contains,omitempty" example:"ci@example.org"`
+	SubjectContains string `json:"subject_contains,omitempty" example:"verification"`
+	CreatedAt       string `json:"created_at" example:"2024-01-01T12:00:00Z"`
+}
+
+type ForwardRulesResponse struct {
+	Rules []ForwardRuleResponse `json:"rules"`
+}
+
+// FilterRequest needs at least one condition; every condition given must
+// match. Label goes with the label action and ExpireMinutes with expire.
+type FilterRequest struct {
+	FromGlob       string `json:"from_glob,omitempty" example:"*@news.example.com"`
+	SubjectRegex   string `json:"subject_regex,omitempty" example:"(?i)sale|% off"`
+	Header         string `json:"header,omitempty" example:"List-Unsubscribe"`
+	HeaderContains string `json:"header_contains,omitempty" example:"example.com"`
+	MinSize        int64  `json:"min_size,omitempty" example:"1048576"`
+	Action         string `json:"action" enums:"drop,reject,label,expire" example:"label"`
+	Label          string `json:"label,omitempty" example:"marketing"`
+	ExpireMinutes  int    `json:"expire_minutes,omitempty" example:"30"`
+}
+
+type FilterRuleResponse struct {
+	ID             string `json:"id" example:"8b0f3e2a-6c1d-4f7e-9a2b-5d4c3e2f1a0b"`
+	FromGlob       string `json:"from_glob,omitempty" example:"*@news.example.com"`
+	SubjectRegex   string `json:"subject_regex,omitempty" example:"(?i)sale|% off"`
+	Header         string `json:"header,omitempty" example:"List-Unsubscribe"`
+	HeaderContains string `json:"header_contains,omitempty" example:"example.com"`
+	MinSize        int64  `json:"min_size,omitempty" example:"1048576"`
+	Action         string `json:"action" example:"label"`
+	Label          string `json:"label,omitempty" example:"marketing"`
+	ExpireMinutes  int    `json:"expire_minutes,omitempty" example:"30"`
+	CreatedAt      string `json:"created_at" example:"2024-01-01T12:00:00Z"`
+}
+
+type FilterRulesResponse struct {
+	Rules []FilterRuleResponse `json:"rules"`
+}
+
+type SendRequest struct {
+	To      []string `json:"to" example:"tester@example.com"`
+	Subject string   `json:"subject" example:"Hello"`
+	// Text and HTML are the body; at least one should be set. When both are
+	// set the message offers them as alternatives.
+	Text string `json:"text,omitempty" example:"Hi there"`
+	HTML string `json:"html,omitempty" example:"<p>Hi there</p>"`
+}
+
+// ReplyRequest overrides the defaults taken from the original email.
+type ReplyRequest struct {
+	// To defaults to the original's Reply-To or sender.
+	To []string `json:"to,omitempty" example:"tester@example.com"`
+	// Subject defaults to the original's with a "Re:" prefix.
+	Subject string `json:"subject,omitempty" example:"Re: Hello"`
+	Text    string `json:"text,omitempty" example:"Thanks"`
+	HTML    string `json:"html,omitempty" example:"<p>Thanks</p>"`
+}
+
+type SentEmailResponse struct {
+	ID         string   `json:"id" example:"550e8400-e29b-41d4-a716-446655440000"`
+	MessageID  string   `json:"message_id" example:"550e8400-e29b-41d4-a716-446655440000@coresend.io"`
+	From       string   `json:"from" example:"a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2@coresend.io"`
+	To         []string `json:"to" example:"tester@example.com"`
+	Subject    string   `json:"subject" example:"Hello"`
+	Body       string   `json:"body" example:"Hi there"`
+	References []string `json:"references,omitempty" example:"CAF1234@mail.example.com"`
+	SentAt     string   `json:"sent_at" example:"2024-01-01T12:00:00Z"`
+}
+
+type SentResponse struct {
+	Count  int                 `json:"count" example:"1"`
+	Emails []SentEmailResponse `json:"emails"`
+}
+
+type WebhookResponse struct {
+	ID          string `json:"id" example:"6f1c2a9e-3b7d-4c1e-9a57-2d8e4f0b1c3a"`
+	URL         string `json:"url" example:"https://ci.example.com/hooks/coresend"`
+	IncludeBody bool   `json:"include_body" example:"false"`
+	CreatedAt   string `json:"created_at" example:"2024-01-01T12:00:00Z"`
+}
+
+// WebhookCreatedResponse is the only response that includes the secret.
+type WebhookCreatedResponse struct {
+	ID          string `json:"id" example:"6f1c2a9e-3b7d-4c1e-9a57-2d8e4f0b1c3a"`
+	URL         string `json:"url" example:"https://ci.example.com/hooks/coresend"`
+	IncludeBody bool   `json:"include_body" example:"false"`
+	CreatedAt   string `json:"created_at" example:"2024-01-01T12:00:00Z"`
+	Secret      string `json:"secret" example:"whsec_3f9a..."`
+	ExpiresIn   int    `json:"expires_in" example:"86400"`
+}
+
+type WebhooksResponse struct {
+	Webhooks []WebhookResponse `json:"webhooks"`
+}
+
+type WebhookDeliveryResponse struct {
+	JobID      string `json:"job_id" example:"0b6a6f8e-8d1f-4a43-9f0e-5c1d2b3a4e5f"`
+	WebhookID  string `json:"webhook_id" example:"6f1c2a9e-3b7d-4c1e-9a57-2d8e4f0b1c3a"`
+	EmailID    string `json:"email_id" example:"550e8400-e29b-41d4-a716-446655440000"`
+	Attempt    int    `json:"attempt" example:"1"`
+	Status     string `json:"status" example:"delivered" enums:"delivered,retrying,dead"`
+	StatusCode int    `json:"status_code,omitempty" example:"200"`
+	Error      string `json:"error,omitempty" example:"receiver answered 503 Service Unavailable"`
+	DurationMS int64  `json:"duration_ms" example:"42"`
+	At         string `json:"at" example:"2024-01-01T12:00:01Z"`
+}
+
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDeliveryResponse `json:"deliveries"`
+}
+
+type DeadLetterResponse struct {
+	JobID     string `json:"job_id" example:"0b6a6f8e-8d1f-4a43-9f0e-5c1d2b3a4e5f"`
+	WebhookID string `json:"webhook_id" example:"6f1c2a9e-3b7d-4c1e-9a57-2d8e4f0b1c3a"`
+	EmailID   string `json:"email_id" example:"550e8400-e29b-41d4-a716-446655440000"`
+	Attempts  int    `json:"attempts" example:"8"`
+	LastError string `json:"last_error" example:"receiver answered 503 Service Unavailable"`
+	CreatedAt string `json:"created_at" example:"2024-01-01T12:00:00Z"`
+}
+
+type DeadLettersResponse struct {
+	DeadLetters []DeadLetterResponse `json:"dead_letters"`
+}
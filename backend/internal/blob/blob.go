@@ -0,0 +1,152 @@
+// Package blob stores content by its SHA-256, in a directory or an
+// S3-compatible bucket, so content received many times is kept once.
+//
+// Content is written as it is read, so parts of any size pass through in a
+// fixed amount of memory.
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Open for content that is not stored.
+var ErrNotFound = errors.New("blob not found")
+
+// Ref identifies stored content.
+type Ref struct {
+	// SHA256 is the hex digest of the content.
+	SHA256 string
+	Size   int64
+}
+
+// Store keeps content by its SHA-256.
+type Store interface {
+	// Put stores what r yields and returns its reference. Nothing is stored
+	// when reading r fails.
+	Put(ctx context.Context, r io.Reader) (Ref, error)
+	// Open returns the content with the given digest, or ErrNotFound.
+	Open(ctx context.Context, sum string) (io.ReadCloser, error)
+	// Delete removes the content with the given digest. Deleting content
+	// that is not stored is not an error.
+	Delete(ctx context.Context, sum string) error
+}
+
+// Holder is a Store that deletes content nothing refers to. Hold keeps the
+// content with the given digests until until for holder, something other
+// than a saved email that refers to it, such as a spooled message.
+type Holder interface {
+	Hold(ctx context.Context, holder string, until time.Time, sums ...string) error
+}
+
+// Hold keeps content in s as Holder does, if s deletes content at all.
+func Hold(ctx context.Context, s Store, holder string, until time.Time, sums ...string) error {
+	h, ok := s.(Holder)
+	if !ok || len(sums) == 0 {
+		return nil
+	}
+	return h.Hold(ctx, holder, until, sums...)
+}
+
+// FS stores content as files named by their digest under a directory.
+type FS struct {
+	dir string
+}
+
+// tempPrefix marks content being written; such files left by a crash are
+// removed by NewFS.
+const tempPrefix = ".put-"
+
+// NewFS returns a store in dir, creating it if needed.
+func NewFS(dir string) (*FS, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	temps, err := filepath.Glob(filepath.Join(dir, tempPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range temps {
+		os.Remove(name)
+	}
+	return &FS{dir: dir}, nil
+}
+
+func (fs *FS) Put(ctx context.Context, r io.Reader) (Ref, error) {
+	tmp, err := os.CreateTemp(fs.dir, tempPrefix+"*")
+	if err != nil {
+		return Ref{}, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return Ref{}, err
+	}
+
+	ref := Ref{SHA256: hex.EncodeToString(h.Sum(nil)), Size: size}
+	path := fs.path(ref.SHA256)
+	if _, err := os.Stat(path); err == nil {
+		// Already stored; content with the same digest is the same
+		os.Remove(tmp.Name())
+		return ref, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		os.Remove(tmp.Name())
+		return Ref{}, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return Ref{}, err
+	}
+	return ref, nil
+}
+
+func (fs *FS) Open(ctx context.Context, sum string) (io.ReadCloser, error) {
+	if !validSum(sum) {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(fs.path(sum))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (fs *FS) Delete(ctx context.Context, sum string) error {
+	if !validSum(sum) {
+		return nil
+	}
+	err := os.Remove(fs.path(sum))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// path spreads content over subdirectories by the first byte of its digest.
+func (fs *FS) path(sum string) string {
+	return filepath.Join(fs.dir, sum[:2], sum)
+}
+
+func validSum(sum string) bool {
+	if len(sum) != sha256.Size*2 || strings.ToLower(sum) != sum {
+		return false
+	}
+	_, err := hex.DecodeString(sum)
+	return err == nil
+}
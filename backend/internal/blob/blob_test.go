@@ -0,0 +1,104 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFS(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	fs, err := NewFS(dir)
+	if err != nil {
+		t.Fatalf("NewFS() error = %v", err)
+	}
+
+	content := strings.Repeat("attachment ", 1000)
+	sum := sha256.Sum256([]byte(content))
+	want := Ref{SHA256: hex.EncodeToString(sum[:]), Size: int64(len(content))}
+
+	for range 2 {
+		ref, err := fs.Put(ctx, strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		if ref != want {
+			t.Fatalf("Put() = %+v, want %+v", ref, want)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if len(files) != 1 {
+		t.Fatalf("stored files = %v, want one", files)
+	}
+
+	r, err := fs.Open(ctx, want.SHA256)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(got) != content {
+		t.Fatalf("Open() content = %d bytes, %v", len(got), err)
+	}
+
+	for _, sum := range []string{strings.Repeat("0", 64), "../" + want.SHA256, strings.ToUpper(want.SHA256)} {
+		if _, err := fs.Open(ctx, sum); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Open(%q) error = %v, want ErrNotFound", sum, err)
+		}
+	}
+
+	for range 2 {
+		if err := fs.Delete(ctx, want.SHA256); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+	}
+	if _, err := fs.Open(ctx, want.SHA256); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Open() after Delete error = %v, want ErrNotFound", err)
+	}
+}
+
+type failingReader struct{ n int }
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, errors.New("connection lost")
+	}
+	r.n--
+	return copy(p, "partial"), nil
+}
+
+func TestFS_PutFailure(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fs, err := NewFS(dir)
+	if err != nil {
+		t.Fatalf("NewFS() error = %v", err)
+	}
+	if _, err := fs.Put(context.Background(), &failingReader{n: 3}); err == nil {
+		t.Fatal("Put() error = nil, want the read error")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("directory has %d entries after a failed Put, want none", len(entries))
+	}
+
+	// Leftovers of an interrupted Put are removed on open
+	if err := os.WriteFile(filepath.Join(dir, tempPrefix+"1"), []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFS(dir); err != nil {
+		t.Fatalf("NewFS() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("directory has %d entries after NewFS, want none", len(entries))
+	}
+}
@@ -0,0 +1,227 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config locates a bucket on an S3-compatible service.
+type S3Config struct {
+	// Endpoint is the service URL, such as https://s3.eu-central-1.amazonaws.com
+	// or the address of a MinIO server. Buckets are addressed by path.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3 stores content as objects named by their digest in a bucket.
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// NewS3 returns a store in the configured bucket. Nothing is requested
+// until the store is used.
+func NewS3(cfg S3Config) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: want an http or https URL", cfg.Endpoint)
+	}
+	return &S3{cfg: cfg, endpoint: endpoint, client: &http.Client{Timeout: time.Minute}, now: time.Now}, nil
+}
+
+// Put writes the content to a temporary file first: the request is signed
+// over its digest, which is also the object name.
+func (s *S3) Put(ctx context.Context, r io.Reader) (Ref, error) {
+	tmp, err := os.CreateTemp("", "coresend-blob-*")
+	if err != nil {
+		return Ref{}, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return Ref{}, err
+	}
+	ref := Ref{SHA256: hex.EncodeToString(h.Sum(nil)), Size: size}
+
+	head, err := s.do(ctx, http.MethodHead, ref.SHA256, nil, 0, emptySHA256)
+	if err != nil {
+		return Ref{}, err
+	}
+	head.Body.Close()
+	if head.StatusCode == http.StatusOK {
+		// Already stored; content with the same digest is the same
+		return ref, nil
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return Ref{}, err
+	}
+	resp, err := s.do(ctx, http.MethodPut, ref.SHA256, io.NopCloser(tmp), size, ref.SHA256)
+	if err != nil {
+		return Ref{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Ref{}, s.statusError(resp)
+	}
+	return ref, nil
+}
+
+func (s *S3) Open(ctx context.Context, sum string) (io.ReadCloser, error) {
+	if !validSum(sum) {
+		return nil, ErrNotFound
+	}
+	resp, err := s.do(ctx, http.MethodGet, sum, nil, 0, emptySHA256)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	defer resp.Body.Close()
+	return nil, s.statusError(resp)
+}
+
+func (s *S3) Delete(ctx context.Context, sum string) error {
+	if !validSum(sum) {
+		return nil
+	}
+	resp, err := s.do(ctx, http.MethodDelete, sum, nil, 0, emptySHA256)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return s.statusError(resp)
+}
+
+// emptySHA256 is the digest of an empty request body.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// do sends a signed request for the object of sum. payloadHash is the hex
+// digest of body.
+func (s *S3) do(ctx context.Context, method, sum string, body io.ReadCloser, size int64, payloadHash string) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path += "/" + s.cfg.Bucket + "/" + sum[:2] + "/" + sum
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Body = body
+		req.ContentLength = size
+	}
+	sign(req, s.cfg, payloadHash, s.now())
+	return s.client.Do(req)
+}
+
+func (s *S3) statusError(resp *http.Response) error {
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("S3 %s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(string(detail)))
+}
+
+// sign adds AWS Signature Version 4 headers to req, covering the host and
+// every header already set.
+func sign(req *http.Request, cfg S3Config, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := now.Format("20060102") + "/" + cfg.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + cfg.SecretKey)
+	for _, part := range []string{now.Format("20060102"), cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+cfg.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode escapes everything but the unreserved characters of RFC 3986,
+// as the signature requires.
+func uriEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,38 @@
+// Package buildinfo reports the version and commit the server was built from.
+//
+// Release builds set both with -ldflags:
+//
+//	go build -ldflags "-X github.com/fn-jakubkarp/coresend/internal/buildinfo.Version=v1.2.0 \
+//	  -X github.com/fn-jakubkarp/coresend/internal/buildinfo.Commit=$(git rev-parse HEAD)"
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version = "dev"
+	// Commit falls back to the VCS revision recorded by the Go toolchain.
+	Commit = ""
+)
+
+type Info struct {
+	Version   string
+	Commit    string
+	GoVersion string
+}
+
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, GoVersion: runtime.Version()}
+	if info.Commit == "" {
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range bi.Settings {
+				if s.Key == "vcs.revision" {
+					info.Commit = s.Value
+				}
+			}
+		}
+	}
+	return info
+}
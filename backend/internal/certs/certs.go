@@ -0,0 +1,186 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/metrics"
+)
+
+// Pair is a certificate and private key file on disk.
+type Pair struct {
+	CertPath string
+	KeyPath  string
+}
+
+// ParsePairs parses a comma-separated list of cert:key path pairs.
+func ParsePairs(spec string) ([]Pair, error) {
+	var pairs []Pair
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		certPath, keyPath, ok := strings.Cut(entry, ":")
+		if !ok || certPath == "" || keyPath == "" {
+			return nil, fmt.Errorf("invalid certificate pair %q, want cert:key", entry)
+		}
+		pairs = append(pairs, Pair{CertPath: certPath, KeyPath: keyPath})
+	}
+	return pairs, nil
+}
+
+type loadedCert struct {
+	cert     *tls.Certificate
+	certTime time.Time
+	keyTime  time.Time
+}
+
+// Manager serves certificates for TLS handshakes and reloads them when the
+// files change on disk. When several certificates are configured the one
+// matching the client's SNI name is used, falling back to the first.
+type Manager struct {
+	pairs []Pair
+
+	mu    sync.RWMutex
+	certs []loadedCert
+}
+
+func NewManager(pairs ...Pair) (*Manager, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("no certificates configured")
+	}
+
+	m := &Manager{
+		pairs: pairs,
+		certs: make([]loadedCert, len(pairs)),
+	}
+	for i := range pairs {
+		if err := m.load(i); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// TLSConfig returns a server config that always uses the latest certificates.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if hello != nil && hello.ServerName != "" {
+		for _, lc := range m.certs {
+			if hello.SupportsCertificate(lc.cert) == nil {
+				return lc.cert, nil
+			}
+		}
+	}
+	return m.certs[0].cert, nil
+}
+
+// Certificates returns the currently loaded certificates in configuration order.
+func (m *Manager) Certificates() []*tls.Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make([]*tls.Certificate, 0, len(m.certs))
+	for _, lc := range m.certs {
+		out = append(out, lc.cert)
+	}
+	return out
+}
+
+// Reload re-reads any certificate whose files changed since the last load.
+// A pair that fails to load keeps serving its previous certificate.
+func (m *Manager) Reload() error {
+	var errs []error
+	for i, pair := range m.pairs {
+		certTime, keyTime, err := modTimes(pair)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		m.mu.RLock()
+		current := m.certs[i]
+		m.mu.RUnlock()
+		if certTime.Equal(current.certTime) && keyTime.Equal(current.keyTime) {
+			continue
+		}
+
+		if err := m.load(i); err != nil {
+			metrics.TLSCertificateReloadsTotal.WithLabelValues("error").Inc()
+			errs = append(errs, err)
+			continue
+		}
+		metrics.TLSCertificateReloadsTotal.WithLabelValues("success").Inc()
+		slog.Info("Reloaded TLS certificate", "cert", pair.CertPath)
+	}
+	return errors.Join(errs...)
+}
+
+// Watch polls the certificate files until ctx is cancelled.
+func (m *Manager) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Reload(); err != nil {
+				slog.Error("TLS certificate reload failed, keeping previous certificate", "error", err)
+			}
+		}
+	}
+}
+
+func (m *Manager) load(i int) error {
+	pair := m.pairs[i]
+
+	certTime, keyTime, err := modTimes(pair)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(pair.CertPath, pair.KeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %w", pair.CertPath, err)
+	}
+
+	m.mu.Lock()
+	m.certs[i] = loadedCert{cert: &cert, certTime: certTime, keyTime: keyTime}
+	m.mu.Unlock()
+
+	if cert.Leaf != nil {
+		metrics.TLSCertificateExpiry.WithLabelValues(pair.CertPath).Set(float64(cert.Leaf.NotAfter.Unix()))
+	}
+	return nil
+}
+
+func modTimes(pair Pair) (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(pair.CertPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(pair.KeyPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
@@ -0,0 +1,207 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func writeTestCert(t *testing.T, dir, name string, notAfter time.Time, dnsNames ...string) Pair {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	pair := Pair{
+		CertPath: filepath.Join(dir, name+".crt"),
+		KeyPath:  filepath.Join(dir, name+".key"),
+	}
+	if err := os.WriteFile(pair.CertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(pair.KeyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return pair
+}
+
+// touch moves a file's modification time forward so reloads notice it
+// regardless of filesystem timestamp granularity.
+func touch(t *testing.T, path string, at time.Time) {
+	t.Helper()
+
+	if err := os.Chtimes(path, at, at); err != nil {
+		t.Fatalf("failed to update mtime: %v", err)
+	}
+}
+
+func TestParsePairs(t *testing.T) {
+	t.Parallel()
+
+	pairs, err := ParsePairs(" a.crt:a.key , ,b.crt:b.key")
+	if err != nil {
+		t.Fatalf("ParsePairs() error = %v", err)
+	}
+	want := []Pair{{CertPath: "a.crt", KeyPath: "a.key"}, {CertPath: "b.crt", KeyPath: "b.key"}}
+	if len(pairs) != len(want) || pairs[0] != want[0] || pairs[1] != want[1] {
+		t.Fatalf("pairs = %+v, want %+v", pairs, want)
+	}
+
+	for _, spec := range []string{"a.crt", "a.crt:", ":a.key"} {
+		if _, err := ParsePairs(spec); err == nil {
+			t.Fatalf("ParsePairs(%q) expected error", spec)
+		}
+	}
+}
+
+func TestNewManager_Errors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewManager(); err == nil {
+		t.Fatalf("NewManager() expected error without pairs")
+	}
+	if _, err := NewManager(Pair{CertPath: "/missing.crt", KeyPath: "/missing.key"}); err == nil {
+		t.Fatalf("NewManager() expected error for missing files")
+	}
+}
+
+func TestManager_GetCertificateBySNI(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	expiry := time.Now().Add(30 * 24 * time.Hour)
+	first := writeTestCert(t, dir, "first", expiry, "mx.coresend.io")
+	second := writeTestCert(t, dir, "second", expiry, "*.example.org")
+
+	m, err := NewManager(first, second)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	loaded := m.Certificates()
+
+	tests := []struct {
+		serverName string
+		want       *tls.Certificate
+	}{
+		{serverName: "mx.coresend.io", want: loaded[0]},
+		{serverName: "mail.example.org", want: loaded[1]},
+		{serverName: "unknown.test", want: loaded[0]},
+		{serverName: "", want: loaded[0]},
+	}
+
+	for _, tc := range tests {
+		hello := &tls.ClientHelloInfo{
+			ServerName:        tc.serverName,
+			SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+			SupportedVersions: []uint16{tls.VersionTLS13},
+			SupportedCurves:   []tls.CurveID{tls.CurveP256},
+		}
+		got, err := m.GetCertificate(hello)
+		if err != nil {
+			t.Fatalf("GetCertificate(%q) error = %v", tc.serverName, err)
+		}
+		if got != tc.want {
+			t.Fatalf("GetCertificate(%q) returned %v, want %v", tc.serverName, got.Leaf.DNSNames, tc.want.Leaf.DNSNames)
+		}
+	}
+}
+
+func TestManager_ReloadAndExpiryGauge(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	oldExpiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	pair := writeTestCert(t, dir, "reload", oldExpiry, "mx.coresend.io")
+
+	m, err := NewManager(pair)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.TLSCertificateExpiry.WithLabelValues(pair.CertPath)); got != float64(oldExpiry.Unix()) {
+		t.Fatalf("expiry gauge = %v, want %v", got, oldExpiry.Unix())
+	}
+
+	before := m.Certificates()[0]
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload() without changes error = %v", err)
+	}
+	if m.Certificates()[0] != before {
+		t.Fatalf("certificate replaced without file changes")
+	}
+
+	newExpiry := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	writeTestCert(t, dir, "reload", newExpiry, "mx.coresend.io")
+	future := time.Now().Add(time.Minute)
+	touch(t, pair.CertPath, future)
+	touch(t, pair.KeyPath, future)
+
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	got, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	if !got.Leaf.NotAfter.Equal(newExpiry) {
+		t.Fatalf("served certificate expires %s, want %s", got.Leaf.NotAfter, newExpiry)
+	}
+	if gauge := testutil.ToFloat64(metrics.TLSCertificateExpiry.WithLabelValues(pair.CertPath)); gauge != float64(newExpiry.Unix()) {
+		t.Fatalf("expiry gauge = %v, want %v", gauge, newExpiry.Unix())
+	}
+}
+
+func TestManager_ReloadKeepsPreviousOnError(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	pair := writeTestCert(t, dir, "broken", time.Now().Add(time.Hour), "mx.coresend.io")
+
+	m, err := NewManager(pair)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	before := m.Certificates()[0]
+
+	if err := os.WriteFile(pair.CertPath, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("failed to corrupt certificate: %v", err)
+	}
+	touch(t, pair.CertPath, time.Now().Add(time.Minute))
+
+	if err := m.Reload(); err == nil {
+		t.Fatalf("Reload() expected error for corrupt certificate")
+	}
+	if m.Certificates()[0] != before {
+		t.Fatalf("previous certificate was not kept after failed reload")
+	}
+}
@@ -0,0 +1,130 @@
+// Package charset decodes the character sets of received mail to UTF-8.
+//
+// Labels are resolved through the WHATWG encoding labels browsers use, which
+// cover the legacy encodings common in mail such as ISO-2022-JP, Shift_JIS,
+// GBK, Big5, EUC-KR, KOI8-R and the Windows code pages, with a few aliases
+// mail clients add. Importing the package makes go-message decode these
+// charsets in message bodies and headers.
+//
+// Decoding is lenient: text in an unknown charset, or that is not valid in
+// the one it declares, is still turned into valid UTF-8 rather than refused.
+package charset
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-message"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// aliases are labels seen in mail that the WHATWG list does not have.
+var aliases = map[string]encoding.Encoding{
+	"iso-2022-jp-1":  japanese.ISO2022JP,
+	"iso-2022-jp-2":  japanese.ISO2022JP,
+	"iso-2022-jp-3":  japanese.ISO2022JP,
+	"cp932":          japanese.ShiftJIS,
+	"shift-jisx0213": japanese.ShiftJIS,
+	"cp949":          korean.EUCKR,
+	"uhc":            korean.EUCKR,
+	"cp936":          simplifiedchinese.GBK,
+	"euc-cn":         simplifiedchinese.GBK,
+	"cp850":          charmap.CodePage850,
+	"cp437":          charmap.CodePage437,
+	"utf8mb4":        unicode.UTF8,
+}
+
+func init() {
+	message.CharsetReader = Reader
+}
+
+// Lookup returns the encoding of a charset label, or nil when it is not
+// known.
+func Lookup(label string) encoding.Encoding {
+	label = strings.ToLower(strings.Trim(strings.TrimSpace(label), `"'`))
+	if enc, ok := aliases[label]; ok {
+		return enc
+	}
+	if enc, err := htmlindex.Get(label); err == nil {
+		return enc
+	}
+	if enc, err := ianaindex.MIME.Encoding(label); err == nil && enc != nil {
+		return enc
+	}
+	return nil
+}
+
+// Reader returns input converted from charset to UTF-8, or an error when the
+// charset is not known. It has the signature of message.CharsetReader.
+func Reader(charset string, input io.Reader) (io.Reader, error) {
+	enc := Lookup(charset)
+	if enc == nil {
+		return nil, fmt.Errorf("unknown charset %q", charset)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// ToUTF8 returns text as valid UTF-8, for text whose charset is unknown or
+// that is invalid in the one it declared. Text that is mostly UTF-8 keeps its
+// valid characters and has the rest replaced; other text is taken as
+// Windows-1252, which gives every byte a character.
+func ToUTF8(text []byte) string {
+	if utf8.Valid(text) {
+		return string(text)
+	}
+	multibyte, invalid := 0, 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRune(text[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			invalid++
+		case size > 1:
+			multibyte++
+		}
+		i += size
+	}
+	if multibyte > 0 && multibyte >= invalid {
+		return string(bytes.ToValidUTF8(text, []byte("\uFFFD")))
+	}
+	decoded, err := charmap.Windows1252.NewDecoder().Bytes(text)
+	if err != nil {
+		return string(bytes.ToValidUTF8(text, []byte("\uFFFD")))
+	}
+	return string(decoded)
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: lenientReader}
+
+// DecodeHeader decodes the RFC 2047 encoded-words in a header value. Words
+// in unknown charsets are decoded as by ToUTF8, malformed words are kept as
+// they are, and the result is valid UTF-8.
+func DecodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		decoded = value
+	}
+	return ToUTF8([]byte(decoded))
+}
+
+// lenientReader is Reader, but decodes unknown charsets with ToUTF8.
+func lenientReader(charset string, input io.Reader) (io.Reader, error) {
+	if r, err := Reader(charset, input); err == nil {
+		return r, nil
+	}
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(ToUTF8(data)), nil
+}
@@ -0,0 +1,110 @@
+package charset
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		charset string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{charset: "ISO-2022-JP", input: "\x1b$B$3$s$K$A$O\x1b(B", want: "こんにちは"},
+		{charset: "iso-2022-jp-2", input: "\x1b$B$3$s$K$A$O\x1b(B", want: "こんにちは"},
+		{charset: "shift_jis", input: "\x93\xfa\x96\x7b", want: "日本"},
+		{charset: "cp932", input: "\x93\xfa\x96\x7b", want: "日本"},
+		{charset: "windows-1251", input: "\xcf\xf0\xe8\xe2\xe5\xf2", want: "Привет"},
+		{charset: "koi8-r", input: "\xf0\xd2\xc9\xd7\xc5\xd4", want: "Привет"},
+		{charset: "GBK", input: "\xc4\xe3\xba\xc3", want: "你好"},
+		{charset: "gb2312", input: "\xc4\xe3\xba\xc3", want: "你好"},
+		{charset: "big5", input: "\xa4\xa4\xa4\xe5", want: "中文"},
+		{charset: "ks_c_5601-1987", input: "\xc7\xd1\xb1\xb9", want: "한국"},
+		{charset: "iso-8859-1", input: "caf\xe9 \x93quoted\x94", want: "café “quoted”"},
+		{charset: `"iso-8859-2"`, input: "\xb3\xf3d\xbc", want: "łódź"},
+		{charset: "x-unknown", input: "x", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.charset, func(t *testing.T) {
+			t.Parallel()
+
+			r, err := Reader(tc.charset, strings.NewReader(tc.input))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Reader() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Reader() error = %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("reading error = %v", err)
+			}
+			if string(got) != tc.want {
+				t.Fatalf("decoded = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestToUTF8(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "valid", input: "héllo ✓", want: "héllo ✓"},
+		{name: "latin text", input: "caf\xe9 cr\xe8me", want: "café crème"},
+		{name: "mostly UTF-8", input: "héllo w\xf6rld ✓", want: "héllo w�rld ✓"},
+		{name: "empty", input: "", want: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := ToUTF8([]byte(tc.input)); got != tc.want {
+				t.Fatalf("ToUTF8(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "plain", input: "Hello", want: "Hello"},
+		{name: "UTF-8 Q", input: "=?UTF-8?Q?Caf=C3=A9?=", want: "Café"},
+		{name: "ISO-2022-JP B", input: "=?ISO-2022-JP?B?GyRCJDMkcyRLJEEkTxsoQg==?=", want: "こんにちは"},
+		{name: "windows-1251 B", input: "=?windows-1251?B?z/Do4uXy?= world", want: "Привет world"},
+		{name: "GBK adjacent words", input: "=?gbk?B?xOM=?= =?gbk?B?usM=?=", want: "你好"},
+		{name: "unknown charset", input: "=?x-unknown?Q?caf=E9?=", want: "café"},
+		{name: "malformed word", input: "=?UTF-8?B?not base64!?= ok", want: "=?UTF-8?B?not base64!?= ok"},
+		{name: "raw 8-bit", input: "Caf\xe9", want: "Café"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := DecodeHeader(tc.input); got != tc.want {
+				t.Fatalf("DecodeHeader(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/api"
+	"github.com/fn-jakubkarp/coresend/internal/identity"
+	"github.com/google/uuid"
+)
+
+// APIError is returned when the server answers with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("server returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("server returned %d: %s (%s)", e.StatusCode, e.Message, e.Code)
+}
+
+// Client talks to the CoreSend API on behalf of a single identity.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Identity   *identity.Identity
+
+	// now is overridable in tests.
+	now func() time.Time
+}
+
+func New(baseURL string, id *identity.Identity) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Identity:   id,
+		now:        time.Now,
+	}
+}
+
+// Register registers the identity's address. An empty domain selects the
+// server's default domain.
+func (c *Client) Register(ctx context.Context, domain string) (*api.RegisterResponse, error) {
+	var req any
+	if domain != "" {
+		req = api.RegisterRequest{Domain: domain}
+	}
+
+	var resp api.RegisterResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/api/register/"+c.Identity.Address, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) Inbox(ctx context.Context) (*api.InboxResponse, error) {
+	var resp api.InboxResponse
+	if err := c.doJSON(ctx, http.MethodGet, c.inboxPath(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) Email(ctx context.Context, emailID string) (*api.EmailResponse, error) {
+	var resp api.EmailResponse
+	if err := c.doJSON(ctx, http.MethodGet, c.inboxPath()+"/"+emailID, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Source returns a single email as it was received. The server keeps
+// sources only when configured to.
+func (c *Client) Source(ctx context.Context, emailID string) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, c.inboxPath()+"/"+emailID+"/source", nil)
+}
+
+func (c *Client) DeleteEmail(ctx context.Context, emailID string) (*api.DeleteResponse, error) {
+	var resp api.DeleteResponse
+	if err := c.doJSON(ctx, http.MethodDelete, c.inboxPath()+"/"+emailID, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) ClearInbox(ctx context.Context) (*api.DeleteResponse, error) {
+	var resp api.DeleteResponse
+	if err := c.doJSON(ctx, http.MethodDelete, c.inboxPath(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// WaitForEmail polls the inbox until an email satisfies match or ctx is done.
+// Emails already present when polling starts are considered too.
+func (c *Client) WaitForEmail(ctx context.Context, interval time.Duration, match func(api.EmailResponse) bool) (*api.EmailResponse, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		inbox, err := c.Inbox(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, email := range inbox.Emails {
+			if match(email) {
+				return &email, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) inboxPath() string {
+	return "/api/inbox/" + c.Identity.Address
+}
+
+func (c *Client) doJSON(ctx context.Context, method, path string, in, out any) error {
+	var reqBody []byte
+	if in != nil {
+		var err error
+		if reqBody, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	body, err := c.do(ctx, method, path, reqBody)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, reqBody []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.sign(req, path, reqBody)
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: res.StatusCode}
+		var errResp api.ErrorResponse
+		if json.Unmarshal(body, &errResp) == nil {
+			apiErr.Code = errResp.Error.Code
+			apiErr.Message = errResp.Error.Message
+		}
+		return nil, apiErr
+	}
+
+	return body, nil
+}
+
+// sign sets the headers expected by the server's signature auth middleware.
+func (c *Client) sign(req *http.Request, path string, body []byte) {
+	timestamp := strconv.FormatInt(c.now().Unix(), 10)
+	nonce := uuid.New().String()
+	bodyHash := sha256.Sum256(body)
+
+	payload := identity.SigningPayload(req.Method, path, timestamp, hex.EncodeToString(bodyHash[:]), nonce)
+	signature := ed25519.Sign(c.Identity.PrivateKey, []byte(payload))
+
+	req.Header.Set("X-Public-Key", hex.EncodeToString(c.Identity.PublicKey))
+	req.Header.Set("X-Signature", hex.EncodeToString(signature))
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("X-Nonce", nonce)
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+}
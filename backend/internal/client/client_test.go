@@ -0,0 +1,153 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/api"
+	"github.com/fn-jakubkarp/coresend/internal/identity"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	id, err := identity.Derive(testMnemonic, 0)
+	if err != nil {
+		t.Fatalf("Derive() error = %v", err)
+	}
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	return New(srv.URL+"/", id)
+}
+
+func verifySignature(t *testing.T, r *http.Request) {
+	t.Helper()
+
+	pub, err := hex.DecodeString(r.Header.Get("X-Public-Key"))
+	if err != nil {
+		t.Errorf("bad public key header: %v", err)
+		return
+	}
+	sig, err := hex.DecodeString(r.Header.Get("X-Signature"))
+	if err != nil {
+		t.Errorf("bad signature header: %v", err)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	bodyHash := sha256.Sum256(body)
+
+	payload := identity.SigningPayload(r.Method, r.URL.Path, r.Header.Get("X-Timestamp"), hex.EncodeToString(bodyHash[:]), r.Header.Get("X-Nonce"))
+	if !ed25519.Verify(pub, []byte(payload), sig) {
+		t.Errorf("signature did not verify for payload %q", payload)
+	}
+}
+
+func TestClient_SignsRequests(t *testing.T) {
+	t.Parallel()
+
+	var (
+		gotPath string
+		gotReq  api.RegisterRequest
+	)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		verifySignature(t, r)
+		gotPath = r.URL.Path
+		json.Unmarshal(body, &gotReq)
+		json.NewEncoder(w).Encode(api.RegisterResponse{Registered: true, Address: "addr", ExpiresIn: 60})
+	})
+
+	resp, err := c.Register(context.Background(), "coresend.io")
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if !resp.Registered {
+		t.Fatalf("registered = false, want true")
+	}
+	if want := "/api/register/" + c.Identity.Address; gotPath != want {
+		t.Fatalf("path = %q, want %q", gotPath, want)
+	}
+	if gotReq.Domain != "coresend.io" {
+		t.Fatalf("request domain = %q, want %q", gotReq.Domain, "coresend.io")
+	}
+}
+
+func TestClient_APIError(t *testing.T) {
+	t.Parallel()
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(api.ErrorResponse{Error: api.ErrorDetails{Code: api.ErrCodeNotFound, Message: "Email not found"}})
+	})
+
+	_, err := c.Email(context.Background(), "missing")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("error = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Code != api.ErrCodeNotFound {
+		t.Fatalf("api error = %+v", apiErr)
+	}
+}
+
+func TestClient_WaitForEmail(t *testing.T) {
+	t.Parallel()
+
+	var polls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		verifySignature(t, r)
+		resp := api.InboxResponse{Emails: []api.EmailResponse{{ID: "1", Subject: "Newsletter"}}}
+		if polls.Add(1) >= 3 {
+			resp.Emails = append(resp.Emails, api.EmailResponse{ID: "2", Subject: "Your code is 123456"})
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email, err := c.WaitForEmail(ctx, time.Millisecond, func(e api.EmailResponse) bool {
+		return e.Subject == "Your code is 123456"
+	})
+	if err != nil {
+		t.Fatalf("WaitForEmail() error = %v", err)
+	}
+	if email.ID != "2" {
+		t.Fatalf("email id = %q, want %q", email.ID, "2")
+	}
+	if got := polls.Load(); got != 3 {
+		t.Fatalf("poll count = %d, want 3", got)
+	}
+}
+
+func TestClient_WaitForEmail_Timeout(t *testing.T) {
+	t.Parallel()
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(api.InboxResponse{})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := c.WaitForEmail(ctx, time.Millisecond, func(api.EmailResponse) bool { return true })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,577 @@
+// Package config loads the server configuration.
+//
+// Settings are layered: built-in defaults, then an optional YAML file, then
+// environment variables, then command-line flags. Every setting has a flag
+// named after its dotted YAML path, for example -smtp.max_recipients=100.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/certs"
+	"github.com/fn-jakubkarp/coresend/internal/domains"
+	"github.com/fn-jakubkarp/coresend/internal/filter"
+	"github.com/fn-jakubkarp/coresend/internal/forward"
+	"github.com/fn-jakubkarp/coresend/internal/health"
+	"github.com/fn-jakubkarp/coresend/internal/imageproxy"
+	"github.com/fn-jakubkarp/coresend/internal/logging"
+	"github.com/fn-jakubkarp/coresend/internal/send"
+	"github.com/fn-jakubkarp/coresend/internal/tracing"
+	"github.com/fn-jakubkarp/coresend/internal/webhook"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Redis    RedisConfig   `yaml:"redis"`
+	HTTP     HTTPConfig    `yaml:"http"`
+	SMTP     SMTPConfig    `yaml:"smtp"`
+	Domains  Domains       `yaml:"domains" env:"DOMAIN_NAME"`
+	Webhooks WebhookConfig `yaml:"webhooks"`
+	Forward  ForwardConfig `yaml:"forward"`
+	Send     SendConfig    `yaml:"send"`
+	Filters  FilterConfig  `yaml:"filters"`
+	Images   ImagesConfig  `yaml:"images"`
+	Blobs    BlobConfig    `yaml:"blobs"`
+	Log      LogConfig     `yaml:"log"`
+	Tracing  TracingConfig `yaml:"tracing"`
+}
+
+type RedisConfig struct {
+	Addr     string `yaml:"addr" env:"REDIS_ADDR"`
+	Password Secret `yaml:"password" env:"REDIS_PASSWORD"`
+	// ConnectTimeout bounds the startup connectivity check.
+	ConnectTimeout time.Duration `yaml:"connect_timeout" env:"REDIS_CONNECT_TIMEOUT"`
+	// Compression stores emails compressed with zstd. Emails saved either
+	// way stay readable when it changes, but versions from before it cannot
+	// read compressed emails, so it is off unless enabled.
+	Compression bool `yaml:"compression" env:"REDIS_COMPRESSION"`
+}
+
+type HTTPConfig struct {
+	ListenAddr      string        `yaml:"listen_addr" env:"HTTP_LISTEN_ADDR"`
+	StaticDir       string        `yaml:"static_dir" env:"STATIC_DIR"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" env:"HTTP_READ_TIMEOUT"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	// DrainDelay is how long readiness fails before the listeners close, so
+	// load balancers stop routing first.
+	DrainDelay time.Duration `yaml:"drain_delay" env:"SHUTDOWN_DRAIN_DELAY"`
+	// HealthTimeout bounds each readiness probe.
+	HealthTimeout time.Duration `yaml:"health_timeout" env:"HTTP_HEALTH_TIMEOUT"`
+	// AuthMaxSkew is how far a signed request's timestamp may drift from the
+	// server clock. Nonces are remembered for twice as long, the time a
+	// timestamp stays within it.
+	AuthMaxSkew time.Duration `yaml:"auth_max_skew" env:"HTTP_AUTH_MAX_SKEW"`
+	// URLKey signs the URLs of inline images in sanitized bodies. When
+	// empty a random key is used, so the URLs stop working on restart and
+	// differ between instances.
+	URLKey     Secret     `yaml:"url_key" env:"HTTP_URL_KEY"`
+	RateLimits RateLimits `yaml:"rate_limits"`
+}
+
+type RateLimits struct {
+	Inbox  RateLimit `yaml:"inbox" env:"HTTP_INBOX_RATE_LIMIT"`
+	Delete RateLimit `yaml:"delete" env:"HTTP_DELETE_RATE_LIMIT"`
+}
+
+type SMTPConfig struct {
+	ListenAddr      string        `yaml:"listen_addr" env:"SMTP_LISTEN_ADDR"`
+	SMTPSListenAddr string        `yaml:"smtps_listen_addr" env:"SMTPS_LISTEN_ADDR"`
+	RequireTLS      bool          `yaml:"require_tls" env:"SMTP_REQUIRE_TLS"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" env:"SMTP_READ_TIMEOUT"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" env:"SMTP_WRITE_TIMEOUT"`
+	MaxRecipients   int           `yaml:"max_recipients" env:"SMTP_MAX_RECIPIENTS"`
+	// DrainTimeout is how long messages being received at shutdown get to
+	// finish before their connections are closed.
+	DrainTimeout time.Duration `yaml:"drain_timeout" env:"SMTP_DRAIN_TIMEOUT"`
+	// StoreTimeout bounds each store call made while receiving a message.
+	StoreTimeout time.Duration `yaml:"store_timeout" env:"SMTP_STORE_TIMEOUT"`
+	// Dedup skips messages already in the recipient's inbox, matched by
+	// Message-ID or, without one, by their headers and body.
+	Dedup bool `yaml:"dedup" env:"SMTP_DEDUP"`
+	// KeepSource saves each message as received with its email, for the
+	// API to return.
+	KeepSource bool `yaml:"keep_source" env:"SMTP_KEEP_SOURCE"`
+	// MaxPartSize bounds the decoded size of each part of a message. Zero
+	// leaves only the message size limit.
+	MaxPartSize ByteSize `yaml:"max_part_size" env:"SMTP_MAX_PART_SIZE"`
+	// DefaultRetention and DefaultMaxMessageSize apply to domains that do
+	// not set their own.
+	DefaultRetention      time.Duration `yaml:"default_retention" env:"SMTP_DEFAULT_RETENTION"`
+	DefaultMaxMessageSize ByteSize      `yaml:"default_max_message_size" env:"SMTP_DEFAULT_MAX_MESSAGE_SIZE"`
+	TLS                   TLSConfig     `yaml:"tls"`
+	Spool                 SpoolConfig   `yaml:"spool"`
+}
+
+// SpoolConfig enables the disk spool that accepts mail while the store is
+// down. It is disabled when Dir is empty.
+type SpoolConfig struct {
+	Dir            string        `yaml:"dir" env:"SMTP_SPOOL_DIR"`
+	MaxSize        ByteSize      `yaml:"max_size" env:"SMTP_SPOOL_MAX_SIZE"`
+	ReplayInterval time.Duration `yaml:"replay_interval" env:"SMTP_SPOOL_REPLAY_INTERVAL"`
+}
+
+type TLSConfig struct {
+	CertPath       string        `yaml:"cert_path" env:"SMTP_CERT_PATH"`
+	KeyPath        string        `yaml:"key_path" env:"SMTP_KEY_PATH"`
+	Certs          CertPairs     `yaml:"certs" env:"SMTP_TLS_CERTS"`
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"SMTP_CERT_RELOAD_INTERVAL"`
+}
+
+// WebhookConfig controls delivery of received-email notifications.
+type WebhookConfig struct {
+	MaxPerAddress int `yaml:"max_per_address" env:"WEBHOOK_MAX_PER_ADDRESS"`
+	// MaxAttempts is how many deliveries are tried before a job is moved to
+	// the dead letters. The wait starts at Backoff and doubles up to
+	// MaxBackoff.
+	MaxAttempts  int           `yaml:"max_attempts" env:"WEBHOOK_MAX_ATTEMPTS"`
+	Backoff      time.Duration `yaml:"backoff" env:"WEBHOOK_BACKOFF"`
+	MaxBackoff   time.Duration `yaml:"max_backoff" env:"WEBHOOK_MAX_BACKOFF"`
+	Timeout      time.Duration `yaml:"timeout" env:"WEBHOOK_TIMEOUT"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"WEBHOOK_POLL_INTERVAL"`
+	// AllowPrivate permits webhook URLs on loopback and private networks.
+	AllowPrivate bool `yaml:"allow_private" env:"WEBHOOK_ALLOW_PRIVATE"`
+}
+
+// ForwardConfig enables forwarding rules. It is disabled when Relay.Addr is
+// empty.
+type ForwardConfig struct {
+	Relay RelayConfig `yaml:"relay"`
+	// SRSDomain receives bounces for rewritten senders and must be one of
+	// the receiving domains. Empty selects the default domain.
+	SRSDomain string `yaml:"srs_domain" env:"FORWARD_SRS_DOMAIN"`
+	SRSSecret Secret `yaml:"srs_secret" env:"FORWARD_SRS_SECRET"`
+	// SRSMaxAge is how long rewritten senders accept bounces.
+	SRSMaxAge     time.Duration `yaml:"srs_max_age" env:"FORWARD_SRS_MAX_AGE"`
+	MaxPerAddress int           `yaml:"max_per_address" env:"FORWARD_MAX_PER_ADDRESS"`
+	// MaxAttempts is how many relays are tried before a message is dropped.
+	// The wait starts at Backoff and doubles up to MaxBackoff.
+	MaxAttempts  int           `yaml:"max_attempts" env:"FORWARD_MAX_ATTEMPTS"`
+	Backoff      time.Duration `yaml:"backoff" env:"FORWARD_BACKOFF"`
+	MaxBackoff   time.Duration `yaml:"max_backoff" env:"FORWARD_MAX_BACKOFF"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"FORWARD_POLL_INTERVAL"`
+}
+
+// RelayConfig is the outbound smarthost forwarded mail is sent through.
+type RelayConfig struct {
+	Addr     string `yaml:"addr" env:"FORWARD_RELAY_ADDR"`
+	Username string `yaml:"username" env:"FORWARD_RELAY_USERNAME"`
+	Password Secret `yaml:"password" env:"FORWARD_RELAY_PASSWORD"`
+	// TLS is starttls, tls or none.
+	TLS     string        `yaml:"tls" env:"FORWARD_RELAY_TLS"`
+	Timeout time.Duration `yaml:"timeout" env:"FORWARD_RELAY_TIMEOUT"`
+}
+
+// Enabled reports whether forwarding is configured.
+func (f ForwardConfig) Enabled() bool {
+	return f.Relay.Addr != ""
+}
+
+// SendConfig lets addresses send mail through forward.relay. It is enabled
+// when DKIM keys are configured, and only domains with a key may send.
+type SendConfig struct {
+	DKIMKeys      DKIMKeys `yaml:"dkim_keys" env:"SEND_DKIM_KEYS"`
+	MaxRecipients int      `yaml:"max_recipients" env:"SEND_MAX_RECIPIENTS"`
+	// HourlyQuota and DailyQuota bound the messages each address may send.
+	HourlyQuota int      `yaml:"hourly_quota" env:"SEND_HOURLY_QUOTA"`
+	DailyQuota  int      `yaml:"daily_quota" env:"SEND_DAILY_QUOTA"`
+	MaxBodySize ByteSize `yaml:"max_body_size" env:"SEND_MAX_BODY_SIZE"`
+}
+
+// Enabled reports whether sending is configured.
+func (s SendConfig) Enabled() bool {
+	return len(s.DKIMKeys) > 0
+}
+
+// FilterConfig bounds the filtering rules of each address.
+type FilterConfig struct {
+	MaxPerAddress int `yaml:"max_per_address" env:"FILTER_MAX_PER_ADDRESS"`
+}
+
+// ImagesConfig controls the proxy that loads remote images in received
+// mail.
+type ImagesConfig struct {
+	// Proxy routes remote images through the server. When false, sanitized
+	// bodies keep the original image URLs.
+	Proxy bool `yaml:"proxy" env:"IMAGE_PROXY"`
+	// ProxyKey signs proxied URLs. When empty a random key is used, so
+	// proxied URLs stop working on restart and differ between instances.
+	ProxyKey     Secret        `yaml:"proxy_key" env:"IMAGE_PROXY_KEY"`
+	MaxSize      ByteSize      `yaml:"max_size" env:"IMAGE_PROXY_MAX_SIZE"`
+	CacheSize    ByteSize      `yaml:"cache_size" env:"IMAGE_PROXY_CACHE_SIZE"`
+	CacheTTL     time.Duration `yaml:"cache_ttl" env:"IMAGE_PROXY_CACHE_TTL"`
+	Timeout      time.Duration `yaml:"timeout" env:"IMAGE_PROXY_TIMEOUT"`
+	AllowPrivate bool          `yaml:"allow_private" env:"IMAGE_PROXY_ALLOW_PRIVATE"`
+}
+
+// BlobConfig enables the blob store, which keeps bodies, inline images and
+// attachments by their content, so each is kept once, and Redis only the
+// rest. It is a directory when Dir is set, an S3 bucket when S3.Endpoint
+// is, and disabled otherwise.
+type BlobConfig struct {
+	Dir string   `yaml:"dir" env:"BLOB_DIR"`
+	S3  S3Config `yaml:"s3"`
+	// GCInterval is how often content no email references is looked for,
+	// and GCGrace how long it is kept before being deleted.
+	GCInterval time.Duration `yaml:"gc_interval" env:"BLOB_GC_INTERVAL"`
+	GCGrace    time.Duration `yaml:"gc_grace" env:"BLOB_GC_GRACE"`
+}
+
+// Enabled reports whether a blob store is configured.
+func (c BlobConfig) Enabled() bool {
+	return c.Dir != "" || c.S3.Endpoint != ""
+}
+
+// S3Config locates a bucket on an S3-compatible service, addressed by path.
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint" env:"BLOB_S3_ENDPOINT"`
+	Region    string `yaml:"region" env:"BLOB_S3_REGION"`
+	Bucket    string `yaml:"bucket" env:"BLOB_S3_BUCKET"`
+	AccessKey string `yaml:"access_key" env:"BLOB_S3_ACCESS_KEY"`
+	SecretKey Secret `yaml:"secret_key" env:"BLOB_S3_SECRET_KEY"`
+}
+
+type LogConfig struct {
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+	Level  string `yaml:"level" env:"LOG_LEVEL"`
+}
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter" env:"OTEL_TRACES_EXPORTER"`
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() *Config {
+	return &Config{
+		Redis: RedisConfig{
+			Addr:           "localhost:6379",
+			ConnectTimeout: 5 * time.Second,
+		},
+		HTTP: HTTPConfig{
+			ListenAddr:      ":8080",
+			StaticDir:       "./app/dist",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+			DrainDelay:      5 * time.Second,
+			HealthTimeout:   health.DefaultTimeout,
+			AuthMaxSkew:     5 * time.Minute,
+			RateLimits: RateLimits{
+				Inbox:  RateLimit{Limit: 60, Window: time.Minute},
+				Delete: RateLimit{Limit: 30, Window: time.Minute},
+			},
+		},
+		SMTP: SMTPConfig{
+			ListenAddr:            ":1025",
+			ReadTimeout:           10 * time.Second,
+			WriteTimeout:          10 * time.Second,
+			MaxRecipients:         50,
+			DrainTimeout:          20 * time.Second,
+			StoreTimeout:          5 * time.Second,
+			DefaultRetention:      domains.DefaultRetention,
+			DefaultMaxMessageSize: domains.DefaultMaxMessageBytes,
+			TLS: TLSConfig{
+				ReloadInterval: time.Minute,
+			},
+			Spool: SpoolConfig{
+				MaxSize:        100 << 20,
+				ReplayInterval: 10 * time.Second,
+			},
+		},
+		Domains: Domains{{Name: "localhost"}},
+		Webhooks: WebhookConfig{
+			MaxPerAddress: webhook.DefaultMaxPerAddress,
+			MaxAttempts:   webhook.DefaultMaxAttempts,
+			Backoff:       webhook.DefaultBackoff,
+			MaxBackoff:    webhook.DefaultMaxBackoff,
+			Timeout:       webhook.DefaultTimeout,
+			PollInterval:  time.Second,
+		},
+		Forward: ForwardConfig{
+			Relay: RelayConfig{
+				TLS:     forward.TLSStartTLS,
+				Timeout: forward.DefaultRelayTimeout,
+			},
+			SRSMaxAge:     forward.DefaultSRSMaxAge,
+			MaxPerAddress: forward.DefaultMaxPerAddress,
+			MaxAttempts:   forward.DefaultMaxAttempts,
+			Backoff:       forward.DefaultBackoff,
+			MaxBackoff:    forward.DefaultMaxBackoff,
+			PollInterval:  5 * time.Second,
+		},
+		Send: SendConfig{
+			MaxRecipients: send.DefaultMaxRecipients,
+			HourlyQuota:   send.DefaultHourlyQuota,
+			DailyQuota:    send.DefaultDailyQuota,
+			MaxBodySize:   send.DefaultMaxBodyBytes,
+		},
+		Filters: FilterConfig{
+			MaxPerAddress: filter.DefaultMaxPerAddress,
+		},
+		Images: ImagesConfig{
+			Proxy:     true,
+			MaxSize:   imageproxy.DefaultMaxBytes,
+			CacheSize: imageproxy.DefaultCacheBytes,
+			CacheTTL:  imageproxy.DefaultCacheTTL,
+			Timeout:   imageproxy.DefaultTimeout,
+		},
+		Blobs: BlobConfig{
+			S3:         S3Config{Region: "us-east-1"},
+			GCInterval: time.Minute,
+			GCGrace:    time.Hour,
+		},
+		Log: LogConfig{
+			Format: "text",
+			Level:  "info",
+		},
+		Tracing: TracingConfig{
+			Exporter: tracing.ExporterNone,
+		},
+	}
+}
+
+// Overrides holds the setting flags registered by RegisterFlags.
+type Overrides struct {
+	values []override
+}
+
+type override struct {
+	path  string
+	value string
+}
+
+// RegisterFlags adds one flag per setting to fs. Flag values are applied by
+// Load after the file and environment.
+func RegisterFlags(fs *flag.FlagSet) *Overrides {
+	o := &Overrides{}
+	for _, s := range settings(Default()) {
+		path := s.path
+		fs.Func(path, fmt.Sprintf("override %s (env %s)", path, s.env), func(value string) error {
+			o.values = append(o.values, override{path: path, value: value})
+			return nil
+		})
+	}
+	return o
+}
+
+// Load builds the configuration from path (optional), lookupEnv and the
+// parsed flag overrides, then validates it.
+func Load(path string, lookupEnv func(string) (string, bool), overrides *Overrides) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := decodeYAML(data, cfg); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	var errs []error
+	byPath := make(map[string]setting)
+	for _, s := range settings(cfg) {
+		byPath[s.path] = s
+		if s.env == "" {
+			continue
+		}
+		if value, ok := lookupEnv(s.env); ok && value != "" {
+			if err := s.set(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+
+	if overrides != nil {
+		for _, o := range overrides.values {
+			if err := byPath[o.path].set(o.value); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", o.path, err))
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func decodeYAML(data []byte, cfg *Config) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Redis.Addr != "", "redis.addr is required")
+	check(c.Redis.ConnectTimeout > 0, "redis.connect_timeout must be positive")
+
+	check(c.HTTP.ListenAddr != "", "http.listen_addr is required")
+	check(c.HTTP.ReadTimeout > 0, "http.read_timeout must be positive")
+	check(c.HTTP.WriteTimeout > 0, "http.write_timeout must be positive")
+	check(c.HTTP.IdleTimeout > 0, "http.idle_timeout must be positive")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout must be positive")
+	check(c.HTTP.DrainDelay >= 0, "http.drain_delay must not be negative")
+	check(c.HTTP.HealthTimeout > 0, "http.health_timeout must be positive")
+	check(c.HTTP.AuthMaxSkew > 0, "http.auth_max_skew must be positive")
+	check(c.HTTP.URLKey == "" || len(c.HTTP.URLKey) >= 16, "http.url_key must be at least 16 characters")
+	check(c.HTTP.RateLimits.Inbox.Limit > 0 && c.HTTP.RateLimits.Inbox.Window > 0, "http.rate_limits.inbox must have a positive limit and window")
+	check(c.HTTP.RateLimits.Delete.Limit > 0 && c.HTTP.RateLimits.Delete.Window > 0, "http.rate_limits.delete must have a positive limit and window")
+
+	check(c.SMTP.ListenAddr != "", "smtp.listen_addr is required")
+	check(c.SMTP.ReadTimeout > 0, "smtp.read_timeout must be positive")
+	check(c.SMTP.WriteTimeout > 0, "smtp.write_timeout must be positive")
+	check(c.SMTP.MaxRecipients > 0, "smtp.max_recipients must be positive")
+	check(c.SMTP.DrainTimeout > 0, "smtp.drain_timeout must be positive")
+	check(c.SMTP.StoreTimeout > 0, "smtp.store_timeout must be positive")
+	check(c.SMTP.DefaultRetention > 0, "smtp.default_retention must be positive")
+	check(c.SMTP.DefaultMaxMessageSize > 0, "smtp.default_max_message_size must be positive")
+	check(c.SMTP.MaxPartSize >= 0, "smtp.max_part_size must not be negative")
+	check(c.SMTP.TLS.ReloadInterval > 0, "smtp.tls.reload_interval must be positive")
+	check(c.SMTP.Spool.MaxSize > 0, "smtp.spool.max_size must be positive")
+	check(c.SMTP.Spool.ReplayInterval > 0, "smtp.spool.replay_interval must be positive")
+	check((c.SMTP.TLS.CertPath == "") == (c.SMTP.TLS.KeyPath == ""), "smtp.tls.cert_path and smtp.tls.key_path must be set together")
+
+	hasCerts := len(c.SMTP.TLS.Pairs()) > 0
+	check(!c.SMTP.RequireTLS || hasCerts, "smtp.require_tls needs a TLS certificate")
+	check(c.SMTP.SMTPSListenAddr == "" || hasCerts, "smtp.smtps_listen_addr needs a TLS certificate")
+
+	check(c.Webhooks.MaxPerAddress > 0, "webhooks.max_per_address must be positive")
+	check(c.Webhooks.MaxAttempts > 0, "webhooks.max_attempts must be positive")
+	check(c.Webhooks.Backoff > 0, "webhooks.backoff must be positive")
+	check(c.Webhooks.MaxBackoff >= c.Webhooks.Backoff, "webhooks.max_backoff must not be less than webhooks.backoff")
+	check(c.Webhooks.Timeout > 0, "webhooks.timeout must be positive")
+	check(c.Webhooks.PollInterval > 0, "webhooks.poll_interval must be positive")
+
+	check(c.Forward.MaxPerAddress > 0, "forward.max_per_address must be positive")
+	check(c.Forward.MaxAttempts > 0, "forward.max_attempts must be positive")
+	check(c.Forward.Backoff > 0, "forward.backoff must be positive")
+	check(c.Forward.MaxBackoff >= c.Forward.Backoff, "forward.max_backoff must not be less than forward.backoff")
+	check(c.Forward.PollInterval > 0, "forward.poll_interval must be positive")
+	check(c.Forward.SRSMaxAge > 0, "forward.srs_max_age must be positive")
+	check(c.Forward.Relay.Timeout > 0, "forward.relay.timeout must be positive")
+	if _, err := forward.ParseTLSMode(c.Forward.Relay.TLS); err != nil {
+		errs = append(errs, fmt.Errorf("forward.relay.tls: %w", err))
+	}
+	if c.Forward.Enabled() {
+		check(len(c.Forward.SRSSecret) >= 16, "forward.srs_secret must be at least 16 characters when forwarding is enabled")
+		check(c.Forward.Relay.Username == "" || c.Forward.Relay.TLS != forward.TLSNone, "forward.relay.username needs forward.relay.tls other than none")
+	}
+
+	check(c.Send.MaxRecipients > 0, "send.max_recipients must be positive")
+	check(c.Send.HourlyQuota > 0, "send.hourly_quota must be positive")
+	check(c.Send.DailyQuota >= c.Send.HourlyQuota, "send.daily_quota must not be less than send.hourly_quota")
+	check(c.Send.MaxBodySize > 0, "send.max_body_size must be positive")
+	check(!c.Send.Enabled() || c.Forward.Enabled(), "send.dkim_keys needs forward.relay.addr to send through")
+	check(c.Filters.MaxPerAddress > 0, "filters.max_per_address must be positive")
+	check(c.Images.ProxyKey == "" || len(c.Images.ProxyKey) >= 16, "images.proxy_key must be at least 16 characters")
+	check(c.Images.MaxSize > 0, "images.max_size must be positive")
+	check(c.Images.CacheSize > 0, "images.cache_size must be positive")
+	check(c.Images.CacheTTL > 0, "images.cache_ttl must be positive")
+	check(c.Images.Timeout > 0, "images.timeout must be positive")
+	check(c.Blobs.Dir == "" || c.Blobs.S3.Endpoint == "", "blobs.dir and blobs.s3.endpoint must not be set together")
+	check(c.Blobs.S3.Endpoint == "" || c.Blobs.S3.Bucket != "", "blobs.s3.bucket is required with blobs.s3.endpoint")
+	check(c.Blobs.S3.Endpoint == "" || c.Blobs.S3.AccessKey != "" && c.Blobs.S3.SecretKey != "", "blobs.s3.access_key and blobs.s3.secret_key are required with blobs.s3.endpoint")
+	check(c.Blobs.GCInterval > 0, "blobs.gc_interval must be positive")
+	check(c.Blobs.GCGrace > 0, "blobs.gc_grace must be positive")
+
+	registry, err := c.Registry()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("domains: %w", err))
+	} else {
+		if c.Forward.SRSDomain != "" {
+			_, ok := registry.Lookup(c.Forward.SRSDomain)
+			check(ok, "forward.srs_domain %q must be one of the receiving domains", c.Forward.SRSDomain)
+		}
+		for _, k := range c.Send.DKIMKeys {
+			_, ok := registry.Lookup(k.Domain)
+			check(ok, "send.dkim_keys: domain %q must be one of the receiving domains", k.Domain)
+		}
+	}
+	if _, err := logging.ParseConfig(c.Log.Format, c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log: %w", err))
+	}
+	if _, err := tracing.ParseExporter(c.Tracing.Exporter); err != nil {
+		errs = append(errs, fmt.Errorf("tracing.exporter: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Registry builds the receiving domains, applying the SMTP defaults to
+// domains without their own retention or size limit.
+func (c *Config) Registry() (*domains.Registry, error) {
+	policies := make([]domains.Policy, 0, len(c.Domains))
+	for _, d := range c.Domains {
+		p := domains.Policy{
+			Name:            d.Name,
+			Retention:       d.Retention,
+			MaxMessageBytes: int64(d.MaxMessageSize),
+		}
+		if p.Retention == 0 {
+			p.Retention = c.SMTP.DefaultRetention
+		}
+		if p.MaxMessageBytes == 0 {
+			p.MaxMessageBytes = int64(c.SMTP.DefaultMaxMessageSize)
+		}
+		policies = append(policies, p)
+	}
+	return domains.NewRegistry(policies...)
+}
+
+// Pairs returns every configured certificate, the cert_path/key_path pair
+// first.
+func (t TLSConfig) Pairs() []certs.Pair {
+	var pairs []certs.Pair
+	if t.CertPath != "" && t.KeyPath != "" {
+		pairs = append(pairs, certs.Pair{CertPath: t.CertPath, KeyPath: t.KeyPath})
+	}
+	return append(pairs, t.Certs...)
+}
+
+// Logging returns the parsed log settings. It must be called on a validated
+// configuration.
+func (c *Config) Logging() logging.Config {
+	cfg, _ := logging.ParseConfig(c.Log.Format, c.Log.Level)
+	return cfg
+}
+
+// TraceExporter returns the parsed exporter name. It must be called on a
+// validated configuration.
+func (c *Config) TraceExporter() string {
+	exporter, _ := tracing.ParseExporter(c.Tracing.Exporter)
+	return exporter
+}
+
+// Write dumps the configuration as YAML with secrets masked.
+func (c *Config) Write(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,345 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/certs"
+	"github.com/fn-jakubkarp/coresend/internal/dkim"
+	"github.com/fn-jakubkarp/coresend/internal/domains"
+)
+
+func envMap(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func parseFlags(t *testing.T, args ...string) *Overrides {
+	t.Helper()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	overrides := RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("flag parse error = %v", err)
+	}
+	return overrides
+}
+
+func TestDefault_IsValid(t *testing.T) {
+	t.Parallel()
+
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("Default().Validate() error = %v", err)
+	}
+}
+
+func TestLoad_File(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfigFile(t, `
+http:
+  read_timeout: 3s
+  rate_limits:
+    inbox: {limit: 10, window: 30s}
+smtp:
+  max_recipients: 5
+  default_max_message_size: 2MiB
+domains:
+  - name: coresend.io
+  - name: tmp.example.org
+    retention: 1h
+`)
+
+	cfg, err := Load(path, envMap(nil), nil)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.HTTP.ReadTimeout != 3*time.Second {
+		t.Fatalf("http.read_timeout = %v, want 3s", cfg.HTTP.ReadTimeout)
+	}
+	if cfg.HTTP.WriteTimeout != 10*time.Second {
+		t.Fatalf("http.write_timeout = %v, want default 10s", cfg.HTTP.WriteTimeout)
+	}
+	if cfg.HTTP.RateLimits.Inbox != (RateLimit{Limit: 10, Window: 30 * time.Second}) {
+		t.Fatalf("inbox rate limit = %+v", cfg.HTTP.RateLimits.Inbox)
+	}
+	if cfg.SMTP.MaxRecipients != 5 {
+		t.Fatalf("smtp.max_recipients = %d, want 5", cfg.SMTP.MaxRecipients)
+	}
+
+	registry, err := cfg.Registry()
+	if err != nil {
+		t.Fatalf("Registry() error = %v", err)
+	}
+	want := []domains.Policy{
+		{Name: "coresend.io", Retention: domains.DefaultRetention, MaxMessageBytes: 2 << 20},
+		{Name: "tmp.example.org", Retention: time.Hour, MaxMessageBytes: 2 << 20},
+	}
+	got := registry.Policies()
+	if len(got) != len(want) {
+		t.Fatalf("policies length = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("policies[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLoad_FileErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "unknown field", content: "smtp:\n  max_recipent: 5\n", wantErr: "max_recipent"},
+		{name: "bad duration", content: "http:\n  read_timeout: soon\n", wantErr: "soon"},
+		{name: "bad size", content: "smtp:\n  default_max_message_size: big\n", wantErr: "big"},
+		{name: "cert without key", content: "smtp:\n  tls:\n    certs:\n      - cert: a.pem\n", wantErr: "both cert and key"},
+		{name: "webhook backoff above max", content: "webhooks:\n  backoff: 2h\n", wantErr: "max_backoff"},
+		{name: "forward without srs secret", content: "forward:\n  relay:\n    addr: smtp.example.com:587\n", wantErr: "srs_secret"},
+		{name: "forward bad tls mode", content: "forward:\n  relay:\n    tls: ssl\n", wantErr: "forward.relay.tls"},
+		{name: "forward auth in plain text", content: "forward:\n  srs_secret: 0123456789abcdef\n  relay:\n    addr: smtp.example.com:25\n    username: relay\n    tls: none\n", wantErr: "forward.relay.username"},
+		{name: "send without relay", content: "send:\n  dkim_keys: localhost:mail:/keys/dkim.pem\n", wantErr: "forward.relay.addr"},
+		{name: "dkim key incomplete", content: "send:\n  dkim_keys:\n    - domain: localhost\n", wantErr: "domain, selector and key"},
+		{name: "send daily below hourly", content: "send:\n  hourly_quota: 20\n  daily_quota: 10\n", wantErr: "send.daily_quota"},
+		{name: "filters without rules", content: "filters:\n  max_per_address: 0\n", wantErr: "filters.max_per_address"},
+		{name: "short image proxy key", content: "images:\n  proxy_key: short\n", wantErr: "images.proxy_key"},
+		{name: "short url key", content: "http:\n  url_key: short\n", wantErr: "http.url_key"},
+		{name: "forward srs domain not served", content: "forward:\n  srs_domain: other.example\n", wantErr: "srs_domain"},
+		{name: "s3 without credentials", content: "blobs:\n  s3:\n    endpoint: http://minio:9000\n    bucket: mail\n", wantErr: "blobs.s3.access_key"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Load(writeConfigFile(t, tc.content), envMap(nil), nil)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("Load() error = %v, want containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfigFile(t, `
+redis:
+  addr: file:6379
+http:
+  listen_addr: ":9000"
+smtp:
+  max_recipients: 5
+`)
+	env := envMap(map[string]string{
+		"HTTP_LISTEN_ADDR":    ":9001",
+		"SMTP_MAX_RECIPIENTS": "7",
+		"REDIS_ADDR":          "",
+	})
+	overrides := parseFlags(t, "-smtp.max_recipients=9")
+
+	cfg, err := Load(path, env, overrides)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.Redis.Addr != "file:6379" {
+		t.Fatalf("redis.addr = %q, empty env should not override the file", cfg.Redis.Addr)
+	}
+	if cfg.HTTP.ListenAddr != ":9001" {
+		t.Fatalf("http.listen_addr = %q, env should override the file", cfg.HTTP.ListenAddr)
+	}
+	if cfg.SMTP.MaxRecipients != 9 {
+		t.Fatalf("smtp.max_recipients = %d, flag should override env", cfg.SMTP.MaxRecipients)
+	}
+}
+
+func TestLoad_EnvFormats(t *testing.T) {
+	t.Parallel()
+
+	env := envMap(map[string]string{
+		"DOMAIN_NAME":            "a.example,b.example;max_size=256KiB",
+		"HTTP_DELETE_RATE_LIMIT": "5/10s",
+		"SMTP_REQUIRE_TLS":       "true",
+		"SMTP_DEDUP":             "true",
+		"SMTP_KEEP_SOURCE":       "true",
+		"REDIS_COMPRESSION":      "true",
+		"SMTP_MAX_PART_SIZE":     "4MiB",
+		"SMTP_TLS_CERTS":         "a.pem:a.key",
+		"SMTP_DEFAULT_RETENTION": "2h",
+		"SMTP_SPOOL_DIR":         "/var/spool/coresend",
+		"SMTP_SPOOL_MAX_SIZE":    "16MiB",
+		"WEBHOOK_MAX_ATTEMPTS":   "3",
+		"WEBHOOK_ALLOW_PRIVATE":  "true",
+		"FORWARD_RELAY_ADDR":     "smtp.example.com:465",
+		"FORWARD_RELAY_TLS":      "tls",
+		"FORWARD_SRS_SECRET":     "0123456789abcdef",
+		"FORWARD_SRS_DOMAIN":     "b.example",
+		"SEND_DKIM_KEYS":         "B.example:mail:/keys/b.pem",
+		"SEND_HOURLY_QUOTA":      "3",
+		"FILTER_MAX_PER_ADDRESS": "5",
+		"IMAGE_PROXY":            "false",
+		"IMAGE_PROXY_MAX_SIZE":   "1MiB",
+		"BLOB_S3_ENDPOINT":       "http://minio:9000",
+		"BLOB_S3_BUCKET":         "mail",
+		"BLOB_S3_ACCESS_KEY":     "coresend",
+		"BLOB_S3_SECRET_KEY":     "secret",
+		"BLOB_GC_GRACE":          "2h",
+	})
+
+	cfg, err := Load("", env, nil)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	wantDomains := Domains{{Name: "a.example"}, {Name: "b.example", MaxMessageSize: 256 << 10}}
+	if len(cfg.Domains) != len(wantDomains) {
+		t.Fatalf("domains = %+v, want %+v", cfg.Domains, wantDomains)
+	}
+	for i := range wantDomains {
+		if cfg.Domains[i] != wantDomains[i] {
+			t.Fatalf("domains[%d] = %+v, want %+v", i, cfg.Domains[i], wantDomains[i])
+		}
+	}
+	if cfg.HTTP.RateLimits.Delete != (RateLimit{Limit: 5, Window: 10 * time.Second}) {
+		t.Fatalf("delete rate limit = %+v", cfg.HTTP.RateLimits.Delete)
+	}
+	if !cfg.SMTP.RequireTLS {
+		t.Fatal("smtp.require_tls = false, want true")
+	}
+	if !cfg.SMTP.Dedup {
+		t.Fatal("smtp.dedup = false, want true")
+	}
+	if !cfg.SMTP.KeepSource {
+		t.Fatal("smtp.keep_source = false, want true")
+	}
+	if !cfg.Redis.Compression {
+		t.Fatal("redis.compression = false, want true")
+	}
+	if cfg.SMTP.MaxPartSize != 4<<20 {
+		t.Fatalf("smtp.max_part_size = %d, want 4MiB", cfg.SMTP.MaxPartSize)
+	}
+	if got := cfg.Blobs; !got.Enabled() || got.S3.Bucket != "mail" || got.S3.Region != "us-east-1" || got.S3.SecretKey != "secret" || got.GCGrace != 2*time.Hour {
+		t.Fatalf("blobs = %+v", got)
+	}
+	if got := cfg.SMTP.TLS.Pairs(); len(got) != 1 || got[0] != (certs.Pair{CertPath: "a.pem", KeyPath: "a.key"}) {
+		t.Fatalf("tls pairs = %+v", got)
+	}
+	if got := cfg.SMTP.Spool; got.Dir != "/var/spool/coresend" || got.MaxSize != 16<<20 || got.ReplayInterval != 10*time.Second {
+		t.Fatalf("smtp.spool = %+v", got)
+	}
+	if got := cfg.Webhooks; got.MaxAttempts != 3 || !got.AllowPrivate || got.Backoff != 10*time.Second {
+		t.Fatalf("webhooks = %+v", got)
+	}
+	if got := cfg.Forward; !got.Enabled() || got.Relay.TLS != "tls" || got.SRSDomain != "b.example" || got.MaxAttempts != 10 {
+		t.Fatalf("forward = %+v", got)
+	}
+	if got := cfg.Send; !got.Enabled() || got.DKIMKeys[0] != (dkim.KeyFile{Domain: "b.example", Selector: "mail", Path: "/keys/b.pem"}) || got.HourlyQuota != 3 {
+		t.Fatalf("send = %+v", got)
+	}
+	if cfg.Filters.MaxPerAddress != 5 {
+		t.Fatalf("filters.max_per_address = %d, want 5", cfg.Filters.MaxPerAddress)
+	}
+	if got := cfg.Images; got.Proxy || got.MaxSize != 1<<20 || got.CacheTTL != time.Hour {
+		t.Fatalf("images = %+v", got)
+	}
+
+	registry, err := cfg.Registry()
+	if err != nil {
+		t.Fatalf("Registry() error = %v", err)
+	}
+	if p, _ := registry.Lookup("a.example"); p.Retention != 2*time.Hour {
+		t.Fatalf("a.example retention = %v, want smtp default 2h", p.Retention)
+	}
+}
+
+func TestLoad_ReportsAllErrors(t *testing.T) {
+	t.Parallel()
+
+	env := envMap(map[string]string{
+		"HTTP_INBOX_RATE_LIMIT": "sixty",
+		"SMTP_REQUIRE_TLS":      "true",
+		"LOG_LEVEL":             "loud",
+	})
+	overrides := parseFlags(t, "-http.read_timeout=0s", "-smtp.tls.cert_path=a.pem")
+
+	_, err := Load("", env, overrides)
+	if err == nil {
+		t.Fatal("Load() expected error")
+	}
+
+	for _, want := range []string{
+		"HTTP_INBOX_RATE_LIMIT",
+		"http.read_timeout must be positive",
+		"smtp.tls.cert_path and smtp.tls.key_path must be set together",
+		"smtp.require_tls needs a TLS certificate",
+		"log:",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("Load() error = %v, want containing %q", err, want)
+		}
+	}
+}
+
+func TestRegisterFlags_RejectsUnknownSetting(t *testing.T) {
+	t.Parallel()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	RegisterFlags(fs)
+	if err := fs.Parse([]string{"-smtp.max_recipent=5"}); err == nil {
+		t.Fatal("Parse() expected error for unknown setting")
+	}
+}
+
+func TestWrite_RoundTripsAndMasksSecrets(t *testing.T) {
+	t.Parallel()
+
+	cfg := Default()
+	cfg.Redis.Password = "hunter2"
+	cfg.SMTP.TLS.Certs = CertPairs{{CertPath: "a.pem", KeyPath: "a.key"}}
+	cfg.Domains = Domains{{Name: "coresend.io", MaxMessageSize: 2 << 20}}
+
+	var buf bytes.Buffer
+	if err := cfg.Write(&buf); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	out := buf.String()
+
+	if strings.Contains(out, "hunter2") {
+		t.Fatalf("Write() leaked the redis password:\n%s", out)
+	}
+	if !strings.Contains(out, "max_message_size: 2MiB") {
+		t.Fatalf("Write() output missing size with unit:\n%s", out)
+	}
+
+	loaded, err := Load(writeConfigFile(t, out), envMap(nil), nil)
+	if err != nil {
+		t.Fatalf("Load() of written config error = %v", err)
+	}
+	if loaded.SMTP.TLS.Certs[0] != cfg.SMTP.TLS.Certs[0] || loaded.Domains[0] != cfg.Domains[0] {
+		t.Fatalf("round trip mismatch: got %+v / %+v", loaded.SMTP.TLS.Certs, loaded.Domains)
+	}
+}
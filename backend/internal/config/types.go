@@ -0,0 +1,280 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/certs"
+	"github.com/fn-jakubkarp/coresend/internal/dkim"
+	"github.com/fn-jakubkarp/coresend/internal/domains"
+	"gopkg.in/yaml.v3"
+)
+
+// ByteSize is a byte count written as a plain number or with a KiB, MiB or
+// GiB suffix.
+type ByteSize int64
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	n, err := domains.ParseSize(string(text))
+	if err != nil {
+		return err
+	}
+	*b = ByteSize(n)
+	return nil
+}
+
+func (b ByteSize) MarshalText() ([]byte, error) {
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}} {
+		if b > 0 && int64(b)%unit.size == 0 {
+			return []byte(strconv.FormatInt(int64(b)/unit.size, 10) + unit.suffix), nil
+		}
+	}
+	return []byte(strconv.FormatInt(int64(b), 10)), nil
+}
+
+// Secret is a string that is masked when the configuration is printed.
+type Secret string
+
+func (s *Secret) UnmarshalText(text []byte) error {
+	*s = Secret(text)
+	return nil
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return []byte("********"), nil
+}
+
+// RateLimit allows Limit requests per Window. In environment variables and
+// flags it is written as "60/1m".
+type RateLimit struct {
+	Limit  int           `yaml:"limit"`
+	Window time.Duration `yaml:"window"`
+}
+
+func (r *RateLimit) UnmarshalText(text []byte) error {
+	limit, window, ok := strings.Cut(string(text), "/")
+	if !ok {
+		return fmt.Errorf("invalid rate limit %q, want limit/window such as 60/1m", text)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(limit))
+	if err != nil {
+		return fmt.Errorf("invalid rate limit %q: %w", text, err)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(window))
+	if err != nil {
+		return fmt.Errorf("invalid rate limit %q: %w", text, err)
+	}
+	*r = RateLimit{Limit: n, Window: d}
+	return nil
+}
+
+// DomainConfig is one receiving domain. Zero values fall back to the SMTP
+// defaults.
+type DomainConfig struct {
+	Name           string        `yaml:"name"`
+	Retention      time.Duration `yaml:"retention,omitempty"`
+	MaxMessageSize ByteSize      `yaml:"max_message_size,omitempty"`
+}
+
+// Domains is written as a YAML list, or as the DOMAIN_NAME spec accepted by
+// domains.Parse.
+type Domains []DomainConfig
+
+func (d *Domains) UnmarshalText(text []byte) error {
+	policies, err := domains.ParsePolicies(string(text))
+	if err != nil {
+		return err
+	}
+	out := make(Domains, 0, len(policies))
+	for _, p := range policies {
+		out = append(out, DomainConfig{Name: p.Name, Retention: p.Retention, MaxMessageSize: ByteSize(p.MaxMessageBytes)})
+	}
+	*d = out
+	return nil
+}
+
+func (d *Domains) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return d.UnmarshalText([]byte(node.Value))
+	}
+	var list []DomainConfig
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*d = list
+	return nil
+}
+
+// CertPairs is written as a YAML list of cert/key entries, or as the
+// SMTP_TLS_CERTS spec accepted by certs.ParsePairs.
+type CertPairs []certs.Pair
+
+type certPairYAML struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+func (c *CertPairs) UnmarshalText(text []byte) error {
+	pairs, err := certs.ParsePairs(string(text))
+	if err != nil {
+		return err
+	}
+	*c = pairs
+	return nil
+}
+
+func (c *CertPairs) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return c.UnmarshalText([]byte(node.Value))
+	}
+	var list []certPairYAML
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	pairs := make(CertPairs, 0, len(list))
+	for _, p := range list {
+		if p.Cert == "" || p.Key == "" {
+			return fmt.Errorf("certificate entries need both cert and key")
+		}
+		pairs = append(pairs, certs.Pair{CertPath: p.Cert, KeyPath: p.Key})
+	}
+	*c = pairs
+	return nil
+}
+
+func (c CertPairs) MarshalYAML() (any, error) {
+	list := make([]certPairYAML, 0, len(c))
+	for _, p := range c {
+		list = append(list, certPairYAML{Cert: p.CertPath, Key: p.KeyPath})
+	}
+	return list, nil
+}
+
+// DKIMKeys is written as a YAML list of domain/selector/key entries, or as
+// the SEND_DKIM_KEYS spec accepted by dkim.ParseKeyFiles.
+type DKIMKeys []dkim.KeyFile
+
+type dkimKeyYAML struct {
+	Domain   string `yaml:"domain"`
+	Selector string `yaml:"selector"`
+	Key      string `yaml:"key"`
+}
+
+func (d *DKIMKeys) UnmarshalText(text []byte) error {
+	keys, err := dkim.ParseKeyFiles(string(text))
+	if err != nil {
+		return err
+	}
+	*d = keys
+	return nil
+}
+
+func (d *DKIMKeys) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return d.UnmarshalText([]byte(node.Value))
+	}
+	var list []dkimKeyYAML
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	keys := make(DKIMKeys, 0, len(list))
+	for _, k := range list {
+		if k.Domain == "" || k.Selector == "" || k.Key == "" {
+			return fmt.Errorf("DKIM key entries need domain, selector and key")
+		}
+		keys = append(keys, dkim.KeyFile{Domain: strings.ToLower(k.Domain), Selector: k.Selector, Path: k.Key})
+	}
+	*d = keys
+	return nil
+}
+
+func (d DKIMKeys) MarshalYAML() (any, error) {
+	list := make([]dkimKeyYAML, 0, len(d))
+	for _, k := range d {
+		list = append(list, dkimKeyYAML{Domain: k.Domain, Selector: k.Selector, Key: k.Path})
+	}
+	return list, nil
+}
+
+// setting is a single overridable value in a Config.
+type setting struct {
+	path  string
+	env   string
+	value reflect.Value
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// settings lists the leaf values of cfg with their dotted YAML paths.
+func settings(cfg *Config) []setting {
+	var out []setting
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			path := prefix + name
+			fv := v.Field(i)
+
+			if field.Type.Kind() == reflect.Struct && !reflect.PointerTo(field.Type).Implements(textUnmarshalerType) {
+				walk(fv, path+".")
+				continue
+			}
+			out = append(out, setting{path: path, env: field.Tag.Get("env"), value: fv})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return out
+}
+
+func (s setting) set(raw string) error {
+	if !s.value.IsValid() {
+		return fmt.Errorf("unknown setting")
+	}
+	if u, ok := s.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(raw))
+	}
+
+	switch {
+	case s.value.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(d))
+	case s.value.Kind() == reflect.String:
+		s.value.SetString(raw)
+	case s.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetBool(b)
+	case s.value.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(n))
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+	return nil
+}
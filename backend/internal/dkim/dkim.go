@@ -0,0 +1,268 @@
+// Package dkim signs outgoing messages with DKIM (RFC 6376).
+//
+// Signatures use relaxed canonicalization for both header and body, and
+// rsa-sha256 or ed25519-sha256 (RFC 8463) depending on the key.
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHeaders are signed when they are present. From is signed once more
+// than it appears so another From cannot be added without breaking the
+// signature.
+var DefaultHeaders = []string{
+	"From", "From", "To", "Cc", "Subject", "Date", "Message-Id",
+	"In-Reply-To", "References", "Mime-Version", "Content-Type",
+	"Content-Transfer-Encoding",
+}
+
+// KeyFile is a private key on disk that signs for Domain under Selector.
+type KeyFile struct {
+	Domain   string
+	Selector string
+	Path     string
+}
+
+// ParseKeyFiles parses a comma-separated list of domain:selector:path
+// entries.
+func ParseKeyFiles(spec string) ([]KeyFile, error) {
+	var keys []KeyFile
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid DKIM key %q, want domain:selector:path", entry)
+		}
+		keys = append(keys, KeyFile{Domain: strings.ToLower(parts[0]), Selector: parts[1], Path: parts[2]})
+	}
+	return keys, nil
+}
+
+// Signer adds DKIM signatures for one domain.
+type Signer struct {
+	Domain   string
+	Selector string
+	// Key is an *rsa.PrivateKey or ed25519.PrivateKey.
+	Key crypto.Signer
+	// Headers are the header fields to sign. Nil selects DefaultHeaders.
+	Headers []string
+}
+
+// Load reads the PEM encoded PKCS#8 or PKCS#1 private key of k.
+func Load(k KeyFile) (*Signer, error) {
+	data, err := os.ReadFile(k.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DKIM key for %s: %w", k.Domain, err)
+	}
+	key, err := ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DKIM key %s: %w", k.Path, err)
+	}
+	return &Signer{Domain: k.Domain, Selector: k.Selector, Key: key}, nil
+}
+
+// ParseKey parses a PEM encoded RSA or Ed25519 private key.
+func ParseKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 1024 {
+			return nil, errors.New("RSA keys must have at least 1024 bits")
+		}
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+// Sign returns msg, a message with CRLF line endings, with a DKIM-Signature
+// header prepended.
+func (s *Signer) Sign(msg []byte, now time.Time) ([]byte, error) {
+	algorithm, hash, err := s.algorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	header, body := splitMessage(msg)
+	fields := parseHeader(header)
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	headers := s.Headers
+	if headers == nil {
+		headers = DefaultHeaders
+	}
+	var (
+		names  []string
+		signed bytes.Buffer
+	)
+	// Repeated fields are signed from the bottom up
+	used := make(map[string]int)
+	for _, name := range headers {
+		key := strings.ToLower(name)
+		instances := fieldsNamed(fields, key)
+		if len(instances) == 0 {
+			continue
+		}
+		// Listing a field more often than it appears signs its absence
+		if n := used[key]; n < len(instances) {
+			signed.WriteString(relaxedHeader(instances[len(instances)-1-n]))
+			signed.WriteString("\r\n")
+		}
+		used[key]++
+		names = append(names, key)
+	}
+
+	sig := "DKIM-Signature: v=1; a=" + algorithm + "; c=relaxed/relaxed;\r\n" +
+		"\td=" + s.Domain + "; s=" + s.Selector + "; t=" + strconv.FormatInt(now.Unix(), 10) + ";\r\n" +
+		"\th=" + strings.Join(names, ":") + ";\r\n" +
+		"\tbh=" + base64.StdEncoding.EncodeToString(bodyHash[:]) + ";\r\n" +
+		"\tb="
+	signed.WriteString(relaxedHeader(sig))
+
+	digest := sha256.Sum256(signed.Bytes())
+	signature, err := s.Key.Sign(rand.Reader, digest[:], hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	out := make([]byte, 0, len(sig)+base64.StdEncoding.EncodedLen(len(signature))+2+len(msg))
+	out = append(out, sig...)
+	out = append(out, base64.StdEncoding.EncodeToString(signature)...)
+	out = append(out, "\r\n"...)
+	return append(out, msg...), nil
+}
+
+// algorithm returns the a= tag and the hash passed to Key.Sign.
+func (s *Signer) algorithm() (string, crypto.Hash, error) {
+	switch s.Key.Public().(type) {
+	case *rsa.PublicKey:
+		return "rsa-sha256", crypto.SHA256, nil
+	case ed25519.PublicKey:
+		// Ed25519 signs the SHA-256 digest itself, without prehashing
+		return "ed25519-sha256", crypto.Hash(0), nil
+	}
+	return "", 0, fmt.Errorf("unsupported key type %T", s.Key)
+}
+
+// splitMessage splits msg at the empty line that ends the header. The header
+// keeps its final CRLF.
+func splitMessage(msg []byte) (header, body []byte) {
+	if bytes.HasPrefix(msg, []byte("\r\n")) {
+		return nil, msg[2:]
+	}
+	if i := bytes.Index(msg, []byte("\r\n\r\n")); i >= 0 {
+		return msg[:i+2], msg[i+4:]
+	}
+	return msg, nil
+}
+
+// parseHeader returns each header field with its folding intact and without
+// the final CRLF.
+func parseHeader(header []byte) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += "\r\n" + strings.TrimSuffix(line, "\r\n")
+			continue
+		}
+		fields = append(fields, strings.TrimSuffix(line, "\r\n"))
+	}
+	return fields
+}
+
+func fieldsNamed(fields []string, name string) []string {
+	var out []string
+	for _, f := range fields {
+		if fieldName, _, ok := strings.Cut(f, ":"); ok && strings.EqualFold(strings.TrimRight(fieldName, " \t"), name) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// relaxedHeader canonicalizes a header field as RFC 6376 section 3.4.2
+// describes, without the final CRLF.
+func relaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	name = strings.ToLower(strings.TrimRight(name, " \t"))
+	value = strings.ReplaceAll(value, "\r\n", "")
+	return name + ":" + strings.TrimSpace(collapseSpace(value))
+}
+
+// relaxedBody canonicalizes a body as RFC 6376 section 3.4.4 describes.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	var out strings.Builder
+	blank := 0
+	for i, line := range lines {
+		if i == len(lines)-1 && line == "" {
+			break
+		}
+		line = strings.TrimRight(collapseSpace(line), " ")
+		if line == "" {
+			// Trailing empty lines are dropped, so hold them back
+			blank++
+			continue
+		}
+		for ; blank > 0; blank-- {
+			out.WriteString("\r\n")
+		}
+		out.WriteString(line)
+		out.WriteString("\r\n")
+	}
+	return []byte(out.String())
+}
+
+// collapseSpace replaces every run of spaces and tabs with a single space.
+func collapseSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteByte(s[i])
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
@@ -0,0 +1,255 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMessage = "From: Box <box@coresend.test>\r\n" +
+	"To: tester@example.com\r\n" +
+	"Subject: Hello\r\n" +
+	"\tagain\r\n" +
+	"Message-Id: <1@coresend.test>\r\n" +
+	"X-Unsigned: yes\r\n" +
+	"\r\n" +
+	"Hi  there \r\n" +
+	"\r\n" +
+	"\r\n"
+
+func TestRelaxedCanonicalization(t *testing.T) {
+	t.Parallel()
+
+	// The example of RFC 6376 section 3.4.5
+	header := "A: X\r\nB : Y\t\r\n\tZ  \r\n"
+	fields := parseHeader([]byte(header))
+	if len(fields) != 2 {
+		t.Fatalf("parseHeader() = %q, want 2 fields", fields)
+	}
+	if got := relaxedHeader(fields[0]) + "\r\n" + relaxedHeader(fields[1]) + "\r\n"; got != "a:X\r\nb:Y Z\r\n" {
+		t.Fatalf("relaxed header = %q", got)
+	}
+
+	body := " C \r\nD \t E\r\n\r\n\r\n"
+	if got := string(relaxedBody([]byte(body))); got != " C\r\nD E\r\n" {
+		t.Fatalf("relaxed body = %q", got)
+	}
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{body: "", want: ""},
+		{body: "\r\n\r\n", want: ""},
+		{body: "a", want: "a\r\n"},
+		{body: "a\r\n\r\nb\r\n", want: "a\r\n\r\nb\r\n"},
+	}
+	for _, tc := range tests {
+		if got := string(relaxedBody([]byte(tc.body))); got != tc.want {
+			t.Fatalf("relaxedBody(%q) = %q, want %q", tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestSigner_Sign(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		key           crypto.Signer
+		wantAlgorithm string
+	}{
+		{name: "rsa", key: rsaKey, wantAlgorithm: "rsa-sha256"},
+		{name: "ed25519", key: edKey, wantAlgorithm: "ed25519-sha256"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			signer := &Signer{Domain: "coresend.test", Selector: "mail", Key: tc.key}
+			now := time.Unix(1700000000, 0)
+			signed, err := signer.Sign([]byte(testMessage), now)
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+			if !strings.HasSuffix(string(signed), testMessage) {
+				t.Fatal("Sign() changed the message")
+			}
+
+			tags, err := verify(signed, tc.key.Public())
+			if err != nil {
+				t.Fatalf("verify() error = %v", err)
+			}
+			want := map[string]string{
+				"a": tc.wantAlgorithm,
+				"c": "relaxed/relaxed",
+				"d": "coresend.test",
+				"s": "mail",
+				"t": "1700000000",
+				"h": "from:from:to:subject:message-id",
+			}
+			for tag, value := range want {
+				if tags[tag] != value {
+					t.Fatalf("%s= %q, want %q", tag, tags[tag], value)
+				}
+			}
+
+			// Changing a signed header, or the body, breaks the signature
+			for _, tampered := range []string{
+				strings.Replace(string(signed), "Subject: Hello", "Subject: Hullo", 1),
+				strings.Replace(string(signed), "Hi  there", "Bye there", 1),
+				strings.Replace(string(signed), "To: tester", "From: mallory@example.org\r\nTo: tester", 1),
+			} {
+				if _, err := verify([]byte(tampered), tc.key.Public()); err == nil {
+					t.Fatalf("tampered message verifies:\n%s", tampered)
+				}
+			}
+			// Unsigned headers and whitespace may change in transit
+			relaxed := strings.Replace(string(signed), "X-Unsigned: yes", "X-Unsigned: no", 1)
+			relaxed = strings.Replace(relaxed, "Subject: Hello", "Subject:  Hello ", 1)
+			if _, err := verify([]byte(relaxed), tc.key.Public()); err != nil {
+				t.Fatalf("relaxed changes broke the signature: %v", err)
+			}
+		})
+	}
+}
+
+// verify checks the DKIM-Signature at the top of msg and returns its tags.
+func verify(msg []byte, pub crypto.PublicKey) (map[string]string, error) {
+	header, body := splitMessage(msg)
+	fields := parseHeader(header)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "DKIM-Signature:") {
+		return nil, errors.New("no DKIM-Signature at the top")
+	}
+	_, value, _ := strings.Cut(fields[0], ":")
+	tags := map[string]string{}
+	for _, tag := range strings.Split(value, ";") {
+		name, v, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(v), "")
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bodyHash[:]) {
+		return tags, errors.New("bh= does not match the body")
+	}
+
+	var data strings.Builder
+	used := map[string]int{}
+	for _, name := range strings.Split(tags["h"], ":") {
+		instances := fieldsNamed(fields[1:], name)
+		if n := used[name]; n < len(instances) {
+			data.WriteString(relaxedHeader(instances[len(instances)-1-n]) + "\r\n")
+		}
+		used[name]++
+	}
+	unsigned := fields[0][:strings.LastIndex(fields[0], "b=")+2]
+	data.WriteString(relaxedHeader(unsigned))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return tags, err
+	}
+	digest := sha256.Sum256([]byte(data.String()))
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return tags, rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest[:], sig) {
+			return tags, errors.New("invalid signature")
+		}
+	}
+	return tags, nil
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		pem     []byte
+		wantErr bool
+	}{
+		{name: "pkcs1 rsa", pem: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})},
+		{name: "pkcs8 ed25519", pem: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})},
+		{name: "not pem", pem: []byte("secret"), wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "dkim.pem")
+			if err := os.WriteFile(path, tc.pem, 0o600); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			signer, err := Load(KeyFile{Domain: "coresend.test", Selector: "mail", Path: path})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Load() error = %v, want error %v", err, tc.wantErr)
+			}
+			if err == nil && (signer.Domain != "coresend.test" || signer.Selector != "mail") {
+				t.Fatalf("Load() = %+v", signer)
+			}
+		})
+	}
+
+	if _, err := Load(KeyFile{Domain: "coresend.test", Path: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Fatal("Load() of a missing file error = nil")
+	}
+}
+
+func TestParseKeyFiles(t *testing.T) {
+	t.Parallel()
+
+	keys, err := ParseKeyFiles("CoreSend.test:mail:/keys/a.pem, tmp.example:s2:/keys/b.pem")
+	if err != nil {
+		t.Fatalf("ParseKeyFiles() error = %v", err)
+	}
+	want := []KeyFile{
+		{Domain: "coresend.test", Selector: "mail", Path: "/keys/a.pem"},
+		{Domain: "tmp.example", Selector: "s2", Path: "/keys/b.pem"},
+	}
+	if len(keys) != len(want) || keys[0] != want[0] || keys[1] != want[1] {
+		t.Fatalf("ParseKeyFiles() = %+v, want %+v", keys, want)
+	}
+
+	for _, spec := range []string{"coresend.test:/keys/a.pem", "coresend.test::/keys/a.pem"} {
+		if _, err := ParseKeyFiles(spec); err == nil {
+			t.Fatalf("ParseKeyFiles(%q) error = nil", spec)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package domains
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRetention       = 24 * time.Hour
+	DefaultMaxMessageBytes = 1024 * 1024
+)
+
+// Policy holds the receiving rules for a single domain.
+type Policy struct {
+	Name            string
+	Retention       time.Duration
+	MaxMessageBytes int64
+}
+
+// Registry is the set of domains the server accepts mail for.
+// The first configured domain is the default.
+type Registry struct {
+	policies map[string]Policy
+	names    []string
+}
+
+func NewRegistry(policies ...Policy) (*Registry, error) {
+	if len(policies) == 0 {
+		return nil, errors.New("at least one domain is required")
+	}
+
+	r := &Registry{policies: make(map[string]Policy, len(policies))}
+	for _, p := range policies {
+		p.Name = Normalize(p.Name)
+		if p.Name == "" {
+			return nil, errors.New("domain name cannot be empty")
+		}
+		if _, exists := r.policies[p.Name]; exists {
+			return nil, fmt.Errorf("domain %q configured twice", p.Name)
+		}
+		if p.Retention < 0 || p.MaxMessageBytes < 0 {
+			return nil, fmt.Errorf("domain %q: retention and size limits must be positive", p.Name)
+		}
+		if p.Retention == 0 {
+			p.Retention = DefaultRetention
+		}
+		if p.MaxMessageBytes == 0 {
+			p.MaxMessageBytes = DefaultMaxMessageBytes
+		}
+
+		r.policies[p.Name] = p
+		r.names = append(r.names, p.Name)
+	}
+
+	return r, nil
+}
+
+// Parse builds a registry from a comma-separated list of domains. Each entry
+// may carry options separated by semicolons, for example:
+//
+//	coresend.io,tmp.example.org;retention=1h;max_size=256KiB
+func Parse(spec string) (*Registry, error) {
+	policies, err := ParsePolicies(spec)
+	if err != nil {
+		return nil, err
+	}
+	return NewRegistry(policies...)
+}
+
+// ParsePolicies parses the same format as Parse but leaves options that were
+// not given as zero instead of applying defaults.
+func ParsePolicies(spec string) ([]Policy, error) {
+	var policies []Policy
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.Split(entry, ";")
+		p := Policy{Name: strings.TrimSpace(fields[0])}
+		for _, opt := range fields[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(opt), "=")
+			if !ok {
+				return nil, fmt.Errorf("domain %q: option %q must be key=value", p.Name, opt)
+			}
+
+			var err error
+			switch strings.TrimSpace(key) {
+			case "retention":
+				p.Retention, err = time.ParseDuration(strings.TrimSpace(value))
+			case "max_size":
+				p.MaxMessageBytes, err = ParseSize(value)
+			default:
+				err = fmt.Errorf("unknown option %q", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("domain %q: %w", p.Name, err)
+			}
+		}
+		policies = append(policies, p)
+	}
+
+	return policies, nil
+}
+
+// ParseSize parses a byte count with an optional KiB, MiB or GiB suffix.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30} {
+		if strings.HasSuffix(s, suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, suffix))
+			multiplier = m
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// Normalize lowercases a domain and strips a trailing root dot.
+func Normalize(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+func (r *Registry) Lookup(name string) (Policy, bool) {
+	p, ok := r.policies[Normalize(name)]
+	return p, ok
+}
+
+func (r *Registry) Default() Policy {
+	return r.policies[r.names[0]]
+}
+
+// Policies returns every configured domain in configuration order.
+func (r *Registry) Policies() []Policy {
+	out := make([]Policy, 0, len(r.names))
+	for _, name := range r.names {
+		out = append(out, r.policies[name])
+	}
+	return out
+}
+
+// MaxRetention returns the longest retention across all domains.
+func (r *Registry) MaxRetention() time.Duration {
+	var max time.Duration
+	for _, p := range r.policies {
+		if p.Retention > max {
+			max = p.Retention
+		}
+	}
+	return max
+}
+
+// MaxMessageBytes returns the largest size limit across all domains.
+func (r *Registry) MaxMessageBytes() int64 {
+	var max int64
+	for _, p := range r.policies {
+		if p.MaxMessageBytes > max {
+			max = p.MaxMessageBytes
+		}
+	}
+	return max
+}
@@ -0,0 +1,135 @@
+package domains
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	r, err := Parse(" CoreSend.io. , tmp.example.org;retention=1h;max_size=256KiB ")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := []Policy{
+		{Name: "coresend.io", Retention: DefaultRetention, MaxMessageBytes: DefaultMaxMessageBytes},
+		{Name: "tmp.example.org", Retention: time.Hour, MaxMessageBytes: 256 << 10},
+	}
+	got := r.Policies()
+	if len(got) != len(want) {
+		t.Fatalf("policies length = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("policies[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if r.Default().Name != "coresend.io" {
+		t.Fatalf("default = %q, want %q", r.Default().Name, "coresend.io")
+	}
+	if r.MaxRetention() != DefaultRetention {
+		t.Fatalf("max retention = %v, want %v", r.MaxRetention(), DefaultRetention)
+	}
+	if r.MaxMessageBytes() != DefaultMaxMessageBytes {
+		t.Fatalf("max message bytes = %d, want %d", r.MaxMessageBytes(), DefaultMaxMessageBytes)
+	}
+}
+
+func TestParsePolicies_LeavesUnsetOptionsZero(t *testing.T) {
+	t.Parallel()
+
+	got, err := ParsePolicies("a.example,b.example;max_size=2MiB")
+	if err != nil {
+		t.Fatalf("ParsePolicies() error = %v", err)
+	}
+
+	want := []Policy{
+		{Name: "a.example"},
+		{Name: "b.example", MaxMessageBytes: 2 << 20},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("policies length = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("policies[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{name: "empty", spec: " , "},
+		{name: "duplicate", spec: "a.example,A.example"},
+		{name: "option without value", spec: "a.example;retention"},
+		{name: "unknown option", spec: "a.example;colour=blue"},
+		{name: "bad retention", spec: "a.example;retention=soon"},
+		{name: "bad size", spec: "a.example;max_size=big"},
+		{name: "negative retention", spec: "a.example;retention=-1h"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := Parse(tc.spec); err == nil {
+				t.Fatalf("Parse(%q) expected error", tc.spec)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	r, err := NewRegistry(Policy{Name: "coresend.io"})
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	for _, name := range []string{"coresend.io", "CORESEND.IO", "coresend.io."} {
+		if _, ok := r.Lookup(name); !ok {
+			t.Fatalf("Lookup(%q) not found", name)
+		}
+	}
+	for _, name := range []string{"", "example.com", "sub.coresend.io"} {
+		if _, ok := r.Lookup(name); ok {
+			t.Fatalf("Lookup(%q) unexpectedly found", name)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]int64{
+		"1024":  1024,
+		"2KiB":  2048,
+		"1 MiB": 1 << 20,
+		"25MiB": 25 << 20,
+		"1GiB":  1 << 30,
+	}
+	for input, want := range tests {
+		got, err := ParseSize(input)
+		if err != nil {
+			t.Fatalf("ParseSize(%q) error = %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("ParseSize(%q) = %d, want %d", input, got, want)
+		}
+	}
+
+	for _, input := range []string{"", "-1", "1MB", "lots"} {
+		if _, err := ParseSize(input); err == nil {
+			t.Fatalf("ParseSize(%q) expected error", input)
+		}
+	}
+}
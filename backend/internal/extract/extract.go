@@ -0,0 +1,286 @@
+// Package extract finds the parts of an email test scripts usually want:
+// one-time codes, verification and password reset links, and unsubscribe
+// targets.
+//
+// Extraction runs once at ingest and its results are stored with the
+// email. Codes are found with heuristics, so each carries a confidence
+// between 0 and 1 and the most likely code comes first.
+package extract
+
+import (
+	"cmp"
+	"math"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/fn-jakubkarp/coresend/internal/store"
+)
+
+// Link kinds.
+const (
+	KindVerify = "verify"
+	KindReset  = "reset"
+	KindLogin  = "login"
+)
+
+const (
+	maxCodes = 5
+	maxLinks = 10
+	// minConfidence drops candidates that are most likely other numbers.
+	minConfidence = 0.2
+)
+
+// Message is what extraction looks at.
+type Message struct {
+	Subject string
+	// Text and HTML are the plain text and HTML bodies, either may be empty.
+	Text string
+	HTML string
+	// Header supplies List-Unsubscribe; it may be nil.
+	Header Header
+}
+
+// Header gives the values of a header field by name, ignoring case.
+type Header interface {
+	Values(key string) []string
+}
+
+// Extract returns what it finds in msg, or nil when there is nothing.
+func Extract(msg Message) *store.Extracted {
+	text := msg.Text
+	var anchors []anchor
+	if msg.HTML != "" {
+		var htmlText string
+		htmlText, anchors = parseHTML(msg.HTML)
+		if text == "" {
+			text = htmlText
+		}
+	}
+
+	var unsubscribe []string
+	if msg.Header != nil {
+		unsubscribe = listUnsubscribe(msg.Header.Values("List-Unsubscribe"))
+	}
+
+	ex := &store.Extracted{
+		Codes:       findCodes(msg.Subject, text),
+		Links:       findLinks(text, anchors, unsubscribe),
+		Unsubscribe: unsubscribe,
+	}
+	if len(ex.Codes) == 0 && len(ex.Links) == 0 && len(ex.Unsubscribe) == 0 {
+		return nil
+	}
+	return ex
+}
+
+var (
+	// candidatePattern matches runs of 4 to 8 digits, two groups of three
+	// digits, and runs of 6 to 10 uppercase letters and digits.
+	candidatePattern = regexp.MustCompile(`\b(?:\d{3}[- ]\d{3}|\d{4,8}|[A-Z0-9]{6,10})\b`)
+	keywordPattern   = regexp.MustCompile(`(?i)\b(?:code|otp|passcode|pin|verification|verify|one[- ]time|security|token|confirm\w*|log ?in|sign[- ]?in|2fa|two[- ]factor|authenticat\w*)\b`)
+	urlPattern       = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'()]+`)
+	emailPattern     = regexp.MustCompile(`[\w.+-]+@[\w-]+(?:\.[\w-]+)+`)
+	yearPattern      = regexp.MustCompile(`^(?:19|20)\d\d$`)
+)
+
+// findCodes scores every candidate in the subject and text and returns the
+// likeliest, best first.
+func findCodes(subject, text string) []store.Code {
+	subjectHint := keywordPattern.MatchString(subject)
+	best := map[string]store.Code{}
+	order := map[string]int{}
+
+	lines := append([]string{subject}, strings.Split(text, "\n")...)
+	prev := ""
+	for i, line := range lines {
+		// URLs and addresses are full of digit runs that are not codes
+		clean := urlPattern.ReplaceAllStringFunc(line, blank)
+		clean = emailPattern.ReplaceAllStringFunc(clean, blank)
+
+		for _, loc := range candidatePattern.FindAllStringIndex(clean, -1) {
+			value := clean[loc[0]:loc[1]]
+			score, ok := baseScore(value)
+			if !ok {
+				continue
+			}
+			before := clean[max(0, loc[0]-60):loc[0]]
+			if strings.TrimSpace(clean[:loc[0]]) == "" {
+				// A code alone on its line is usually introduced by the one
+				// before
+				before = prev + " " + before
+			}
+			if keywordPattern.MatchString(before) || keywordPattern.MatchString(clean[loc[1]:min(len(clean), loc[1]+30)]) {
+				score += 0.35
+			}
+			if strings.TrimSpace(clean) == value {
+				score += 0.1
+			}
+			if subjectHint {
+				score += 0.05
+			}
+			if looksLikeQuantity(clean, loc[0], loc[1]) {
+				score -= 0.4
+			}
+			if yearPattern.MatchString(value) {
+				score -= 0.3
+			}
+
+			score = math.Round(min(score, 1)*100) / 100
+			value = strings.NewReplacer("-", "", " ", "").Replace(value)
+			if score < minConfidence || score <= best[value].Confidence {
+				continue
+			}
+			if _, seen := order[value]; !seen {
+				order[value] = len(order)
+			}
+			best[value] = store.Code{Value: value, Confidence: score}
+		}
+		if i > 0 && strings.TrimSpace(line) != "" {
+			prev = line
+		}
+	}
+
+	codes := make([]store.Code, 0, len(best))
+	for _, c := range best {
+		codes = append(codes, c)
+	}
+	slices.SortFunc(codes, func(a, b store.Code) int {
+		if c := cmp.Compare(b.Confidence, a.Confidence); c != 0 {
+			return c
+		}
+		return cmp.Compare(order[a.Value], order[b.Value])
+	})
+	if len(codes) > maxCodes {
+		codes = codes[:maxCodes]
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	return codes
+}
+
+// baseScore rates a candidate by its shape alone. ok is false for words
+// that cannot be codes.
+func baseScore(value string) (score float64, ok bool) {
+	digits, letters := 0, 0
+	for _, c := range value {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c >= 'A' && c <= 'Z':
+			letters++
+		}
+	}
+	switch {
+	case letters > 0 && digits == 0:
+		return 0, false
+	case letters > 0:
+		return 0.3, true
+	case len(value) == 7 && digits == 6:
+		// 123-456 or 123 456
+		return 0.45, true
+	case digits == 6:
+		return 0.5, true
+	case digits > 8:
+		return 0, false
+	default:
+		return 0.35, true
+	}
+}
+
+// looksLikeQuantity reports whether the candidate at s[start:end] is part of
+// a price, percentage, time, date, phone number or longer number.
+func looksLikeQuantity(s string, start, end int) bool {
+	if start > 0 {
+		switch p := s[start-1]; {
+		case strings.IndexByte("$€£#/:+", p) >= 0:
+			return true
+		case (p == '.' || p == ',') && start > 1 && isDigit(s[start-2]):
+			return true
+		}
+	}
+	if end < len(s) {
+		switch n := s[end]; {
+		case strings.IndexByte("%/:", n) >= 0:
+			return true
+		case (n == '.' || n == ',') && end+1 < len(s) && isDigit(s[end+1]):
+			return true
+		}
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func blank(s string) string {
+	return strings.Repeat(" ", len(s))
+}
+
+// linkKinds classifies links by the words in their URL or anchor text; the
+// first match wins.
+var linkKinds = []struct {
+	kind    string
+	pattern *regexp.Regexp
+}{
+	{kind: KindReset, pattern: regexp.MustCompile(`(?i)reset|forgot|recover|password`)},
+	{kind: KindVerify, pattern: regexp.MustCompile(`(?i)verif|confirm|activat|validat`)},
+	{kind: KindLogin, pattern: regexp.MustCompile(`(?i)magic|log[- ]?in|sign[- ]?in|auth|token|otp`)},
+}
+
+// findLinks returns the verification, reset and login links among the URLs
+// of the text and the HTML anchors. Unsubscribe links are left out.
+func findLinks(text string, anchors []anchor, unsubscribe []string) []store.Link {
+	for _, u := range urlPattern.FindAllString(text, -1) {
+		anchors = append(anchors, anchor{href: strings.TrimRight(u, ".,;:!?")})
+	}
+
+	var links []store.Link
+	seen := map[string]bool{}
+	for _, a := range anchors {
+		if seen[a.href] || slices.Contains(unsubscribe, a.href) || !isWebURL(a.href) {
+			continue
+		}
+		seen[a.href] = true
+		if strings.Contains(strings.ToLower(a.href+" "+a.text), "unsubscribe") {
+			continue
+		}
+		for _, k := range linkKinds {
+			if k.pattern.MatchString(a.href) || k.pattern.MatchString(a.text) {
+				links = append(links, store.Link{URL: a.href, Kind: k.kind})
+				break
+			}
+		}
+		if len(links) == maxLinks {
+			break
+		}
+	}
+	return links
+}
+
+func isWebURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// listUnsubscribe returns the targets of List-Unsubscribe values, which are
+// comma-separated URIs in angle brackets.
+func listUnsubscribe(values []string) []string {
+	var targets []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if !strings.HasPrefix(part, "<") || !strings.HasSuffix(part, ">") {
+				continue
+			}
+			target := strings.TrimSpace(part[1 : len(part)-1])
+			if u, err := url.Parse(target); err == nil && (u.Scheme == "mailto" || isWebURL(target)) && !slices.Contains(targets, target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets
+}
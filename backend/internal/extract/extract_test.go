@@ -0,0 +1,148 @@
+package extract
+
+import (
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/fn-jakubkarp/coresend/internal/store"
+)
+
+func TestExtract_Codes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		msg      Message
+		wantCode string
+		// wantMin bounds the confidence of wantCode from below
+		wantMin float64
+	}{
+		{
+			name:     "code after a keyword",
+			msg:      Message{Subject: "Welcome", Text: "Your verification code is 482913. It expires in 10 minutes."},
+			wantCode: "482913",
+			wantMin:  0.8,
+		},
+		{
+			name:     "code on its own line",
+			msg:      Message{Subject: "Sign in to Example", Text: "Enter this code to continue:\n\n  731055\n\nThanks"},
+			wantCode: "731055",
+			wantMin:  0.9,
+		},
+		{
+			name:     "code in the subject",
+			msg:      Message{Subject: "193844 is your Example login code", Text: "Hi there"},
+			wantCode: "193844",
+			wantMin:  0.8,
+		},
+		{
+			name:     "split code",
+			msg:      Message{Text: "Your one-time passcode: 123 456"},
+			wantCode: "123456",
+			wantMin:  0.75,
+		},
+		{
+			name:     "alphanumeric code",
+			msg:      Message{Text: "Use security code K7Q2ZP to confirm your email"},
+			wantCode: "K7Q2ZP",
+			wantMin:  0.6,
+		},
+		{
+			name:     "HTML only",
+			msg:      Message{HTML: `<html><head><style>p{color:#123456}</style></head><body><p>Your code</p><p><b>904417</b></p></body></html>`},
+			wantCode: "904417",
+			wantMin:  0.9,
+		},
+		{
+			name:     "code beats the year and price",
+			msg:      Message{Text: "Order total $1999 in 2024.\nYour PIN is 8841"},
+			wantCode: "8841",
+			wantMin:  0.7,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := Extract(tc.msg)
+			if got == nil || len(got.Codes) == 0 {
+				t.Fatalf("Extract() = %+v, want code %q", got, tc.wantCode)
+			}
+			if got.Codes[0].Value != tc.wantCode || got.Codes[0].Confidence < tc.wantMin || got.Codes[0].Confidence > 1 {
+				t.Fatalf("Extract() codes = %+v, want %q first with confidence >= %v", got.Codes, tc.wantCode, tc.wantMin)
+			}
+		})
+	}
+}
+
+func TestExtract_NoCodes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{name: "prose", msg: Message{Subject: "Lunch", Text: "See you at NOON tomorrow"}},
+		{name: "numbers in URLs and addresses", msg: Message{Text: "See https://example.com/orders/482913 or mail 482913@example.com"}},
+		{name: "time and date", msg: Message{Text: "Meeting at 14:30 on 2024/06/01, 25% done"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := Extract(tc.msg); got != nil && len(got.Codes) > 0 {
+				t.Fatalf("Extract() codes = %+v, want none", got.Codes)
+			}
+		})
+	}
+}
+
+func TestExtract_Links(t *testing.T) {
+	t.Parallel()
+
+	header := textproto.MIMEHeader{}
+	header.Set("List-Unsubscribe", "<mailto:leave@example.com?subject=unsubscribe>, <https://example.com/u/1>, junk")
+	msg := Message{
+		Text: "Confirm your account: https://example.com/verify?token=abc.\nRead the docs at https://example.com/docs",
+		HTML: `<p><a href="https://example.com/r/9f8e">Reset your password</a>
+<a href="https://example.com/m/1">Sign in</a>
+<a href="https://example.com/prefs">Unsubscribe</a>
+<a href="javascript:alert(1)">verify</a>
+<a href="https://example.com/u/1">Confirm unsubscribe</a></p>`,
+		Header: header,
+	}
+
+	got := Extract(msg)
+	if got == nil {
+		t.Fatal("Extract() = nil")
+	}
+
+	want := []store.Link{
+		{URL: "https://example.com/r/9f8e", Kind: KindReset},
+		{URL: "https://example.com/m/1", Kind: KindLogin},
+		{URL: "https://example.com/verify?token=abc", Kind: KindVerify},
+	}
+	if len(got.Links) != len(want) {
+		t.Fatalf("Extract() links = %+v, want %+v", got.Links, want)
+	}
+	for i := range want {
+		if got.Links[i] != want[i] {
+			t.Fatalf("Extract() links = %+v, want %+v", got.Links, want)
+		}
+	}
+
+	if strings.Join(got.Unsubscribe, " ") != "mailto:leave@example.com?subject=unsubscribe https://example.com/u/1" {
+		t.Fatalf("Extract() unsubscribe = %v", got.Unsubscribe)
+	}
+}
+
+func TestExtract_Nothing(t *testing.T) {
+	t.Parallel()
+
+	if got := Extract(Message{Subject: "Hello", Text: "Just saying hi"}); got != nil {
+		t.Fatalf("Extract() = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,82 @@
+package extract
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// anchor is a link in an HTML body.
+type anchor struct {
+	href string
+	text string
+}
+
+// blockElements end a line of text.
+var blockElements = map[atom.Atom]bool{
+	atom.Br: true, atom.P: true, atom.Div: true, atom.Tr: true, atom.Td: true,
+	atom.Li: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true,
+	atom.H5: true, atom.H6: true, atom.Table: true, atom.Blockquote: true,
+}
+
+// parseHTML returns the visible text of body, one line per block, and its
+// links.
+func parseHTML(body string) (string, []anchor) {
+	var (
+		text    strings.Builder
+		anchors []anchor
+		current *anchor
+		skip    int
+	)
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return text.String(), anchors
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			t := strings.Join(strings.Fields(string(z.Text())), " ")
+			if t == "" {
+				continue
+			}
+			text.WriteString(t + " ")
+			if current != nil {
+				current.text = strings.TrimSpace(current.text + " " + t)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			tag := atom.Lookup(name)
+			switch {
+			case tag == atom.Script || tag == atom.Style || tag == atom.Head:
+				skip++
+			case blockElements[tag]:
+				text.WriteString("\n")
+			case tag == atom.A && hasAttr:
+				for {
+					key, val, more := z.TagAttr()
+					if string(key) == "href" {
+						anchors = append(anchors, anchor{href: strings.TrimSpace(string(val))})
+						current = &anchors[len(anchors)-1]
+					}
+					if !more {
+						break
+					}
+				}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			tag := atom.Lookup(name)
+			switch {
+			case tag == atom.Script || tag == atom.Style || tag == atom.Head:
+				skip = max(0, skip-1)
+			case blockElements[tag]:
+				text.WriteString("\n")
+			case tag == atom.A:
+				current = nil
+			}
+		}
+	}
+}
@@ -0,0 +1,337 @@
+// Package filter applies the filtering rules an address owner defines to
+// the mail it receives.
+//
+// A rule matches on the envelope sender, the subject, a header or the
+// message size, and drops the message, rejects it at SMTP time, labels it or
+// makes it expire early. Rules that only look at the envelope are checked at
+// RCPT TO, so matching senders are refused before they send any data; the
+// rest are checked once the message has been received.
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/fn-jakubkarp/coresend/internal/store"
+	"github.com/google/uuid"
+)
+
+// Actions a rule can take.
+const (
+	ActionDrop   = "drop"
+	ActionReject = "reject"
+	ActionLabel  = "label"
+	ActionExpire = "expire"
+)
+
+// DefaultMaxPerAddress applies when Filters.MaxPerAddress is zero.
+const DefaultMaxPerAddress = 20
+
+const (
+	// maxPatternLength bounds the sender glob, subject regex and header
+	// values.
+	maxPatternLength = 256
+	maxLabelLength   = 64
+	// maxExpireMinutes bounds early expiry to a week; inboxes rarely live
+	// longer.
+	maxExpireMinutes = 7 * 24 * 60
+)
+
+// ErrInvalidRule is wrapped by every error Validate returns.
+var ErrInvalidRule = errors.New("invalid filtering rule")
+
+var (
+	ErrNoCondition    = invalid("a rule needs at least one condition")
+	ErrInvalidPattern = invalid("from_glob, subject_regex, header and header_contains must be at most 256 characters")
+	ErrInvalidRegex   = invalid("subject_regex is not a valid regular expression")
+	ErrInvalidHeader  = invalid("header must be a header field name, and is needed for header_contains")
+	ErrInvalidSize    = invalid("min_size must not be negative")
+	ErrInvalidAction  = invalid("action must be drop, reject, label or expire")
+	ErrInvalidLabel   = invalid("label must be 1 to 64 printable characters, and only set for the label action")
+	ErrInvalidExpiry  = invalid("expire_minutes must be 1 to 10080, and only set for the expire action")
+	ErrTooMany        = errors.New("too many filtering rules for this address")
+	ErrNotRegistered  = errors.New("address is not registered")
+)
+
+func invalid(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidRule, msg)
+}
+
+type Filters struct {
+	Store         store.FilterStore
+	MaxPerAddress int
+}
+
+// Message is what rules are matched against. At RCPT TO only Sender, and
+// Size when the client declared one, are known; Header is nil until the
+// message has been received.
+type Message struct {
+	Sender  string
+	Subject string
+	Header  Header
+	Size    int64
+}
+
+// Header gives the values of a header field by name, ignoring case.
+type Header interface {
+	Values(key string) []string
+}
+
+// Verdict is the combined outcome of the rules matching a message.
+type Verdict struct {
+	// Reject and Drop are set by the first matching rule with either
+	// action; later rules are not applied.
+	Reject bool
+	Drop   bool
+	// RuleID is the rule that rejected or dropped the message.
+	RuleID string
+	Labels []string
+	// ExpireAfter is the shortest expiry of the matching rules, or zero.
+	ExpireAfter time.Duration
+}
+
+// Apply sets the verdict's labels and expiry on email, received at
+// email.ReceivedAt.
+func (v Verdict) Apply(email store.Email) store.Email {
+	if len(v.Labels) > 0 {
+		email.Labels = append(append([]string(nil), email.Labels...), v.Labels...)
+	}
+	if v.ExpireAfter > 0 {
+		email.ExpiresAt = email.ReceivedAt.Add(v.ExpireAfter)
+	}
+	return email
+}
+
+// Rule is a filtering rule with its patterns compiled, as rules are matched
+// against every message their address receives.
+type Rule struct {
+	store.FilterRule
+	from    *regexp.Regexp
+	subject *regexp.Regexp
+}
+
+// Compile compiles the patterns of rule.
+func Compile(rule store.FilterRule) (Rule, error) {
+	r := Rule{FilterRule: rule}
+	if rule.FromGlob != "" {
+		r.from = globRegexp(rule.FromGlob)
+	}
+	if rule.SubjectRegex != "" {
+		var err error
+		if r.subject, err = regexp.Compile(rule.SubjectRegex); err != nil {
+			return Rule{}, ErrInvalidRegex
+		}
+	}
+	return r, nil
+}
+
+// CompileAll compiles the rules of an address as loaded from the store. A
+// rule whose patterns do not compile could never match, so it is left out.
+func CompileAll(rules []store.FilterRule) []Rule {
+	compiled := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		if r, err := Compile(rule); err == nil {
+			compiled = append(compiled, r)
+		}
+	}
+	return compiled
+}
+
+// Register validates a rule and adds it after the other rules of address.
+// The rules are kept with the address's registration and end with it.
+func (f *Filters) Register(ctx context.Context, address string, rule store.FilterRule) (store.FilterRule, error) {
+	if err := Validate(rule); err != nil {
+		return store.FilterRule{}, err
+	}
+
+	rule.ID = uuid.New().String()
+	rule.CreatedAt = time.Now().UTC()
+	err := f.Store.AddFilterRule(ctx, address, rule, f.maxPerAddress())
+	switch {
+	case errors.Is(err, store.ErrLimitReached):
+		return store.FilterRule{}, ErrTooMany
+	case errors.Is(err, store.ErrNotRegistered):
+		return store.FilterRule{}, ErrNotRegistered
+	case err != nil:
+		return store.FilterRule{}, err
+	}
+	return rule, nil
+}
+
+// Validate checks that rule has a condition, valid patterns and an action
+// with the settings it needs.
+func Validate(rule store.FilterRule) error {
+	if rule.FromGlob == "" && rule.SubjectRegex == "" && rule.Header == "" && rule.MinSize == 0 {
+		return ErrNoCondition
+	}
+	for _, p := range []string{rule.FromGlob, rule.SubjectRegex, rule.Header, rule.HeaderContains} {
+		if len(p) > maxPatternLength {
+			return ErrInvalidPattern
+		}
+	}
+	if _, err := Compile(rule); err != nil {
+		return err
+	}
+	if (rule.Header == "" && rule.HeaderContains != "") || !isFieldName(rule.Header) {
+		return ErrInvalidHeader
+	}
+	if rule.MinSize < 0 {
+		return ErrInvalidSize
+	}
+
+	switch rule.Action {
+	case ActionDrop, ActionReject, ActionLabel, ActionExpire:
+	default:
+		return ErrInvalidAction
+	}
+	if (rule.Action == ActionLabel) != (rule.Label != "") || !isLabel(rule.Label) {
+		return ErrInvalidLabel
+	}
+	if (rule.Action == ActionExpire) != (rule.ExpireMinutes != 0) || rule.ExpireMinutes < 0 || rule.ExpireMinutes > maxExpireMinutes {
+		return ErrInvalidExpiry
+	}
+	return nil
+}
+
+// isFieldName reports whether name is empty or a valid header field name.
+func isFieldName(name string) bool {
+	for _, c := range name {
+		if c <= ' ' || c > '~' || c == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+func isLabel(label string) bool {
+	if len(label) > maxLabelLength {
+		return false
+	}
+	for _, c := range label {
+		if !unicode.IsPrint(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Evaluate applies rules, in order, to msg.
+func Evaluate(rules []Rule, msg Message) Verdict {
+	var v Verdict
+	for _, rule := range rules {
+		if matched, decided := rule.Matches(msg); !matched || !decided {
+			continue
+		}
+		switch rule.Action {
+		case ActionReject:
+			v.Reject, v.RuleID = true, rule.ID
+			return v
+		case ActionDrop:
+			v.Drop, v.RuleID = true, rule.ID
+			return v
+		case ActionLabel:
+			if !slices.Contains(v.Labels, rule.Label) {
+				v.Labels = append(v.Labels, rule.Label)
+			}
+		case ActionExpire:
+			after := time.Duration(rule.ExpireMinutes) * time.Minute
+			if v.ExpireAfter == 0 || after < v.ExpireAfter {
+				v.ExpireAfter = after
+			}
+		}
+	}
+	return v
+}
+
+// RejectsEnvelope reports whether a reject rule refuses msg on its envelope
+// alone, and returns the rule's ID. A drop or reject rule that comes first
+// and may match once the header is known leaves the decision to DATA.
+func RejectsEnvelope(rules []Rule, msg Message) (string, bool) {
+	msg.Header = nil
+	for _, rule := range rules {
+		if rule.Action != ActionReject && rule.Action != ActionDrop {
+			continue
+		}
+		matched, decided := rule.Matches(msg)
+		if !decided {
+			return "", false
+		}
+		if matched && rule.Action == ActionReject {
+			return rule.ID, true
+		}
+		// A matching drop rule that comes first wins at DATA
+		if matched {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// Matches reports whether the rule applies to msg. decided is false when msg
+// lacks what the rule needs: the header, or a size.
+func (rule Rule) Matches(msg Message) (matched, decided bool) {
+	decided = true
+	if rule.from != nil && !rule.from.MatchString(msg.Sender) {
+		return false, true
+	}
+	if rule.MinSize > 0 {
+		if msg.Size == 0 {
+			decided = false
+		} else if msg.Size < rule.MinSize {
+			return false, true
+		}
+	}
+	if rule.SubjectRegex != "" || rule.Header != "" {
+		if msg.Header == nil {
+			return false, false
+		}
+		if rule.subject != nil && !rule.subject.MatchString(msg.Subject) {
+			return false, true
+		}
+		if rule.Header != "" && !headerMatch(msg.Header.Values(rule.Header), rule.HeaderContains) {
+			return false, true
+		}
+	}
+	return decided, decided
+}
+
+func headerMatch(values []string, contains string) bool {
+	for _, v := range values {
+		if strings.Contains(strings.ToLower(v), strings.ToLower(contains)) {
+			return true
+		}
+	}
+	return false
+}
+
+// globRegexp returns the expression matching what pattern does, ignoring
+// case. * matches any run of characters and ? any single one.
+func globRegexp(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("(?is)^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+func (f *Filters) maxPerAddress() int {
+	if f.MaxPerAddress > 0 {
+		return f.MaxPerAddress
+	}
+	return DefaultMaxPerAddress
+}
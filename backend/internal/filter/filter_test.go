@@ -0,0 +1,235 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fn-jakubkarp/coresend/internal/store"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		rule    store.FilterRule
+		wantErr error
+	}{
+		{name: "sender glob", rule: store.FilterRule{FromGlob: "*@news.example.com", Action: ActionReject}},
+		{name: "header presence", rule: store.FilterRule{Header: "List-Unsubscribe", Action: ActionLabel, Label: "marketing"}},
+		{name: "size and expiry", rule: store.FilterRule{MinSize: 1 << 20, Action: ActionExpire, ExpireMinutes: 30}},
+		{name: "no condition", rule: store.FilterRule{Action: ActionDrop}, wantErr: ErrNoCondition},
+		{name: "long glob", rule: store.FilterRule{FromGlob: strings.Repeat("a", 257), Action: ActionDrop}, wantErr: ErrInvalidPattern},
+		{name: "bad regex", rule: store.FilterRule{SubjectRegex: "(", Action: ActionDrop}, wantErr: ErrInvalidRegex},
+		{name: "bad header name", rule: store.FilterRule{Header: "X Bad:", Action: ActionDrop}, wantErr: ErrInvalidHeader},
+		{name: "value without header", rule: store.FilterRule{FromGlob: "*", HeaderContains: "x", Action: ActionDrop}, wantErr: ErrInvalidHeader},
+		{name: "negative size", rule: store.FilterRule{FromGlob: "*", MinSize: -1, Action: ActionDrop}, wantErr: ErrInvalidSize},
+		{name: "unknown action", rule: store.FilterRule{FromGlob: "*", Action: "archive"}, wantErr: ErrInvalidAction},
+		{name: "label missing", rule: store.FilterRule{FromGlob: "*", Action: ActionLabel}, wantErr: ErrInvalidLabel},
+		{name: "label on drop", rule: store.FilterRule{FromGlob: "*", Action: ActionDrop, Label: "x"}, wantErr: ErrInvalidLabel},
+		{name: "label with newline", rule: store.FilterRule{FromGlob: "*", Action: ActionLabel, Label: "a\nb"}, wantErr: ErrInvalidLabel},
+		{name: "expiry missing", rule: store.FilterRule{FromGlob: "*", Action: ActionExpire}, wantErr: ErrInvalidExpiry},
+		{name: "expiry too long", rule: store.FilterRule{FromGlob: "*", Action: ActionExpire, ExpireMinutes: maxExpireMinutes + 1}, wantErr: ErrInvalidExpiry},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := Validate(tc.rule); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	t.Parallel()
+
+	header := textproto.MIMEHeader{}
+	header.Set("List-Unsubscribe", "<https://news.example.com/unsubscribe>")
+	received := Message{Sender: "Deals@News.Example.com", Subject: "50% off today", Header: header, Size: 2048}
+	envelope := Message{Sender: received.Sender}
+
+	tests := []struct {
+		name        string
+		rule        store.FilterRule
+		msg         Message
+		wantMatch   bool
+		wantDecided bool
+	}{
+		{name: "glob ignores case", rule: store.FilterRule{FromGlob: "*@news.example.com"}, msg: envelope, wantMatch: true, wantDecided: true},
+		{name: "glob single character", rule: store.FilterRule{FromGlob: "deal?@*"}, msg: envelope, wantMatch: true, wantDecided: true},
+		{name: "glob is anchored", rule: store.FilterRule{FromGlob: "news.example.com"}, msg: envelope, wantDecided: true},
+		{name: "glob metacharacters are literal", rule: store.FilterRule{FromGlob: "deals@news.example.co."}, msg: envelope, wantDecided: true},
+		{name: "subject regex", rule: store.FilterRule{SubjectRegex: `\d+% off`}, msg: received, wantMatch: true, wantDecided: true},
+		{name: "subject regex at envelope", rule: store.FilterRule{SubjectRegex: `off`}, msg: envelope},
+		{name: "header presence", rule: store.FilterRule{Header: "list-unsubscribe"}, msg: received, wantMatch: true, wantDecided: true},
+		{name: "header value", rule: store.FilterRule{Header: "List-Unsubscribe", HeaderContains: "NEWS.example"}, msg: received, wantMatch: true, wantDecided: true},
+		{name: "header missing", rule: store.FilterRule{Header: "X-Spam"}, msg: received, wantDecided: true},
+		{name: "size", rule: store.FilterRule{MinSize: 1024}, msg: received, wantMatch: true, wantDecided: true},
+		{name: "too small", rule: store.FilterRule{MinSize: 4096}, msg: received, wantDecided: true},
+		{name: "size unknown", rule: store.FilterRule{MinSize: 1024}, msg: envelope},
+		{name: "sender mismatch decides early", rule: store.FilterRule{FromGlob: "*@other.test", SubjectRegex: "off"}, msg: envelope, wantDecided: true},
+		{name: "all conditions", rule: store.FilterRule{FromGlob: "*@news.example.com", SubjectRegex: "off", MinSize: 1024}, msg: received, wantMatch: true, wantDecided: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rule, err := Compile(tc.rule)
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			matched, decided := rule.Matches(tc.msg)
+			if matched != tc.wantMatch || decided != tc.wantDecided {
+				t.Fatalf("Matches() = %v, %v, want %v, %v", matched, decided, tc.wantMatch, tc.wantDecided)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	t.Parallel()
+
+	header := textproto.MIMEHeader{}
+	header.Set("List-Unsubscribe", "<mailto:leave@news.example.com>")
+	msg := Message{Sender: "deals@news.example.com", Subject: "Sale", Header: header, Size: 100}
+
+	label := func(id, l string) store.FilterRule {
+		return store.FilterRule{ID: id, Header: "List-Unsubscribe", Action: ActionLabel, Label: l}
+	}
+	expire := func(id string, minutes int) store.FilterRule {
+		return store.FilterRule{ID: id, FromGlob: "*", Action: ActionExpire, ExpireMinutes: minutes}
+	}
+
+	tests := []struct {
+		name  string
+		rules []store.FilterRule
+		want  Verdict
+	}{
+		{name: "no rules"},
+		{
+			name:  "labels and shortest expiry accumulate",
+			rules: []store.FilterRule{label("1", "marketing"), expire("2", 60), label("3", "marketing"), label("4", "sale"), expire("5", 10)},
+			want:  Verdict{Labels: []string{"marketing", "sale"}, ExpireAfter: 10 * time.Minute},
+		},
+		{
+			name:  "first drop or reject wins",
+			rules: []store.FilterRule{label("1", "marketing"), {ID: "2", SubjectRegex: "Sale", Action: ActionDrop}, {ID: "3", FromGlob: "*", Action: ActionReject}},
+			want:  Verdict{Drop: true, RuleID: "2", Labels: []string{"marketing"}},
+		},
+		{
+			name:  "non-matching rules are skipped",
+			rules: []store.FilterRule{{ID: "1", FromGlob: "*@other.test", Action: ActionReject}, {ID: "2", MinSize: 1000, Action: ActionDrop}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := Evaluate(CompileAll(tc.rules), msg)
+			if got.Reject != tc.want.Reject || got.Drop != tc.want.Drop || got.RuleID != tc.want.RuleID ||
+				strings.Join(got.Labels, ",") != strings.Join(tc.want.Labels, ",") || got.ExpireAfter != tc.want.ExpireAfter {
+				t.Fatalf("Evaluate() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRejectsEnvelope(t *testing.T) {
+	t.Parallel()
+
+	reject := store.FilterRule{ID: "reject", FromGlob: "*@news.example.com", Action: ActionReject}
+	msg := Message{Sender: "deals@news.example.com"}
+
+	tests := []struct {
+		name  string
+		rules []store.FilterRule
+		msg   Message
+		want  bool
+	}{
+		{name: "matching sender", rules: []store.FilterRule{reject}, msg: msg, want: true},
+		{name: "other sender", rules: []store.FilterRule{reject}, msg: Message{Sender: "ci@example.com"}},
+		{name: "needs the header", rules: []store.FilterRule{{ID: "r", FromGlob: "*", SubjectRegex: "x", Action: ActionReject}}, msg: msg},
+		{name: "declared size", rules: []store.FilterRule{{ID: "r", MinSize: 10, Action: ActionReject}}, msg: Message{Size: 20}, want: true},
+		{name: "earlier drop wins", rules: []store.FilterRule{{ID: "d", FromGlob: "deals@*", Action: ActionDrop}, reject}, msg: msg},
+		{name: "earlier header drop defers to DATA", rules: []store.FilterRule{{ID: "d", SubjectRegex: "deal", Action: ActionDrop}, reject}, msg: msg},
+		{name: "earlier header reject defers to DATA", rules: []store.FilterRule{{ID: "r", Header: "List-Id", Action: ActionReject}, reject}, msg: msg},
+		{name: "earlier header label does not", rules: []store.FilterRule{{ID: "l", SubjectRegex: "deal", Action: ActionLabel, Label: "x"}, reject}, msg: msg, want: true},
+		{name: "labels do not stop it", rules: []store.FilterRule{{ID: "l", FromGlob: "*", Action: ActionLabel, Label: "x"}, reject}, msg: msg, want: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, got := RejectsEnvelope(CompileAll(tc.rules), tc.msg); got != tc.want {
+				t.Fatalf("RejectsEnvelope() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestVerdict_Apply(t *testing.T) {
+	t.Parallel()
+
+	received := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	email := store.Email{ID: "e", ReceivedAt: received}
+
+	got := Verdict{Labels: []string{"marketing"}, ExpireAfter: 15 * time.Minute}.Apply(email)
+	if strings.Join(got.Labels, ",") != "marketing" || !got.ExpiresAt.Equal(received.Add(15*time.Minute)) {
+		t.Fatalf("Apply() = %+v", got)
+	}
+	if email.Labels != nil || !email.ExpiresAt.IsZero() {
+		t.Fatalf("Apply() changed the original: %+v", email)
+	}
+	if got := (Verdict{}).Apply(email); got.Labels != nil || !got.ExpiresAt.IsZero() {
+		t.Fatalf("empty verdict Apply() = %+v", got)
+	}
+}
+
+func TestFilters_Register(t *testing.T) {
+	t.Parallel()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	ctx := context.Background()
+	s := store.NewStore(mr.Addr(), "")
+	f := &Filters{Store: s, MaxPerAddress: 1}
+	address := "0123456789abcdef0123456789abcdef01234567"
+
+	if _, err := f.Register(ctx, address, store.FilterRule{Action: ActionDrop}); !errors.Is(err, ErrNoCondition) {
+		t.Fatalf("Register() invalid rule error = %v, want ErrNoCondition", err)
+	}
+	if _, err := f.Register(ctx, address, store.FilterRule{FromGlob: "*", Action: ActionDrop}); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("Register() unregistered error = %v, want ErrNotRegistered", err)
+	}
+
+	if err := s.RegisterAddress(ctx, address, "coresend.io", time.Hour); err != nil {
+		t.Fatalf("RegisterAddress() error = %v", err)
+	}
+
+	rule, err := f.Register(ctx, address, store.FilterRule{FromGlob: "*@news.example.com", Action: ActionDrop})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if rule.ID == "" || rule.CreatedAt.IsZero() {
+		t.Fatalf("Register() = %+v, want an ID and creation time", rule)
+	}
+
+	if _, err := f.Register(ctx, address, store.FilterRule{FromGlob: "*", Action: ActionDrop}); !errors.Is(err, ErrTooMany) {
+		t.Fatalf("Register() over the limit error = %v, want ErrTooMany", err)
+	}
+}
@@ -0,0 +1,434 @@
+// Package forward relays mail received by an address to real mailboxes.
+//
+// An address owner registers rules naming a destination and, optionally,
+// sender and subject filters. Matching messages are queued in the store with
+// their raw content, or its digest when there is a blob store, and relayed
+// unchanged, apart from two added headers, through a configured smarthost. The envelope sender is rewritten with SRS
+// so the destination's SPF checks pass; bounces to the rewritten sender come
+// back to this server and are relayed to the original sender.
+//
+// Failed relays are retried with exponential backoff. Messages the smarthost
+// rejects permanently, or that run out of attempts, are dropped and logged.
+// The same queue carries mail sent from addresses by package send.
+package forward
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/blob"
+	"github.com/fn-jakubkarp/coresend/internal/domains"
+	"github.com/fn-jakubkarp/coresend/internal/logging"
+	"github.com/fn-jakubkarp/coresend/internal/metrics"
+	"github.com/fn-jakubkarp/coresend/internal/store"
+	"github.com/fn-jakubkarp/coresend/internal/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Defaults applied when the matching Forwarder field is zero.
+const (
+	DefaultMaxAttempts   = 10
+	DefaultBackoff       = time.Minute
+	DefaultMaxBackoff    = 4 * time.Hour
+	DefaultMaxPerAddress = 3
+)
+
+// Relay results counted in metrics.
+const (
+	ResultRelayed  = "relayed"
+	ResultRetrying = "retrying"
+	ResultFailed   = "failed"
+)
+
+const (
+	// batchSize bounds the jobs claimed, and relayed concurrently, per poll.
+	batchSize = 16
+	// leaseMargin is added to the relay timeout to cover the store calls
+	// around a relay.
+	leaseMargin = 30 * time.Second
+	// maxFilterLength bounds the sender and subject filters.
+	maxFilterLength = 256
+)
+
+var (
+	ErrInvalidDestination = errors.New("destination must be a plain email address")
+	ErrLocalDestination   = errors.New("destination must not be on a domain served here")
+	ErrInvalidFilter      = errors.New("filters must be at most 256 characters")
+	ErrTooMany            = errors.New("too many forwarding rules for this address")
+
+	errNoBlobs = errors.New("queued message is in a blob store, but there is none")
+)
+
+type Forwarder struct {
+	Store store.ForwardStore
+	Relay *Relay
+	SRS   *SRS
+	// Domains are the receiving domains; destinations on them are refused so
+	// rules cannot loop.
+	Domains *domains.Registry
+	// MaxAttempts is how many relays are tried before a message is dropped.
+	MaxAttempts int
+	// Backoff is the wait after the first failure; it doubles with every
+	// further failure up to MaxBackoff.
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	MaxPerAddress int
+	// Blobs, when set, keeps the messages of queued relays, which are then
+	// held there until the relay can no longer be retried.
+	Blobs blob.Store
+}
+
+// Raw is a message as received: its content, or the digest of it in
+// Forwarder.Blobs.
+type Raw struct {
+	Data   []byte
+	SHA256 string
+}
+
+// Source returns a message as received. Enqueue calls it only once a rule
+// matches, so it can be read back from wherever it was kept.
+type Source func(ctx context.Context) (Raw, error)
+
+// Source returns a Source that gives r.
+func (r Raw) Source() Source {
+	return func(context.Context) (Raw, error) { return r, nil }
+}
+
+// Register validates a rule and adds it to address. Adding a rule keeps the
+// address's existing rules too, for another ttl.
+func (f *Forwarder) Register(ctx context.Context, address string, rule store.ForwardRule, ttl time.Duration) (store.ForwardRule, error) {
+	destination, err := f.validate(rule)
+	if err != nil {
+		return store.ForwardRule{}, err
+	}
+
+	rules, err := f.Store.ListForwardRules(ctx, address)
+	if err != nil {
+		return store.ForwardRule{}, err
+	}
+	if len(rules) >= f.maxPerAddress() {
+		return store.ForwardRule{}, ErrTooMany
+	}
+
+	rule.ID = uuid.New().String()
+	rule.Destination = destination
+	rule.CreatedAt = time.Now().UTC()
+	if err := f.Store.AddForwardRule(ctx, address, rule, ttl); err != nil {
+		return store.ForwardRule{}, err
+	}
+	return rule, nil
+}
+
+// validate checks rule and returns its destination normalized.
+func (f *Forwarder) validate(rule store.ForwardRule) (string, error) {
+	addr, err := mail.ParseAddress(rule.Destination)
+	if err != nil || addr.Name != "" || addr.Address != strings.TrimSpace(rule.Destination) {
+		return "", ErrInvalidDestination
+	}
+	_, host, _ := splitAddress(addr.Address)
+	if f.Domains != nil {
+		if _, ok := f.Domains.Lookup(host); ok {
+			return "", ErrLocalDestination
+		}
+	}
+	if len(rule.FromContains) > maxFilterLength || len(rule.SubjectContains) > maxFilterLength {
+		return "", ErrInvalidFilter
+	}
+	return addr.Address, nil
+}
+
+// Matches reports whether rule applies to email.
+func Matches(rule store.ForwardRule, email store.Email) bool {
+	return containsFold(email.From, rule.FromContains) && containsFold(email.Subject, rule.SubjectContains)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// Enqueue queues the message src gives for every rule on address that
+// matches email, and returns how many were queued.
+func (f *Forwarder) Enqueue(ctx context.Context, address string, email store.Email, src Source) (int, error) {
+	rules, err := f.Store.ListForwardRules(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	queued := 0
+	var raw *Raw
+	for _, rule := range rules {
+		if !Matches(rule, email) {
+			continue
+		}
+		if raw == nil {
+			r, err := src(ctx)
+			if err != nil {
+				return queued, err
+			}
+			raw = &r
+		}
+		job := store.ForwardJob{
+			ID:          uuid.New().String(),
+			Address:     address,
+			RuleID:      rule.ID,
+			Sender:      f.SRS.Forward(email.From, now),
+			Destination: rule.Destination,
+			EmailID:     email.ID,
+			TraceParent: email.TraceParent,
+			CreatedAt:   now.UTC(),
+		}
+		if raw.SHA256 != "" {
+			// Forwarding headers are added as it is relayed
+			job.RawSHA256 = raw.SHA256
+		} else {
+			job.Raw = withForwardHeaders(raw.Data, address, rule.Destination)
+		}
+		if err := f.enqueue(ctx, job, now); err != nil {
+			return queued, err
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// ReverseSRS returns the original sender a bounce to addr should be returned
+// to, or ErrInvalidSRS.
+func (f *Forwarder) ReverseSRS(addr string) (string, error) {
+	return f.SRS.Reverse(addr, time.Now())
+}
+
+// EnqueueBounce queues the bounce src gives, received for a rewritten
+// sender, for relay to the original sender with the null sender.
+func (f *Forwarder) EnqueueBounce(ctx context.Context, destination string, src Source, traceParent string) error {
+	raw, err := src(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return f.enqueue(ctx, store.ForwardJob{
+		ID:          uuid.New().String(),
+		Destination: destination,
+		Raw:         raw.Data,
+		RawSHA256:   raw.SHA256,
+		TraceParent: traceParent,
+		CreatedAt:   now.UTC(),
+	}, now)
+}
+
+// enqueue holds the message of job in the blob store, if it is there, for
+// as long as the job may be retried, and queues it.
+func (f *Forwarder) enqueue(ctx context.Context, job store.ForwardJob, at time.Time) error {
+	if job.RawSHA256 != "" {
+		if err := blob.Hold(ctx, f.Blobs, "forward/"+job.ID, at.Add(f.holdFor()), job.RawSHA256); err != nil {
+			return err
+		}
+	}
+	return f.Store.EnqueueForwardJob(ctx, job, at)
+}
+
+// forwardHeaders returns the headers that tell the destination where the
+// message was forwarded from.
+func forwardHeaders(address, destination string) string {
+	return "X-Forwarded-For: " + address + " " + destination + "\r\n" +
+		"X-Forwarded-To: " + destination + "\r\n"
+}
+
+func withForwardHeaders(raw []byte, address, destination string) []byte {
+	return append([]byte(forwardHeaders(address, destination)), raw...)
+}
+
+// message returns the content to relay for job.
+func (f *Forwarder) message(ctx context.Context, job store.ForwardJob) (io.ReadCloser, error) {
+	if job.RawSHA256 == "" {
+		return io.NopCloser(bytes.NewReader(job.Raw)), nil
+	}
+	if f.Blobs == nil {
+		return nil, errNoBlobs
+	}
+	r, err := f.Blobs.Open(ctx, job.RawSHA256)
+	if err != nil || job.RuleID == "" {
+		return r, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(strings.NewReader(forwardHeaders(job.Address, job.Destination)), r), r}, nil
+}
+
+// Run relays due jobs every interval until ctx is cancelled.
+func (f *Forwarder) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := f.ProcessDue(ctx); err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "Failed to claim forwarded mail", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue claims the jobs that are due, relays them concurrently and
+// returns how many were attempted.
+func (f *Forwarder) ProcessDue(ctx context.Context) (int, error) {
+	jobs, err := f.Store.ClaimForwardJobs(ctx, time.Now(), f.Relay.timeout()+leaseMargin, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Go(func() { f.relay(ctx, job) })
+	}
+	wg.Wait()
+	return len(jobs), nil
+}
+
+func (f *Forwarder) relay(ctx context.Context, job store.ForwardJob) {
+	job.Attempt++
+	ctx, span := tracing.Tracer().Start(ctx, "forward.relay",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("coresend.forward_job_id", job.ID),
+			attribute.Bool("coresend.bounce", job.RuleID == "" && job.Sender == ""),
+			attribute.Int("coresend.attempt", job.Attempt),
+		),
+	)
+	defer span.End()
+	tracing.AddLink(ctx, job.TraceParent)
+
+	if job.RuleID != "" {
+		active, err := f.isRegistered(ctx, job)
+		if err != nil {
+			// Leave the job to be claimed again once the lease runs out
+			tracing.RecordError(span, err)
+			slog.WarnContext(ctx, "Failed to look up forwarding rule", "rule_id", job.RuleID, "error", err)
+			return
+		}
+		if !active {
+			slog.InfoContext(ctx, "Dropped forward for removed rule", "rule_id", job.RuleID, "job_id", job.ID)
+			if err := f.Store.CompleteForwardJob(ctx, job.ID); err != nil {
+				slog.WarnContext(ctx, "Failed to drop forward job", "job_id", job.ID, "error", err)
+			}
+			return
+		}
+	}
+
+	start := time.Now()
+	msg, sendErr := f.message(ctx, job)
+	if sendErr == nil {
+		sendErr = f.Relay.Send(ctx, job.Sender, []string{job.Destination}, msg)
+		msg.Close()
+	}
+	if sendErr != nil && ctx.Err() != nil {
+		// Shutting down; the job is claimed again once its lease runs out
+		return
+	}
+	metrics.ForwardRelayDuration.Observe(time.Since(start).Seconds())
+
+	var (
+		result string
+		err    error
+	)
+	switch {
+	case sendErr == nil:
+		result = ResultRelayed
+		err = f.Store.CompleteForwardJob(ctx, job.ID)
+		slog.InfoContext(ctx, "Relayed forwarded mail", logging.KeyAddress, job.Address, logging.KeyTo, job.Destination, "attempt", job.Attempt)
+
+	case IsPermanent(sendErr), errors.Is(sendErr, blob.ErrNotFound), errors.Is(sendErr, errNoBlobs), job.Attempt >= f.maxAttempts():
+		tracing.RecordError(span, sendErr)
+		result = ResultFailed
+		err = f.Store.CompleteForwardJob(ctx, job.ID)
+		slog.WarnContext(ctx, "Gave up on forwarded mail", logging.KeyAddress, job.Address, logging.KeyTo, job.Destination, "attempts", job.Attempt, "error", sendErr)
+
+	default:
+		tracing.RecordError(span, sendErr)
+		result = ResultRetrying
+		job.LastError = sendErr.Error()
+		wait := f.backoff(job.Attempt)
+		err = f.Store.EnqueueForwardJob(ctx, job, time.Now().Add(wait))
+		slog.InfoContext(ctx, "Relay of forwarded mail failed, retrying", logging.KeyTo, job.Destination, "attempt", job.Attempt, "retry_in", wait, "error", sendErr)
+	}
+	metrics.ForwardsTotal.WithLabelValues(result).Inc()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to update forward job", "job_id", job.ID, "result", result, "error", err)
+	}
+}
+
+// isRegistered reports whether the job's rule still exists, so removing a
+// rule also stops its pending retries.
+func (f *Forwarder) isRegistered(ctx context.Context, job store.ForwardJob) (bool, error) {
+	rules, err := f.Store.ListForwardRules(ctx, job.Address)
+	if err != nil {
+		return false, err
+	}
+	for _, rule := range rules {
+		if rule.ID == job.RuleID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// holdFor returns how long a job may stay queued: through every relay it is
+// allowed and the waits between them.
+func (f *Forwarder) holdFor() time.Duration {
+	var d time.Duration
+	for attempt := 1; attempt <= f.maxAttempts(); attempt++ {
+		d += f.backoff(attempt) + f.Relay.timeout() + leaseMargin
+	}
+	return d
+}
+
+// backoff returns the wait after the given failed attempt.
+func (f *Forwarder) backoff(attempt int) time.Duration {
+	wait := f.baseBackoff()
+	for i := 1; i < attempt && wait < f.maxBackoff(); i++ {
+		wait *= 2
+	}
+	return min(wait, f.maxBackoff())
+}
+
+func (f *Forwarder) maxAttempts() int {
+	if f.MaxAttempts > 0 {
+		return f.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+func (f *Forwarder) baseBackoff() time.Duration {
+	if f.Backoff > 0 {
+		return f.Backoff
+	}
+	return DefaultBackoff
+}
+
+func (f *Forwarder) maxBackoff() time.Duration {
+	if f.MaxBackoff > 0 {
+		return f.MaxBackoff
+	}
+	return DefaultMaxBackoff
+}
+
+func (f *Forwarder) maxPerAddress() int {
+	if f.MaxPerAddress > 0 {
+		return f.MaxPerAddress
+	}
+	return DefaultMaxPerAddress
+}
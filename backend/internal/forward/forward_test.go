@@ -0,0 +1,318 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/fn-jakubkarp/coresend/internal/blob"
+	"github.com/fn-jakubkarp/coresend/internal/domains"
+	"github.com/fn-jakubkarp/coresend/internal/store"
+)
+
+const testAddress = "0123456789abcdef0123456789abcdef01234567"
+
+func newTestForwarder(t *testing.T, snk *sink) *Forwarder {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	registry, err := domains.NewRegistry(domains.Policy{Name: "coresend.test"})
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	f := &Forwarder{
+		Store:      store.NewStore(mr.Addr(), ""),
+		Relay:      &Relay{TLS: TLSNone, Timeout: 5 * time.Second},
+		SRS:        &SRS{Domain: "coresend.test", Secret: []byte("0123456789abcdef")},
+		Domains:    registry,
+		Backoff:    time.Millisecond,
+		MaxBackoff: time.Millisecond,
+	}
+	if snk != nil {
+		f.Relay.Addr = snk.addr
+	}
+	return f
+}
+
+func testEmail() store.Email {
+	return store.Email{
+		ID:      "email-1",
+		From:    "ci@example.org",
+		To:      []string{testAddress},
+		Subject: "Your verification code",
+	}
+}
+
+func TestForwarder_RelaysMatchingMail(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	snk := startSink(t, nil, false)
+	f := newTestForwarder(t, snk)
+
+	rules := []store.ForwardRule{
+		{Destination: "all@example.com"},
+		{Destination: "ci@example.com", FromContains: "CI@example.org"},
+		{Destination: "other@example.com", SubjectContains: "invoice"},
+	}
+	for _, rule := range rules {
+		if _, err := f.Register(ctx, testAddress, rule, time.Hour); err != nil {
+			t.Fatalf("Register(%s) error = %v", rule.Destination, err)
+		}
+	}
+
+	if n, err := f.Enqueue(ctx, testAddress, testEmail(), Raw{Data: []byte(testMessage)}.Source()); err != nil || n != 2 {
+		t.Fatalf("Enqueue() = %d, %v, want 2, nil", n, err)
+	}
+	if n, err := f.ProcessDue(ctx); err != nil || n != 2 {
+		t.Fatalf("ProcessDue() = %d, %v, want 2, nil", n, err)
+	}
+
+	got := snk.received()
+	if len(got) != 2 {
+		t.Fatalf("sink received %d messages, want 2", len(got))
+	}
+	destinations := map[string]bool{}
+	for _, msg := range got {
+		destinations[msg.to[0]] = true
+		if !strings.HasPrefix(msg.from, "SRS0=") || !strings.HasSuffix(msg.from, "@coresend.test") {
+			t.Fatalf("envelope sender = %q, want an SRS0 address", msg.from)
+		}
+		if original, err := f.ReverseSRS(msg.from); err != nil || original != "ci@example.org" {
+			t.Fatalf("ReverseSRS(%q) = %q, %v", msg.from, original, err)
+		}
+		if !strings.HasPrefix(msg.data, "X-Forwarded-For: "+testAddress+" "+msg.to[0]+"\r\n") {
+			t.Fatalf("data = %q, want forwarding headers first", msg.data)
+		}
+		if !strings.HasSuffix(msg.data, testMessage) {
+			t.Fatalf("data = %q, want the original message", msg.data)
+		}
+	}
+	if !destinations["all@example.com"] || !destinations["ci@example.com"] {
+		t.Fatalf("destinations = %v", destinations)
+	}
+	if n, _ := f.ProcessDue(ctx); n != 0 {
+		t.Fatalf("ProcessDue() after relay = %d, want the queue empty", n)
+	}
+}
+
+func TestForwarder_Retries(t *testing.T) {
+	t.Parallel()
+
+	tempFail := &gosmtp.SMTPError{Code: 451, EnhancedCode: gosmtp.EnhancedCode{4, 3, 0}, Message: "try later"}
+	permFail := &gosmtp.SMTPError{Code: 550, EnhancedCode: gosmtp.EnhancedCode{5, 1, 1}, Message: "no such user"}
+
+	tests := []struct {
+		name         string
+		failures     []*gosmtp.SMTPError
+		maxAttempts  int
+		wantAttempts int
+		wantRelayed  bool
+	}{
+		{name: "temporary then relayed", failures: []*gosmtp.SMTPError{tempFail, tempFail}, maxAttempts: 5, wantAttempts: 3, wantRelayed: true},
+		{name: "runs out of attempts", failures: []*gosmtp.SMTPError{tempFail, tempFail, tempFail}, maxAttempts: 2, wantAttempts: 2},
+		{name: "permanent failure is not retried", failures: []*gosmtp.SMTPError{permFail}, maxAttempts: 5, wantAttempts: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			snk := startSink(t, nil, false)
+			snk.failNext(tc.failures...)
+			f := newTestForwarder(t, snk)
+			f.MaxAttempts = tc.maxAttempts
+
+			if _, err := f.Register(ctx, testAddress, store.ForwardRule{Destination: "tester@example.com"}, time.Hour); err != nil {
+				t.Fatalf("Register() error = %v", err)
+			}
+			if _, err := f.Enqueue(ctx, testAddress, testEmail(), Raw{Data: []byte(testMessage)}.Source()); err != nil {
+				t.Fatalf("Enqueue() error = %v", err)
+			}
+
+			attempts := 0
+			for range 10 {
+				time.Sleep(5 * time.Millisecond)
+				n, err := f.ProcessDue(ctx)
+				if err != nil {
+					t.Fatalf("ProcessDue() error = %v", err)
+				}
+				if n == 0 {
+					break
+				}
+				attempts += n
+			}
+
+			if attempts != tc.wantAttempts {
+				t.Fatalf("attempts = %d, want %d", attempts, tc.wantAttempts)
+			}
+			if relayed := len(snk.received()) == 1; relayed != tc.wantRelayed {
+				t.Fatalf("relayed = %v, want %v", relayed, tc.wantRelayed)
+			}
+		})
+	}
+}
+
+func TestForwarder_RelaysFromBlobs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	snk := startSink(t, nil, false)
+	f := newTestForwarder(t, snk)
+	blobs, err := blob.NewFS(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFS() error = %v", err)
+	}
+	s := f.Store.(*store.Store)
+	s.UseBlobs(blobs)
+	f.Blobs = s.Blobs()
+
+	if _, err := f.Register(ctx, testAddress, store.ForwardRule{Destination: "tester@example.com"}, time.Hour); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	ref, err := f.Blobs.Put(ctx, strings.NewReader(testMessage))
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if n, err := f.Enqueue(ctx, testAddress, testEmail(), Raw{SHA256: ref.SHA256}.Source()); err != nil || n != 1 {
+		t.Fatalf("Enqueue() = %d, %v, want 1, nil", n, err)
+	}
+
+	// Held for the job past the grace period of unreferenced content
+	if n, err := s.CollectBlobs(ctx, time.Now().Add(2*time.Minute), time.Minute); err != nil || n != 0 {
+		t.Fatalf("CollectBlobs() = %d, %v, want the message kept for the job", n, err)
+	}
+	if n, err := f.ProcessDue(ctx); err != nil || n != 1 {
+		t.Fatalf("ProcessDue() = %d, %v, want 1, nil", n, err)
+	}
+	got := snk.received()
+	if len(got) != 1 || got[0].data != "X-Forwarded-For: "+testAddress+" tester@example.com\r\nX-Forwarded-To: tester@example.com\r\n"+testMessage {
+		t.Fatalf("sink received %+v, want the stored message with forwarding headers", got)
+	}
+}
+
+func TestForwarder_DropsJobsOfRemovedRule(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	snk := startSink(t, nil, false)
+	f := newTestForwarder(t, snk)
+
+	rule, err := f.Register(ctx, testAddress, store.ForwardRule{Destination: "tester@example.com"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if _, err := f.Enqueue(ctx, testAddress, testEmail(), Raw{Data: []byte(testMessage)}.Source()); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if _, err := f.Store.DeleteForwardRule(ctx, testAddress, rule.ID); err != nil {
+		t.Fatalf("DeleteForwardRule() error = %v", err)
+	}
+
+	if _, err := f.ProcessDue(ctx); err != nil {
+		t.Fatalf("ProcessDue() error = %v", err)
+	}
+	if got := len(snk.received()); got != 0 {
+		t.Fatalf("sink received %d messages, want 0", got)
+	}
+}
+
+func TestForwarder_RelaysBounceWithNullSender(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	snk := startSink(t, nil, false)
+	f := newTestForwarder(t, snk)
+
+	if err := f.EnqueueBounce(ctx, "ci@example.org", Raw{Data: []byte(testMessage)}.Source(), ""); err != nil {
+		t.Fatalf("EnqueueBounce() error = %v", err)
+	}
+	if n, err := f.ProcessDue(ctx); err != nil || n != 1 {
+		t.Fatalf("ProcessDue() = %d, %v, want 1, nil", n, err)
+	}
+
+	got := snk.received()
+	if len(got) != 1 || got[0].from != "" || got[0].to[0] != "ci@example.org" || got[0].data != testMessage {
+		t.Fatalf("sink received %+v, want the bounce from the null sender", got)
+	}
+}
+
+func TestForwarder_Register(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		rule    store.ForwardRule
+		wantErr error
+	}{
+		{name: "valid", rule: store.ForwardRule{Destination: "tester@example.com", SubjectContains: "code"}},
+		{name: "display name", rule: store.ForwardRule{Destination: "Tester <tester@example.com>"}, wantErr: ErrInvalidDestination},
+		{name: "not an address", rule: store.ForwardRule{Destination: "tester"}, wantErr: ErrInvalidDestination},
+		{name: "several addresses", rule: store.ForwardRule{Destination: "a@example.com, b@example.com"}, wantErr: ErrInvalidDestination},
+		{name: "served domain", rule: store.ForwardRule{Destination: testAddress + "@coresend.test"}, wantErr: ErrLocalDestination},
+		{name: "served domain other case", rule: store.ForwardRule{Destination: testAddress + "@CoreSend.test"}, wantErr: ErrLocalDestination},
+		{name: "long filter", rule: store.ForwardRule{Destination: "tester@example.com", FromContains: strings.Repeat("a", 257)}, wantErr: ErrInvalidFilter},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newTestForwarder(t, nil)
+			rule, err := f.Register(context.Background(), testAddress, tc.rule, time.Hour)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Register() error = %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr == nil && (rule.ID == "" || rule.CreatedAt.IsZero()) {
+				t.Fatalf("Register() = %+v, want an ID and creation time", rule)
+			}
+		})
+	}
+
+	t.Run("limit", func(t *testing.T) {
+		t.Parallel()
+
+		f := newTestForwarder(t, nil)
+		f.MaxPerAddress = 1
+		ctx := context.Background()
+		if _, err := f.Register(ctx, testAddress, store.ForwardRule{Destination: "a@example.com"}, time.Hour); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+		if _, err := f.Register(ctx, testAddress, store.ForwardRule{Destination: "b@example.com"}, time.Hour); !errors.Is(err, ErrTooMany) {
+			t.Fatalf("Register() over the limit error = %v, want ErrTooMany", err)
+		}
+	})
+}
+
+func TestForwarder_Backoff(t *testing.T) {
+	t.Parallel()
+
+	f := &Forwarder{Backoff: time.Minute, MaxBackoff: 10 * time.Minute}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Minute},
+		{attempt: 2, want: 2 * time.Minute},
+		{attempt: 4, want: 8 * time.Minute},
+		{attempt: 5, want: 10 * time.Minute},
+		{attempt: 50, want: 10 * time.Minute},
+	}
+
+	for _, tc := range tests {
+		if got := f.backoff(tc.attempt); got != tc.want {
+			t.Fatalf("backoff(%d) = %v, want %v", tc.attempt, got, tc.want)
+		}
+	}
+}
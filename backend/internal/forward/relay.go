@@ -0,0 +1,137 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	gosmtp "github.com/emersion/go-smtp"
+)
+
+// Connection security of the relay.
+const (
+	// TLSStartTLS requires STARTTLS on a plain connection.
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects with TLS, as on port 465.
+	TLSImplicit = "tls"
+	// TLSNone sends in plain text; only for local sinks.
+	TLSNone = "none"
+)
+
+// DefaultRelayTimeout bounds a whole relay session when Relay.Timeout is unset.
+const DefaultRelayTimeout = 30 * time.Second
+
+// ParseTLSMode checks a relay TLS mode, defaulting to TLSStartTLS.
+func ParseTLSMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return TLSStartTLS, nil
+	case TLSStartTLS, TLSImplicit, TLSNone:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown relay TLS mode %q, want %s, %s or %s", mode, TLSStartTLS, TLSImplicit, TLSNone)
+}
+
+// Relay sends messages through an outbound SMTP smarthost.
+type Relay struct {
+	// Addr is the smarthost's host:port.
+	Addr string
+	// Username and Password, when set, authenticate with AUTH PLAIN.
+	Username string
+	Password string
+	// TLS is one of TLSStartTLS, TLSImplicit or TLSNone. Empty selects
+	// TLSStartTLS.
+	TLS string
+	// TLSConfig overrides the TLS settings, for example to trust a private
+	// CA. The server name defaults to the host of Addr.
+	TLSConfig *tls.Config
+	// Timeout bounds a whole relay session. Zero selects DefaultRelayTimeout.
+	Timeout time.Duration
+}
+
+// Send relays msg from the envelope sender to the recipients in one session.
+// Rejections by the smarthost are returned as *gosmtp.SMTPError.
+func (r *Relay) Send(ctx context.Context, from string, to []string, msg io.Reader) error {
+	mode, err := ParseTLSMode(r.TLS)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout())
+	defer cancel()
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", r.Addr)
+	if err != nil {
+		return err
+	}
+	// Bound every command by the session deadline and abort on cancellation
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var c *gosmtp.Client
+	switch mode {
+	case TLSImplicit:
+		c = gosmtp.NewClient(tls.Client(conn, r.tlsConfig()))
+	case TLSStartTLS:
+		c, err = gosmtp.NewClientStartTLS(conn, r.tlsConfig())
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	default:
+		c = gosmtp.NewClient(conn)
+	}
+	defer c.Close()
+	c.CommandTimeout = r.timeout()
+	c.SubmissionTimeout = r.timeout()
+
+	if r.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("relay does not support AUTH")
+		}
+		if err := c.Auth(sasl.NewPlainClient("", r.Username, r.Password)); err != nil {
+			return err
+		}
+	}
+	if err := c.SendMail(from, to, msg); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (r *Relay) tlsConfig() *tls.Config {
+	var cfg *tls.Config
+	if r.TLSConfig != nil {
+		cfg = r.TLSConfig.Clone()
+	} else {
+		cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if cfg.ServerName == "" {
+		if host, _, err := net.SplitHostPort(r.Addr); err == nil {
+			cfg.ServerName = host
+		}
+	}
+	return cfg
+}
+
+func (r *Relay) timeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+	return DefaultRelayTimeout
+}
+
+// IsPermanent reports whether err is a 5xx rejection that retrying will not
+// fix.
+func IsPermanent(err error) bool {
+	var smtpErr *gosmtp.SMTPError
+	return errors.As(err, &smtpErr) && smtpErr.Code >= 500 && smtpErr.Code <= 599
+}
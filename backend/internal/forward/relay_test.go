@@ -0,0 +1,328 @@
+package forward
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	gosmtp "github.com/emersion/go-smtp"
+)
+
+// sink is a local SMTP server that records what it is sent.
+type sink struct {
+	addr string
+	// username and password, when set, are required through AUTH PLAIN.
+	username string
+	password string
+
+	mu       sync.Mutex
+	failures []*gosmtp.SMTPError
+	messages []sunkMessage
+}
+
+type sunkMessage struct {
+	from string
+	to   []string
+	data string
+	tls  bool
+}
+
+// startSink serves a sink on a loopback port until the test ends. A non-nil
+// tlsConfig enables STARTTLS, or implicit TLS when implicit is set.
+func startSink(t *testing.T, tlsConfig *tls.Config, implicit bool) *sink {
+	t.Helper()
+
+	snk := &sink{}
+	srv := gosmtp.NewServer(snk)
+	srv.Domain = "sink.test"
+	srv.AllowInsecureAuth = true
+	srv.TLSConfig = tlsConfig
+
+	var (
+		l   net.Listener
+		err error
+	)
+	if implicit {
+		l, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	snk.addr = l.Addr().String()
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return snk
+}
+
+// failNext makes the next deliveries fail with the given errors, in order.
+func (snk *sink) failNext(errs ...*gosmtp.SMTPError) {
+	snk.mu.Lock()
+	defer snk.mu.Unlock()
+	snk.failures = append(snk.failures, errs...)
+}
+
+func (snk *sink) received() []sunkMessage {
+	snk.mu.Lock()
+	defer snk.mu.Unlock()
+	return append([]sunkMessage(nil), snk.messages...)
+}
+
+func (snk *sink) NewSession(c *gosmtp.Conn) (gosmtp.Session, error) {
+	_, isTLS := c.TLSConnectionState()
+	return &sinkSession{sink: snk, tls: isTLS}, nil
+}
+
+type sinkSession struct {
+	sink   *sink
+	tls    bool
+	authed bool
+	from   string
+	to     []string
+}
+
+func (s *sinkSession) AuthMechanisms() []string {
+	return []string{sasl.Plain}
+}
+
+func (s *sinkSession) Auth(string) (sasl.Server, error) {
+	return sasl.NewPlainServer(func(_, username, password string) error {
+		if username != s.sink.username || password != s.sink.password {
+			return errors.New("invalid credentials")
+		}
+		s.authed = true
+		return nil
+	}), nil
+}
+
+func (s *sinkSession) Mail(from string, _ *gosmtp.MailOptions) error {
+	if s.sink.username != "" && !s.authed {
+		return gosmtp.ErrAuthRequired
+	}
+	s.from = from
+	return nil
+}
+
+func (s *sinkSession) Rcpt(to string, _ *gosmtp.RcptOptions) error {
+	s.to = append(s.to, to)
+	return nil
+}
+
+func (s *sinkSession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.sink.mu.Lock()
+	defer s.sink.mu.Unlock()
+	if len(s.sink.failures) > 0 {
+		err := s.sink.failures[0]
+		s.sink.failures = s.sink.failures[1:]
+		return err
+	}
+	s.sink.messages = append(s.sink.messages, sunkMessage{from: s.from, to: s.to, data: string(data), tls: s.tls})
+	return nil
+}
+
+func (s *sinkSession) Reset() {
+	s.from = ""
+	s.to = nil
+}
+
+func (s *sinkSession) Logout() error { return nil }
+
+// testCertificates returns a server config with a self-signed certificate
+// for 127.0.0.1 and a client config that trusts it.
+func testCertificates(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{RootCAs: pool}
+	return server, client
+}
+
+const testMessage = "From: ci@example.org\r\nTo: box@coresend.test\r\nSubject: Code\r\n\r\nYour code is 123456\r\n"
+
+func TestRelay_Send(t *testing.T) {
+	t.Parallel()
+
+	serverTLS, clientTLS := testCertificates(t)
+
+	tests := []struct {
+		name      string
+		serverTLS *tls.Config
+		implicit  bool
+		relay     Relay
+		wantTLS   bool
+	}{
+		{
+			name:  "plain",
+			relay: Relay{TLS: TLSNone},
+		},
+		{
+			name:      "starttls with auth",
+			serverTLS: serverTLS,
+			relay:     Relay{TLS: TLSStartTLS, TLSConfig: clientTLS, Username: "relay", Password: "secret"},
+			wantTLS:   true,
+		},
+		{
+			name:      "implicit tls",
+			serverTLS: serverTLS,
+			implicit:  true,
+			relay:     Relay{TLS: TLSImplicit, TLSConfig: clientTLS},
+			wantTLS:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			snk := startSink(t, tc.serverTLS, tc.implicit)
+			snk.username, snk.password = tc.relay.Username, tc.relay.Password
+			relay := tc.relay
+			relay.Addr = snk.addr
+			relay.Timeout = 5 * time.Second
+
+			if err := relay.Send(context.Background(), "SRS0=abc@coresend.test", []string{"tester@example.com"}, strings.NewReader(testMessage)); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+
+			got := snk.received()
+			if len(got) != 1 {
+				t.Fatalf("sink received %d messages, want 1", len(got))
+			}
+			if got[0].from != "SRS0=abc@coresend.test" || len(got[0].to) != 1 || got[0].to[0] != "tester@example.com" {
+				t.Fatalf("envelope = %q -> %v", got[0].from, got[0].to)
+			}
+			if got[0].data != testMessage {
+				t.Fatalf("data = %q, want the message unchanged", got[0].data)
+			}
+			if got[0].tls != tc.wantTLS {
+				t.Fatalf("tls = %v, want %v", got[0].tls, tc.wantTLS)
+			}
+		})
+	}
+}
+
+func TestRelay_SendErrors(t *testing.T) {
+	t.Parallel()
+
+	t.Run("starttls required", func(t *testing.T) {
+		t.Parallel()
+
+		snk := startSink(t, nil, false)
+		relay := Relay{Addr: snk.addr, Timeout: 5 * time.Second}
+		err := relay.Send(context.Background(), "a@example.org", []string{"b@example.com"}, strings.NewReader(testMessage))
+		if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+			t.Fatalf("Send() error = %v, want STARTTLS refused", err)
+		}
+		if len(snk.received()) != 0 {
+			t.Fatal("message sent without TLS")
+		}
+	})
+
+	t.Run("bad credentials", func(t *testing.T) {
+		t.Parallel()
+
+		snk := startSink(t, nil, false)
+		snk.username, snk.password = "relay", "secret"
+		relay := Relay{Addr: snk.addr, TLS: TLSNone, Username: "relay", Password: "wrong", Timeout: 5 * time.Second}
+		if err := relay.Send(context.Background(), "a@example.org", []string{"b@example.com"}, strings.NewReader(testMessage)); err == nil {
+			t.Fatal("Send() error = nil, want authentication failure")
+		}
+	})
+
+	t.Run("rejections", func(t *testing.T) {
+		t.Parallel()
+
+		snk := startSink(t, nil, false)
+		snk.failNext(
+			&gosmtp.SMTPError{Code: 451, EnhancedCode: gosmtp.EnhancedCode{4, 3, 0}, Message: "try later"},
+			&gosmtp.SMTPError{Code: 550, EnhancedCode: gosmtp.EnhancedCode{5, 7, 1}, Message: "rejected"},
+		)
+		relay := Relay{Addr: snk.addr, TLS: TLSNone, Timeout: 5 * time.Second}
+
+		for _, wantPermanent := range []bool{false, true} {
+			err := relay.Send(context.Background(), "a@example.org", []string{"b@example.com"}, strings.NewReader(testMessage))
+			if err == nil || IsPermanent(err) != wantPermanent {
+				t.Fatalf("Send() error = %v, want permanent %v", err, wantPermanent)
+			}
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		t.Parallel()
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+
+		relay := Relay{Addr: addr, TLS: TLSNone, Timeout: time.Second}
+		err = relay.Send(context.Background(), "a@example.org", []string{"b@example.com"}, strings.NewReader(testMessage))
+		if err == nil || IsPermanent(err) {
+			t.Fatalf("Send() error = %v, want a temporary failure", err)
+		}
+	})
+}
+
+func TestParseTLSMode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		mode    string
+		want    string
+		wantErr bool
+	}{
+		{mode: "", want: TLSStartTLS},
+		{mode: "starttls", want: TLSStartTLS},
+		{mode: "tls", want: TLSImplicit},
+		{mode: "none", want: TLSNone},
+		{mode: "ssl", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseTLSMode(tc.mode)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Fatalf("ParseTLSMode(%q) = %q, %v, want %q, error %v", tc.mode, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
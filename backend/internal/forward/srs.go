@@ -0,0 +1,191 @@
+package forward
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSRS is returned for bounce addresses that were not issued by this
+// server, were altered or have expired.
+var ErrInvalidSRS = errors.New("invalid or expired SRS address")
+
+// DefaultSRSMaxAge is how long rewritten senders accept bounces.
+const DefaultSRSMaxAge = 21 * 24 * time.Hour
+
+const (
+	srs0Prefix = "SRS0"
+	srs1Prefix = "SRS1"
+	srsSep     = "="
+	hashLength = 6
+	// timestamps count days modulo 1024 in two base32 characters.
+	timestampBase  = 32
+	timestampRange = timestampBase * timestampBase
+	day            = 24 * time.Hour
+)
+
+const base32Alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+// SRS rewrites envelope senders with the Sender Rewriting Scheme, so relayed
+// mail passes SPF at the destination while bounces still reach the original
+// sender through this server:
+//
+//	alice@example.org -> SRS0=hash=tt=example.org=alice@Domain
+//
+// Senders that another forwarder already rewrote become SRS1 addresses that
+// point back at that forwarder.
+type SRS struct {
+	// Domain receives the bounces and must be served by this server.
+	Domain string
+	Secret []byte
+	// MaxAge is how long a rewritten sender accepts bounces. Zero selects
+	// DefaultSRSMaxAge.
+	MaxAge time.Duration
+}
+
+// Forward returns the envelope sender to relay a message from sender with.
+// The null sender of bounces, and senders without a domain, are returned
+// unchanged.
+func (s *SRS) Forward(sender string, now time.Time) string {
+	local, host, ok := splitAddress(sender)
+	if !ok {
+		return sender
+	}
+
+	switch {
+	case hasPrefixFold(local, srs1Prefix+srsSep):
+		// SRS1=hash=host==rest: keep the first forwarder and its SRS0 part.
+		parts := strings.SplitN(local[len(srs1Prefix+srsSep):], srsSep, 3)
+		if len(parts) != 3 || !strings.HasPrefix(parts[2], srsSep) {
+			break
+		}
+		origHost, rest := parts[1], parts[2]
+		return srs1Prefix + srsSep + s.hash(origHost, rest) + srsSep + origHost + srsSep + rest + "@" + s.Domain
+
+	case hasPrefixFold(local, srs0Prefix+srsSep):
+		// SRS0 from another forwarder: point back at it.
+		rest := local[len(srs0Prefix):]
+		return srs1Prefix + srsSep + s.hash(host, rest) + srsSep + host + srsSep + rest + "@" + s.Domain
+	}
+
+	ts := encodeTimestamp(now)
+	return srs0Prefix + srsSep + s.hash(ts, host, local) + srsSep + ts + srsSep + host + srsSep + local + "@" + s.Domain
+}
+
+// Reverse returns where a bounce sent to addr should go: the original sender
+// for an SRS0 address, or the previous forwarder for an SRS1 address.
+func (s *SRS) Reverse(addr string, now time.Time) (string, error) {
+	local, host, ok := splitAddress(addr)
+	if !ok || !strings.EqualFold(host, s.Domain) {
+		return "", ErrInvalidSRS
+	}
+
+	switch {
+	case hasPrefixFold(local, srs0Prefix+srsSep):
+		parts := strings.SplitN(local[len(srs0Prefix+srsSep):], srsSep, 4)
+		if len(parts) != 4 || parts[3] == "" {
+			return "", ErrInvalidSRS
+		}
+		hash, ts, origHost, origLocal := parts[0], parts[1], parts[2], parts[3]
+		if !s.validHash(hash, ts, origHost, origLocal) || !s.fresh(ts, now) {
+			return "", ErrInvalidSRS
+		}
+		return origLocal + "@" + origHost, nil
+
+	case hasPrefixFold(local, srs1Prefix+srsSep):
+		parts := strings.SplitN(local[len(srs1Prefix+srsSep):], srsSep, 3)
+		if len(parts) != 3 || !strings.HasPrefix(parts[2], srsSep) {
+			return "", ErrInvalidSRS
+		}
+		hash, origHost, rest := parts[0], parts[1], parts[2]
+		if !s.validHash(hash, origHost, rest) {
+			return "", ErrInvalidSRS
+		}
+		return srs0Prefix + rest + "@" + origHost, nil
+	}
+	return "", ErrInvalidSRS
+}
+
+// IsSRS reports whether addr looks like a rewritten sender, without checking
+// its hash.
+func IsSRS(addr string) bool {
+	local, _, _ := splitAddress(addr)
+	return hasPrefixFold(local, srs0Prefix+srsSep) || hasPrefixFold(local, srs1Prefix+srsSep)
+}
+
+// hash authenticates the parts of an SRS address. Mail systems may change the
+// case of local parts, so it is computed over lower case and compared
+// case-insensitively. Parts are separated so their boundaries cannot move.
+func (s *SRS) hash(parts ...string) string {
+	mac := hmac.New(sha1.New, s.Secret)
+	for _, p := range parts {
+		mac.Write([]byte(strings.ToLower(p)))
+		mac.Write([]byte{0})
+	}
+	enc := base32.NewEncoding(base32Alphabet).WithPadding(base32.NoPadding)
+	return enc.EncodeToString(mac.Sum(nil))[:hashLength]
+}
+
+func (s *SRS) validHash(hash string, parts ...string) bool {
+	return hmac.Equal([]byte(strings.ToLower(hash)), []byte(s.hash(parts...)))
+}
+
+// fresh reports whether the timestamp ts is no older than MaxAge. Timestamps
+// wrap around every 1024 days.
+func (s *SRS) fresh(ts string, now time.Time) bool {
+	then, ok := decodeTimestamp(ts)
+	if !ok {
+		return false
+	}
+	today := int(now.Unix()/int64(day.Seconds())) % timestampRange
+	age := (today - then + timestampRange) % timestampRange
+	return time.Duration(age)*day <= s.maxAge()
+}
+
+func (s *SRS) maxAge() time.Duration {
+	if s.MaxAge > 0 {
+		return s.MaxAge
+	}
+	return DefaultSRSMaxAge
+}
+
+func encodeTimestamp(now time.Time) string {
+	days := int(now.Unix()/int64(day.Seconds())) % timestampRange
+	return string([]byte{base32Alphabet[days/timestampBase], base32Alphabet[days%timestampBase]})
+}
+
+func decodeTimestamp(ts string) (int, bool) {
+	if len(ts) != 2 {
+		return 0, false
+	}
+	hi := strings.IndexByte(base32Alphabet, lower(ts[0]))
+	lo := strings.IndexByte(base32Alphabet, lower(ts[1]))
+	if hi < 0 || lo < 0 {
+		return 0, false
+	}
+	return hi*timestampBase + lo, true
+}
+
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// splitAddress splits addr at its last @, reporting false when either side is
+// empty.
+func splitAddress(addr string) (local, host string, ok bool) {
+	i := strings.LastIndex(addr, "@")
+	if i <= 0 || i == len(addr)-1 {
+		return "", "", false
+	}
+	return addr[:i], addr[i+1:], true
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
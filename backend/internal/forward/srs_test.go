@@ -0,0 +1,129 @@
+package forward
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSRS_ForwardAndReverse(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	srs := &SRS{Domain: "coresend.test", Secret: []byte("0123456789abcdef")}
+
+	tests := []struct {
+		name       string
+		sender     string
+		wantPrefix string
+		// wantReverse is where a bounce to the rewritten sender goes.
+		wantReverse string
+	}{
+		{
+			name:        "plain sender",
+			sender:      "alice@example.org",
+			wantPrefix:  "SRS0=",
+			wantReverse: "alice@example.org",
+		},
+		{
+			name:        "local part with separator",
+			sender:      "first=last@example.org",
+			wantPrefix:  "SRS0=",
+			wantReverse: "first=last@example.org",
+		},
+		{
+			name:        "SRS0 from another forwarder",
+			sender:      "SRS0=xyz=ab=example.org=alice@relay.example.net",
+			wantPrefix:  "SRS1=",
+			wantReverse: "SRS0=xyz=ab=example.org=alice@relay.example.net",
+		},
+		{
+			name:        "SRS1 from another forwarder",
+			sender:      "SRS1=qqq=relay.example.net==xyz=ab=example.org=alice@second.example.net",
+			wantPrefix:  "SRS1=",
+			wantReverse: "SRS0=xyz=ab=example.org=alice@relay.example.net",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rewritten := srs.Forward(tc.sender, now)
+			if !strings.HasPrefix(rewritten, tc.wantPrefix) || !strings.HasSuffix(rewritten, "@coresend.test") {
+				t.Fatalf("Forward(%q) = %q, want %s...@coresend.test", tc.sender, rewritten, tc.wantPrefix)
+			}
+			if !IsSRS(rewritten) {
+				t.Fatalf("IsSRS(%q) = false", rewritten)
+			}
+
+			got, err := srs.Reverse(rewritten, now.Add(24*time.Hour))
+			if err != nil || got != tc.wantReverse {
+				t.Fatalf("Reverse(%q) = %q, %v, want %q", rewritten, got, err, tc.wantReverse)
+			}
+			// Mail systems may change the case of the local part
+			if got, err := srs.Reverse(strings.ToUpper(rewritten), now); err != nil || !strings.EqualFold(got, tc.wantReverse) {
+				t.Fatalf("Reverse(upper case) = %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestSRS_ForwardLeavesNullSender(t *testing.T) {
+	t.Parallel()
+
+	srs := &SRS{Domain: "coresend.test", Secret: []byte("0123456789abcdef")}
+	for _, sender := range []string{"", "postmaster"} {
+		if got := srs.Forward(sender, time.Now()); got != sender {
+			t.Fatalf("Forward(%q) = %q, want it unchanged", sender, got)
+		}
+	}
+}
+
+func TestSRS_ReverseRejects(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	srs := &SRS{Domain: "coresend.test", Secret: []byte("0123456789abcdef"), MaxAge: 7 * 24 * time.Hour}
+	valid := srs.Forward("alice@example.org", now)
+	other := &SRS{Domain: "coresend.test", Secret: []byte("another secret!!")}
+
+	tests := []struct {
+		name string
+		addr string
+		at   time.Time
+	}{
+		{name: "expired", addr: valid, at: now.Add(8 * 24 * time.Hour)},
+		{name: "other secret", addr: other.Forward("alice@example.org", now), at: now},
+		{name: "redirected", addr: strings.Replace(valid, "=alice@", "=mallory@", 1), at: now},
+		{name: "moved boundary", addr: strings.Replace(valid, "=example.org=alice@", "=example.or=galice@", 1), at: now},
+		{name: "other domain", addr: strings.Replace(valid, "@coresend.test", "@example.net", 1), at: now},
+		{name: "not SRS", addr: "alice@coresend.test", at: now},
+		{name: "truncated", addr: "SRS0=abc@coresend.test", at: now},
+		{name: "bad timestamp", addr: "SRS0=abcdef=!!=example.org=alice@coresend.test", at: now},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got, err := srs.Reverse(tc.addr, tc.at); !errors.Is(err, ErrInvalidSRS) {
+				t.Fatalf("Reverse(%q) = %q, %v, want ErrInvalidSRS", tc.addr, got, err)
+			}
+		})
+	}
+}
+
+func TestSRS_TimestampWraps(t *testing.T) {
+	t.Parallel()
+
+	srs := &SRS{Domain: "coresend.test", Secret: []byte("0123456789abcdef")}
+	// Day 1023 of a 1024 day cycle, reversed two days later on day 1
+	then := time.Unix(1023*int64(day.Seconds()), 0)
+	rewritten := srs.Forward("alice@example.org", then)
+
+	if _, err := srs.Reverse(rewritten, then.Add(2*day)); err != nil {
+		t.Fatalf("Reverse() across the wrap error = %v", err)
+	}
+}
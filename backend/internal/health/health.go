@@ -0,0 +1,195 @@
+// Package health reports whether the server is alive and ready for traffic.
+//
+// Liveness only says the process is serving HTTP. Readiness probes the store
+// and the SMTP listeners for real and turns false once shutdown begins, so
+// load balancers stop routing before the listeners close.
+package health
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout bounds each probe when Checker.Timeout is unset.
+const DefaultTimeout = 2 * time.Second
+
+// Pinger is the part of the store readiness depends on.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// SMTPProbe is a listener that must greet with a 220 banner.
+type SMTPProbe struct {
+	Name string
+	Addr string
+	// TLS dials with implicit TLS, as for an SMTPS listener. The certificate
+	// is not verified; the check is whether the listener answers.
+	TLS bool
+}
+
+type Checker struct {
+	Store Pinger
+	SMTP  []SMTPProbe
+	// Certificates returns the TLS certificates in use, if any.
+	Certificates func() []*tls.Certificate
+	Timeout      time.Duration
+
+	draining atomic.Bool
+}
+
+// Check is the outcome of one probe.
+type Check struct {
+	Name    string
+	Latency time.Duration
+	Err     error
+}
+
+func (c Check) OK() bool {
+	return c.Err == nil
+}
+
+// Certificate describes a loaded TLS certificate.
+type Certificate struct {
+	Subject  string
+	DNSNames []string
+	NotAfter time.Time
+	Valid    bool
+}
+
+type Report struct {
+	Ready    bool
+	Draining bool
+	Checks   []Check
+	// Certificates are informational: an expired certificate breaks
+	// STARTTLS but mail is still accepted, so it does not fail readiness.
+	Certificates []Certificate
+}
+
+// Drain marks the server as shutting down. Readiness fails from then on.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Ready runs every probe concurrently.
+func (c *Checker) Ready(ctx context.Context) Report {
+	checks := make([]Check, 1+len(c.SMTP))
+
+	var wg sync.WaitGroup
+	wg.Add(len(checks))
+	go func() {
+		defer wg.Done()
+		checks[0] = c.run(ctx, "store", func(ctx context.Context) error {
+			if c.Store == nil {
+				return fmt.Errorf("no store configured")
+			}
+			return c.Store.Ping(ctx)
+		})
+	}()
+	for i, p := range c.SMTP {
+		go func() {
+			defer wg.Done()
+			checks[i+1] = c.run(ctx, p.Name, func(ctx context.Context) error {
+				return probeSMTP(ctx, p)
+			})
+		}()
+	}
+	wg.Wait()
+
+	report := Report{
+		Ready:    !c.Draining(),
+		Draining: c.Draining(),
+		Checks:   checks,
+	}
+	for _, check := range checks {
+		if !check.OK() {
+			report.Ready = false
+		}
+	}
+	if c.Certificates != nil {
+		now := time.Now()
+		for _, cert := range c.Certificates() {
+			if info, ok := describeCertificate(cert, now); ok {
+				report.Certificates = append(report.Certificates, info)
+			}
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, name string, probe func(context.Context) error) Check {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := probe(ctx)
+	return Check{Name: name, Latency: time.Since(start), Err: err}
+}
+
+// probeSMTP connects, waits for the greeting and says QUIT. The server
+// creates no session until HELO, so probes leave no trace in SMTP metrics.
+func probeSMTP(ctx context.Context, p SMTPProbe) error {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if p.TLS {
+		dialer := &tls.Dialer{Config: &tls.Config{InsecureSkipVerify: true}}
+		conn, err = dialer.DialContext(ctx, "tcp", p.Addr)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", p.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read banner: %w", err)
+	}
+	if !strings.HasPrefix(banner, "220") {
+		return fmt.Errorf("unexpected banner %q", strings.TrimSpace(banner))
+	}
+	_, _ = conn.Write([]byte("QUIT\r\n"))
+	return nil
+}
+
+func describeCertificate(cert *tls.Certificate, now time.Time) (Certificate, bool) {
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return Certificate{}, false
+		}
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return Certificate{}, false
+		}
+	}
+	return Certificate{
+		Subject:  leaf.Subject.CommonName,
+		DNSNames: leaf.DNSNames,
+		NotAfter: leaf.NotAfter,
+		Valid:    now.After(leaf.NotBefore) && now.Before(leaf.NotAfter),
+	}, true
+}
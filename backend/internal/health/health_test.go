@@ -0,0 +1,231 @@
+package health
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakePinger struct {
+	pingFn func(ctx context.Context) error
+}
+
+func (f *fakePinger) Ping(ctx context.Context) error {
+	if f.pingFn != nil {
+		return f.pingFn(ctx)
+	}
+	return nil
+}
+
+// startBannerServer accepts connections, writes banner and waits for QUIT.
+func startBannerServer(t *testing.T, banner string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if banner == "" {
+					return
+				}
+				conn.Write([]byte(banner + "\r\n"))
+				bufio.NewReader(conn).ReadString('\n')
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func closedAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func testCertificate(t *testing.T, notBefore, notAfter time.Time) *tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mx.coresend.test"},
+		DNSNames:     []string{"mx.coresend.test"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestChecker_Ready(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		pingErr   error
+		probe     func(t *testing.T) string
+		wantReady bool
+		wantErr   map[string]string
+	}{
+		{
+			name:      "all healthy",
+			probe:     func(t *testing.T) string { return startBannerServer(t, "220 coresend.test ESMTP") },
+			wantReady: true,
+		},
+		{
+			name:    "store down",
+			pingErr: errors.New("redis down"),
+			probe:   func(t *testing.T) string { return startBannerServer(t, "220 coresend.test ESMTP") },
+			wantErr: map[string]string{"store": "redis down"},
+		},
+		{
+			name:    "smtp refuses connections",
+			probe:   closedAddr,
+			wantErr: map[string]string{"smtp": "refused"},
+		},
+		{
+			name:    "smtp greets with error",
+			probe:   func(t *testing.T) string { return startBannerServer(t, "554 go away") },
+			wantErr: map[string]string{"smtp": "unexpected banner"},
+		},
+		{
+			name:    "smtp closes without banner",
+			probe:   func(t *testing.T) string { return startBannerServer(t, "") },
+			wantErr: map[string]string{"smtp": "failed to read banner"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := &Checker{
+				Store: &fakePinger{pingFn: func(ctx context.Context) error { return tc.pingErr }},
+				SMTP:  []SMTPProbe{{Name: "smtp", Addr: tc.probe(t)}},
+			}
+
+			report := c.Ready(context.Background())
+
+			if report.Ready != tc.wantReady {
+				t.Fatalf("Ready = %v, want %v (checks %+v)", report.Ready, tc.wantReady, report.Checks)
+			}
+			if len(report.Checks) != 2 || report.Checks[0].Name != "store" || report.Checks[1].Name != "smtp" {
+				t.Fatalf("checks = %+v, want store then smtp", report.Checks)
+			}
+			for _, check := range report.Checks {
+				want, failing := tc.wantErr[check.Name]
+				if check.OK() == failing {
+					t.Fatalf("check %s error = %v, want failing %v", check.Name, check.Err, failing)
+				}
+				if failing && !strings.Contains(check.Err.Error(), want) {
+					t.Fatalf("check %s error = %v, want containing %q", check.Name, check.Err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestChecker_ReadyRespectsTimeout(t *testing.T) {
+	t.Parallel()
+
+	c := &Checker{
+		Store: &fakePinger{pingFn: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		Timeout: 20 * time.Millisecond,
+	}
+
+	start := time.Now()
+	report := c.Ready(context.Background())
+
+	if report.Ready {
+		t.Fatal("Ready = true, want false on timeout")
+	}
+	if !errors.Is(report.Checks[0].Err, context.DeadlineExceeded) {
+		t.Fatalf("store error = %v, want deadline exceeded", report.Checks[0].Err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Ready took %v, want bounded by timeout", elapsed)
+	}
+}
+
+func TestChecker_DrainFailsReadiness(t *testing.T) {
+	t.Parallel()
+
+	c := &Checker{Store: &fakePinger{}}
+	if report := c.Ready(context.Background()); !report.Ready || report.Draining {
+		t.Fatalf("before Drain: Ready = %v, Draining = %v", report.Ready, report.Draining)
+	}
+
+	c.Drain()
+
+	report := c.Ready(context.Background())
+	if report.Ready || !report.Draining {
+		t.Fatalf("after Drain: Ready = %v, Draining = %v", report.Ready, report.Draining)
+	}
+	if !report.Checks[0].OK() {
+		t.Fatalf("store check = %v, probes should still run while draining", report.Checks[0].Err)
+	}
+}
+
+func TestChecker_Certificates(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	valid := testCertificate(t, now.Add(-time.Hour), now.Add(time.Hour))
+	expired := testCertificate(t, now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	c := &Checker{
+		Store:        &fakePinger{},
+		Certificates: func() []*tls.Certificate { return []*tls.Certificate{valid, expired} },
+	}
+
+	report := c.Ready(context.Background())
+
+	if !report.Ready {
+		t.Fatal("Ready = false, an expired certificate should not fail readiness")
+	}
+	if len(report.Certificates) != 2 {
+		t.Fatalf("certificates length = %d, want 2", len(report.Certificates))
+	}
+	if got := report.Certificates[0]; !got.Valid || got.Subject != "mx.coresend.test" || len(got.DNSNames) != 1 {
+		t.Fatalf("certificates[0] = %+v, want valid mx.coresend.test", got)
+	}
+	if report.Certificates[1].Valid {
+		t.Fatalf("certificates[1] = %+v, want expired", report.Certificates[1])
+	}
+}
@@ -0,0 +1,126 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// hardenedOffset marks a BIP32 child index as hardened.
+const hardenedOffset uint32 = 0x80000000
+
+var ErrInvalidMnemonic = errors.New("invalid mnemonic: must be a valid BIP39 phrase with correct word count and checksum")
+
+// Identity is a single inbox keypair derived from a mnemonic.
+type Identity struct {
+	Index      uint32
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+	Address    string
+}
+
+// GenerateMnemonic returns a new 12-word BIP39 phrase.
+func GenerateMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(128)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// NormalizeMnemonic lowercases the phrase and collapses whitespace between words.
+func NormalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
+
+// Derive returns the identity at the given index. It matches the web app:
+// the BIP32 key at m/44'/0'/{index}'/0/0 is used as the Ed25519 seed.
+func Derive(mnemonic string, index uint32) (*Identity, error) {
+	if index >= hardenedOffset {
+		return nil, fmt.Errorf("index must be below %d", hardenedOffset)
+	}
+
+	mnemonic = NormalizeMnemonic(mnemonic)
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return nil, ErrInvalidMnemonic
+	}
+
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+	if err != nil {
+		return nil, ErrInvalidMnemonic
+	}
+
+	key, chainCode := masterKey(seed)
+	path := []uint32{44 + hardenedOffset, 0 + hardenedOffset, index + hardenedOffset, 0, 0}
+	for _, child := range path {
+		key, chainCode, err = deriveChild(key, chainCode, child)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+	}
+
+	privateKey := ed25519.NewKeyFromSeed(key)
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	return &Identity{
+		Index:      index,
+		PrivateKey: privateKey,
+		PublicKey:  publicKey,
+		Address:    AddressFromPublicKey(publicKey),
+	}, nil
+}
+
+// AddressFromPublicKey returns the inbox address for a public key:
+// the first 20 bytes of its SHA-256 hash, hex-encoded.
+func AddressFromPublicKey(publicKey []byte) string {
+	hash := sha256.Sum256(publicKey)
+	return hex.EncodeToString(hash[:])[:40]
+}
+
+// SigningPayload builds the message clients sign for authenticated API requests.
+func SigningPayload(method, path, timestamp, bodyHashHex, nonce string) string {
+	return fmt.Sprintf("%s:%s:%s:%s:%s", method, path, timestamp, bodyHashHex, nonce)
+}
+
+func masterKey(seed []byte) ([]byte, []byte) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	return sum[:32], sum[32:]
+}
+
+func deriveChild(key, chainCode []byte, index uint32) ([]byte, []byte, error) {
+	var data []byte
+	if index >= hardenedOffset {
+		data = append([]byte{0x00}, key...)
+	} else {
+		data = secp256k1.PrivKeyFromBytes(key).PubKey().SerializeCompressed()
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	var tweak, parent secp256k1.ModNScalar
+	if overflow := tweak.SetByteSlice(sum[:32]); overflow {
+		return nil, nil, errors.New("child key out of range")
+	}
+	parent.SetByteSlice(key)
+	tweak.Add(&parent)
+	if tweak.IsZero() {
+		return nil, nil, errors.New("child key is zero")
+	}
+
+	childKey := tweak.Bytes()
+	return childKey[:], sum[32:], nil
+}
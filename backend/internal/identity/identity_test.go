@@ -0,0 +1,139 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func TestDerive_KnownVectors(t *testing.T) {
+	t.Parallel()
+
+	// Vectors for the web app's derivation path, m/44'/0'/{index}'/0/0.
+	tests := []struct {
+		index     uint32
+		seed      string
+		publicKey string
+		address   string
+	}{
+		{
+			index:     0,
+			seed:      "e284129cc0922579a535bbf4d1a3b25773090d28c909bc0fed73b5e0222cc372",
+			publicKey: "3c35d187ea9428787cb3343d4a724fc961902012bbce5ce4f43369861e19127f",
+			address:   "840c4084865fc7153bcef07c5458e1bae1137053",
+		},
+		{
+			index:     1,
+			seed:      "5fa6b9ea573e9ccae299c38193a5e61e4f9cc31e2f0ef45ae38e2487bcc215b4",
+			publicKey: "3525c32858ce80473ee9bdc1d580fde43bd6e640b8c19583f9160b2efc116e91",
+			address:   "b8aece28623ce188040068c43558930c6fe4eee8",
+		},
+	}
+
+	for _, tc := range tests {
+		id, err := Derive(testMnemonic, tc.index)
+		if err != nil {
+			t.Fatalf("Derive(%d) error = %v", tc.index, err)
+		}
+		if got := hex.EncodeToString(id.PrivateKey.Seed()); got != tc.seed {
+			t.Fatalf("index %d seed = %s, want %s", tc.index, got, tc.seed)
+		}
+		if got := hex.EncodeToString(id.PublicKey); got != tc.publicKey {
+			t.Fatalf("index %d public key = %s, want %s", tc.index, got, tc.publicKey)
+		}
+		if id.Address != tc.address {
+			t.Fatalf("index %d address = %s, want %s", tc.index, id.Address, tc.address)
+		}
+		if id.Index != tc.index {
+			t.Fatalf("index = %d, want %d", id.Index, tc.index)
+		}
+	}
+}
+
+func TestDerive_NormalizesWhitespaceAndCase(t *testing.T) {
+	t.Parallel()
+
+	messy := "  " + strings.ToUpper(strings.ReplaceAll(testMnemonic, " ", "  \n")) + "\n"
+
+	want, err := Derive(testMnemonic, 0)
+	if err != nil {
+		t.Fatalf("Derive() error = %v", err)
+	}
+	got, err := Derive(messy, 0)
+	if err != nil {
+		t.Fatalf("Derive(messy) error = %v", err)
+	}
+	if got.Address != want.Address {
+		t.Fatalf("address = %s, want %s", got.Address, want.Address)
+	}
+}
+
+func TestDerive_InvalidInput(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		mnemonic string
+		index    uint32
+	}{
+		{name: "empty", mnemonic: ""},
+		{name: "unknown word", mnemonic: strings.Replace(testMnemonic, "about", "xyzzy", 1)},
+		{name: "bad checksum", mnemonic: strings.Replace(testMnemonic, "about", "abandon", 1)},
+		{name: "hardened index", mnemonic: testMnemonic, index: hardenedOffset},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := Derive(tc.mnemonic, tc.index); err == nil {
+				t.Fatalf("Derive() expected error")
+			}
+		})
+	}
+
+	if _, err := Derive("", 0); !errors.Is(err, ErrInvalidMnemonic) {
+		t.Fatalf("error = %v, want ErrInvalidMnemonic", err)
+	}
+}
+
+func TestGenerateMnemonic(t *testing.T) {
+	t.Parallel()
+
+	mnemonic, err := GenerateMnemonic()
+	if err != nil {
+		t.Fatalf("GenerateMnemonic() error = %v", err)
+	}
+	if words := strings.Fields(mnemonic); len(words) != 12 {
+		t.Fatalf("word count = %d, want 12", len(words))
+	}
+	if _, err := Derive(mnemonic, 0); err != nil {
+		t.Fatalf("generated mnemonic does not derive: %v", err)
+	}
+}
+
+func TestSigningPayloadVerifies(t *testing.T) {
+	t.Parallel()
+
+	id, err := Derive(testMnemonic, 0)
+	if err != nil {
+		t.Fatalf("Derive() error = %v", err)
+	}
+
+	payload := SigningPayload("GET", "/api/inbox/"+id.Address, "1700000000", "abc", "nonce")
+	if want := "GET:/api/inbox/" + id.Address + ":1700000000:abc:nonce"; payload != want {
+		t.Fatalf("payload = %q, want %q", payload, want)
+	}
+
+	sig := ed25519.Sign(id.PrivateKey, []byte(payload))
+	if !ed25519.Verify(id.PublicKey, []byte(payload), sig) {
+		t.Fatalf("signature did not verify")
+	}
+	if got := AddressFromPublicKey(id.PublicKey); got != id.Address {
+		t.Fatalf("AddressFromPublicKey = %s, want %s", got, id.Address)
+	}
+}
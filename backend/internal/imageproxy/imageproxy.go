@@ -0,0 +1,267 @@
+// Package imageproxy fetches remote images in received mail on behalf of
+// the viewer, so opening an email does not reveal their address to the
+// sender's servers.
+//
+// Sanitized HTML points images at the proxy with a URL signed by the
+// server, so the proxy cannot be used to fetch arbitrary URLs. Only common
+// raster image types up to a size limit are served, and fetched images are
+// cached in memory.
+package imageproxy
+
+import (
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/metrics"
+	"github.com/fn-jakubkarp/coresend/internal/netguard"
+)
+
+// Path is where the proxy is served.
+const Path = "/api/images"
+
+// Defaults applied when the matching Proxy field is zero.
+const (
+	DefaultMaxBytes   = 5 << 20
+	DefaultCacheBytes = 64 << 20
+	DefaultCacheTTL   = time.Hour
+	DefaultTimeout    = 10 * time.Second
+)
+
+const maxRedirects = 3
+
+// Request results counted in metrics.
+const (
+	ResultCached   = "cached"
+	ResultFetched  = "fetched"
+	ResultRejected = "rejected"
+	ResultFailed   = "failed"
+)
+
+var (
+	ErrInvalidURL       = errors.New("image URL must be a public http or https URL")
+	ErrTooLarge         = errors.New("image is too large")
+	ErrUnsupportedType  = errors.New("image type is not supported")
+	ErrUpstreamResponse = errors.New("image server did not return the image")
+)
+
+// allowedTypes are served; SVG is left out because it can carry scripts.
+var allowedTypes = map[string]bool{
+	"image/png":  true,
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/webp": true,
+}
+
+type Proxy struct {
+	// Key signs proxied URLs.
+	Key []byte
+	// Client fetches images. When nil, a client that refuses private
+	// addresses unless AllowPrivate is set is used.
+	Client       *http.Client
+	MaxBytes     int64
+	CacheBytes   int64
+	CacheTTL     time.Duration
+	Timeout      time.Duration
+	AllowPrivate bool
+
+	clientOnce sync.Once
+	client     *http.Client
+
+	mu         sync.Mutex
+	cache      map[string]*list.Element
+	lru        list.List
+	cachedSize int64
+}
+
+// Image is a fetched image.
+type Image struct {
+	ContentType string
+	Data        []byte
+}
+
+type cacheEntry struct {
+	url     string
+	image   Image
+	expires time.Time
+}
+
+// URL returns the proxied, signed URL of the image at src.
+func (p *Proxy) URL(src string) string {
+	q := url.Values{"url": {src}, "sig": {p.sign(src)}}
+	return Path + "?" + q.Encode()
+}
+
+// Verify reports whether sig was made by URL for src.
+func (p *Proxy) Verify(src, sig string) bool {
+	return hmac.Equal([]byte(sig), []byte(p.sign(src)))
+}
+
+func (p *Proxy) sign(src string) string {
+	mac := hmac.New(sha256.New, p.Key)
+	mac.Write([]byte(src))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Fetch returns the image at src from the cache or its server.
+func (p *Proxy) Fetch(ctx context.Context, src string) (img Image, err error) {
+	if img, ok := p.cached(src, time.Now()); ok {
+		metrics.ImageProxyRequestsTotal.WithLabelValues(ResultCached).Inc()
+		return img, nil
+	}
+	defer func() {
+		switch {
+		case err == nil:
+			metrics.ImageProxyRequestsTotal.WithLabelValues(ResultFetched).Inc()
+		case errors.Is(err, ErrInvalidURL), errors.Is(err, ErrTooLarge), errors.Is(err, ErrUnsupportedType):
+			metrics.ImageProxyRequestsTotal.WithLabelValues(ResultRejected).Inc()
+		default:
+			metrics.ImageProxyRequestsTotal.WithLabelValues(ResultFailed).Inc()
+		}
+	}()
+
+	if err := netguard.ValidateURL(src, p.AllowPrivate); err != nil {
+		return Image{}, ErrInvalidURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return Image{}, ErrInvalidURL
+	}
+	req.Header.Set("Accept", "image/png, image/gif, image/jpeg, image/webp")
+
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		if errors.Is(err, netguard.ErrPrivateURL) {
+			return Image{}, ErrInvalidURL
+		}
+		return Image{}, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Image{}, fmt.Errorf("%w: status %d", ErrUpstreamResponse, resp.StatusCode)
+	}
+	if resp.ContentLength > p.maxBytes() {
+		return Image{}, ErrTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, p.maxBytes()+1))
+	if err != nil {
+		return Image{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	if int64(len(data)) > p.maxBytes() {
+		return Image{}, ErrTooLarge
+	}
+
+	// The declared type is not trusted; the data must look like an image
+	contentType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if !allowedTypes[contentType] {
+		return Image{}, ErrUnsupportedType
+	}
+
+	img = Image{ContentType: contentType, Data: data}
+	p.store(src, img, time.Now())
+	return img, nil
+}
+
+func (p *Proxy) cached(src string, now time.Time) (Image, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	el, ok := p.cache[src]
+	if !ok {
+		return Image{}, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		p.remove(el)
+		return Image{}, false
+	}
+	p.lru.MoveToFront(el)
+	return entry.image, true
+}
+
+// store caches img, evicting the least recently used images to stay within
+// CacheBytes.
+func (p *Proxy) store(src string, img Image, now time.Time) {
+	size := int64(len(img.Data))
+	if size > p.cacheBytes() {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cache == nil {
+		p.cache = map[string]*list.Element{}
+	}
+	if el, ok := p.cache[src]; ok {
+		p.remove(el)
+	}
+	for p.cachedSize+size > p.cacheBytes() {
+		p.remove(p.lru.Back())
+	}
+	p.cache[src] = p.lru.PushFront(&cacheEntry{url: src, image: img, expires: now.Add(p.cacheTTL())})
+	p.cachedSize += size
+}
+
+func (p *Proxy) remove(el *list.Element) {
+	entry := p.lru.Remove(el).(*cacheEntry)
+	delete(p.cache, entry.url)
+	p.cachedSize -= int64(len(entry.image.Data))
+}
+
+func (p *Proxy) httpClient() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	p.clientOnce.Do(func() {
+		p.client = netguard.NewClient(p.timeout(), p.AllowPrivate)
+		// Image hosts often redirect to a CDN; every hop is dialled with
+		// the same address checks
+		p.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return netguard.ValidateURL(req.URL.String(), p.AllowPrivate)
+		}
+	})
+	return p.client
+}
+
+func (p *Proxy) maxBytes() int64 {
+	if p.MaxBytes > 0 {
+		return p.MaxBytes
+	}
+	return DefaultMaxBytes
+}
+
+func (p *Proxy) cacheBytes() int64 {
+	if p.CacheBytes > 0 {
+		return p.CacheBytes
+	}
+	return DefaultCacheBytes
+}
+
+func (p *Proxy) cacheTTL() time.Duration {
+	if p.CacheTTL > 0 {
+		return p.CacheTTL
+	}
+	return DefaultCacheTTL
+}
+
+func (p *Proxy) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return DefaultTimeout
+}
@@ -0,0 +1,166 @@
+package imageproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pngData is the start of a PNG file, enough for content sniffing.
+var pngData = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 24)...)
+
+func TestProxy_URL(t *testing.T) {
+	t.Parallel()
+
+	p := &Proxy{Key: []byte("0123456789abcdef")}
+	src := "https://cdn.example.com/logo.png?v=1&x=y"
+
+	u, err := url.Parse(p.URL(src))
+	if err != nil {
+		t.Fatalf("URL() is not a URL: %v", err)
+	}
+	if u.Path != Path || u.Query().Get("url") != src {
+		t.Fatalf("URL() = %q", u)
+	}
+	if !p.Verify(src, u.Query().Get("sig")) {
+		t.Fatal("Verify() = false for a signed URL")
+	}
+	if p.Verify("https://cdn.example.com/other.png", u.Query().Get("sig")) {
+		t.Fatal("Verify() = true for another URL")
+	}
+	if (&Proxy{Key: []byte("another key 0123")}).Verify(src, u.Query().Get("sig")) {
+		t.Fatal("Verify() = true with another key")
+	}
+}
+
+func TestProxy_Fetch(t *testing.T) {
+	t.Parallel()
+
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/logo.png":
+			// The declared type is ignored
+			w.Header().Set("Content-Type", "text/html")
+			w.Write(pngData)
+		case "/moved.png":
+			http.Redirect(w, r, "/logo.png", http.StatusFound)
+		case "/page.html":
+			w.Write([]byte("<!DOCTYPE html><html><script>alert(1)</script></html>"))
+		case "/image.svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`))
+		case "/large.png":
+			w.Write(append(pngData, make([]byte, 1024)...))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr error
+	}{
+		{name: "image", path: "/logo.png"},
+		{name: "redirect", path: "/moved.png"},
+		{name: "HTML", path: "/page.html", wantErr: ErrUnsupportedType},
+		{name: "SVG", path: "/image.svg", wantErr: ErrUnsupportedType},
+		{name: "too large", path: "/large.png", wantErr: ErrTooLarge},
+		{name: "missing", path: "/missing.png", wantErr: ErrUpstreamResponse},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &Proxy{Key: []byte("k"), MaxBytes: 512, AllowPrivate: true}
+			img, err := p.Fetch(context.Background(), server.URL+tc.path)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Fetch() error = %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && (img.ContentType != "image/png" || !bytes.Equal(img.Data, pngData)) {
+				t.Fatalf("Fetch() = %q, %d bytes", img.ContentType, len(img.Data))
+			}
+		})
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		t.Parallel()
+
+		p := &Proxy{Key: []byte("k"), AllowPrivate: true}
+		src := server.URL + "/logo.png?cached"
+		before := hits.Load()
+		for range 3 {
+			if _, err := p.Fetch(context.Background(), src); err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+		}
+		if got := hits.Load() - before; got != 1 {
+			t.Fatalf("server hits = %d, want 1", got)
+		}
+	})
+}
+
+func TestProxy_FetchRefusesPrivateAddresses(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pngData)
+	}))
+	t.Cleanup(server.Close)
+
+	p := &Proxy{Key: []byte("k"), Timeout: time.Second}
+	for _, src := range []string{
+		server.URL + "/logo.png",
+		"http://localhost/logo.png",
+		"file:///etc/passwd",
+		"cid:logo",
+	} {
+		if _, err := p.Fetch(context.Background(), src); !errors.Is(err, ErrInvalidURL) {
+			t.Fatalf("Fetch(%q) error = %v, want ErrInvalidURL", src, err)
+		}
+	}
+}
+
+func TestProxy_CacheEviction(t *testing.T) {
+	t.Parallel()
+
+	p := &Proxy{CacheBytes: 10, CacheTTL: time.Minute}
+	now := time.Now()
+	img := func(n int) Image { return Image{Data: []byte(strings.Repeat("x", n))} }
+
+	p.store("a", img(4), now)
+	p.store("b", img(4), now)
+	// Using a makes b the least recently used
+	if _, ok := p.cached("a", now); !ok {
+		t.Fatal("a is not cached")
+	}
+	p.store("c", img(4), now)
+
+	if _, ok := p.cached("b", now); ok {
+		t.Fatal("b is still cached, want it evicted")
+	}
+	if _, ok := p.cached("a", now); !ok {
+		t.Fatal("a was evicted")
+	}
+	if _, ok := p.cached("c", now.Add(time.Minute)); ok {
+		t.Fatal("c is cached past its TTL")
+	}
+	p.store("d", img(11), now)
+	if _, ok := p.cached("d", now); ok {
+		t.Fatal("an image larger than the cache was cached")
+	}
+	if p.cachedSize != 4 {
+		t.Fatalf("cached size = %d, want 4", p.cachedSize)
+	}
+}
@@ -0,0 +1,205 @@
+// Package logging configures the process-wide slog logger.
+//
+// Request and SMTP session IDs stored in a context, and the ID of the active
+// trace, are attached to every record logged with that context. Attributes
+// that carry personal data are redacted by key unless the level is debug.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute keys that carry personal data. Identifier keys are masked so
+// records can still be told apart; content keys are dropped entirely.
+const (
+	KeyAddress   = "address"
+	KeyPublicKey = "public_key"
+	KeyFrom      = "from"
+	KeyTo        = "to"
+	KeySubject   = "subject"
+	KeyBody      = "body"
+	KeyPayload   = "payload"
+	KeyPath      = "path"
+)
+
+const (
+	keyRequestID = "request_id"
+	keySessionID = "session_id"
+	keyTraceID   = "trace_id"
+)
+
+// Config selects the output format and minimum level.
+type Config struct {
+	// Format is "text" or "json".
+	Format string
+	Level  slog.Level
+}
+
+// ParseConfig parses LOG_FORMAT and LOG_LEVEL style values. Empty values
+// select text output at info level.
+func ParseConfig(format, level string) (Config, error) {
+	cfg := Config{Format: strings.ToLower(strings.TrimSpace(format))}
+	if cfg.Format == "" {
+		cfg.Format = "text"
+	}
+	if cfg.Format != "text" && cfg.Format != "json" {
+		return Config{}, fmt.Errorf("invalid log format %q, want text or json", format)
+	}
+
+	if level = strings.TrimSpace(level); level != "" {
+		if err := cfg.Level.UnmarshalText([]byte(level)); err != nil {
+			return Config{}, fmt.Errorf("invalid log level %q", level)
+		}
+	}
+	return cfg, nil
+}
+
+// Redacts reports whether personal data is masked at this level.
+func (c Config) Redacts() bool {
+	return c.Level > slog.LevelDebug
+}
+
+// New returns a logger writing to w.
+func New(w io.Writer, cfg Config) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	if cfg.Redacts() {
+		opts.ReplaceAttr = redactAttr
+	}
+
+	var h slog.Handler
+	if cfg.Format == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(contextHandler{h})
+}
+
+// Setup installs a logger as the slog default. Output from the standard log
+// package, including go-smtp's, goes through it as well.
+func Setup(w io.Writer, cfg Config) *slog.Logger {
+	logger := New(w, cfg)
+	log.SetFlags(0)
+	slog.SetDefault(logger)
+	return logger
+}
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	sessionIDKey
+)
+
+// NewID returns a random 16 character hex identifier.
+func NewID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the HTTP request ID stored in ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func WithSessionID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, sessionIDKey, id)
+}
+
+// SessionID returns the SMTP session ID stored in ctx, if any.
+func SessionID(ctx context.Context) string {
+	id, _ := ctx.Value(sessionIDKey).(string)
+	return id
+}
+
+// contextHandler adds the request, session and trace IDs found in the context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String(keyRequestID, id))
+	}
+	if id := SessionID(ctx); id != "" {
+		r.AddAttrs(slog.String(keySessionID, id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String(keyTraceID, sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	switch a.Key {
+	case KeyAddress, KeyPublicKey:
+		return slog.String(a.Key, maskIdentifier(a.Value.String()))
+	case KeyFrom, KeyTo:
+		if a.Value.Kind() == slog.KindAny {
+			if list, ok := a.Value.Any().([]string); ok {
+				masked := make([]string, len(list))
+				for i, addr := range list {
+					masked[i] = maskEmail(addr)
+				}
+				return slog.Any(a.Key, masked)
+			}
+		}
+		return slog.String(a.Key, maskEmail(a.Value.String()))
+	case KeySubject, KeyBody, KeyPayload:
+		return slog.String(a.Key, "[redacted]")
+	case KeyPath:
+		return slog.String(a.Key, maskPath(a.Value.String()))
+	}
+	return a
+}
+
+// maskIdentifier keeps the first four characters of an identifier.
+func maskIdentifier(s string) string {
+	if len(s) <= 4 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:4] + "…"
+}
+
+// maskEmail masks the local part of an email address and keeps its domain.
+func maskEmail(addr string) string {
+	local, domain, ok := strings.Cut(addr, "@")
+	if !ok {
+		return maskIdentifier(addr)
+	}
+	return maskIdentifier(local) + "@" + domain
+}
+
+// maskPath masks path segments long enough to be addresses or email IDs.
+func maskPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if len(part) >= 32 {
+			parts[i] = maskIdentifier(part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
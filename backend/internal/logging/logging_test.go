@@ -0,0 +1,166 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		format  string
+		level   string
+		want    Config
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			want: Config{Format: "text", Level: slog.LevelInfo},
+		},
+		{
+			name:   "json debug",
+			format: "JSON",
+			level:  "debug",
+			want:   Config{Format: "json", Level: slog.LevelDebug},
+		},
+		{
+			name:  "warn",
+			level: "WARN",
+			want:  Config{Format: "text", Level: slog.LevelWarn},
+		},
+		{
+			name:    "unknown format",
+			format:  "xml",
+			wantErr: true,
+		},
+		{
+			name:    "unknown level",
+			level:   "verbose",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseConfig(tc.format, tc.level)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("ParseConfig() expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseConfig() error = %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("ParseConfig() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func logJSON(t *testing.T, ctx context.Context, level slog.Level, msg string, args ...any) map[string]any {
+	t.Helper()
+
+	var buf bytes.Buffer
+	New(&buf, Config{Format: "json", Level: level}).InfoContext(ctx, msg, args...)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("invalid JSON log output %q: %v", buf.String(), err)
+	}
+	return record
+}
+
+func TestNew_Redaction(t *testing.T) {
+	t.Parallel()
+
+	const address = "0123456789abcdef0123456789abcdef01234567"
+	args := []any{
+		KeyAddress, address,
+		KeyPublicKey, "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a",
+		KeyFrom, "alice@example.com",
+		KeyTo, []string{address + "@coresend.io"},
+		KeySubject, "Your login code is 123456",
+		KeyPath, "/api/inbox/" + address,
+		"status", 200,
+	}
+
+	t.Run("redacted at info", func(t *testing.T) {
+		t.Parallel()
+
+		record := logJSON(t, context.Background(), slog.LevelInfo, "msg", args...)
+		want := map[string]any{
+			KeyAddress:   "0123…",
+			KeyPublicKey: "d75a…",
+			KeyFrom:      "alic…@example.com",
+			KeySubject:   "[redacted]",
+			KeyPath:      "/api/inbox/0123…",
+		}
+		for key, value := range want {
+			if record[key] != value {
+				t.Fatalf("%s = %v, want %v", key, record[key], value)
+			}
+		}
+		to, _ := record[KeyTo].([]any)
+		if len(to) != 1 || to[0] != "0123…@coresend.io" {
+			t.Fatalf("to = %v, want [0123…@coresend.io]", record[KeyTo])
+		}
+		if record["status"] != float64(200) {
+			t.Fatalf("status = %v, want 200", record["status"])
+		}
+	})
+
+	t.Run("kept at debug", func(t *testing.T) {
+		t.Parallel()
+
+		record := logJSON(t, context.Background(), slog.LevelDebug, "msg", args...)
+		if record[KeyAddress] != address {
+			t.Fatalf("address = %v, want %v", record[KeyAddress], address)
+		}
+		if record[KeySubject] != "Your login code is 123456" {
+			t.Fatalf("subject = %v, want unredacted", record[KeySubject])
+		}
+	})
+}
+
+func TestNew_ContextIDs(t *testing.T) {
+	t.Parallel()
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	ctx = WithSessionID(ctx, "sess-1")
+
+	record := logJSON(t, ctx, slog.LevelInfo, "msg")
+	if record["request_id"] != "req-1" {
+		t.Fatalf("request_id = %v, want req-1", record["request_id"])
+	}
+	if record["session_id"] != "sess-1" {
+		t.Fatalf("session_id = %v, want sess-1", record["session_id"])
+	}
+
+	var buf bytes.Buffer
+	New(&buf, Config{Format: "text"}).With("component", "test").InfoContext(ctx, "msg")
+	if !strings.Contains(buf.String(), "request_id=req-1") || !strings.Contains(buf.String(), "component=test") {
+		t.Fatalf("text output = %q, want request_id and component", buf.String())
+	}
+}
+
+func TestNewID(t *testing.T) {
+	t.Parallel()
+
+	a, b := NewID(), NewID()
+	if len(a) != 16 {
+		t.Fatalf("len(NewID()) = %d, want 16", len(a))
+	}
+	if a == b {
+		t.Fatalf("NewID() returned %q twice", a)
+	}
+}
@@ -0,0 +1,303 @@
+// Package mailparse reads a received message into what is stored of it: the
+// body to show, the text to extract codes from, embedded images and the
+// MIME tree.
+//
+// The whole tree is walked, including messages attached as message/rfc822
+// parts such as forwarded mail. Attached messages are only described; the
+// body and images come from the message itself. The first HTML part in
+// depth-first order is the body, or the first plain text part when there is
+// none, so nested alternatives give the same result every time.
+//
+// Parts are streamed: only the body and inline images are held in memory,
+// while other parts are hashed, and written to a blob store when one is
+// given, as they are read.
+package mailparse
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/fn-jakubkarp/coresend/internal/blob"
+	"github.com/fn-jakubkarp/coresend/internal/charset"
+	"github.com/fn-jakubkarp/coresend/internal/store"
+)
+
+// Limits on the tree that is described. Parts beyond them are read past but
+// not recorded.
+const (
+	maxDepth = 16
+	maxParts = 256
+)
+
+// ErrPartTooLarge is returned by Parse when a part decodes to more than
+// Options.MaxPartSize.
+var ErrPartTooLarge = errors.New("message part exceeds the size limit")
+
+// Options bound what Parse reads and say where parts go.
+type Options struct {
+	// MaxPartSize bounds the decoded size of each part. Zero means no
+	// limit.
+	MaxPartSize int64
+	// Blobs, when set, stores the parts that are not the body or inline
+	// images.
+	Blobs blob.Store
+}
+
+// Message is a parsed message.
+type Message struct {
+	Header mail.Header
+	// Body is the HTML part, or the plain text part, decoded to UTF-8.
+	// ContentType is its type and Charset the one it declared.
+	Body        string
+	ContentType string
+	Charset     string
+	// Text and HTML are the first part of each type, for extraction.
+	// TextPart and HTMLPart describe the parts they were read from, or are
+	// nil.
+	Text     string
+	HTML     string
+	TextPart *store.MIMEPart
+	HTMLPart *store.MIMEPart
+	// Inline are the images with a Content-ID.
+	Inline    []store.InlinePart
+	Structure *store.MIMEPart
+}
+
+// Parse reads a message from r. Malformed parts are skipped or kept as far
+// as they decode; only an unreadable header and a part over the size limit
+// are errors.
+func Parse(ctx context.Context, r io.Reader, opts Options) (*Message, error) {
+	e, err := message.Read(r)
+	if !usable(err) {
+		return nil, err
+	}
+
+	p := &parser{ctx: ctx, opts: opts}
+	root, err := p.walk(e, "", 0, false)
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{
+		Header:    mail.Header{Header: e.Header},
+		Text:      p.text,
+		HTML:      p.html,
+		TextPart:  p.textPart,
+		HTMLPart:  p.htmlPart,
+		Inline:    p.inline,
+		Structure: &root,
+	}
+	switch {
+	case p.htmlPart != nil:
+		msg.Body, msg.ContentType, msg.Charset = p.html, p.htmlPart.ContentType, p.htmlPart.Charset
+	case p.textPart != nil:
+		msg.Body, msg.ContentType, msg.Charset = p.text, p.textPart.ContentType, p.textPart.Charset
+	}
+	return msg, nil
+}
+
+// usable reports whether an entity returned with err can be read: unknown
+// charsets and transfer encodings leave the content as it is.
+func usable(err error) bool {
+	return err == nil || message.IsUnknownCharset(err) || message.IsUnknownEncoding(err)
+}
+
+type parser struct {
+	ctx   context.Context
+	opts  Options
+	parts int
+
+	text, html         string
+	textPart, htmlPart *store.MIMEPart
+	inline             []store.InlinePart
+}
+
+// walk describes e and its parts, and collects the content of the parts
+// that are not in an attached message.
+func (p *parser) walk(e *message.Entity, path string, depth int, attached bool) (store.MIMEPart, error) {
+	p.parts++
+	contentType, params, err := e.Header.ContentType()
+	if err != nil || contentType == "" {
+		// RFC 2045 section 5.2
+		contentType = "text/plain"
+	}
+	disposition, dispositionParams, _ := e.Header.ContentDisposition()
+	part := store.MIMEPart{
+		Path:        path,
+		ContentType: contentType,
+		Charset:     strings.ToLower(params["charset"]),
+		Encoding:    strings.ToLower(strings.TrimSpace(e.Header.Get("Content-Transfer-Encoding"))),
+		Disposition: disposition,
+		Filename:    filename(params, dispositionParams),
+		ContentID:   contentID(e.Header.Get("Content-Id")),
+	}
+
+	if mr := e.MultipartReader(); mr != nil {
+		for i := 1; ; i++ {
+			child, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if !usable(err) {
+				slog.WarnContext(p.ctx, "Failed to read email part", "path", path, "error", err)
+				break
+			}
+			if depth >= maxDepth || p.parts >= maxParts {
+				// The next part is found past this one's content
+				continue
+			}
+			described, err := p.walk(child, childPath(path, i), depth+1, attached)
+			if err != nil {
+				return part, err
+			}
+			part.Size += described.Size
+			part.Parts = append(part.Parts, described)
+		}
+		return part, nil
+	}
+
+	body := &partReader{r: e.Body, limit: p.opts.MaxPartSize}
+	err = p.read(&part, body, depth, attached)
+	if body.exceeded {
+		return part, ErrPartTooLarge
+	}
+	if err != nil {
+		// A malformed transfer encoding still decodes up to the error
+		slog.WarnContext(p.ctx, "Failed to read whole email part, keeping the decoded content", "path", path, "error", err)
+	}
+	part.Size = int(body.read)
+	return part, nil
+}
+
+// read consumes the content of a leaf part: attached messages are walked,
+// the body and inline images are collected, and the rest is stored. Past
+// the tree limits an attached message is stored like any other part.
+func (p *parser) read(part *store.MIMEPart, body io.Reader, depth int, attached bool) error {
+	if isMessage(part.ContentType) && depth < maxDepth && p.parts < maxParts {
+		inner, err := message.Read(body)
+		if !usable(err) {
+			slog.WarnContext(p.ctx, "Failed to read attached message", "path", part.Path, "error", err)
+		} else {
+			described, err := p.walk(inner, childPath(part.Path, 1), depth+1, true)
+			if err != nil {
+				return err
+			}
+			part.Parts = []store.MIMEPart{described}
+		}
+		// Count what the attached message left unread
+		_, err = io.Copy(io.Discard, body)
+		return err
+	}
+
+	if !attached && p.wants(*part) {
+		data, err := io.ReadAll(body)
+		if len(data) > 0 || err == nil {
+			p.collect(*part, data)
+		}
+		if err == nil {
+			sum := sha256.Sum256(data)
+			part.SHA256 = hex.EncodeToString(sum[:])
+		}
+		return err
+	}
+
+	if p.opts.Blobs != nil {
+		ref, err := p.opts.Blobs.Put(p.ctx, body)
+		part.SHA256 = ref.SHA256
+		return err
+	}
+	h := sha256.New()
+	_, err := io.Copy(h, body)
+	if err == nil {
+		part.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	return err
+}
+
+// wants reports whether collect keeps the content of part.
+func (p *parser) wants(part store.MIMEPart) bool {
+	switch {
+	case strings.HasPrefix(part.ContentType, "image/"):
+		// Images that HTML can reference, whatever their disposition
+		return part.ContentID != ""
+	case part.Disposition != "" && part.Disposition != "inline":
+		return false
+	case part.ContentType == "text/html":
+		return p.htmlPart == nil
+	case part.ContentType == "text/plain":
+		return p.textPart == nil
+	}
+	return false
+}
+
+// collect keeps a part that wants reports it needs.
+func (p *parser) collect(part store.MIMEPart, body []byte) {
+	switch {
+	case strings.HasPrefix(part.ContentType, "image/"):
+		p.inline = append(p.inline, store.InlinePart{
+			ContentID:   part.ContentID,
+			ContentType: part.ContentType,
+			Filename:    part.Filename,
+			Size:        len(body),
+			Data:        body,
+		})
+	case part.ContentType == "text/html":
+		// Known charsets are decoded by the reader already; this repairs
+		// unknown ones and text invalid in its charset
+		p.html, p.htmlPart = charset.ToUTF8(body), &part
+	case part.ContentType == "text/plain":
+		p.text, p.textPart = charset.ToUTF8(body), &part
+	}
+}
+
+// partReader counts what is read from a part and fails once it is over
+// limit, when there is one.
+type partReader struct {
+	r        io.Reader
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (r *partReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.read += int64(n)
+	if r.limit > 0 && r.read > r.limit {
+		r.exceeded = true
+		return n, ErrPartTooLarge
+	}
+	return n, err
+}
+
+func isMessage(contentType string) bool {
+	return contentType == "message/rfc822" || contentType == "message/global"
+}
+
+func childPath(parent string, i int) string {
+	if parent == "" {
+		return strconv.Itoa(i)
+	}
+	return parent + "." + strconv.Itoa(i)
+}
+
+// filename returns the decoded file name from the Content-Disposition
+// parameters, or the older Content-Type name parameter.
+func filename(params, dispositionParams map[string]string) string {
+	name := dispositionParams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	return charset.DecodeHeader(name)
+}
+
+// contentID returns a Content-ID without its angle brackets.
+func contentID(id string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(id), "<"), ">")
+}
@@ -0,0 +1,298 @@
+package mailparse
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fn-jakubkarp/coresend/internal/blob"
+	"github.com/fn-jakubkarp/coresend/internal/store"
+)
+
+const forwarded = "From: sender@example.com\r\n" +
+	"Subject: Fwd: hello\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/related; boundary=related\r\n" +
+	"\r\n" +
+	"--related\r\n" +
+	"Content-Type: multipart/alternative; boundary=alt\r\n" +
+	"\r\n" +
+	"--alt\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"outer text\r\n" +
+	"--alt\r\n" +
+	"Content-Type: text/html; charset=UTF-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"<p>outer html</p>\r\n" +
+	"--alt--\r\n" +
+	"--related\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Id: <logo@example.com>\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw==\r\n" +
+	"--related--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"Content-Disposition: attachment; filename=\"=?UTF-8?Q?original_mail.eml?=\"\r\n" +
+	"\r\n" +
+	"From: other@example.com\r\n" +
+	"Subject: hello\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"inner text\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>inner html</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=report.pdf\r\n" +
+	"Content-Disposition: attachment\r\n" +
+	"\r\n" +
+	"%PDF\r\n" +
+	"--outer--\r\n"
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestParse_Structure(t *testing.T) {
+	t.Parallel()
+
+	msg, err := Parse(context.Background(), strings.NewReader(forwarded), Options{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := &store.MIMEPart{
+		ContentType: "multipart/mixed",
+		Size:        31 + 209 + 4,
+		Parts: []store.MIMEPart{
+			{
+				Path:        "1",
+				ContentType: "multipart/related",
+				Size:        27 + 4,
+				Parts: []store.MIMEPart{
+					{
+						Path:        "1.1",
+						ContentType: "multipart/alternative",
+						Size:        10 + 17,
+						Parts: []store.MIMEPart{
+							{Path: "1.1.1", ContentType: "text/plain", Charset: "utf-8", SHA256: sum("outer text"), Size: 10},
+							{Path: "1.1.2", ContentType: "text/html", Charset: "utf-8", Encoding: "quoted-printable", SHA256: sum("<p>outer html</p>"), Size: 17},
+						},
+					},
+					{Path: "1.2", ContentType: "image/png", Encoding: "base64", ContentID: "logo@example.com", SHA256: sum("\x89PNG"), Size: 4},
+				},
+			},
+			{
+				Path:        "2",
+				ContentType: "message/rfc822",
+				Disposition: "attachment",
+				Filename:    "original mail.eml",
+				Size:        209,
+				Parts: []store.MIMEPart{
+					{
+						Path:        "2.1",
+						ContentType: "multipart/alternative",
+						Size:        10 + 17,
+						Parts: []store.MIMEPart{
+							{Path: "2.1.1", ContentType: "text/plain", SHA256: sum("inner text"), Size: 10},
+							{Path: "2.1.2", ContentType: "text/html", SHA256: sum("<p>inner html</p>"), Size: 17},
+						},
+					},
+				},
+			},
+			{Path: "3", ContentType: "application/pdf", Disposition: "attachment", Filename: "report.pdf", SHA256: sum("%PDF"), Size: 4},
+		},
+	}
+	if !reflect.DeepEqual(msg.Structure, want) {
+		t.Fatalf("Structure = %+v\nwant %+v", msg.Structure, want)
+	}
+
+	// The attached message does not provide the body or extraction text
+	if msg.Body != "<p>outer html</p>" || msg.ContentType != "text/html" || msg.Charset != "utf-8" {
+		t.Fatalf("Body = %q (%s, %s), want the outer HTML part", msg.Body, msg.ContentType, msg.Charset)
+	}
+	if msg.Text != "outer text" || msg.HTML != "<p>outer html</p>" {
+		t.Fatalf("Text, HTML = %q, %q, want the outer parts", msg.Text, msg.HTML)
+	}
+	if len(msg.Inline) != 1 || msg.Inline[0].ContentID != "logo@example.com" {
+		t.Fatalf("Inline = %+v, want the logo", msg.Inline)
+	}
+	if got := msg.Header.Get("Subject"); got != "Fwd: hello" {
+		t.Fatalf("Subject = %q", got)
+	}
+}
+
+func TestParse_Body(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		raw             string
+		wantBody        string
+		wantContentType string
+		// wantPart is the path of the part Body is read from
+		wantPart string
+	}{
+		{
+			name:            "single part",
+			raw:             "Subject: hi\r\n\r\nplain body",
+			wantBody:        "plain body",
+			wantContentType: "text/plain",
+			wantPart:        "",
+		},
+		{
+			name: "first HTML part wins",
+			raw: "Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+				"--b\r\nContent-Type: text/plain\r\n\r\nintro\r\n" +
+				"--b\r\nContent-Type: text/html\r\n\r\n<p>first</p>\r\n" +
+				"--b\r\nContent-Type: text/html\r\n\r\n<p>second</p>\r\n" +
+				"--b--\r\n",
+			wantBody:        "<p>first</p>",
+			wantContentType: "text/html",
+			wantPart:        "2",
+		},
+		{
+			name: "text attachments are not the body",
+			raw: "Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+				"--b\r\nContent-Type: text/html\r\nContent-Disposition: attachment; filename=page.html\r\n\r\n<p>file</p>\r\n" +
+				"--b\r\nContent-Type: text/plain\r\n\r\nmessage\r\n" +
+				"--b--\r\n",
+			wantBody:        "message",
+			wantContentType: "text/plain",
+			wantPart:        "2",
+		},
+		{
+			name: "forwarded message only",
+			raw: "Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+				"--b\r\nContent-Type: message/rfc822\r\n\r\nSubject: inner\r\n\r\ninner body\r\n" +
+				"--b--\r\n",
+			wantBody: "",
+			wantPart: "none",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, err := Parse(context.Background(), strings.NewReader(tc.raw), Options{})
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if msg.Body != tc.wantBody || msg.ContentType != tc.wantContentType {
+				t.Fatalf("Body = %q (%s), want %q (%s)", msg.Body, msg.ContentType, tc.wantBody, tc.wantContentType)
+			}
+			part, got := msg.HTMLPart, "none"
+			if part == nil {
+				part = msg.TextPart
+			}
+			if part != nil {
+				got = part.Path
+			}
+			if got != tc.wantPart {
+				t.Fatalf("body part = %q, want %q", got, tc.wantPart)
+			}
+		})
+	}
+}
+
+func TestParse_Limits(t *testing.T) {
+	t.Parallel()
+
+	// Nested deeper than maxDepth
+	const levels = maxDepth + 4
+	var raw strings.Builder
+	for i := 0; i < levels; i++ {
+		fmt.Fprintf(&raw, "Content-Type: multipart/mixed; boundary=b%d\r\n\r\n--b%d\r\n", i, i)
+	}
+	raw.WriteString("\r\ndeep")
+	for i := levels - 1; i >= 0; i-- {
+		fmt.Fprintf(&raw, "\r\n--b%d--", i)
+	}
+
+	msg, err := Parse(context.Background(), strings.NewReader(raw.String()), Options{})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	depth := 0
+	for part := msg.Structure; len(part.Parts) > 0; part = &part.Parts[0] {
+		depth++
+	}
+	if depth > maxDepth {
+		t.Fatalf("described depth = %d, want at most %d", depth, maxDepth)
+	}
+}
+
+func TestParse_Blobs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	blobs, err := blob.NewFS(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFS() error = %v", err)
+	}
+	msg, err := Parse(ctx, strings.NewReader(forwarded), Options{Blobs: blobs})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	// The attachment is stored; the body and inline image are kept in the
+	// message instead
+	pdf := msg.Structure.Parts[2]
+	r, err := blobs.Open(ctx, pdf.SHA256)
+	if err != nil {
+		t.Fatalf("Open(%s) error = %v", pdf.Path, err)
+	}
+	got, _ := io.ReadAll(r)
+	r.Close()
+	if string(got) != "%PDF" {
+		t.Fatalf("stored %s = %q, want %%PDF", pdf.Path, got)
+	}
+	if _, err := blobs.Open(ctx, sum("outer text")); !errors.Is(err, blob.ErrNotFound) {
+		t.Fatalf("Open(body) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestParse_MaxPartSize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		limit   int64
+		wantErr error
+	}{
+		{name: "no limit", limit: 0},
+		{name: "largest part fits", limit: 209},
+		{name: "attached message over", limit: 208, wantErr: ErrPartTooLarge},
+		{name: "every part over", limit: 3, wantErr: ErrPartTooLarge},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Parse(context.Background(), strings.NewReader(forwarded), Options{MaxPartSize: tc.limit})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Parse() error = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -61,6 +61,35 @@ var (
 			Help: "Number of active SMTP sessions",
 		},
 	)
+
+	// SMTPMessagesAbortedTotal counts messages cut off mid-DATA because the
+	// drain timeout ran out at shutdown
+	SMTPMessagesAbortedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "smtp_messages_aborted_total",
+			Help: "Total number of messages aborted by shutdown before they were saved",
+		},
+	)
+)
+
+var (
+	// TLSCertificateExpiry exposes the NotAfter time of each loaded certificate
+	TLSCertificateExpiry = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "coresend_tls_certificate_expiry_timestamp_seconds",
+			Help: "Expiry time of loaded TLS certificates as a Unix timestamp",
+		},
+		[]string{"cert"},
+	)
+
+	// TLSCertificateReloadsTotal counts certificate reload attempts by result
+	TLSCertificateReloadsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "coresend_tls_certificate_reloads_total",
+			Help: "Total number of TLS certificate reloads",
+		},
+		[]string{"result"},
+	)
 )
 
 var (
@@ -121,3 +150,134 @@ var (
 		[]string{"operation"},
 	)
 )
+
+var (
+	// StoreRecordBytesTotal counts the bytes of saved email records before
+	// compression and as stored
+	StoreRecordBytesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "coresend_store_record_bytes_total",
+			Help: "Total bytes of saved email records: uncompressed or stored",
+		},
+		[]string{"kind"},
+	)
+
+	// StoreBytesSavedTotal counts the bytes compression saved on email
+	// records
+	StoreBytesSavedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "coresend_store_bytes_saved_total",
+			Help: "Total bytes saved by compressing email records",
+		},
+	)
+
+	// BlobsDeletedTotal counts stored content deleted once no email
+	// referenced it
+	BlobsDeletedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "coresend_blobs_deleted_total",
+			Help: "Total blobs deleted after no email referenced them",
+		},
+	)
+
+	// SpoolMessages tracks messages waiting on disk for the store to recover
+	SpoolMessages = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "coresend_spool_messages",
+			Help: "Number of messages waiting in the disk spool",
+		},
+	)
+
+	// SpoolBytes tracks the disk space used by the spool
+	SpoolBytes = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "coresend_spool_bytes",
+			Help: "Bytes used by messages in the disk spool",
+		},
+	)
+
+	// SpoolOperationsTotal counts spool writes and replays by operation
+	SpoolOperationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "coresend_spool_operations_total",
+			Help: "Total number of spool operations: spooled, replayed, dropped, full or corrupt",
+		},
+		[]string{"operation"},
+	)
+)
+
+var (
+	// WebhookDeliveriesTotal counts webhook delivery attempts by result
+	WebhookDeliveriesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "coresend_webhook_deliveries_total",
+			Help: "Total number of webhook delivery attempts: delivered, retrying or dead",
+		},
+		[]string{"result"},
+	)
+
+	// WebhookDeliveryDuration tracks how long receivers take to answer
+	WebhookDeliveryDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "coresend_webhook_delivery_duration_seconds",
+			Help:    "Duration of webhook delivery requests",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+)
+
+var (
+	// ForwardsTotal counts relay attempts of forwarded mail by result
+	ForwardsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "coresend_forwards_total",
+			Help: "Total number of forwarding relay attempts: relayed, retrying or failed",
+		},
+		[]string{"result"},
+	)
+
+	// ForwardRelayDuration tracks how long the smarthost takes to accept a message
+	ForwardRelayDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "coresend_forward_relay_duration_seconds",
+			Help:    "Duration of relaying a forwarded message to the smarthost",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+
+	// SentEmailsTotal counts messages sent from addresses by result
+	SentEmailsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "coresend_sent_emails_total",
+			Help: "Total number of send requests: queued, rejected or quota_exceeded",
+		},
+		[]string{"result"},
+	)
+
+	// FilteredEmailsTotal counts messages a filtering rule acted on, by action
+	FilteredEmailsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "coresend_filtered_emails_total",
+			Help: "Total number of messages filtered per recipient: drop, reject, label or expire",
+		},
+		[]string{"action"},
+	)
+
+	// DuplicateEmailsTotal counts copies of messages already in an inbox
+	// that were not saved
+	DuplicateEmailsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "coresend_duplicate_emails_total",
+			Help: "Total number of duplicate messages suppressed per recipient",
+		},
+	)
+
+	// ImageProxyRequestsTotal counts proxied image requests by result
+	ImageProxyRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "coresend_image_proxy_requests_total",
+			Help: "Total number of proxied image requests: cached, fetched, rejected or failed",
+		},
+		[]string{"result"},
+	)
+)
@@ -0,0 +1,91 @@
+// Package netguard checks URLs given by users before the server fetches
+// them, so webhooks and proxied images cannot reach hosts on private
+// networks.
+package netguard
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrInvalidURL = errors.New("URL must be an absolute http or https URL")
+	ErrPrivateURL = errors.New("URL must not point to a private network")
+)
+
+const maxURLLength = 2048
+
+// cgnat is the shared address space of RFC 6598, not covered by
+// netip.Addr.IsPrivate.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// ValidateURL checks that rawURL can be fetched. Unless allowPrivate is
+// set, hosts that are loopback or private IP literals are refused; names are
+// checked again when they are dialled.
+func ValidateURL(rawURL string, allowPrivate bool) error {
+	if len(rawURL) > maxURLLength {
+		return ErrInvalidURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidURL
+	}
+	if allowPrivate {
+		return nil
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateURL
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublic(addr) {
+		return ErrPrivateURL
+	}
+	return nil
+}
+
+// NewClient returns a client for fetching URLs given by users. It does not
+// follow redirects or use proxies from the environment, and unless
+// allowPrivate is set it refuses to connect to non-public addresses after DNS
+// resolution.
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !isPublic(addr) {
+				return ErrPrivateURL
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() &&
+		!addr.IsPrivate() &&
+		!cgnat.Contains(addr)
+}
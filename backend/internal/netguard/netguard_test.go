@@ -0,0 +1,90 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		url          string
+		allowPrivate bool
+		want         error
+	}{
+		{name: "https", url: "https://ci.example.com/hooks/coresend"},
+		{name: "http with port", url: "http://ci.example.com:8080/hook"},
+		{name: "public IP", url: "https://93.184.216.34/hook"},
+		{name: "relative", url: "/hook", want: ErrInvalidURL},
+		{name: "ftp", url: "ftp://ci.example.com/hook", want: ErrInvalidURL},
+		{name: "no host", url: "https:///hook", want: ErrInvalidURL},
+		{name: "too long", url: "https://ci.example.com/" + strings.Repeat("a", maxURLLength), want: ErrInvalidURL},
+		{name: "localhost", url: "http://localhost:3000/hook", want: ErrPrivateURL},
+		{name: "loopback", url: "http://127.0.0.1/hook", want: ErrPrivateURL},
+		{name: "private", url: "http://10.0.0.5/hook", want: ErrPrivateURL},
+		{name: "link-local metadata", url: "http://169.254.169.254/latest", want: ErrPrivateURL},
+		{name: "CGNAT", url: "http://100.64.1.1/hook", want: ErrPrivateURL},
+		{name: "IPv6 loopback", url: "http://[::1]/hook", want: ErrPrivateURL},
+		{name: "IPv4-mapped private", url: "http://[::ffff:192.168.1.1]/hook", want: ErrPrivateURL},
+		{name: "private allowed", url: "http://127.0.0.1:9000/hook", allowPrivate: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := ValidateURL(tc.url, tc.allowPrivate); !errors.Is(err, tc.want) {
+				t.Fatalf("ValidateURL(%q) = %v, want %v", tc.url, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewClient_RefusesPrivateAddresses(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, srv.URL, nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+
+	if _, err := NewClient(time.Second, false).Do(req); !errors.Is(err, ErrPrivateURL) {
+		t.Fatalf("Do() error = %v, want ErrPrivateURL", err)
+	}
+
+	resp, err := NewClient(time.Second, true).Do(req.Clone(context.Background()))
+	if err != nil {
+		t.Fatalf("Do() with allowPrivate error = %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewClient_DoesNotFollowRedirects(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest", http.StatusFound)
+	}))
+	t.Cleanup(srv.Close)
+
+	resp, err := NewClient(time.Second, true).Post(srv.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("status = %d, want the redirect itself", resp.StatusCode)
+	}
+}
@@ -0,0 +1,293 @@
+// Package sanitize makes sender HTML safe to show in the web app.
+//
+// Only an allow-list of formatting elements and attributes survives. Scripts,
+// styles, frames, forms and embedded objects are removed with their content,
+// event handler attributes are dropped, and links and images keep only
+// http, https, mailto and inline image URLs. Other elements are unwrapped so
+// their text remains.
+package sanitize
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedElements are kept with their allowed attributes.
+var allowedElements = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.Address: true, atom.B: true, atom.Bdi: true,
+	atom.Bdo: true, atom.Big: true, atom.Blockquote: true, atom.Br: true, atom.Caption: true,
+	atom.Center: true, atom.Cite: true, atom.Code: true, atom.Col: true, atom.Colgroup: true,
+	atom.Dd: true, atom.Del: true, atom.Details: true, atom.Dfn: true, atom.Div: true,
+	atom.Dl: true, atom.Dt: true, atom.Em: true, atom.Font: true, atom.H1: true,
+	atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Hr: true, atom.I: true, atom.Img: true, atom.Ins: true, atom.Kbd: true,
+	atom.Li: true, atom.Mark: true, atom.Ol: true, atom.P: true, atom.Pre: true,
+	atom.Q: true, atom.S: true, atom.Samp: true, atom.Small: true, atom.Span: true,
+	atom.Strike: true, atom.Strong: true, atom.Sub: true, atom.Summary: true, atom.Sup: true,
+	atom.Table: true, atom.Tbody: true, atom.Td: true, atom.Tfoot: true, atom.Th: true,
+	atom.Thead: true, atom.Time: true, atom.Tr: true, atom.Tt: true, atom.U: true,
+	atom.Ul: true, atom.Wbr: true,
+}
+
+// droppedElements are removed together with their content.
+var droppedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Iframe: true, atom.Frame: true, atom.Frameset: true,
+	atom.Object: true, atom.Embed: true, atom.Applet: true, atom.Noscript: true, atom.Template: true,
+	atom.Head: true, atom.Title: true, atom.Meta: true, atom.Link: true, atom.Base: true,
+	atom.Form: true, atom.Input: true, atom.Button: true, atom.Select: true, atom.Textarea: true,
+	atom.Svg: true, atom.Math: true, atom.Audio: true, atom.Video: true, atom.Source: true,
+}
+
+// allowedAttributes apply to every allowed element; href and src are checked
+// separately.
+var allowedAttributes = map[string]bool{
+	"align": true, "alt": true, "bgcolor": true, "border": true, "cellpadding": true,
+	"cellspacing": true, "class": true, "color": true, "colspan": true, "dir": true,
+	"face": true, "height": true, "lang": true, "rowspan": true, "size": true,
+	"start": true, "style": true, "title": true, "valign": true, "width": true,
+}
+
+// unsafeStyle are CSS fragments that run code or load resources. Besides
+// url(), image-set() and image() take a bare string as the URL to load.
+var unsafeStyle = []string{
+	"expression(", "javascript:", "url(", "image-set(", "image(", "src(", "cross-fade(",
+	"@import", "behavior:", "-moz-binding",
+}
+
+// Policy adjusts sanitization.
+type Policy struct {
+	// ImageURL rewrites the source of remote images, such as to an image
+	// proxy. When nil, sources are kept.
+	ImageURL func(src string) string
+	// InlineURL returns the URL of the inline part with the given
+	// Content-ID, for images with cid: sources, or "" when the message has no
+	// such part. When nil, cid: sources are kept.
+	InlineURL func(contentID string) string
+}
+
+// Report lists what sanitization removed for privacy.
+type Report struct {
+	// TrackingPixels are the sources of removed tracking images.
+	TrackingPixels []string
+	// TrackingLinks are redirect links replaced by their destination.
+	TrackingLinks []string
+}
+
+// HTML returns the sanitized content of the body of document, which may be
+// a full document or a fragment.
+func HTML(document string) string {
+	out, _ := Sanitize(document, Policy{})
+	return out
+}
+
+// Sanitize is HTML with a policy. It also removes tracking pixels and
+// unwraps known click-tracking redirects, and reports what it removed.
+func Sanitize(document string, policy Policy) (string, Report) {
+	doc, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		// The parser only fails on read errors, which a string cannot have
+		return html.EscapeString(document), Report{}
+	}
+	body := findBody(doc)
+	if body == nil {
+		return "", Report{}
+	}
+
+	s := &sanitizer{policy: policy}
+	s.clean(body)
+	var b strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&b, c); err != nil {
+			return "", Report{}
+		}
+	}
+	return b.String(), s.report
+}
+
+type sanitizer struct {
+	policy Policy
+	report Report
+}
+
+func findBody(n *html.Node) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Body {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if body := findBody(c); body != nil {
+			return body
+		}
+	}
+	return nil
+}
+
+// clean sanitizes the children of n in place.
+func (s *sanitizer) clean(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.TextNode:
+		case html.ElementNode:
+			switch {
+			case droppedElements[c.DataAtom]:
+				n.RemoveChild(c)
+			case c.DataAtom == atom.Img && isTrackingPixel(c.Attr):
+				s.report.TrackingPixels = append(s.report.TrackingPixels, attr(c.Attr, "src"))
+				n.RemoveChild(c)
+			case allowedElements[c.DataAtom]:
+				c.Attr = s.cleanAttributes(c)
+				s.clean(c)
+			default:
+				// Keep the text of unknown elements; their children are
+				// cleaned when the loop reaches them
+				next = unwrap(n, c)
+			}
+		default:
+			// Comments, doctypes and anything else
+			n.RemoveChild(c)
+		}
+		c = next
+	}
+}
+
+// unwrap replaces c with its children and returns the first of them, or c's
+// next sibling when it has none.
+func unwrap(parent, c *html.Node) *html.Node {
+	next := c.NextSibling
+	first := c.FirstChild
+	for child := c.FirstChild; child != nil; {
+		following := child.NextSibling
+		c.RemoveChild(child)
+		parent.InsertBefore(child, c)
+		child = following
+	}
+	parent.RemoveChild(c)
+	if first != nil {
+		return first
+	}
+	return next
+}
+
+func (s *sanitizer) cleanAttributes(n *html.Node) []html.Attribute {
+	var attrs []html.Attribute
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		switch {
+		case a.Namespace != "":
+		case key == "href" && n.DataAtom == atom.A:
+			if !SafeURL(a.Val, false) {
+				continue
+			}
+			href := a.Val
+			if target, ok := redirectTarget(href); ok {
+				s.report.TrackingLinks = append(s.report.TrackingLinks, href)
+				href = target
+			}
+			attrs = append(attrs, html.Attribute{Key: key, Val: href})
+		case key == "src" && n.DataAtom == atom.Img:
+			if !SafeURL(a.Val, true) {
+				continue
+			}
+			src := strings.TrimSpace(a.Val)
+			switch {
+			case s.policy.ImageURL != nil && isRemote(src):
+				src = s.policy.ImageURL(src)
+			case s.policy.InlineURL != nil && hasScheme(src, "cid"):
+				if src = s.policy.InlineURL(contentID(src)); src == "" {
+					continue
+				}
+			}
+			attrs = append(attrs, html.Attribute{Key: key, Val: src})
+		case key == "style":
+			if safeStyle(a.Val) {
+				attrs = append(attrs, html.Attribute{Key: key, Val: a.Val})
+			}
+		case allowedAttributes[key]:
+			attrs = append(attrs, html.Attribute{Key: key, Val: a.Val})
+		}
+	}
+	if n.DataAtom == atom.A {
+		// Links open outside the rendered email and do not leak where the
+		// reader came from
+		attrs = append(attrs,
+			html.Attribute{Key: "target", Val: "_blank"},
+			html.Attribute{Key: "rel", Val: "noopener noreferrer nofollow"},
+		)
+	}
+	return attrs
+}
+
+// attr returns the value of the attribute key, or "".
+func attr(attrs []html.Attribute, key string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// SafeURL reports whether u may appear in a sanitized link, or in an image
+// source when image is set. Links may use http, https and mailto; images
+// http, https, cid and data URLs of common image types.
+func SafeURL(u string, image bool) bool {
+	u = strings.TrimSpace(u)
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		return parsed.Host != ""
+	case "mailto":
+		return !image
+	case "cid":
+		return image
+	case "data":
+		if !image {
+			return false
+		}
+		mediaType, _, _ := strings.Cut(strings.ToLower(parsed.Opaque), ";")
+		switch mediaType {
+		case "image/png", "image/gif", "image/jpeg", "image/webp":
+			return true
+		}
+	}
+	return false
+}
+
+func isRemote(u string) bool {
+	return hasScheme(u, "http") || hasScheme(u, "https")
+}
+
+func hasScheme(u, scheme string) bool {
+	s, _, ok := strings.Cut(strings.TrimSpace(u), ":")
+	return ok && strings.EqualFold(s, scheme)
+}
+
+// contentID returns the Content-ID a cid: URL refers to, which is URL
+// encoded.
+func contentID(u string) string {
+	_, id, _ := strings.Cut(strings.TrimSpace(u), ":")
+	if unescaped, err := url.PathUnescape(id); err == nil {
+		return unescaped
+	}
+	return id
+}
+
+func safeStyle(style string) bool {
+	s := strings.ToLower(style)
+	// Escapes and comments can hide the fragments below
+	if strings.ContainsAny(s, `\`) || strings.Contains(s, "/*") {
+		return false
+	}
+	for _, bad := range unsafeStyle {
+		if strings.Contains(s, bad) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+package sanitize
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHTML(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "formatting is kept",
+			input: `<p align="center">Hello <b>world</b><br>again</p>`,
+			want:  `<p align="center">Hello <b>world</b><br/>again</p>`,
+		},
+		{
+			name:  "full document keeps only the body",
+			input: `<!DOCTYPE html><html><head><title>T</title><style>p{color:red}</style></head><body><p>Hi</p><!-- note --></body></html>`,
+			want:  `<p>Hi</p>`,
+		},
+		{
+			name:  "scripts are removed with their content",
+			input: `<p>a</p><script>alert(1)</script><noscript>b</noscript><p>c</p>`,
+			want:  `<p>a</p><p>c</p>`,
+		},
+		{
+			name:  "event handlers are dropped",
+			input: `<img src="https://example.com/a.png" onerror="alert(1)" alt="a"><div onclick="x()" onmouseover="y()">d</div>`,
+			want:  `<img src="https://example.com/a.png" alt="a"/><div>d</div>`,
+		},
+		{
+			name:  "javascript links lose their href",
+			input: `<a href="javascript:alert(1)">x</a><a href=" JaVaScRiPt:alert(1)">y</a><a href="java&#x09;script:alert(1)">z</a>`,
+			want:  `<a target="_blank" rel="noopener noreferrer nofollow">x</a><a target="_blank" rel="noopener noreferrer nofollow">y</a><a target="_blank" rel="noopener noreferrer nofollow">z</a>`,
+		},
+		{
+			name:  "safe links open in a new window",
+			input: `<a href="https://example.com/verify" target="_self" rel="opener">Verify</a>`,
+			want:  `<a href="https://example.com/verify" target="_blank" rel="noopener noreferrer nofollow">Verify</a>`,
+		},
+		{
+			name:  "unknown elements are unwrapped",
+			input: `<custom-tag><section><p>kept</p></section></custom-tag>`,
+			want:  `<p>kept</p>`,
+		},
+		{
+			name:  "frames forms and objects are removed",
+			input: `<iframe src="https://evil.test"></iframe><form action="https://evil.test"><input name="p"><button>Go</button></form><object data="x"></object><svg><script>alert(1)</script></svg>ok`,
+			want:  `ok`,
+		},
+		{
+			name:  "unsafe styles are dropped",
+			input: `<p style="color: red">a</p><p style="background: url(https://track.test/p.gif)">b</p><p style="width: expression(alert(1))">c</p><p style="x: \75rl(y)">d</p>`,
+			want:  `<p style="color: red">a</p><p>b</p><p>c</p><p>d</p>`,
+		},
+		{
+			name:  "image sets are dropped",
+			input: `<p style="background-image:image-set(&quot;https://tracker.test/x.png&quot; 1x)">a</p><p style="background-image: -webkit-image-set('https://tracker.test/x.png' 1x)">b</p><p style="background: image('https://tracker.test/x.png')">c</p>`,
+			want:  `<p>a</p><p>b</p><p>c</p>`,
+		},
+		{
+			name:  "image sources",
+			input: `<img src="cid:logo@example.com"><img src="data:image/png;base64,iVBORw0KGgo="><img src="data:text/html;base64,PHNjcmlwdD4="><img src="/relative.png">`,
+			want:  `<img src="cid:logo@example.com"/><img src="data:image/png;base64,iVBORw0KGgo="/><img/><img/>`,
+		},
+		{
+			name:  "text is escaped",
+			input: `1 &lt; 2 &amp; <b>3 &gt; 2</b>`,
+			want:  `1 &lt; 2 &amp; <b>3 &gt; 2</b>`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := HTML(tc.input); got != tc.want {
+				t.Fatalf("HTML() =\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		url   string
+		image bool
+		want  bool
+	}{
+		{url: "https://example.com/a", want: true},
+		{url: "http://example.com/a", image: true, want: true},
+		{url: "mailto:a@example.com", want: true},
+		{url: "mailto:a@example.com", image: true},
+		{url: "cid:part1", image: true, want: true},
+		{url: "cid:part1"},
+		{url: "data:image/gif;base64,R0lGOD==", image: true, want: true},
+		{url: "data:image/svg+xml;base64,PHN2Zz4=", image: true},
+		{url: "javascript:alert(1)"},
+		{url: "vbscript:msgbox(1)"},
+		{url: "https:///no-host"},
+		{url: "//example.com/a"},
+		{url: "java\nscript:alert(1)"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.url, func(t *testing.T) {
+			t.Parallel()
+
+			if got := SafeURL(tc.url, tc.image); got != tc.want {
+				t.Fatalf("SafeURL(%q, %v) = %v, want %v", tc.url, tc.image, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	t.Parallel()
+
+	proxy := func(src string) string { return "/proxy?u=" + src }
+	inline := func(contentID string) string {
+		if contentID == "missing@example.com" {
+			return ""
+		}
+		return "/inline/" + contentID
+	}
+
+	tests := []struct {
+		name       string
+		input      string
+		want       string
+		wantPixels []string
+		wantLinks  []string
+	}{
+		{
+			name:  "remote images are rewritten",
+			input: `<img src="https://cdn.example.com/logo.png" width="120"><img src="cid:logo"><img src="data:image/gif;base64,R0lGOD==">`,
+			want:  `<img src="/proxy?u=https://cdn.example.com/logo.png" width="120"/><img src="/inline/logo"/><img src="data:image/gif;base64,R0lGOD=="/>`,
+		},
+		{
+			name:  "inline images are resolved",
+			input: `<img src="cid:logo@example.com" alt="Logo"><img src=" CID:part%201 "><img src="cid:missing@example.com" alt="gone">`,
+			want:  `<img src="/inline/logo@example.com" alt="Logo"/><img src="/inline/part 1"/><img alt="gone"/>`,
+		},
+		{
+			name:       "sized pixels are removed",
+			input:      `<p>Hi</p><img src="https://news.example.com/u/1.gif" width="1" height="1"><img src="https://news.example.com/u/2.gif" width="0px" height="0px" alt="">`,
+			want:       `<p>Hi</p>`,
+			wantPixels: []string{"https://news.example.com/u/1.gif", "https://news.example.com/u/2.gif"},
+		},
+		{
+			name:       "styled pixels are removed",
+			input:      `<img src="https://a.example.com/x" style="width: 1px; height: 1px"><img src="https://a.example.com/y" style="display:none">`,
+			wantPixels: []string{"https://a.example.com/x", "https://a.example.com/y"},
+		},
+		{
+			name:       "known trackers are removed",
+			input:      `<img src="https://u1.ct.sendgrid.net/wf/open?upn=abc"><img src="https://example.us1.list-manage.com/track/open.php?u=1"><img src="https://mail.example.com/e/track/open?id=2"><img src="https://www.google-analytics.com/collect?v=1">`,
+			wantPixels: []string{"https://u1.ct.sendgrid.net/wf/open?upn=abc", "https://example.us1.list-manage.com/track/open.php?u=1", "https://mail.example.com/e/track/open?id=2", "https://www.google-analytics.com/collect?v=1"},
+		},
+		{
+			name:  "small but visible images are kept",
+			input: `<img src="https://cdn.example.com/icon.png" width="1" height="16"><img src="data:image/png;base64,iVBORw0KGgo=" width="1" height="1">`,
+			want:  `<img src="/proxy?u=https://cdn.example.com/icon.png" width="1" height="16"/><img src="data:image/png;base64,iVBORw0KGgo=" width="1" height="1"/>`,
+		},
+		{
+			name:      "redirects are unwrapped",
+			input:     `<a href="https://www.google.com/url?q=https%3A%2F%2Fexample.com%2Fverify%3Ft%3D1&amp;sa=D">a</a><a href="https://l.facebook.com/l.php?u=https%3A%2F%2Fexample.com%2F">b</a><a href="https://eur01.safelinks.protection.outlook.com/?url=https%3A%2F%2Fexample.com%2Fx&amp;data=1">c</a>`,
+			want:      `<a href="https://example.com/verify?t=1" target="_blank" rel="noopener noreferrer nofollow">a</a><a href="https://example.com/" target="_blank" rel="noopener noreferrer nofollow">b</a><a href="https://example.com/x" target="_blank" rel="noopener noreferrer nofollow">c</a>`,
+			wantLinks: []string{"https://www.google.com/url?q=https%3A%2F%2Fexample.com%2Fverify%3Ft%3D1&sa=D", "https://l.facebook.com/l.php?u=https%3A%2F%2Fexample.com%2F", "https://eur01.safelinks.protection.outlook.com/?url=https%3A%2F%2Fexample.com%2Fx&data=1"},
+		},
+		{
+			name:  "redirects to unsafe targets and other redirect parameters are kept",
+			input: `<a href="https://www.google.com/url?q=javascript:alert(1)">a</a><a href="https://app.example.com/login?redirect=https://app.example.com/home">b</a>`,
+			want:  `<a href="https://www.google.com/url?q=javascript:alert(1)" target="_blank" rel="noopener noreferrer nofollow">a</a><a href="https://app.example.com/login?redirect=https://app.example.com/home" target="_blank" rel="noopener noreferrer nofollow">b</a>`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, report := Sanitize(tc.input, Policy{ImageURL: proxy, InlineURL: inline})
+			if got != tc.want {
+				t.Fatalf("Sanitize() =\n%s\nwant\n%s", got, tc.want)
+			}
+			if strings.Join(report.TrackingPixels, " ") != strings.Join(tc.wantPixels, " ") {
+				t.Fatalf("tracking pixels = %v, want %v", report.TrackingPixels, tc.wantPixels)
+			}
+			if strings.Join(report.TrackingLinks, " ") != strings.Join(tc.wantLinks, " ") {
+				t.Fatalf("tracking links = %v, want %v", report.TrackingLinks, tc.wantLinks)
+			}
+		})
+	}
+}
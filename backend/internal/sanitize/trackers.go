@@ -0,0 +1,100 @@
+package sanitize
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// trackerSources match the sources of open-tracking images by known mail
+// services and common beacon paths.
+var trackerSources = regexp.MustCompile(`(?i)` +
+	`^https?://[^/]*(` +
+	`google-analytics\.com|` +
+	`list-manage\.com/track/open|` +
+	`mandrillapp\.com/track/open|` +
+	`sendgrid\.net/wf/open|` +
+	`t\.hubspotemail\.net|` +
+	`mailtrack\.io|emltrk\.com|pstmrk\.it|` +
+	`exacttarget\.com/open|` +
+	`(/[^?]*)?/(track|trk|tracking)/open|` +
+	`(/[^?]*)?/(open|pixel|beacon|spacer)\.(gif|png|php|aspx)` +
+	`)`)
+
+// isTrackingPixel reports whether an image with attrs is an open tracker:
+// a known tracking source, or an image sized or styled to be invisible.
+func isTrackingPixel(attrs []html.Attribute) bool {
+	src := strings.TrimSpace(attr(attrs, "src"))
+	if !isRemote(src) {
+		// Inline images cannot report anything
+		return false
+	}
+	if trackerSources.MatchString(src) {
+		return true
+	}
+	if tiny(attr(attrs, "width")) && tiny(attr(attrs, "height")) {
+		return true
+	}
+
+	style := strings.ToLower(strings.Join(strings.Fields(attr(attrs, "style")), ""))
+	if strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden") {
+		return true
+	}
+	return styleTiny(style, "width") && styleTiny(style, "height")
+}
+
+// tiny reports whether a width or height attribute is at most one pixel.
+func tiny(v string) bool {
+	n, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(v), "px"), 64)
+	return err == nil && n <= 1
+}
+
+// styleTiny reports whether the CSS property in style, without spaces, is
+// at most one pixel.
+func styleTiny(style, property string) bool {
+	for _, decl := range strings.Split(style, ";") {
+		name, value, ok := strings.Cut(decl, ":")
+		if ok && name == property && tiny(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectors are click-tracking redirect services that carry the
+// destination in a query parameter.
+var redirectors = []struct {
+	host  *regexp.Regexp
+	path  string
+	param string
+}{
+	{host: regexp.MustCompile(`^(www\.)?google\.[a-z.]+$`), path: "/url", param: "q"},
+	{host: regexp.MustCompile(`^(www\.)?google\.[a-z.]+$`), path: "/url", param: "url"},
+	{host: regexp.MustCompile(`^(l|lm)\.facebook\.com$`), path: "/l.php", param: "u"},
+	{host: regexp.MustCompile(`^(www\.)?linkedin\.com$`), path: "/redir/redirect", param: "url"},
+	{host: regexp.MustCompile(`^(www\.)?youtube\.com$`), path: "/redirect", param: "q"},
+	{host: regexp.MustCompile(`\.safelinks\.protection\.outlook\.com$`), path: "/", param: "url"},
+}
+
+// redirectTarget returns the destination of a known click-tracking
+// redirect.
+func redirectTarget(href string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, r := range redirectors {
+		if !r.host.MatchString(host) || u.Path != r.path {
+			continue
+		}
+		target := u.Query().Get(r.param)
+		if target != "" && isRemote(target) && SafeURL(target, false) {
+			return target, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,346 @@
+// Package send composes mail from a registered address and queues it for
+// the outbound relay.
+//
+// Messages are built as MIME, signed with the DKIM key of the address's
+// domain and handed to the forwarding queue, which relays them through the
+// smarthost with retries. A copy is kept in the address's sent folder. Each
+// address may only send a few messages per hour and per day, so disposable
+// addresses cannot be used to spam.
+package send
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/fn-jakubkarp/coresend/internal/dkim"
+	"github.com/fn-jakubkarp/coresend/internal/domains"
+	"github.com/fn-jakubkarp/coresend/internal/metrics"
+	"github.com/fn-jakubkarp/coresend/internal/store"
+	"github.com/fn-jakubkarp/coresend/internal/tracing"
+	"github.com/google/uuid"
+)
+
+// Defaults applied when the matching Sender field is zero.
+const (
+	DefaultMaxRecipients = 5
+	DefaultHourlyQuota   = 10
+	DefaultDailyQuota    = 50
+	DefaultMaxBodyBytes  = 256 << 10
+)
+
+// Send results counted in metrics.
+const (
+	ResultQueued        = "queued"
+	ResultRejected      = "rejected"
+	ResultQuotaExceeded = "quota_exceeded"
+)
+
+// maxSubjectLength bounds the subject in characters.
+const maxSubjectLength = 255
+
+var (
+	ErrNoRecipients      = errors.New("at least one recipient is required")
+	ErrTooManyRecipients = errors.New("too many recipients")
+	ErrInvalidRecipient  = errors.New("recipients must be plain email addresses")
+	ErrInvalidSubject    = errors.New("subject must be at most 255 characters on one line")
+	ErrBodyTooLarge      = errors.New("message body is too large")
+	ErrQuotaExceeded     = errors.New("send quota exceeded for this address")
+	ErrDomainDisabled    = errors.New("sending is not enabled for this domain")
+)
+
+// Store keeps sent copies and counts quota usage.
+type Store interface {
+	store.SentStore
+	CheckRateLimit(ctx context.Context, key string, limit int, window time.Duration) (bool, int, error)
+}
+
+type Sender struct {
+	Store Store
+	// Queue is the forwarding queue; a running forward.Forwarder relays what
+	// is queued.
+	Queue store.ForwardStore
+	// Signers hold the DKIM key of every domain that may send, by lowercase
+	// domain name.
+	Signers map[string]*dkim.Signer
+	// MaxRecipients bounds the recipients of one message.
+	MaxRecipients int
+	// HourlyQuota and DailyQuota bound the messages each address may send.
+	HourlyQuota  int
+	DailyQuota   int
+	MaxBodyBytes int
+}
+
+// Message is what an address asks to send.
+type Message struct {
+	To      []string
+	Subject string
+	// Text and HTML are the body; when both are set the message offers
+	// them as alternatives.
+	Text string
+	HTML string
+	// InReplyTo and References thread the message, without angle brackets.
+	InReplyTo  string
+	References []string
+}
+
+// Reply returns msg as a reply to original. Recipients default to the
+// original's Reply-To or sender, and the subject to the original's with a
+// "Re:" prefix.
+func Reply(original store.Email, msg Message) Message {
+	if len(msg.To) == 0 {
+		to := original.ReplyTo
+		if to == "" {
+			to = original.From
+		}
+		if to != "" {
+			msg.To = []string{to}
+		}
+	}
+	if msg.Subject == "" {
+		msg.Subject = original.Subject
+		if !strings.HasPrefix(strings.ToLower(msg.Subject), "re:") {
+			msg.Subject = "Re: " + msg.Subject
+		}
+	}
+	if original.MessageID != "" {
+		msg.InReplyTo = original.MessageID
+		msg.References = append(append([]string(nil), original.References...), original.MessageID)
+	}
+	return msg
+}
+
+// Enabled reports whether addresses on domain may send.
+func (s *Sender) Enabled(domain string) bool {
+	return s.Signers[strings.ToLower(domain)] != nil
+}
+
+// Send checks msg and the address's quota, then queues the signed message
+// from address on policy's domain to every recipient and saves a copy to the
+// sent folder.
+func (s *Sender) Send(ctx context.Context, address string, policy domains.Policy, msg Message) (email store.Email, err error) {
+	defer func() {
+		switch {
+		case err == nil:
+			metrics.SentEmailsTotal.WithLabelValues(ResultQueued).Inc()
+		case errors.Is(err, ErrQuotaExceeded):
+			metrics.SentEmailsTotal.WithLabelValues(ResultQuotaExceeded).Inc()
+		default:
+			metrics.SentEmailsTotal.WithLabelValues(ResultRejected).Inc()
+		}
+	}()
+
+	signer := s.Signers[strings.ToLower(policy.Name)]
+	if signer == nil {
+		return store.Email{}, ErrDomainDisabled
+	}
+	recipients, err := s.validate(msg)
+	if err != nil {
+		return store.Email{}, err
+	}
+	if err := s.checkQuota(ctx, address); err != nil {
+		return store.Email{}, err
+	}
+
+	now := time.Now()
+	from := address + "@" + policy.Name
+	email = store.Email{
+		ID:          uuid.New().String(),
+		From:        from,
+		To:          recipients,
+		Subject:     msg.Subject,
+		Body:        msg.Text,
+		ContentType: "text/plain",
+		ReceivedAt:  now,
+		References:  msg.References,
+	}
+	if msg.HTML != "" {
+		email.Body, email.ContentType = msg.HTML, "text/html"
+	}
+	email.MessageID = email.ID + "@" + policy.Name
+	email.TraceParent = tracing.Inject(ctx)
+
+	raw, err := build(email, msg)
+	if err != nil {
+		return store.Email{}, err
+	}
+	raw, err = signer.Sign(raw, now)
+	if err != nil {
+		return store.Email{}, err
+	}
+
+	for _, to := range recipients {
+		job := store.ForwardJob{
+			ID:          uuid.New().String(),
+			Address:     address,
+			Sender:      from,
+			Destination: to,
+			EmailID:     email.ID,
+			Raw:         raw,
+			TraceParent: email.TraceParent,
+			CreatedAt:   now.UTC(),
+		}
+		if err := s.Queue.EnqueueForwardJob(ctx, job, now); err != nil {
+			return store.Email{}, fmt.Errorf("failed to queue message: %w", err)
+		}
+	}
+
+	// The message is already queued, so a lost copy is only logged
+	if err := s.Store.SaveSent(ctx, address, email, policy.Retention); err != nil {
+		slog.WarnContext(ctx, "Failed to save sent copy", "email_id", email.ID, "error", err)
+	}
+	return email, nil
+}
+
+// validate checks msg and returns its recipients normalized.
+func (s *Sender) validate(msg Message) ([]string, error) {
+	if len(msg.To) == 0 {
+		return nil, ErrNoRecipients
+	}
+	if len(msg.To) > s.maxRecipients() {
+		return nil, ErrTooManyRecipients
+	}
+	recipients := make([]string, 0, len(msg.To))
+	for _, to := range msg.To {
+		addr, err := netmail.ParseAddress(to)
+		if err != nil || addr.Name != "" || addr.Address != strings.TrimSpace(to) {
+			return nil, ErrInvalidRecipient
+		}
+		recipients = append(recipients, addr.Address)
+	}
+	if len([]rune(msg.Subject)) > maxSubjectLength || strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, ErrInvalidSubject
+	}
+	if len(msg.Text)+len(msg.HTML) > s.maxBodyBytes() {
+		return nil, ErrBodyTooLarge
+	}
+	return recipients, nil
+}
+
+// checkQuota counts a message against the address's hourly and daily
+// quotas. Rejected attempts count too.
+func (s *Sender) checkQuota(ctx context.Context, address string) error {
+	quotas := []struct {
+		key    string
+		limit  int
+		window time.Duration
+	}{
+		{key: "send_hour:" + address, limit: s.hourlyQuota(), window: time.Hour},
+		{key: "send_day:" + address, limit: s.dailyQuota(), window: 24 * time.Hour},
+	}
+	for _, q := range quotas {
+		allowed, _, err := s.Store.CheckRateLimit(ctx, q.key, q.limit, q.window)
+		if err != nil {
+			return fmt.Errorf("failed to check send quota: %w", err)
+		}
+		if !allowed {
+			return ErrQuotaExceeded
+		}
+	}
+	return nil
+}
+
+// build writes email as a MIME message with CRLF line endings.
+func build(email store.Email, msg Message) ([]byte, error) {
+	var h mail.Header
+	h.SetDate(email.ReceivedAt)
+	h.SetAddressList("From", []*mail.Address{{Address: email.From}})
+	to := make([]*mail.Address, 0, len(email.To))
+	for _, addr := range email.To {
+		to = append(to, &mail.Address{Address: addr})
+	}
+	h.SetAddressList("To", to)
+	h.SetSubject(email.Subject)
+	h.SetMessageID(email.MessageID)
+	if msg.InReplyTo != "" {
+		h.SetMsgIDList("In-Reply-To", []string{msg.InReplyTo})
+	}
+	h.SetMsgIDList("References", msg.References)
+
+	var buf bytes.Buffer
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{contentType: "text/plain", body: msg.Text},
+		{contentType: "text/html", body: msg.HTML},
+	}
+	if msg.HTML == "" {
+		parts = parts[:1]
+	} else if msg.Text == "" {
+		parts = parts[1:]
+	}
+
+	if len(parts) == 1 {
+		h.SetContentType(parts[0].contentType, map[string]string{"charset": "utf-8"})
+		w, err := mail.CreateSingleInlineWriter(&buf, h)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(w, parts[0].body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	iw, err := mail.CreateInlineWriter(&buf, h)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		var ph mail.InlineHeader
+		ph.SetContentType(part.contentType, map[string]string{"charset": "utf-8"})
+		w, err := iw.CreatePart(ph)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(w, part.body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := iw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Sender) maxRecipients() int {
+	if s.MaxRecipients > 0 {
+		return s.MaxRecipients
+	}
+	return DefaultMaxRecipients
+}
+
+func (s *Sender) hourlyQuota() int {
+	if s.HourlyQuota > 0 {
+		return s.HourlyQuota
+	}
+	return DefaultHourlyQuota
+}
+
+func (s *Sender) dailyQuota() int {
+	if s.DailyQuota > 0 {
+		return s.DailyQuota
+	}
+	return DefaultDailyQuota
+}
+
+func (s *Sender) maxBodyBytes() int {
+	if s.MaxBodyBytes > 0 {
+		return s.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
@@ -0,0 +1,237 @@
+package send
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fn-jakubkarp/coresend/internal/dkim"
+	"github.com/fn-jakubkarp/coresend/internal/domains"
+	"github.com/fn-jakubkarp/coresend/internal/store"
+)
+
+const testAddress = "0123456789abcdef0123456789abcdef01234567"
+
+var testPolicy = domains.Policy{Name: "coresend.test", Retention: time.Hour}
+
+func newTestSender(t *testing.T) *Sender {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	s := store.NewStore(mr.Addr(), "")
+	return &Sender{
+		Store:   s,
+		Queue:   s,
+		Signers: map[string]*dkim.Signer{"coresend.test": {Domain: "coresend.test", Selector: "mail", Key: key}},
+	}
+}
+
+func TestSender_Send(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := newTestSender(t)
+
+	email, err := s.Send(ctx, testAddress, testPolicy, Message{
+		To:         []string{"a@example.com", "b@example.org"},
+		Subject:    "Re: Build failed",
+		Text:       "Looking into it",
+		InReplyTo:  "1@example.com",
+		References: []string{"0@example.com", "1@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	from := testAddress + "@coresend.test"
+	if email.From != from || email.MessageID != email.ID+"@coresend.test" {
+		t.Fatalf("Send() = %+v", email)
+	}
+
+	jobs, err := s.Queue.ClaimForwardJobs(ctx, time.Now(), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimForwardJobs() error = %v", err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("jobs = %+v, want one per recipient", jobs)
+	}
+	for _, job := range jobs {
+		if job.Sender != from || job.RuleID != "" || job.EmailID != email.ID {
+			t.Fatalf("job = %+v", job)
+		}
+		raw := string(job.Raw)
+		for _, want := range []string{
+			"DKIM-Signature: v=1; a=ed25519-sha256;",
+			"d=coresend.test; s=mail;",
+			"From: <" + from + ">\r\n",
+			"To: <a@example.com>, <b@example.org>\r\n",
+			"Message-Id: <" + email.MessageID + ">\r\n",
+			"In-Reply-To: <1@example.com>\r\n",
+			"References: <0@example.com> <1@example.com>\r\n",
+			"\r\n\r\nLooking into it",
+		} {
+			if !strings.Contains(raw, want) {
+				t.Fatalf("raw message missing %q:\n%s", want, raw)
+			}
+		}
+		if !strings.HasPrefix(raw, "DKIM-Signature:") {
+			t.Fatalf("raw message does not start with the signature:\n%s", raw)
+		}
+	}
+
+	sent, err := s.Store.GetSent(ctx, testAddress)
+	if err != nil {
+		t.Fatalf("GetSent() error = %v", err)
+	}
+	if len(sent) != 1 || sent[0].ID != email.ID || sent[0].Body != "Looking into it" || sent[0].ContentType != "text/plain" {
+		t.Fatalf("sent = %+v, want the sent copy", sent)
+	}
+}
+
+func TestSender_SendRejects(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		policy  domains.Policy
+		msg     Message
+		wantErr error
+	}{
+		{name: "domain without key", policy: domains.Policy{Name: "other.test"}, msg: Message{To: []string{"a@example.com"}}, wantErr: ErrDomainDisabled},
+		{name: "no recipients", policy: testPolicy, msg: Message{Text: "hi"}, wantErr: ErrNoRecipients},
+		{name: "too many recipients", policy: testPolicy, msg: Message{To: strings.Split("a@x.test,b@x.test,c@x.test,d@x.test,e@x.test,f@x.test", ",")}, wantErr: ErrTooManyRecipients},
+		{name: "display name", policy: testPolicy, msg: Message{To: []string{"A <a@example.com>"}}, wantErr: ErrInvalidRecipient},
+		{name: "not an address", policy: testPolicy, msg: Message{To: []string{"a"}}, wantErr: ErrInvalidRecipient},
+		{name: "header injection", policy: testPolicy, msg: Message{To: []string{"a@example.com"}, Subject: "Hi\r\nBcc: b@example.com"}, wantErr: ErrInvalidSubject},
+		{name: "long subject", policy: testPolicy, msg: Message{To: []string{"a@example.com"}, Subject: strings.Repeat("s", 256)}, wantErr: ErrInvalidSubject},
+		{name: "large body", policy: testPolicy, msg: Message{To: []string{"a@example.com"}, HTML: strings.Repeat("b", DefaultMaxBodyBytes+1)}, wantErr: ErrBodyTooLarge},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestSender(t)
+			if _, err := s.Send(context.Background(), testAddress, tc.policy, tc.msg); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Send() error = %v, want %v", err, tc.wantErr)
+			}
+			if jobs, _ := s.Queue.ClaimForwardJobs(context.Background(), time.Now(), time.Minute, 10); len(jobs) != 0 {
+				t.Fatalf("jobs = %+v, want nothing queued", jobs)
+			}
+		})
+	}
+}
+
+func TestSender_Quota(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		hourly int
+		daily  int
+	}{
+		{name: "hourly", hourly: 2, daily: 10},
+		{name: "daily", hourly: 10, daily: 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			s := newTestSender(t)
+			s.HourlyQuota, s.DailyQuota = tc.hourly, tc.daily
+			msg := Message{To: []string{"a@example.com"}, Text: "hi"}
+
+			for range 2 {
+				if _, err := s.Send(ctx, testAddress, testPolicy, msg); err != nil {
+					t.Fatalf("Send() error = %v", err)
+				}
+			}
+			if _, err := s.Send(ctx, testAddress, testPolicy, msg); !errors.Is(err, ErrQuotaExceeded) {
+				t.Fatalf("Send() over quota error = %v, want ErrQuotaExceeded", err)
+			}
+			// Quotas are per address
+			other := strings.Repeat("f", 40)
+			if _, err := s.Send(ctx, other, testPolicy, msg); err != nil {
+				t.Fatalf("Send() from another address error = %v", err)
+			}
+		})
+	}
+}
+
+func TestReply(t *testing.T) {
+	t.Parallel()
+
+	original := store.Email{
+		From:       "bounces@example.com",
+		ReplyTo:    "ci@example.com",
+		Subject:    "Build failed",
+		MessageID:  "2@example.com",
+		References: []string{"1@example.com"},
+	}
+
+	tests := []struct {
+		name           string
+		original       store.Email
+		msg            Message
+		wantTo         string
+		wantSubject    string
+		wantReferences []string
+	}{
+		{
+			name:           "defaults from the original",
+			original:       original,
+			wantTo:         "ci@example.com",
+			wantSubject:    "Re: Build failed",
+			wantReferences: []string{"1@example.com", "2@example.com"},
+		},
+		{
+			name:           "explicit recipient and subject",
+			original:       original,
+			msg:            Message{To: []string{"dev@example.com"}, Subject: "Fixed"},
+			wantTo:         "dev@example.com",
+			wantSubject:    "Fixed",
+			wantReferences: []string{"1@example.com", "2@example.com"},
+		},
+		{
+			name:        "no Re: twice and envelope sender fallback",
+			original:    store.Email{From: "ci@example.com", Subject: "RE: Build failed"},
+			wantTo:      "ci@example.com",
+			wantSubject: "RE: Build failed",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := Reply(tc.original, tc.msg)
+			if len(got.To) != 1 || got.To[0] != tc.wantTo || got.Subject != tc.wantSubject {
+				t.Fatalf("Reply() = %+v", got)
+			}
+			if got.InReplyTo != tc.original.MessageID || strings.Join(got.References, " ") != strings.Join(tc.wantReferences, " ") {
+				t.Fatalf("Reply() threading = %q, %v", got.InReplyTo, got.References)
+			}
+		})
+	}
+
+	// The original's references are not modified
+	Reply(original, Message{})
+	if len(original.References) != 1 {
+		t.Fatalf("original references = %v", original.References)
+	}
+}
@@ -2,48 +2,231 @@ package smtp
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/emersion/go-message/mail"
 	gosmtp "github.com/emersion/go-smtp"
+	"github.com/fn-jakubkarp/coresend/internal/blob"
+	"github.com/fn-jakubkarp/coresend/internal/charset"
+	"github.com/fn-jakubkarp/coresend/internal/domains"
+	"github.com/fn-jakubkarp/coresend/internal/extract"
+	"github.com/fn-jakubkarp/coresend/internal/filter"
+	"github.com/fn-jakubkarp/coresend/internal/forward"
+	"github.com/fn-jakubkarp/coresend/internal/logging"
+	"github.com/fn-jakubkarp/coresend/internal/mailparse"
 	"github.com/fn-jakubkarp/coresend/internal/metrics"
+	"github.com/fn-jakubkarp/coresend/internal/spool"
 	"github.com/fn-jakubkarp/coresend/internal/store"
+	"github.com/fn-jakubkarp/coresend/internal/tracing"
 	"github.com/fn-jakubkarp/coresend/internal/validator"
+	"github.com/fn-jakubkarp/coresend/internal/webhook"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Backend struct {
-	Store store.EmailStore
+	Store   store.EmailStore
+	Domains *domains.Registry
+	// RequireTLS refuses MAIL on connections that are not encrypted.
+	RequireTLS bool
+	// StoreTimeout bounds each store call. Zero selects DefaultStoreTimeout.
+	StoreTimeout time.Duration
+	// Dedup skips messages already in the recipient's inbox.
+	Dedup bool
+	// KeepSource saves messages as received with their emails, in Blobs
+	// when it is set. A Forwarder then shares Blobs.
+	KeepSource bool
+	// MaxPartSize bounds the decoded size of each message part. Zero leaves
+	// only the domain's message size limit.
+	MaxPartSize int64
+	// Blobs, when set, stores message parts other than the body and inline
+	// images.
+	Blobs blob.Store
+	// Spool, when set, takes messages the store cannot accept.
+	Spool *spool.Spool
+	// Webhooks, when set, is told about every saved message.
+	Webhooks *webhook.Dispatcher
+	// Forwarder, when set, relays saved messages that match forwarding rules
+	// and accepts bounces for rewritten senders.
+	Forwarder *forward.Forwarder
+	// Filters, when set, applies the recipients' filtering rules.
+	Filters *filter.Filters
 }
 
+// DefaultStoreTimeout bounds store calls when Backend.StoreTimeout is unset.
+const DefaultStoreTimeout = 5 * time.Second
+
 func (bkd *Backend) NewSession(c *gosmtp.Conn) (gosmtp.Session, error) {
 	// Track active SMTP sessions
 	metrics.SMTPSessionsActive.Inc()
-	return &Session{Store: bkd.Store}, nil
+
+	session := &Session{
+		Store:        bkd.Store,
+		Domains:      bkd.Domains,
+		RequireTLS:   bkd.RequireTLS,
+		StoreTimeout: bkd.StoreTimeout,
+		Dedup:        bkd.Dedup,
+		KeepSource:   bkd.KeepSource,
+		MaxPartSize:  bkd.MaxPartSize,
+		Blobs:        bkd.Blobs,
+		Spool:        bkd.Spool,
+		Webhooks:     bkd.Webhooks,
+		Forwarder:    bkd.Forwarder,
+		Filters:      bkd.Filters,
+		ID:           logging.NewID(),
+	}
+	ctx, span := tracing.Tracer().Start(context.Background(), "smtp.session",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("coresend.session_id", session.ID)),
+	)
+	session.span = span
+	session.ctx = logging.WithSessionID(ctx, session.ID)
+	// go-smtp starts a fresh session after STARTTLS, so this sees the upgraded connection
+	if c != nil {
+		if state, ok := c.TLSConnectionState(); ok {
+			session.tlsState = &state
+		}
+		session.conn = drainConnOf(c.Conn())
+		session.helo = c.Hostname()
+		if host, _, err := net.SplitHostPort(c.Conn().RemoteAddr().String()); err == nil {
+			session.clientIP = host
+		}
+	}
+
+	span.SetAttributes(attribute.Bool("coresend.tls", session.tlsState != nil))
+	attrs := []any{"tls", session.tlsState != nil}
+	if c != nil {
+		attrs = append(attrs, "remote_addr", c.Conn().RemoteAddr().String())
+	}
+	slog.DebugContext(session.ctx, "SMTP session started", attrs...)
+	return session, nil
 }
 
 type Session struct {
-	Store store.EmailStore
-	From  string
-	To    []string
+	Store      store.EmailStore
+	Domains    *domains.Registry
+	RequireTLS bool
+	// StoreTimeout bounds each store call. Zero selects DefaultStoreTimeout.
+	StoreTimeout time.Duration
+	// Dedup skips messages already in the recipient's inbox.
+	Dedup bool
+	// KeepSource saves messages as received with their emails, in Blobs
+	// when it is set. A Forwarder then shares Blobs.
+	KeepSource bool
+	// MaxPartSize bounds the decoded size of each message part. Zero leaves
+	// only the domain's message size limit.
+	MaxPartSize int64
+	// Blobs, when set, stores message parts other than the body and inline
+	// images.
+	Blobs blob.Store
+	// Spool, when set, takes messages the store cannot accept.
+	Spool *spool.Spool
+	// Webhooks, when set, is told about every saved message.
+	Webhooks *webhook.Dispatcher
+	// Forwarder, when set, relays saved messages that match forwarding rules
+	// and accepts bounces for rewritten senders.
+	Forwarder *forward.Forwarder
+	// Filters, when set, applies the recipients' filtering rules.
+	Filters *filter.Filters
+	// ID identifies the session in logs.
+	ID   string
+	From string
+	To   []string
+
+	// ctx carries the session ID and span to log records and store calls.
+	ctx  context.Context
+	span trace.Span
+
+	// tlsState is set when the connection is encrypted.
+	tlsState *tls.ConnectionState
+	// conn is set when the connection came from a Listener, so shutdown
+	// waits for a message being received.
+	conn *drainConn
+	// helo and clientIP identify the client in the envelopes of its mail.
+	helo     string
+	clientIP string
+
+	// declaredSize is the SIZE parameter from MAIL FROM, if any.
+	declaredSize int64
+	// policies maps each accepted recipient to its domain policy.
+	policies map[string]domains.Policy
+	// recipients maps each accepted recipient to the RCPT TO address it
+	// was given as.
+	recipients map[string]string
+	// unverified holds recipients accepted for the spool while the store
+	// could not say whether they are active.
+	unverified map[string]bool
+	// bounces holds the original senders of accepted bounces to SRS
+	// addresses.
+	bounces []string
+	// rules holds the filtering rules of accepted recipients that have any.
+	rules map[string][]filter.Rule
 }
 
-func (s *Session) Mail(from string, opts *gosmtp.MailOptions) error {
-	log.Printf("MAIL FROM: %s", from)
+func (s *Session) Mail(from string, opts *gosmtp.MailOptions) (err error) {
+	ctx, span := tracing.Tracer().Start(s.context(), "smtp.mail")
+	defer func() { endSpan(span, err) }()
+
+	slog.DebugContext(ctx, "MAIL FROM", logging.KeyFrom, from)
+
+	if s.RequireTLS && s.tlsState == nil {
+		slog.InfoContext(ctx, "Rejected MAIL before STARTTLS", logging.KeyFrom, from)
+		metrics.SMTPEmailsRejectedTotal.WithLabelValues("tls_required").Inc()
+		return &gosmtp.SMTPError{
+			Code:         530,
+			EnhancedCode: gosmtp.EnhancedCode{5, 7, 0},
+			Message:      "Must issue a STARTTLS command first",
+		}
+	}
+
 	s.From = from
+	if opts != nil {
+		s.declaredSize = opts.Size
+	}
 	return nil
 }
 
-func (s *Session) Rcpt(to string, opts *gosmtp.RcptOptions) error {
-	log.Printf("RCPT TO: %s", to)
+func (s *Session) Rcpt(to string, opts *gosmtp.RcptOptions) (err error) {
+	ctx, span := tracing.Tracer().Start(s.context(), "smtp.rcpt")
+	defer func() { endSpan(span, err) }()
+
+	slog.DebugContext(ctx, "RCPT TO", logging.KeyTo, to)
+
+	var (
+		policy domains.Policy
+		known  bool
+	)
+	if s.Domains != nil {
+		policy, known = s.Domains.Lookup(extractDomain(to))
+	}
+	if !known {
+		slog.InfoContext(ctx, "Rejected recipient on unknown domain", logging.KeyTo, to)
+		metrics.SMTPEmailsRejectedTotal.WithLabelValues("unknown_domain").Inc()
+		return &gosmtp.SMTPError{
+			Code:         550,
+			EnhancedCode: gosmtp.EnhancedCode{5, 7, 1},
+			Message:      "Relaying denied: domain not served here",
+		}
+	}
+
+	if s.Forwarder != nil && forward.IsSRS(to) {
+		return s.acceptBounce(ctx, to, policy)
+	}
 
 	localPart := strings.ToLower(extractLocalPart(to))
 
 	if !validator.IsValidHexAddress(localPart) {
-		log.Printf("Rejected malformed address: %s", to)
+		slog.InfoContext(ctx, "Rejected malformed address", logging.KeyTo, to)
 		metrics.SMTPEmailsRejectedTotal.WithLabelValues("invalid_address_format").Inc()
 		return &gosmtp.SMTPError{
 			Code:         550,
@@ -52,21 +235,34 @@ func (s *Session) Rcpt(to string, opts *gosmtp.RcptOptions) error {
 		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// A client may name a recipient twice; it gets the message once
+	if slices.Contains(s.To, localPart) {
+		return nil
+	}
+
+	span.SetAttributes(attribute.String("coresend.domain", policy.Name))
+
+	ctx, cancel := context.WithTimeout(ctx, s.storeTimeout())
 	defer cancel()
 
-	isValid, err := s.Store.IsAddressActive(ctx, localPart)
+	isValid, err := s.Store.IsAddressActive(ctx, localPart, policy.Name)
+	unverified := false
 	if err != nil {
-		log.Printf("Redis error checking address %s: %v", localPart, err)
-		return &gosmtp.SMTPError{
-			Code:         451,
-			EnhancedCode: gosmtp.EnhancedCode{4, 3, 0},
-			Message:      "Temporary server error, please try again later",
+		if s.Spool == nil {
+			slog.ErrorContext(ctx, "Failed to check address", logging.KeyAddress, localPart, "error", err)
+			return &gosmtp.SMTPError{
+				Code:         451,
+				EnhancedCode: gosmtp.EnhancedCode{4, 3, 0},
+				Message:      "Temporary server error, please try again later",
+			}
 		}
+		// The spool checks the address again before replaying
+		slog.WarnContext(ctx, "Failed to check address, accepting for the spool", logging.KeyAddress, localPart, "error", err)
+		isValid, unverified = true, true
 	}
 
 	if !isValid {
-		log.Printf("Rejected inactive address: %s", to)
+		slog.InfoContext(ctx, "Rejected inactive address", logging.KeyTo, to)
 		metrics.SMTPEmailsRejectedTotal.WithLabelValues("inactive_address").Inc()
 		return &gosmtp.SMTPError{
 			Code:         550,
@@ -75,10 +271,93 @@ func (s *Session) Rcpt(to string, opts *gosmtp.RcptOptions) error {
 		}
 	}
 
+	if s.declaredSize > policy.MaxMessageBytes {
+		slog.InfoContext(ctx, "Rejected recipient over declared size limit", logging.KeyTo, to, "size", s.declaredSize, "limit", policy.MaxMessageBytes)
+		metrics.SMTPEmailsRejectedTotal.WithLabelValues("message_too_large").Inc()
+		return errMessageTooLarge
+	}
+
+	if s.Filters != nil && !unverified {
+		if err := s.loadRules(ctx, localPart); err != nil {
+			return err
+		}
+	}
+
+	if s.policies == nil {
+		s.policies = make(map[string]domains.Policy)
+	}
+	s.policies[localPart] = policy
+	if s.recipients == nil {
+		s.recipients = make(map[string]string)
+	}
+	s.recipients[localPart] = to
+	if unverified {
+		if s.unverified == nil {
+			s.unverified = make(map[string]bool)
+		}
+		s.unverified[localPart] = true
+	}
 	s.To = append(s.To, localPart)
 	return nil
 }
 
+// loadRules fetches the filtering rules of recipient and refuses it when a
+// rule rejects the envelope. Mail is accepted unfiltered if the rules cannot
+// be read.
+func (s *Session) loadRules(ctx context.Context, recipient string) error {
+	stored, err := s.Filters.Store.ListFilterRules(ctx, recipient)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to load filtering rules, accepting unfiltered", logging.KeyAddress, recipient, "error", err)
+		return nil
+	}
+	rules := filter.CompileAll(stored)
+	if len(rules) == 0 {
+		return nil
+	}
+
+	if ruleID, ok := filter.RejectsEnvelope(rules, filter.Message{Sender: s.From, Size: s.declaredSize}); ok {
+		slog.InfoContext(ctx, "Rejected recipient by filtering rule", logging.KeyAddress, recipient, logging.KeyFrom, s.From, "rule_id", ruleID)
+		metrics.SMTPEmailsRejectedTotal.WithLabelValues("filtered").Inc()
+		metrics.FilteredEmailsTotal.WithLabelValues(filter.ActionReject).Inc()
+		return errFiltered
+	}
+
+	if s.rules == nil {
+		s.rules = make(map[string][]filter.Rule)
+	}
+	s.rules[recipient] = rules
+	return nil
+}
+
+// acceptBounce accepts a recipient that is a sender rewritten for forwarding,
+// if this server issued it and it has not expired.
+func (s *Session) acceptBounce(ctx context.Context, to string, policy domains.Policy) error {
+	original, err := s.Forwarder.ReverseSRS(to)
+	if err != nil {
+		slog.InfoContext(ctx, "Rejected invalid SRS address", logging.KeyTo, to)
+		metrics.SMTPEmailsRejectedTotal.WithLabelValues("invalid_srs").Inc()
+		return &gosmtp.SMTPError{
+			Code:         550,
+			EnhancedCode: gosmtp.EnhancedCode{5, 1, 1},
+			Message:      "Invalid or expired return address",
+		}
+	}
+	if s.declaredSize > policy.MaxMessageBytes {
+		metrics.SMTPEmailsRejectedTotal.WithLabelValues("message_too_large").Inc()
+		return errMessageTooLarge
+	}
+	if slices.Contains(s.bounces, original) {
+		return nil
+	}
+
+	if s.policies == nil {
+		s.policies = make(map[string]domains.Policy)
+	}
+	s.policies[to] = policy
+	s.bounces = append(s.bounces, original)
+	return nil
+}
+
 func extractLocalPart(email string) string {
 	if idx := strings.LastIndex(email, "@"); idx != -1 {
 		return email[:idx]
@@ -86,90 +365,512 @@ func extractLocalPart(email string) string {
 	return email
 }
 
-func (s *Session) Data(r io.Reader) error {
-	mr, err := mail.CreateReader(r)
+func extractDomain(email string) string {
+	if idx := strings.LastIndex(email, "@"); idx != -1 {
+		return email[idx+1:]
+	}
+	return ""
+}
+
+var errMessageTooLarge = &gosmtp.SMTPError{
+	Code:         552,
+	EnhancedCode: gosmtp.EnhancedCode{5, 3, 4},
+	Message:      "Message exceeds the size limit for this domain",
+}
+
+var errPartTooLarge = &gosmtp.SMTPError{
+	Code:         552,
+	EnhancedCode: gosmtp.EnhancedCode{5, 3, 4},
+	Message:      "Message part exceeds the size limit",
+}
+
+var errFiltered = &gosmtp.SMTPError{
+	Code:         550,
+	EnhancedCode: gosmtp.EnhancedCode{5, 7, 1},
+	Message:      "Message rejected by the recipient's filtering rules",
+}
+
+var errSpoolFull = &gosmtp.SMTPError{
+	Code:         452,
+	EnhancedCode: gosmtp.EnhancedCode{4, 3, 1},
+	Message:      "Insufficient system storage, please try again later",
+}
+
+// sizeLimit returns the smallest size limit among the accepted recipients,
+// or zero when none applies.
+func (s *Session) sizeLimit() int64 {
+	var limit int64
+	for _, p := range s.policies {
+		if limit == 0 || p.MaxMessageBytes < limit {
+			limit = p.MaxMessageBytes
+		}
+	}
+	return limit
+}
+
+// limitedReader fails once more than limit bytes have been read.
+type limitedReader struct {
+	r        io.Reader
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.limit > 0 && l.read > l.limit {
+		l.exceeded = true
+		return n, errMessageTooLarge
+	}
+	return n, err
+}
+
+// rawSource keeps a message as received in a temporary file, so its size
+// costs disk rather than memory, until a relay or the spool needs it.
+type rawSource struct {
+	file  *os.File
+	blobs blob.Store
+
+	loaded bool
+	raw    forward.Raw
+	err    error
+}
+
+func newRawSource(blobs blob.Store) (*rawSource, error) {
+	file, err := os.CreateTemp("", "coresend-data-*")
+	if err != nil {
+		return nil, err
+	}
+	return &rawSource{file: file, blobs: blobs}, nil
+}
+
+func (r *rawSource) Write(p []byte) (int, error) {
+	return r.file.Write(p)
+}
+
+func (r *rawSource) Close() error {
+	r.file.Close()
+	return os.Remove(r.file.Name())
+}
+
+// load returns the message, written to the blob store when there is one and
+// read into memory otherwise. It does so once, however often it is called.
+func (r *rawSource) load(ctx context.Context) (forward.Raw, error) {
+	if r.loaded {
+		return r.raw, r.err
+	}
+	r.loaded = true
+	if _, r.err = r.file.Seek(0, io.SeekStart); r.err != nil {
+		return r.raw, r.err
+	}
+	if r.blobs != nil {
+		var ref blob.Ref
+		ref, r.err = r.blobs.Put(ctx, r.file)
+		r.raw.SHA256 = ref.SHA256
+	} else {
+		r.raw.Data, r.err = io.ReadAll(r.file)
+	}
+	return r.raw, r.err
+}
+
+// sourceBlobs is where the message as received is written. Saved emails
+// refer to it in Blobs by digest, and forwarding jobs by the same digest.
+func (s *Session) sourceBlobs() blob.Store {
+	if s.KeepSource || s.Forwarder == nil {
+		return s.Blobs
+	}
+	return s.Forwarder.Blobs
+}
+
+func tlsInfo(state *tls.ConnectionState) *store.TLSInfo {
+	if state == nil {
+		return &store.TLSInfo{Encrypted: false}
+	}
+	return &store.TLSInfo{
+		Encrypted:   true,
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+	}
+}
+
+func (s *Session) Data(r io.Reader) (err error) {
+	ctx, span := tracing.Tracer().Start(s.context(), "smtp.data",
+		trace.WithAttributes(attribute.Int("coresend.recipients", len(s.To))),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if s.conn != nil {
+		s.conn.beginMessage()
+		defer s.conn.endMessage()
+	}
+
+	lr := &limitedReader{r: r, limit: s.sizeLimit()}
+
+	// Forwarding relays the message as received, so keep a copy
+	var (
+		src io.Reader = lr
+		raw *rawSource
+	)
+	if s.Forwarder != nil || s.KeepSource {
+		raw, err = newRawSource(s.sourceBlobs())
+		if err != nil {
+			return err
+		}
+		defer raw.Close()
+		src = io.TeeReader(lr, raw)
+	}
+
+	msg, err := mailparse.Parse(ctx, src, mailparse.Options{MaxPartSize: s.MaxPartSize, Blobs: s.Blobs})
 	if err != nil {
+		if lr.exceeded {
+			metrics.SMTPEmailsRejectedTotal.WithLabelValues("message_too_large").Inc()
+			return errMessageTooLarge
+		}
+		if errors.Is(err, mailparse.ErrPartTooLarge) {
+			metrics.SMTPEmailsRejectedTotal.WithLabelValues("part_too_large").Inc()
+			return errPartTooLarge
+		}
 		return err
 	}
 
 	email := store.Email{
+		// Set here so webhook payloads carry the ID the inbox will show
+		ID:         uuid.New().String(),
 		From:       s.From,
-		To:         s.To,
+		To:         headerAddresses(msg.Header, "To"),
+		Cc:         headerAddresses(msg.Header, "Cc"),
 		ReceivedAt: time.Now(),
+		TLS:        tlsInfo(s.tlsState),
+		// Readers of the email link back to this delivery
+		TraceParent: tracing.Inject(ctx),
+		Body:        msg.Body,
+		ContentType: msg.ContentType,
+		Charset:     msg.Charset,
+		Inline:      msg.Inline,
+		Structure:   msg.Structure,
 	}
 
-	if subject, err := mr.Header.Subject(); err == nil {
-		email.Subject = subject
+	email.Subject = charset.DecodeHeader(msg.Header.Get("Subject"))
+	// Kept so replies sent from the inbox thread with this message
+	if id, err := msg.Header.MessageID(); err == nil {
+		email.MessageID = id
+	}
+	if refs, err := msg.Header.MsgIDList("References"); err == nil {
+		email.References = refs
+	}
+	replyTo, _ := msg.Header.AddressList("Reply-To")
+	if len(replyTo) == 0 {
+		replyTo, _ = msg.Header.AddressList("From")
+	}
+	if len(replyTo) > 0 {
+		email.ReplyTo = replyTo[0].Address
+	}
+	if s.Dedup {
+		email.DedupKey = dedupKey(msg)
 	}
 
-	for {
-		p, err := mr.NextPart()
-		if err == io.EOF {
-			break
+	if raw != nil {
+		// The parser may stop before the end of the message
+		if _, err := io.Copy(io.Discard, src); err != nil && !lr.exceeded {
+			return err
 		}
+	}
+
+	if lr.exceeded {
+		metrics.SMTPEmailsRejectedTotal.WithLabelValues("message_too_large").Inc()
+		return errMessageTooLarge
+	}
+
+	email.Extracted = extract.Extract(extract.Message{Subject: email.Subject, Text: msg.Text, HTML: msg.HTML, Header: &msg.Header})
+
+	// The reply to DATA cannot differ per recipient, so the message is only
+	// refused when every recipient rejects it; the others drop it
+	verdicts := s.evaluateRules(filter.Message{Sender: s.From, Subject: email.Subject, Header: decodedHeader{&msg.Header}, Size: lr.read})
+	if rejected := countRejected(verdicts); rejected > 0 && rejected == len(s.To) && len(s.bounces) == 0 {
+		slog.InfoContext(ctx, "Rejected email by filtering rules", "recipients", rejected)
+		metrics.SMTPEmailsRejectedTotal.WithLabelValues("filtered").Inc()
+		return errFiltered
+	}
+
+	if s.KeepSource {
+		source, err := raw.load(ctx)
 		if err != nil {
-			log.Printf("Error reading email part: %v", err)
-			break
+			return fmt.Errorf("failed to keep message source: %w", err)
 		}
+		email.Source, email.SourceSHA256 = source.Data, source.SHA256
+	}
 
-		switch h := p.Header.(type) {
-		case *mail.InlineHeader:
-			contentType, _, err := h.ContentType()
-			if err != nil {
-				log.Printf("Error reading content type: %v", err)
+	// Save email to each recipient's inbox, spooling what the store refuses
+	var (
+		lastErr error
+		spooled int
+	)
+	for _, recipient := range s.To {
+		policy := s.policies[recipient]
+		verdict := verdicts[recipient]
+		if verdict.Reject || verdict.Drop {
+			slog.InfoContext(ctx, "Dropped email by filtering rule", logging.KeyAddress, recipient, "rule_id", verdict.RuleID)
+			continue
+		}
+		email := verdict.Apply(email)
+		// Each inbox sees only the recipient it was delivered to
+		email.Envelope = s.envelope(recipient)
+		if !s.unverified[recipient] {
+			saveCtx, cancel := context.WithTimeout(ctx, s.storeTimeout())
+			err := s.Store.SaveEmail(saveCtx, recipient, email, policy.Retention)
+			cancel()
+
+			if err == nil {
+				s.notify(ctx, recipient, policy.Retention, email)
+				s.forward(ctx, recipient, email, raw)
 				continue
 			}
+			if errors.Is(err, store.ErrDuplicate) {
+				slog.InfoContext(ctx, "Skipped duplicate email", logging.KeyAddress, recipient, "message_id", email.MessageID)
+				metrics.DuplicateEmailsTotal.Inc()
+				continue
+			}
+			if s.Spool == nil {
+				slog.ErrorContext(ctx, "Failed to save email", logging.KeyAddress, recipient, "error", err)
+				lastErr = err
+				continue
+			}
+			slog.WarnContext(ctx, "Failed to save email, spooling", logging.KeyAddress, recipient, "error", err)
+		}
 
-			body, err := io.ReadAll(p.Body)
+		entry := spool.Entry{
+			Address:    recipient,
+			Domain:     policy.Name,
+			Email:      email,
+			Retention:  policy.Retention,
+			Unverified: s.unverified[recipient],
+		}
+		// The spool keeps the email, not its content in the blob store
+		holder := "spool/" + recipient + "/" + email.ID
+		s.holdBlobs(ctx, s.Blobs, holder, policy.Retention, store.BlobSums(email)...)
+		if raw != nil {
+			msg, err := raw.load(ctx)
 			if err != nil {
-				log.Printf("Error reading body: %v", err)
+				slog.ErrorContext(ctx, "Failed to keep email for forwarding", logging.KeyAddress, recipient, "error", err)
+				lastErr = err
 				continue
 			}
-
-			// Prefer HTML over plain text when both are present
-			if contentType == "text/html" {
-				email.Body = string(body)
-			} else if contentType == "text/plain" && email.Body == "" {
-				email.Body = string(body)
+			entry.Raw, entry.RawSHA256 = msg.Data, msg.SHA256
+			if msg.SHA256 != "" {
+				s.holdBlobs(ctx, s.Forwarder.Blobs, holder, policy.Retention, msg.SHA256)
 			}
-
-		case *mail.AttachmentHeader:
-			filename, _ := h.Filename()
-			log.Printf("Skipping attachment: %s (not supported)", filename)
 		}
+		err := s.Spool.Add(entry)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to spool email", logging.KeyAddress, recipient, "error", err)
+			lastErr = err
+			continue
+		}
+		spooled++
 	}
 
-	// Save email to each recipient's inbox
-	var lastErr error
-	for _, recipient := range s.To {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		err := s.Store.SaveEmail(ctx, recipient, email)
+	for _, original := range s.bounces {
+		queueCtx, cancel := context.WithTimeout(ctx, s.storeTimeout())
+		err := s.Forwarder.EnqueueBounce(queueCtx, original, raw.load, email.TraceParent)
 		cancel()
-
 		if err != nil {
-			log.Printf("Error saving email for %s: %v", recipient, err)
+			slog.ErrorContext(ctx, "Failed to queue bounce", logging.KeyTo, original, "error", err)
 			lastErr = err
 		}
 	}
 
 	if lastErr != nil {
 		metrics.SMTPEmailsRejectedTotal.WithLabelValues("storage_error").Inc()
+		if errors.Is(lastErr, spool.ErrFull) {
+			return errSpoolFull
+		}
 		return fmt.Errorf("failed to save email to one or more recipients: %w", lastErr)
 	}
 
 	// Track successful email reception
 	metrics.SMTPEmailsReceivedTotal.Inc()
-	log.Printf("Email saved to %d recipient(s)", len(s.To))
+	if spooled > 0 {
+		span.SetAttributes(attribute.Int("coresend.spooled", spooled))
+		slog.InfoContext(ctx, "Email spooled until the store recovers", "recipients", len(s.To), "spooled", spooled, logging.KeySubject, email.Subject)
+		return nil
+	}
+	slog.InfoContext(ctx, "Email saved", "recipients", len(s.To), logging.KeySubject, email.Subject)
 	return nil
 }
 
+// envelope returns the envelope of the copy of a message for recipient.
+func (s *Session) envelope(recipient string) *store.Envelope {
+	rcpt := s.recipients[recipient]
+	if rcpt == "" {
+		rcpt = recipient
+	}
+	return &store.Envelope{
+		MailFrom:  s.From,
+		Recipient: rcpt,
+		ClientIP:  s.clientIP,
+		Helo:      s.helo,
+	}
+}
+
+// headerAddresses returns the addresses in a header field, with their
+// display names. A field that does not parse is kept decoded as it is.
+func headerAddresses(h mail.Header, key string) []string {
+	list, err := h.AddressList(key)
+	if err != nil {
+		if v := charset.DecodeHeader(h.Get(key)); v != "" {
+			return []string{v}
+		}
+		return nil
+	}
+	addrs := make([]string, 0, len(list))
+	for _, a := range list {
+		if a.Name == "" {
+			addrs = append(addrs, a.Address)
+			continue
+		}
+		addrs = append(addrs, a.Name+" <"+a.Address+">")
+	}
+	return addrs
+}
+
+// evaluateRules evaluates the loaded rules of each recipient against msg and
+// counts what they do.
+func (s *Session) evaluateRules(msg filter.Message) map[string]filter.Verdict {
+	if len(s.rules) == 0 {
+		return nil
+	}
+	verdicts := make(map[string]filter.Verdict, len(s.rules))
+	for recipient, rules := range s.rules {
+		v := filter.Evaluate(rules, msg)
+		verdicts[recipient] = v
+		switch {
+		case v.Reject:
+			metrics.FilteredEmailsTotal.WithLabelValues(filter.ActionReject).Inc()
+		case v.Drop:
+			metrics.FilteredEmailsTotal.WithLabelValues(filter.ActionDrop).Inc()
+		}
+		if len(v.Labels) > 0 {
+			metrics.FilteredEmailsTotal.WithLabelValues(filter.ActionLabel).Inc()
+		}
+		if v.ExpireAfter > 0 {
+			metrics.FilteredEmailsTotal.WithLabelValues(filter.ActionExpire).Inc()
+		}
+	}
+	return verdicts
+}
+
+func countRejected(verdicts map[string]filter.Verdict) int {
+	n := 0
+	for _, v := range verdicts {
+		if v.Reject {
+			n++
+		}
+	}
+	return n
+}
+
+// decodedHeader gives header values with their encoded-words decoded, so
+// filtering rules match the text readers see.
+type decodedHeader struct {
+	header *mail.Header
+}
+
+func (h decodedHeader) Values(key string) []string {
+	values := h.header.Values(key)
+	decoded := make([]string, len(values))
+	for i, v := range values {
+		decoded[i] = charset.DecodeHeader(v)
+	}
+	return decoded
+}
+
+// notify queues webhook deliveries for an email saved to recipient. The
+// email is already saved, so failures are only logged.
+func (s *Session) notify(ctx context.Context, recipient string, retention time.Duration, email store.Email) {
+	if s.Webhooks == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.storeTimeout())
+	defer cancel()
+
+	if _, err := s.Webhooks.Enqueue(ctx, recipient, retention, email); err != nil {
+		slog.WarnContext(ctx, "Failed to queue webhook deliveries", logging.KeyAddress, recipient, "error", err)
+	}
+}
+
+// forward queues relays of an email saved to recipient for its matching
+// forwarding rules. The email is already saved, so failures are only logged.
+func (s *Session) forward(ctx context.Context, recipient string, email store.Email, raw *rawSource) {
+	if s.Forwarder == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.storeTimeout())
+	defer cancel()
+
+	if _, err := s.Forwarder.Enqueue(ctx, recipient, email, raw.load); err != nil {
+		slog.WarnContext(ctx, "Failed to queue forwarded mail", logging.KeyAddress, recipient, "error", err)
+	}
+}
+
+// holdBlobs keeps content in blobs for holder, something other than a saved
+// email, for ttl. The message is accepted even if this fails.
+func (s *Session) holdBlobs(ctx context.Context, blobs blob.Store, holder string, ttl time.Duration, sums ...string) {
+	if blobs == nil {
+		return
+	}
+	if ttl <= 0 {
+		ttl = store.DefaultRetention
+	}
+	holdCtx, cancel := context.WithTimeout(ctx, s.storeTimeout())
+	defer cancel()
+	if err := blob.Hold(holdCtx, blobs, holder, time.Now().Add(ttl), sums...); err != nil {
+		slog.WarnContext(ctx, "Failed to hold stored content", "holder", holder, "error", err)
+	}
+}
+
+// context returns the session's base context. Sessions built without
+// NewSession fall back to the background context.
+func (s *Session) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func (s *Session) storeTimeout() time.Duration {
+	if s.StoreTimeout <= 0 {
+		return DefaultStoreTimeout
+	}
+	return s.StoreTimeout
+}
+
 func (s *Session) Reset() {
 	s.From = ""
 	s.To = nil
+	s.declaredSize = 0
+	s.policies = nil
+	s.recipients = nil
+	s.unverified = nil
+	s.bounces = nil
+	s.rules = nil
 }
 
 func (s *Session) Logout() error {
 	// Decrement active sessions on logout
 	metrics.SMTPSessionsActive.Dec()
+	if s.span != nil {
+		s.span.End()
+	}
 	return nil
 }
+
+// endSpan records an SMTP reply code and any error on span, then ends it.
+func endSpan(span trace.Span, err error) {
+	var smtpErr *gosmtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		span.SetAttributes(attribute.Int("smtp.response.status_code", smtpErr.Code))
+	}
+	tracing.RecordError(span, err)
+	span.End()
+}
@@ -2,18 +2,49 @@ package smtp
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	gosmtp "github.com/emersion/go-smtp"
+	"github.com/fn-jakubkarp/coresend/internal/blob"
+	"github.com/fn-jakubkarp/coresend/internal/domains"
+	"github.com/fn-jakubkarp/coresend/internal/filter"
+	"github.com/fn-jakubkarp/coresend/internal/forward"
+	"github.com/fn-jakubkarp/coresend/internal/logging"
+	"github.com/fn-jakubkarp/coresend/internal/spool"
 	"github.com/fn-jakubkarp/coresend/internal/store"
+	"github.com/fn-jakubkarp/coresend/internal/webhook"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 const smtpValidHexAddress = "0123456789abcdef0123456789abcdef01234567"
 
+// spanRecorder collects spans from every test in the package.
+var spanRecorder = tracetest.NewSpanRecorder()
+
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	os.Exit(m.Run())
+}
+
 type smtpFakeStore struct {
 	saveEmailFn          func(ctx context.Context, addressBox string, email store.Email) error
 	getEmailsFn          func(ctx context.Context, addressBox string) ([]store.Email, error)
@@ -23,24 +54,29 @@ type smtpFakeStore struct {
 	checkRateLimitFn     func(ctx context.Context, key string, limit int, window time.Duration) (bool, int, error)
 	registerAddressFn    func(ctx context.Context, addressBox string, duration time.Duration) error
 	isAddressActiveFn    func(ctx context.Context, addressBox string) (bool, error)
+	addressDomainFn      func(ctx context.Context, addressBox string) (string, error)
+	getInlinePartFn      func(ctx context.Context, addressBox, emailID, contentID string) (*store.InlinePart, error)
+	getSourceFn          func(ctx context.Context, addressBox, emailID string) ([]byte, error)
 	pingFn               func(ctx context.Context) error
 	checkAndStoreNonceFn func(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
 
-	saveCalls      []smtpSaveCall
-	isActiveCalls  []string
-	pingErr        error
-	registerErr    error
-	checkNonceResp bool
-	checkNonceSet  bool
+	saveCalls       []smtpSaveCall
+	isActiveCalls   []string
+	isActiveDomains []string
+	pingErr         error
+	registerErr     error
+	checkNonceResp  bool
+	checkNonceSet   bool
 }
 
 type smtpSaveCall struct {
 	addressBox string
 	email      store.Email
+	retention  time.Duration
 }
 
-func (f *smtpFakeStore) SaveEmail(ctx context.Context, addressBox string, email store.Email) error {
-	f.saveCalls = append(f.saveCalls, smtpSaveCall{addressBox: addressBox, email: email})
+func (f *smtpFakeStore) SaveEmail(ctx context.Context, addressBox string, email store.Email, retention time.Duration) error {
+	f.saveCalls = append(f.saveCalls, smtpSaveCall{addressBox: addressBox, email: email, retention: retention})
 	if f.saveEmailFn != nil {
 		return f.saveEmailFn(ctx, addressBox, email)
 	}
@@ -82,7 +118,7 @@ func (f *smtpFakeStore) CheckRateLimit(ctx context.Context, key string, limit in
 	panic(fmt.Sprintf("unexpected CheckRateLimit call: key=%q limit=%d window=%s", key, limit, window))
 }
 
-func (f *smtpFakeStore) RegisterAddress(ctx context.Context, addressBox string, duration time.Duration) error {
+func (f *smtpFakeStore) RegisterAddress(ctx context.Context, addressBox string, domain string, duration time.Duration) error {
 	if f.registerAddressFn != nil {
 		return f.registerAddressFn(ctx, addressBox, duration)
 	}
@@ -92,14 +128,36 @@ func (f *smtpFakeStore) RegisterAddress(ctx context.Context, addressBox string,
 	panic(fmt.Sprintf("unexpected RegisterAddress call: addressBox=%q duration=%s", addressBox, duration))
 }
 
-func (f *smtpFakeStore) IsAddressActive(ctx context.Context, addressBox string) (bool, error) {
+func (f *smtpFakeStore) IsAddressActive(ctx context.Context, addressBox string, domain string) (bool, error) {
 	f.isActiveCalls = append(f.isActiveCalls, addressBox)
+	f.isActiveDomains = append(f.isActiveDomains, domain)
 	if f.isAddressActiveFn != nil {
 		return f.isAddressActiveFn(ctx, addressBox)
 	}
 	panic(fmt.Sprintf("unexpected IsAddressActive call: addressBox=%q", addressBox))
 }
 
+func (f *smtpFakeStore) AddressDomain(ctx context.Context, addressBox string) (string, error) {
+	if f.addressDomainFn != nil {
+		return f.addressDomainFn(ctx, addressBox)
+	}
+	panic(fmt.Sprintf("unexpected AddressDomain call: addressBox=%q", addressBox))
+}
+
+func (f *smtpFakeStore) GetInlinePart(ctx context.Context, addressBox string, emailID string, contentID string) (*store.InlinePart, error) {
+	if f.getInlinePartFn != nil {
+		return f.getInlinePartFn(ctx, addressBox, emailID, contentID)
+	}
+	panic(fmt.Sprintf("unexpected GetInlinePart call: addressBox=%q emailID=%q contentID=%q", addressBox, emailID, contentID))
+}
+
+func (f *smtpFakeStore) GetSource(ctx context.Context, addressBox string, emailID string) ([]byte, error) {
+	if f.getSourceFn != nil {
+		return f.getSourceFn(ctx, addressBox, emailID)
+	}
+	panic(fmt.Sprintf("unexpected GetSource call: addressBox=%q emailID=%q", addressBox, emailID))
+}
+
 func (f *smtpFakeStore) Ping(ctx context.Context) error {
 	if f.pingFn != nil {
 		return f.pingFn(ctx)
@@ -132,6 +190,19 @@ func requireSMTPErrorCode(t *testing.T, err error, wantCode int) {
 	}
 }
 
+func newTestDomains(t *testing.T, policies ...domains.Policy) *domains.Registry {
+	t.Helper()
+
+	if len(policies) == 0 {
+		policies = []domains.Policy{{Name: "example.com"}}
+	}
+	registry, err := domains.NewRegistry(policies...)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	return registry
+}
+
 type forcedReadErrorReader struct{}
 
 func (forcedReadErrorReader) Read(_ []byte) (int, error) {
@@ -142,7 +213,8 @@ func TestBackend_NewSession(t *testing.T) {
 	t.Parallel()
 
 	s := &smtpFakeStore{}
-	backend := &Backend{Store: s}
+	registry := newTestDomains(t)
+	backend := &Backend{Store: s, Domains: registry}
 
 	gotSession, err := backend.NewSession(nil)
 	if err != nil {
@@ -156,6 +228,12 @@ func TestBackend_NewSession(t *testing.T) {
 	if session.Store != s {
 		t.Fatalf("session store was not propagated")
 	}
+	if session.Domains != registry {
+		t.Fatalf("session domains were not propagated")
+	}
+	if session.ID == "" || logging.SessionID(session.context()) != session.ID {
+		t.Fatalf("session ID %q was not attached to the session context", session.ID)
+	}
 
 	if err := session.Logout(); err != nil {
 		t.Fatalf("Logout() error = %v", err)
@@ -172,6 +250,26 @@ func TestSession_Mail(t *testing.T) {
 	if session.From != "alice@example.com" {
 		t.Fatalf("From = %q, want %q", session.From, "alice@example.com")
 	}
+
+	t.Run("require TLS refuses plaintext", func(t *testing.T) {
+		t.Parallel()
+
+		session := &Session{RequireTLS: true}
+		err := session.Mail("alice@example.com", nil)
+		requireSMTPErrorCode(t, err, 530)
+		if session.From != "" {
+			t.Fatalf("From = %q, want empty", session.From)
+		}
+	})
+
+	t.Run("require TLS accepts encrypted session", func(t *testing.T) {
+		t.Parallel()
+
+		session := &Session{RequireTLS: true, tlsState: &tls.ConnectionState{Version: tls.VersionTLS13}}
+		if err := session.Mail("alice@example.com", nil); err != nil {
+			t.Fatalf("Mail() error = %v", err)
+		}
+	})
 }
 
 func TestSession_Rcpt(t *testing.T) {
@@ -180,7 +278,7 @@ func TestSession_Rcpt(t *testing.T) {
 	t.Run("malformed address returns 550", func(t *testing.T) {
 		t.Parallel()
 
-		session := &Session{Store: &smtpFakeStore{}}
+		session := &Session{Store: &smtpFakeStore{}, Domains: newTestDomains(t)}
 		err := session.Rcpt("not-a-hex@example.com", nil)
 		if err == nil {
 			t.Fatalf("Rcpt() expected error")
@@ -196,7 +294,7 @@ func TestSession_Rcpt(t *testing.T) {
 				return false, fmt.Errorf("redis down")
 			},
 		}
-		session := &Session{Store: fakeStore}
+		session := &Session{Store: fakeStore, Domains: newTestDomains(t)}
 
 		err := session.Rcpt(strings.ToUpper(smtpValidHexAddress)+"@example.com", nil)
 		if err == nil {
@@ -210,6 +308,9 @@ func TestSession_Rcpt(t *testing.T) {
 		if fakeStore.isActiveCalls[0] != smtpValidHexAddress {
 			t.Fatalf("isAddressActive address = %q, want %q", fakeStore.isActiveCalls[0], smtpValidHexAddress)
 		}
+		if fakeStore.isActiveDomains[0] != "example.com" {
+			t.Fatalf("isAddressActive domain = %q, want %q", fakeStore.isActiveDomains[0], "example.com")
+		}
 	})
 
 	t.Run("inactive address returns 550", func(t *testing.T) {
@@ -220,7 +321,7 @@ func TestSession_Rcpt(t *testing.T) {
 				return false, nil
 			},
 		}
-		session := &Session{Store: fakeStore}
+		session := &Session{Store: fakeStore, Domains: newTestDomains(t)}
 
 		err := session.Rcpt(smtpValidHexAddress+"@example.com", nil)
 		if err == nil {
@@ -237,7 +338,7 @@ func TestSession_Rcpt(t *testing.T) {
 				return true, nil
 			},
 		}
-		session := &Session{Store: fakeStore}
+		session := &Session{Store: fakeStore, Domains: newTestDomains(t)}
 
 		err := session.Rcpt(strings.ToUpper(smtpValidHexAddress)+"@example.com", nil)
 		if err != nil {
@@ -250,6 +351,87 @@ func TestSession_Rcpt(t *testing.T) {
 			t.Fatalf("recipient = %q, want %q", session.To[0], smtpValidHexAddress)
 		}
 	})
+
+	t.Run("repeated recipient is delivered once", func(t *testing.T) {
+		t.Parallel()
+
+		fakeStore := &smtpFakeStore{
+			isAddressActiveFn: func(ctx context.Context, addressBox string) (bool, error) {
+				return true, nil
+			},
+		}
+		session := &Session{Store: fakeStore, Domains: newTestDomains(t)}
+
+		for _, to := range []string{smtpValidHexAddress + "@example.com", strings.ToUpper(smtpValidHexAddress) + "@example.com"} {
+			if err := session.Rcpt(to, nil); err != nil {
+				t.Fatalf("Rcpt(%q) error = %v", to, err)
+			}
+		}
+		if len(session.To) != 1 || len(fakeStore.isActiveCalls) != 1 {
+			t.Fatalf("recipients = %v after %d checks, want one", session.To, len(fakeStore.isActiveCalls))
+		}
+
+		if err := session.Data(strings.NewReader(plainMessage("Once", "Hello"))); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+		if len(fakeStore.saveCalls) != 1 {
+			t.Fatalf("save call count = %d, want 1", len(fakeStore.saveCalls))
+		}
+	})
+
+	t.Run("unknown domain returns 550 5.7.1 without store lookup", func(t *testing.T) {
+		t.Parallel()
+
+		fakeStore := &smtpFakeStore{}
+		session := &Session{Store: fakeStore, Domains: newTestDomains(t)}
+
+		err := session.Rcpt(smtpValidHexAddress+"@elsewhere.org", nil)
+		requireSMTPErrorCode(t, err, 550)
+
+		var smtpErr *gosmtp.SMTPError
+		errors.As(err, &smtpErr)
+		if smtpErr.EnhancedCode != (gosmtp.EnhancedCode{5, 7, 1}) {
+			t.Fatalf("enhanced code = %v, want 5.7.1", smtpErr.EnhancedCode)
+		}
+		if len(fakeStore.isActiveCalls) != 0 {
+			t.Fatalf("isAddressActive call count = %d, want 0", len(fakeStore.isActiveCalls))
+		}
+	})
+
+	t.Run("domain match is case-insensitive", func(t *testing.T) {
+		t.Parallel()
+
+		fakeStore := &smtpFakeStore{
+			isAddressActiveFn: func(ctx context.Context, addressBox string) (bool, error) {
+				return true, nil
+			},
+		}
+		session := &Session{Store: fakeStore, Domains: newTestDomains(t)}
+
+		if err := session.Rcpt(smtpValidHexAddress+"@EXAMPLE.com", nil); err != nil {
+			t.Fatalf("Rcpt() error = %v", err)
+		}
+	})
+
+	t.Run("declared size above domain limit returns 552", func(t *testing.T) {
+		t.Parallel()
+
+		fakeStore := &smtpFakeStore{
+			isAddressActiveFn: func(ctx context.Context, addressBox string) (bool, error) {
+				return true, nil
+			},
+		}
+		session := &Session{
+			Store:   fakeStore,
+			Domains: newTestDomains(t, domains.Policy{Name: "example.com", MaxMessageBytes: 100}),
+		}
+
+		if err := session.Mail("sender@example.org", &gosmtp.MailOptions{Size: 101}); err != nil {
+			t.Fatalf("Mail() error = %v", err)
+		}
+		err := session.Rcpt(smtpValidHexAddress+"@example.com", nil)
+		requireSMTPErrorCode(t, err, 552)
+	})
 }
 
 func plainMessage(subject, body string) string {
@@ -365,14 +547,53 @@ func TestSession_Data(t *testing.T) {
 		if saved.email.From != "sender@example.com" {
 			t.Fatalf("saved from = %q, want %q", saved.email.From, "sender@example.com")
 		}
-		if len(saved.email.To) != 1 || saved.email.To[0] != "recipient-a" {
-			t.Fatalf("saved recipients = %v, want [recipient-a]", saved.email.To)
+		if len(saved.email.To) != 1 || saved.email.To[0] != "recipient@example.com" {
+			t.Fatalf("saved recipients = %v, want the header's", saved.email.To)
+		}
+		if env := saved.email.Envelope; env == nil || env.Recipient != "recipient-a" || env.MailFrom != "sender@example.com" {
+			t.Fatalf("saved envelope = %+v", env)
 		}
 		if saved.email.ReceivedAt.IsZero() {
 			t.Fatalf("saved receivedAt is zero")
 		}
 	})
 
+	t.Run("threading headers are kept", func(t *testing.T) {
+		t.Parallel()
+
+		fakeStore := &smtpFakeStore{}
+		session := &Session{
+			Store: fakeStore,
+			From:  "bounces@example.com",
+			To:    []string{"recipient-a"},
+		}
+
+		message := "Message-ID: <2@example.com>\r\n" +
+			"References: <0@example.com> <1@example.com>\r\n" +
+			"Reply-To: CI <ci@example.com>\r\n" +
+			plainMessage("Threaded", "body")
+		if err := session.Data(strings.NewReader(message)); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+
+		saved := fakeStore.saveCalls[0].email
+		if saved.MessageID != "2@example.com" || len(saved.References) != 2 || saved.References[1] != "1@example.com" {
+			t.Fatalf("saved message ID = %q, references = %v", saved.MessageID, saved.References)
+		}
+		if saved.ReplyTo != "ci@example.com" {
+			t.Fatalf("saved reply-to = %q, want the Reply-To address", saved.ReplyTo)
+		}
+
+		fakeStore = &smtpFakeStore{}
+		session.Store = fakeStore
+		if err := session.Data(strings.NewReader(plainMessage("Unthreaded", "body"))); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+		if got := fakeStore.saveCalls[0].email.ReplyTo; got != "sender@example.com" {
+			t.Fatalf("saved reply-to = %q, want the header From", got)
+		}
+	})
+
 	t.Run("html preferred over plain text", func(t *testing.T) {
 		t.Parallel()
 
@@ -466,25 +687,1145 @@ func TestSession_Data(t *testing.T) {
 			t.Fatalf("save call count = %d, want 2", len(fakeStore.saveCalls))
 		}
 	})
+
+	t.Run("each recipient sees only its own envelope", func(t *testing.T) {
+		t.Parallel()
+
+		fakeStore := &smtpFakeStore{
+			isAddressActiveFn: func(ctx context.Context, addressBox string) (bool, error) {
+				return true, nil
+			},
+		}
+		session := &Session{
+			Store:    fakeStore,
+			Domains:  newTestDomains(t, domains.Policy{Name: "example.com"}),
+			helo:     "mx.sender.example",
+			clientIP: "192.0.2.10",
+		}
+		if err := session.Mail("sender@example.com", nil); err != nil {
+			t.Fatalf("Mail() error = %v", err)
+		}
+		bcc := strings.Repeat("ab", 20)
+		for _, rcpt := range []string{smtpValidHexAddress + "@Example.com", bcc + "@example.com"} {
+			if err := session.Rcpt(rcpt, nil); err != nil {
+				t.Fatalf("Rcpt(%s) error = %v", rcpt, err)
+			}
+		}
+
+		message := "From: sender@example.com\r\n" +
+			"To: =?UTF-8?Q?J=C3=B6rg?= <" + smtpValidHexAddress + "@example.com>\r\n" +
+			"Cc: team@example.org, Ops <ops@example.org>\r\n" +
+			"Subject: Hi\r\n\r\nbody"
+		if err := session.Data(strings.NewReader(message)); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+		if len(fakeStore.saveCalls) != 2 {
+			t.Fatalf("save call count = %d, want 2", len(fakeStore.saveCalls))
+		}
+
+		wantRcpt := map[string]string{smtpValidHexAddress: smtpValidHexAddress + "@Example.com", bcc: bcc + "@example.com"}
+		for _, call := range fakeStore.saveCalls {
+			email := call.email
+			want := &store.Envelope{MailFrom: "sender@example.com", Recipient: wantRcpt[call.addressBox], ClientIP: "192.0.2.10", Helo: "mx.sender.example"}
+			if !reflect.DeepEqual(email.Envelope, want) {
+				t.Fatalf("envelope for %s = %+v, want %+v", call.addressBox, email.Envelope, want)
+			}
+			if !reflect.DeepEqual(email.To, []string{"Jörg <" + smtpValidHexAddress + "@example.com>"}) {
+				t.Fatalf("To = %q, want the header recipients", email.To)
+			}
+			if !reflect.DeepEqual(email.Cc, []string{"team@example.org", "Ops <ops@example.org>"}) {
+				t.Fatalf("Cc = %q, want the header recipients", email.Cc)
+			}
+		}
+	})
+
+	t.Run("saves with recipient domain retention", func(t *testing.T) {
+		t.Parallel()
+
+		fakeStore := &smtpFakeStore{
+			isAddressActiveFn: func(ctx context.Context, addressBox string) (bool, error) {
+				return true, nil
+			},
+		}
+		session := &Session{
+			Store: fakeStore,
+			Domains: newTestDomains(t,
+				domains.Policy{Name: "example.com"},
+				domains.Policy{Name: "short.example", Retention: time.Hour},
+			),
+		}
+
+		if err := session.Rcpt(smtpValidHexAddress+"@short.example", nil); err != nil {
+			t.Fatalf("Rcpt() error = %v", err)
+		}
+		if err := session.Data(strings.NewReader(plainMessage("Subject", "Body"))); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+		if len(fakeStore.saveCalls) != 1 {
+			t.Fatalf("save call count = %d, want 1", len(fakeStore.saveCalls))
+		}
+		if got := fakeStore.saveCalls[0].retention; got != time.Hour {
+			t.Fatalf("retention = %s, want %s", got, time.Hour)
+		}
+	})
+
+	t.Run("message above domain limit returns 552", func(t *testing.T) {
+		t.Parallel()
+
+		fakeStore := &smtpFakeStore{
+			isAddressActiveFn: func(ctx context.Context, addressBox string) (bool, error) {
+				return true, nil
+			},
+		}
+		session := &Session{
+			Store:   fakeStore,
+			Domains: newTestDomains(t, domains.Policy{Name: "example.com", MaxMessageBytes: 200}),
+		}
+
+		if err := session.Rcpt(smtpValidHexAddress+"@example.com", nil); err != nil {
+			t.Fatalf("Rcpt() error = %v", err)
+		}
+		err := session.Data(strings.NewReader(plainMessage("Subject", strings.Repeat("x", 500))))
+		requireSMTPErrorCode(t, err, 552)
+		if len(fakeStore.saveCalls) != 0 {
+			t.Fatalf("save call count = %d, want 0", len(fakeStore.saveCalls))
+		}
+	})
+
+	t.Run("rejects a part over the part size limit", func(t *testing.T) {
+		t.Parallel()
+
+		msg := "Subject: Report\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain\r\n\r\nsee attached\r\n" +
+			"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment\r\n\r\n" +
+			strings.Repeat("x", 500) + "\r\n--b--\r\n"
+
+		fakeStore := &smtpFakeStore{}
+		session := &Session{Store: fakeStore, MaxPartSize: 100, To: []string{"recipient-a"}}
+		err := session.Data(strings.NewReader(msg))
+		requireSMTPErrorCode(t, err, 552)
+		if err != errPartTooLarge {
+			t.Fatalf("Data() error = %v, want errPartTooLarge", err)
+		}
+		if len(fakeStore.saveCalls) != 0 {
+			t.Fatalf("save call count = %d, want 0", len(fakeStore.saveCalls))
+		}
+	})
+
+	t.Run("stores attachments in the blob store", func(t *testing.T) {
+		t.Parallel()
+
+		msg := "Subject: Report\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain\r\n\r\nsee attached\r\n" +
+			"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment\r\n\r\n" +
+			"%PDF\r\n--b--\r\n"
+
+		blobs, err := blob.NewFS(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewFS() error = %v", err)
+		}
+		fakeStore := &smtpFakeStore{}
+		session := &Session{Store: fakeStore, Blobs: blobs, To: []string{"recipient-a"}}
+		if err := session.Data(strings.NewReader(msg)); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+		if len(fakeStore.saveCalls) != 1 {
+			t.Fatalf("save call count = %d, want 1", len(fakeStore.saveCalls))
+		}
+		email := fakeStore.saveCalls[0].email
+		if email.Body != "see attached" {
+			t.Fatalf("body = %q, want the text part", email.Body)
+		}
+		r, err := blobs.Open(context.Background(), email.Structure.Parts[1].SHA256)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		r.Close()
+	})
+
+	t.Run("keeps the message source", func(t *testing.T) {
+		t.Parallel()
+
+		msg := plainMessage("Kept", "as received")
+		fakeStore := &smtpFakeStore{}
+		session := &Session{Store: fakeStore, KeepSource: true, To: []string{"recipient-a"}}
+		if err := session.Data(strings.NewReader(msg)); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+		if email := fakeStore.saveCalls[0].email; string(email.Source) != msg || email.SourceSHA256 != "" {
+			t.Fatalf("source = %q, %q, want the message as received", email.Source, email.SourceSHA256)
+		}
+
+		blobs, err := blob.NewFS(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewFS() error = %v", err)
+		}
+		fakeStore = &smtpFakeStore{}
+		session = &Session{Store: fakeStore, Blobs: blobs, KeepSource: true, To: []string{"recipient-a"}}
+		if err := session.Data(strings.NewReader(msg)); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+		email := fakeStore.saveCalls[0].email
+		if email.Source != nil || email.SourceSHA256 == "" {
+			t.Fatalf("source = %q, %q, want it in the blob store", email.Source, email.SourceSHA256)
+		}
+		r, err := blobs.Open(context.Background(), email.SourceSHA256)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		defer r.Close()
+		if got, _ := io.ReadAll(r); string(got) != msg {
+			t.Fatalf("stored source = %q, want %q", got, msg)
+		}
+	})
+
+	t.Run("records TLS metadata", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []struct {
+			name  string
+			state *tls.ConnectionState
+			want  store.TLSInfo
+		}{
+			{
+				name: "plaintext",
+				want: store.TLSInfo{Encrypted: false},
+			},
+			{
+				name:  "TLS 1.3",
+				state: &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256},
+				want:  store.TLSInfo{Encrypted: true, Version: "TLS 1.3", CipherSuite: "TLS_AES_128_GCM_SHA256"},
+			},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				fakeStore := &smtpFakeStore{}
+				session := &Session{
+					Store:    fakeStore,
+					From:     "sender@example.com",
+					To:       []string{"recipient-a"},
+					tlsState: tc.state,
+				}
+				if err := session.Data(strings.NewReader(plainMessage("Subject", "body"))); err != nil {
+					t.Fatalf("Data() error = %v", err)
+				}
+				if len(fakeStore.saveCalls) != 1 {
+					t.Fatalf("save call count = %d, want 1", len(fakeStore.saveCalls))
+				}
+				got := fakeStore.saveCalls[0].email.TLS
+				if got == nil || *got != tc.want {
+					t.Fatalf("saved TLS = %+v, want %+v", got, tc.want)
+				}
+			})
+		}
+	})
 }
 
-func TestSession_ResetAndLogout(t *testing.T) {
+func selfSignedTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestBackend_RequireTLS(t *testing.T) {
 	t.Parallel()
 
-	session := &Session{
-		From: "sender@example.com",
-		To:   []string{"recipient-a", "recipient-b"},
+	serverTLS := selfSignedTLSConfig(t)
+	clientTLS := &tls.Config{InsecureSkipVerify: true}
+
+	// listen starts a server for fakeStore; implicit wraps the listener in TLS.
+	listen := func(t *testing.T, fakeStore *smtpFakeStore, implicit bool) string {
+		server := gosmtp.NewServer(&Backend{Store: fakeStore, Domains: newTestDomains(t), RequireTLS: true})
+		server.Domain = "localhost"
+		server.TLSConfig = serverTLS
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen() error = %v", err)
+		}
+		if implicit {
+			ln = tls.NewListener(ln, serverTLS)
+		}
+		go server.Serve(ln)
+		t.Cleanup(func() { server.Close() })
+		return ln.Addr().String()
 	}
 
-	session.Reset()
-	if session.From != "" {
-		t.Fatalf("From after reset = %q, want empty", session.From)
+	tests := []struct {
+		name     string
+		implicit bool
+		dial     func(addr string) (*gosmtp.Client, error)
+		wantCode int
+	}{
+		{
+			name:     "plaintext is refused",
+			dial:     gosmtp.Dial,
+			wantCode: 530,
+		},
+		{
+			name: "STARTTLS",
+			dial: func(addr string) (*gosmtp.Client, error) { return gosmtp.DialStartTLS(addr, clientTLS) },
+		},
+		{
+			name:     "implicit TLS",
+			implicit: true,
+			dial:     func(addr string) (*gosmtp.Client, error) { return gosmtp.DialTLS(addr, clientTLS) },
+		},
 	}
-	if len(session.To) != 0 {
-		t.Fatalf("To after reset = %v, want nil", session.To)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeStore := &smtpFakeStore{
+				isAddressActiveFn: func(context.Context, string) (bool, error) { return true, nil },
+			}
+			c, err := tc.dial(listen(t, fakeStore, tc.implicit))
+			if err != nil {
+				t.Fatalf("dial error = %v", err)
+			}
+			defer c.Close()
+
+			err = c.Mail("sender@example.com", nil)
+			if tc.wantCode != 0 {
+				requireSMTPErrorCode(t, err, tc.wantCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Mail() error = %v", err)
+			}
+			if err := c.Rcpt(smtpValidHexAddress+"@example.com", nil); err != nil {
+				t.Fatalf("Rcpt() error = %v", err)
+			}
+			w, err := c.Data()
+			if err != nil {
+				t.Fatalf("Data() error = %v", err)
+			}
+			if _, err := w.Write([]byte(plainMessage("Secure", "hello"))); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if err := c.Quit(); err != nil {
+				t.Fatalf("Quit() error = %v", err)
+			}
+
+			if len(fakeStore.saveCalls) != 1 {
+				t.Fatalf("save call count = %d, want 1", len(fakeStore.saveCalls))
+			}
+			got := fakeStore.saveCalls[0].email.TLS
+			if got == nil || !got.Encrypted || got.Version != "TLS 1.3" || got.CipherSuite == "" {
+				t.Fatalf("saved TLS = %+v, want encrypted TLS 1.3", got)
+			}
+		})
 	}
+}
 
-	if err := session.Logout(); err != nil {
-		t.Fatalf("Logout() error = %v", err)
+func TestSession_Tracing(t *testing.T) {
+	t.Parallel()
+
+	fakeStore := &smtpFakeStore{
+		isAddressActiveFn: func(context.Context, string) (bool, error) { return true, nil },
+	}
+	backend := &Backend{Store: store.WithTracing(fakeStore), Domains: newTestDomains(t)}
+
+	gotSession, err := backend.NewSession(nil)
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	session := gotSession.(*Session)
+	if err := session.Mail("sender@example.com", nil); err != nil {
+		t.Fatalf("Mail() error = %v", err)
+	}
+	if err := session.Rcpt(smtpValidHexAddress+"@example.com", nil); err != nil {
+		t.Fatalf("Rcpt() error = %v", err)
+	}
+	if err := session.Rcpt("bad@example.com", nil); err == nil {
+		t.Fatalf("Rcpt() expected error for malformed address")
+	}
+	if err := session.Data(strings.NewReader(plainMessage("Traced", "body"))); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+	if err := session.Logout(); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+
+	traceID := session.span.SpanContext().TraceID()
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range spanRecorder.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			spans[span.Name()] = append(spans[span.Name()], span)
+		}
+	}
+
+	for _, name := range []string{"smtp.session", "smtp.mail", "smtp.data", "store.is_address_active", "store.save_email"} {
+		if len(spans[name]) != 1 {
+			t.Fatalf("%s span count = %d, want 1", name, len(spans[name]))
+		}
+	}
+	if len(spans["smtp.rcpt"]) != 2 {
+		t.Fatalf("smtp.rcpt span count = %d, want 2", len(spans["smtp.rcpt"]))
+	}
+	if spans["smtp.rcpt"][1].Status().Code != codes.Error {
+		t.Fatalf("rejected rcpt span status = %v, want error", spans["smtp.rcpt"][1].Status().Code)
+	}
+	if got := spans["store.save_email"][0].Parent().SpanID(); got != spans["smtp.data"][0].SpanContext().SpanID() {
+		t.Fatalf("save_email parent = %s, want smtp.data span", got)
+	}
+
+	saved := fakeStore.saveCalls[0].email
+	if !strings.Contains(saved.TraceParent, traceID.String()) {
+		t.Fatalf("saved trace parent = %q, want trace %s", saved.TraceParent, traceID)
+	}
+}
+
+func TestSession_ResetAndLogout(t *testing.T) {
+	t.Parallel()
+
+	session := &Session{
+		From: "sender@example.com",
+		To:   []string{"recipient-a", "recipient-b"},
+	}
+
+	session.Reset()
+	if session.From != "" {
+		t.Fatalf("From after reset = %q, want empty", session.From)
+	}
+	if len(session.To) != 0 {
+		t.Fatalf("To after reset = %v, want nil", session.To)
+	}
+
+	if err := session.Logout(); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+}
+
+func TestSession_Spool(t *testing.T) {
+	t.Parallel()
+
+	t.Run("store down at RCPT spools unverified message", func(t *testing.T) {
+		t.Parallel()
+
+		storeDown := true
+		fakeStore := &smtpFakeStore{
+			isAddressActiveFn: func(ctx context.Context, addressBox string) (bool, error) {
+				if storeDown {
+					return false, fmt.Errorf("redis down")
+				}
+				return true, nil
+			},
+		}
+		sp, err := spool.Open(t.TempDir(), 0, fakeStore)
+		if err != nil {
+			t.Fatalf("spool.Open() error = %v", err)
+		}
+		session := &Session{Store: fakeStore, Domains: newTestDomains(t), Spool: sp, From: "sender@example.com"}
+
+		if err := session.Rcpt(smtpValidHexAddress+"@example.com", nil); err != nil {
+			t.Fatalf("Rcpt() error = %v, want accepted for the spool", err)
+		}
+		if err := session.Data(strings.NewReader(plainMessage("Spooled", "body"))); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+		if len(fakeStore.saveCalls) != 0 {
+			t.Fatalf("save call count = %d, want 0 for an unverified recipient", len(fakeStore.saveCalls))
+		}
+		if sp.Len() != 1 {
+			t.Fatalf("spool Len() = %d, want 1", sp.Len())
+		}
+
+		storeDown = false
+		if n, err := sp.Replay(context.Background()); err != nil || n != 1 {
+			t.Fatalf("Replay() = %d, %v, want 1, nil", n, err)
+		}
+		if len(fakeStore.saveCalls) != 1 || fakeStore.saveCalls[0].email.Subject != "Spooled" {
+			t.Fatalf("save calls = %+v, want the spooled message", fakeStore.saveCalls)
+		}
+		if got := fakeStore.isActiveCalls; len(got) != 2 {
+			t.Fatalf("isAddressActive call count = %d, want a recheck at replay", len(got))
+		}
+	})
+
+	t.Run("save error spools message", func(t *testing.T) {
+		t.Parallel()
+
+		fakeStore := &smtpFakeStore{
+			saveEmailFn: func(ctx context.Context, addressBox string, email store.Email) error {
+				if addressBox == "recipient-b" {
+					return fmt.Errorf("save failed")
+				}
+				return nil
+			},
+		}
+		sp, err := spool.Open(t.TempDir(), 0, fakeStore)
+		if err != nil {
+			t.Fatalf("spool.Open() error = %v", err)
+		}
+		session := &Session{
+			Store: fakeStore,
+			Spool: sp,
+			From:  "sender@example.com",
+			To:    []string{"recipient-a", "recipient-b"},
+		}
+
+		if err := session.Data(strings.NewReader(plainMessage("Subject", "body"))); err != nil {
+			t.Fatalf("Data() error = %v, want nil once spooled", err)
+		}
+		if len(fakeStore.saveCalls) != 2 {
+			t.Fatalf("save call count = %d, want 2", len(fakeStore.saveCalls))
+		}
+		if sp.Len() != 1 {
+			t.Fatalf("spool Len() = %d, want only the failed recipient", sp.Len())
+		}
+	})
+
+	t.Run("spooled message holds its attachments", func(t *testing.T) {
+		t.Parallel()
+
+		mr, err := miniredis.Run()
+		if err != nil {
+			t.Fatalf("failed to start miniredis: %v", err)
+		}
+		t.Cleanup(mr.Close)
+		blobs, err := blob.NewFS(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewFS() error = %v", err)
+		}
+		blobStore := store.NewStore(mr.Addr(), "")
+		blobStore.UseBlobs(blobs)
+
+		fakeStore := &smtpFakeStore{
+			saveEmailFn: func(ctx context.Context, addressBox string, email store.Email) error {
+				return fmt.Errorf("save failed")
+			},
+		}
+		sp, err := spool.Open(t.TempDir(), 0, fakeStore)
+		if err != nil {
+			t.Fatalf("spool.Open() error = %v", err)
+		}
+		session := &Session{Store: fakeStore, Blobs: blobStore.Blobs(), Spool: sp, From: "sender@example.com", To: []string{"recipient-a"}}
+
+		msg := "Subject: Report\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain\r\n\r\nsee attached\r\n" +
+			"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment\r\n\r\n" +
+			"%PDF\r\n--b--\r\n"
+		if err := session.Data(strings.NewReader(msg)); err != nil {
+			t.Fatalf("Data() error = %v, want nil once spooled", err)
+		}
+		email := fakeStore.saveCalls[0].email
+		refs, err := mr.ZMembers("blob_refs:" + email.Structure.Parts[1].SHA256)
+		if err != nil || len(refs) != 1 || refs[0] != "hold:spool/recipient-a/"+email.ID {
+			t.Fatalf("attachment references = %v, %v, want a hold for the spooled message", refs, err)
+		}
+	})
+
+	t.Run("full spool returns error", func(t *testing.T) {
+		t.Parallel()
+
+		fakeStore := &smtpFakeStore{
+			saveEmailFn: func(ctx context.Context, addressBox string, email store.Email) error {
+				return fmt.Errorf("save failed")
+			},
+		}
+		sp, err := spool.Open(t.TempDir(), 1, fakeStore)
+		if err != nil {
+			t.Fatalf("spool.Open() error = %v", err)
+		}
+		session := &Session{
+			Store: fakeStore,
+			Spool: sp,
+			From:  "sender@example.com",
+			To:    []string{"recipient-a"},
+		}
+
+		err = session.Data(strings.NewReader(plainMessage("Subject", "body")))
+		requireSMTPErrorCode(t, err, 452)
+	})
+}
+
+func TestSession_Webhooks(t *testing.T) {
+	t.Parallel()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	ctx := context.Background()
+	hooks := &webhook.Dispatcher{Store: store.NewStore(mr.Addr(), ""), AllowPrivate: true}
+	if _, err := hooks.Register(ctx, "recipient-a", "https://ci.example.com/hook", false, time.Hour); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	fakeStore := &smtpFakeStore{}
+	session := &Session{
+		Store:    fakeStore,
+		Webhooks: hooks,
+		From:     "sender@example.com",
+		To:       []string{"recipient-a", "recipient-b"},
+	}
+
+	if err := session.Data(strings.NewReader(plainMessage("Hooked", "body"))); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+
+	jobs, err := hooks.Store.ClaimWebhookJobs(ctx, time.Now(), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimWebhookJobs() error = %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Address != "recipient-a" {
+		t.Fatalf("jobs = %+v, want one for recipient-a", jobs)
+	}
+	if len(fakeStore.saveCalls) != 2 || jobs[0].EmailID != fakeStore.saveCalls[0].email.ID {
+		t.Fatalf("job email ID = %q, want the saved email's ID", jobs[0].EmailID)
+	}
+}
+
+func TestSession_Forwarding(t *testing.T) {
+	t.Parallel()
+
+	newForwarder := func(t *testing.T) *forward.Forwarder {
+		t.Helper()
+
+		mr, err := miniredis.Run()
+		if err != nil {
+			t.Fatalf("failed to start miniredis: %v", err)
+		}
+		t.Cleanup(mr.Close)
+
+		return &forward.Forwarder{
+			Store:   store.NewStore(mr.Addr(), ""),
+			Relay:   &forward.Relay{},
+			SRS:     &forward.SRS{Domain: "example.com", Secret: []byte("0123456789abcdef")},
+			Domains: newTestDomains(t),
+		}
+	}
+
+	t.Run("queues the raw message for matching rules", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		forwarder := newForwarder(t)
+		if _, err := forwarder.Register(ctx, smtpValidHexAddress, store.ForwardRule{Destination: "tester@example.org"}, time.Hour); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+
+		fakeStore := &smtpFakeStore{}
+		session := &Session{
+			Store:     fakeStore,
+			Forwarder: forwarder,
+			From:      "sender@example.net",
+			To:        []string{smtpValidHexAddress},
+		}
+		message := plainMessage("Forwarded", "body")
+		if err := session.Data(strings.NewReader(message)); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+
+		jobs, err := forwarder.Store.ClaimForwardJobs(ctx, time.Now(), time.Minute, 10)
+		if err != nil {
+			t.Fatalf("ClaimForwardJobs() error = %v", err)
+		}
+		if len(jobs) != 1 || jobs[0].Destination != "tester@example.org" || !strings.HasPrefix(jobs[0].Sender, "SRS0=") {
+			t.Fatalf("jobs = %+v, want one SRS relay to tester@example.org", jobs)
+		}
+		if !strings.HasSuffix(string(jobs[0].Raw), message) {
+			t.Fatalf("raw = %q, want the message as received", jobs[0].Raw)
+		}
+		if jobs[0].EmailID != fakeStore.saveCalls[0].email.ID {
+			t.Fatalf("job email ID = %q, want the saved email's ID", jobs[0].EmailID)
+		}
+	})
+
+	t.Run("keeps the message in the blob store", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		forwarder := newForwarder(t)
+		blobs, err := blob.NewFS(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewFS() error = %v", err)
+		}
+		forwarder.Store.(*store.Store).UseBlobs(blobs)
+		forwarder.Blobs = forwarder.Store.(*store.Store).Blobs()
+		if _, err := forwarder.Register(ctx, smtpValidHexAddress, store.ForwardRule{Destination: "tester@example.org"}, time.Hour); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+
+		session := &Session{Store: &smtpFakeStore{}, Forwarder: forwarder, From: "sender@example.net", To: []string{smtpValidHexAddress}}
+		message := plainMessage("Forwarded", "body")
+		if err := session.Data(strings.NewReader(message)); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+
+		jobs, err := forwarder.Store.ClaimForwardJobs(ctx, time.Now(), time.Minute, 10)
+		if err != nil || len(jobs) != 1 || jobs[0].Raw != nil || jobs[0].RawSHA256 == "" {
+			t.Fatalf("jobs = %+v, %v, want one naming the message by its digest", jobs, err)
+		}
+		r, err := blobs.Open(ctx, jobs[0].RawSHA256)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		defer r.Close()
+		if got, _ := io.ReadAll(r); string(got) != message {
+			t.Fatalf("stored message = %q, want %q", got, message)
+		}
+	})
+
+	t.Run("accepts bounces to rewritten senders", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		forwarder := newForwarder(t)
+		rewritten := forwarder.SRS.Forward("sender@example.net", time.Now())
+
+		fakeStore := &smtpFakeStore{}
+		session := &Session{Store: fakeStore, Domains: newTestDomains(t), Forwarder: forwarder}
+		if err := session.Rcpt(rewritten, nil); err != nil {
+			t.Fatalf("Rcpt(%q) error = %v", rewritten, err)
+		}
+		message := plainMessage("Undeliverable", "bounce")
+		if err := session.Data(strings.NewReader(message)); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+
+		jobs, err := forwarder.Store.ClaimForwardJobs(ctx, time.Now(), time.Minute, 10)
+		if err != nil {
+			t.Fatalf("ClaimForwardJobs() error = %v", err)
+		}
+		if len(jobs) != 1 || jobs[0].Destination != "sender@example.net" || jobs[0].Sender != "" || string(jobs[0].Raw) != message {
+			t.Fatalf("jobs = %+v, want the bounce relayed to the original sender", jobs)
+		}
+		if len(fakeStore.saveCalls) != 0 {
+			t.Fatalf("save calls = %d, want bounces kept out of inboxes", len(fakeStore.saveCalls))
+		}
+	})
+
+	t.Run("rejects invalid SRS addresses", func(t *testing.T) {
+		t.Parallel()
+
+		forwarder := newForwarder(t)
+		other := &forward.SRS{Domain: "example.com", Secret: []byte("another secret!!")}
+		session := &Session{Store: &smtpFakeStore{}, Domains: newTestDomains(t), Forwarder: forwarder}
+
+		err := session.Rcpt(other.Forward("sender@example.net", time.Now()), nil)
+		requireSMTPErrorCode(t, err, 550)
+	})
+}
+
+func TestSession_Filters(t *testing.T) {
+	t.Parallel()
+
+	const other = "fedcba9876543210fedcba9876543210fedcba98"
+
+	newSession := func(t *testing.T, rules map[string][]store.FilterRule) (*Session, *smtpFakeStore) {
+		t.Helper()
+
+		mr, err := miniredis.Run()
+		if err != nil {
+			t.Fatalf("failed to start miniredis: %v", err)
+		}
+		t.Cleanup(mr.Close)
+
+		s := store.NewStore(mr.Addr(), "")
+		filters := &filter.Filters{Store: s}
+		for address, list := range rules {
+			if err := s.RegisterAddress(context.Background(), address, "coresend.io", time.Hour); err != nil {
+				t.Fatalf("RegisterAddress() error = %v", err)
+			}
+			for _, rule := range list {
+				if _, err := filters.Register(context.Background(), address, rule); err != nil {
+					t.Fatalf("Register() error = %v", err)
+				}
+			}
+		}
+
+		fakeStore := &smtpFakeStore{
+			isAddressActiveFn: func(context.Context, string) (bool, error) { return true, nil },
+		}
+		return &Session{Store: fakeStore, Domains: newTestDomains(t), Filters: filters}, fakeStore
+	}
+	marketing := "From: deals@news.example.net\r\n" +
+		"Subject: 50% off today\r\n" +
+		"List-Unsubscribe: <mailto:leave@news.example.net>\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Buy now"
+
+	t.Run("rejects matching senders at RCPT", func(t *testing.T) {
+		t.Parallel()
+
+		session, _ := newSession(t, map[string][]store.FilterRule{
+			smtpValidHexAddress: {{FromGlob: "*@news.example.net", Action: filter.ActionReject}},
+		})
+		if err := session.Mail("deals@news.example.net", nil); err != nil {
+			t.Fatalf("Mail() error = %v", err)
+		}
+		requireSMTPErrorCode(t, session.Rcpt(smtpValidHexAddress+"@example.com", nil), 550)
+		if err := session.Rcpt(other+"@example.com", nil); err != nil {
+			t.Fatalf("Rcpt() without rules error = %v", err)
+		}
+	})
+
+	t.Run("rejects at DATA when every recipient rejects", func(t *testing.T) {
+		t.Parallel()
+
+		session, fakeStore := newSession(t, map[string][]store.FilterRule{
+			smtpValidHexAddress: {{SubjectRegex: `\d+% off`, Action: filter.ActionReject}},
+		})
+		if err := session.Rcpt(smtpValidHexAddress+"@example.com", nil); err != nil {
+			t.Fatalf("Rcpt() error = %v", err)
+		}
+		requireSMTPErrorCode(t, session.Data(strings.NewReader(marketing)), 550)
+		if len(fakeStore.saveCalls) != 0 {
+			t.Fatalf("save calls = %d, want none", len(fakeStore.saveCalls))
+		}
+	})
+
+	t.Run("drops, labels and expires per recipient", func(t *testing.T) {
+		t.Parallel()
+
+		third := strings.Repeat("a", 40)
+		session, fakeStore := newSession(t, map[string][]store.FilterRule{
+			smtpValidHexAddress: {{Header: "List-Unsubscribe", Action: filter.ActionReject}},
+			other: {
+				{Header: "list-unsubscribe", HeaderContains: "news.example.net", Action: filter.ActionLabel, Label: "marketing"},
+				{MinSize: 10, Action: filter.ActionExpire, ExpireMinutes: 30},
+			},
+			third: {{FromGlob: "deals@*", Action: filter.ActionDrop}},
+		})
+		if err := session.Mail("deals@news.example.net", nil); err != nil {
+			t.Fatalf("Mail() error = %v", err)
+		}
+		for _, to := range []string{smtpValidHexAddress, other, third} {
+			if err := session.Rcpt(to+"@example.com", nil); err != nil {
+				t.Fatalf("Rcpt(%s) error = %v", to, err)
+			}
+		}
+		if err := session.Data(strings.NewReader(marketing)); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+
+		if len(fakeStore.saveCalls) != 1 || fakeStore.saveCalls[0].addressBox != other {
+			t.Fatalf("save calls = %+v, want only %s", fakeStore.saveCalls, other)
+		}
+		saved := fakeStore.saveCalls[0].email
+		if len(saved.Labels) != 1 || saved.Labels[0] != "marketing" {
+			t.Fatalf("labels = %v, want marketing", saved.Labels)
+		}
+		if !saved.ExpiresAt.Equal(saved.ReceivedAt.Add(30 * time.Minute)) {
+			t.Fatalf("expires at = %v, want 30 minutes after %v", saved.ExpiresAt, saved.ReceivedAt)
+		}
+	})
+
+	t.Run("reset forgets the rules", func(t *testing.T) {
+		t.Parallel()
+
+		session, fakeStore := newSession(t, map[string][]store.FilterRule{
+			smtpValidHexAddress: {{SubjectRegex: "off", Action: filter.ActionDrop}},
+		})
+		if err := session.Rcpt(smtpValidHexAddress+"@example.com", nil); err != nil {
+			t.Fatalf("Rcpt() error = %v", err)
+		}
+		session.Reset()
+		if err := session.Rcpt(other+"@example.com", nil); err != nil {
+			t.Fatalf("Rcpt() error = %v", err)
+		}
+		if err := session.Data(strings.NewReader(marketing)); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+		if len(fakeStore.saveCalls) != 1 {
+			t.Fatalf("save calls = %d, want the message saved", len(fakeStore.saveCalls))
+		}
+	})
+}
+
+func TestSession_Extraction(t *testing.T) {
+	t.Parallel()
+
+	msg := strings.Join([]string{
+		"From: Example <no-reply@example.com>",
+		"To: recipient@coresend.test",
+		"Subject: Verify your email",
+		"List-Unsubscribe: <mailto:leave@example.com>",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=b",
+		"",
+		"--b",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		"Your verification code is 482913",
+		"--b",
+		"Content-Type: text/html; charset=utf-8",
+		"",
+		`<p>Your verification code is <b>482913</b></p><a href="https://example.com/verify?t=1">Confirm</a>`,
+		"--b--",
+		"",
+	}, "\r\n")
+
+	fakeStore := &smtpFakeStore{}
+	session := &Session{Store: fakeStore, From: "no-reply@example.com", To: []string{"recipient"}}
+	if err := session.Data(strings.NewReader(msg)); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+
+	if len(fakeStore.saveCalls) != 1 {
+		t.Fatalf("save calls = %d, want 1", len(fakeStore.saveCalls))
+	}
+	if ct := fakeStore.saveCalls[0].email.ContentType; ct != "text/html" {
+		t.Fatalf("content type = %q, want text/html", ct)
+	}
+	ex := fakeStore.saveCalls[0].email.Extracted
+	if ex == nil || len(ex.Codes) == 0 || ex.Codes[0].Value != "482913" {
+		t.Fatalf("extracted = %+v, want code 482913", ex)
+	}
+	if len(ex.Links) != 1 || ex.Links[0].URL != "https://example.com/verify?t=1" {
+		t.Fatalf("extracted links = %+v", ex.Links)
+	}
+	if len(ex.Unsubscribe) != 1 || ex.Unsubscribe[0] != "mailto:leave@example.com" {
+		t.Fatalf("extracted unsubscribe = %v", ex.Unsubscribe)
+	}
+}
+
+func TestSession_InlineParts(t *testing.T) {
+	t.Parallel()
+
+	msg := strings.Join([]string{
+		"From: shop@example.com",
+		"To: recipient@coresend.test",
+		"Subject: Your receipt",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/related; boundary=r",
+		"",
+		"--r",
+		"Content-Type: text/html; charset=utf-8",
+		"",
+		`<img src="cid:logo@example.com"><img src="cid:photo">`,
+		"--r",
+		"Content-Type: image/png",
+		"Content-ID: <logo@example.com>",
+		"Content-Disposition: inline; filename=logo.png",
+		"Content-Transfer-Encoding: base64",
+		"",
+		"iVBORw0KGgo=",
+		"--r",
+		"Content-Type: image/jpeg",
+		"Content-ID: <photo>",
+		"Content-Disposition: attachment; filename=photo.jpg",
+		"",
+		"jpeg",
+		"--r",
+		"Content-Type: image/gif",
+		"Content-Disposition: inline",
+		"",
+		"no content id",
+		"--r",
+		"Content-Type: application/pdf",
+		"Content-ID: <doc>",
+		"Content-Disposition: attachment; filename=doc.pdf",
+		"",
+		"pdf",
+		"--r--",
+		"",
+	}, "\r\n")
+
+	fakeStore := &smtpFakeStore{}
+	session := &Session{Store: fakeStore, From: "shop@example.com", To: []string{"recipient"}}
+	if err := session.Data(strings.NewReader(msg)); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+
+	if len(fakeStore.saveCalls) != 1 {
+		t.Fatalf("save calls = %d, want 1", len(fakeStore.saveCalls))
+	}
+	email := fakeStore.saveCalls[0].email
+	if email.ContentType != "text/html" || !strings.Contains(email.Body, "cid:logo@example.com") {
+		t.Fatalf("body = %q (%s), want the HTML part", email.Body, email.ContentType)
+	}
+	want := []store.InlinePart{
+		{ContentID: "logo@example.com", ContentType: "image/png", Filename: "logo.png", Size: 8, Data: []byte("\x89PNG\r\n\x1a\n")},
+		{ContentID: "photo", ContentType: "image/jpeg", Filename: "photo.jpg", Size: 4, Data: []byte("jpeg")},
+	}
+	if !reflect.DeepEqual(email.Inline, want) {
+		t.Fatalf("inline parts = %+v, want %+v", email.Inline, want)
+	}
+}
+
+func TestSession_Charsets(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		header      []string
+		body        string
+		wantSubject string
+		wantBody    string
+		wantCharset string
+	}{
+		{
+			name:        "ISO-2022-JP",
+			header:      []string{"Subject: =?ISO-2022-JP?B?GyRCJDMkcyRLJEEkTxsoQg==?=", "Content-Type: text/plain; charset=ISO-2022-JP", "Content-Transfer-Encoding: 7bit"},
+			body:        "\x1b$B$3$s$K$A$O\x1b(B",
+			wantSubject: "こんにちは",
+			wantBody:    "こんにちは",
+			wantCharset: "iso-2022-jp",
+		},
+		{
+			name:        "windows-1251 quoted-printable",
+			header:      []string{"Subject: =?windows-1251?Q?=CF=F0=E8=E2=E5=F2?=", "Content-Type: text/html; charset=windows-1251", "Content-Transfer-Encoding: quoted-printable"},
+			body:        "<p>=CF=F0=E8=E2=E5=F2</p>",
+			wantSubject: "Привет",
+			wantBody:    "<p>Привет</p>",
+			wantCharset: "windows-1251",
+		},
+		{
+			name:        "GBK base64",
+			header:      []string{"Subject: =?GBK?B?xOO6ww==?=", "Content-Type: text/plain; charset=\"gbk\"", "Content-Transfer-Encoding: base64"},
+			body:        "xOO6ww==",
+			wantSubject: "你好",
+			wantBody:    "你好",
+			wantCharset: "gbk",
+		},
+		{
+			name:        "unknown charset",
+			header:      []string{"Subject: =?x-mystery?Q?caf=E9?=", "Content-Type: text/plain; charset=x-mystery", "Content-Transfer-Encoding: 8bit"},
+			body:        "caf\xe9",
+			wantSubject: "café",
+			wantBody:    "café",
+			wantCharset: "x-mystery",
+		},
+		{
+			name:        "invalid UTF-8",
+			header:      []string{"Subject: plain", "Content-Type: text/plain; charset=utf-8", "Content-Transfer-Encoding: 8bit"},
+			body:        "héllo w\xf6rld ✓",
+			wantSubject: "plain",
+			wantBody:    "héllo w�rld ✓",
+			wantCharset: "utf-8",
+		},
+		{
+			name:        "malformed base64",
+			header:      []string{"Subject: =?UTF-8?B?bm90IGJhc2U2NCE?=", "Content-Type: text/plain", "Content-Transfer-Encoding: base64"},
+			body:        "aGVsbG8gd29ybGQh*garbage",
+			wantSubject: "=?UTF-8?B?bm90IGJhc2U2NCE?=",
+			wantBody:    "hello world!",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			msg := strings.Join(append(append([]string{"From: sender@example.com", "To: recipient@coresend.test", "MIME-Version: 1.0"}, tc.header...), "", tc.body, ""), "\r\n")
+			fakeStore := &smtpFakeStore{}
+			session := &Session{Store: fakeStore, From: "sender@example.com", To: []string{"recipient"}}
+			if err := session.Data(strings.NewReader(msg)); err != nil {
+				t.Fatalf("Data() error = %v", err)
+			}
+
+			if len(fakeStore.saveCalls) != 1 {
+				t.Fatalf("save calls = %d, want 1", len(fakeStore.saveCalls))
+			}
+			email := fakeStore.saveCalls[0].email
+			if email.Subject != tc.wantSubject {
+				t.Fatalf("subject = %q, want %q", email.Subject, tc.wantSubject)
+			}
+			if body := strings.TrimSpace(email.Body); body != tc.wantBody {
+				t.Fatalf("body = %q, want %q", body, tc.wantBody)
+			}
+			if email.Charset != tc.wantCharset {
+				t.Fatalf("charset = %q, want %q", email.Charset, tc.wantCharset)
+			}
+		})
+	}
+}
+
+func TestSession_Dedup(t *testing.T) {
+	t.Parallel()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	ctx := context.Background()
+	emailStore := store.NewStore(mr.Addr(), "")
+	deliver := func(raw string) {
+		t.Helper()
+		// The recipient repeated, as a client sending one copy per RCPT would
+		session := &Session{Store: emailStore, Dedup: true, From: "sender@example.com", To: []string{"recipient-a", "recipient-a"}}
+		if err := session.Data(strings.NewReader(raw)); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+	}
+
+	withID := "Message-ID: <retry@example.com>\r\nReceived: from a\r\n" + plainMessage("Retried", "body")
+	deliver(withID)
+	deliver(strings.Replace(withID, "from a", "from b", 1))
+
+	// Without a Message-ID, trace headers and rewrapping do not matter
+	deliver("Received: from a\r\n" + plainMessage("No ID", "same  body\r\nwrapped"))
+	deliver("Received: from b\r\n" + plainMessage("No ID", "same body wrapped"))
+	deliver(plainMessage("Other", "other body"))
+
+	// The same text with another attachment is a different message
+	report := multipartWithAttachmentMessage("Report", "See attached")
+	deliver(report)
+	deliver(report)
+	deliver(strings.Replace(report, "attachment-contents", "other-contents", 1))
+
+	// Text in a forwarded message is not the body of an HTML-only one
+	forwarded := "From: sender@example.com\r\n" +
+		"Subject: Forwarded\r\n" +
+		"Content-Type: multipart/mixed; boundary=MIXED\r\n" +
+		"\r\n" +
+		"--MIXED\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>See below</p>\r\n" +
+		"--MIXED\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"\r\n" +
+		"Subject: Inner\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"first text\r\n" +
+		"--MIXED--\r\n"
+	deliver(forwarded)
+	deliver(strings.Replace(forwarded, "first text", "second text", 1))
+
+	emails, err := emailStore.GetEmails(ctx, "recipient-a")
+	if err != nil {
+		t.Fatalf("GetEmails() error = %v", err)
+	}
+	duplicates := map[string][]int{}
+	for _, e := range emails {
+		duplicates[e.Subject] = append(duplicates[e.Subject], e.Duplicates)
+	}
+	for _, counts := range duplicates {
+		slices.Sort(counts)
+	}
+	want := map[string][]int{"Retried": {3}, "No ID": {3}, "Other": {1}, "Report": {1, 3}, "Forwarded": {1, 1}}
+	if !reflect.DeepEqual(duplicates, want) {
+		t.Fatalf("duplicates by subject = %v, want %v", duplicates, want)
 	}
 }
@@ -48,3 +48,45 @@ func TestExtractLocalPart(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractDomain(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "standard email",
+			input: "alice@example.com",
+			want:  "example.com",
+		},
+		{
+			name:  "no at symbol",
+			input: "no-at-symbol",
+			want:  "",
+		},
+		{
+			name:  "multiple at symbols uses last index",
+			input: "x@y@z",
+			want:  "z",
+		},
+		{
+			name:  "empty domain",
+			input: "alice@",
+			want:  "",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := extractDomain(tc.input)
+			if got != tc.want {
+				t.Fatalf("extractDomain(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package smtp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/fn-jakubkarp/coresend/internal/charset"
+	"github.com/fn-jakubkarp/coresend/internal/mailparse"
+	"github.com/fn-jakubkarp/coresend/internal/store"
+)
+
+// dedupHeaders are hashed for messages without a Message-ID. Trace headers
+// such as Received differ between retries, so they are left out.
+var dedupHeaders = []string{"From", "To", "Cc", "Subject", "Date"}
+
+// dedupKey identifies msg among the copies a sender may deliver: by its
+// Message-ID, or by a hash of its headers, its text with whitespace
+// normalized and the digest of every other part.
+func dedupKey(msg *mailparse.Message) string {
+	if id, err := msg.Header.MessageID(); err == nil && id != "" {
+		return "mid:" + id
+	}
+
+	h := sha256.New()
+	for _, key := range dedupHeaders {
+		for _, v := range msg.Header.Values(key) {
+			h.Write([]byte(key + ":" + normalize(charset.DecodeHeader(v)) + "\n"))
+		}
+	}
+	h.Write([]byte("\n" + normalize(msg.Text) + "\n" + normalize(msg.HTML) + "\n"))
+	for _, part := range msg.Inline {
+		h.Write([]byte(part.ContentID + ":" + strconv.Itoa(part.Size) + "\n"))
+	}
+	if msg.Structure != nil {
+		hashParts(h, msg.Structure, msg)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// hashParts writes the digest and size of the leaves of part to h, except
+// for the ones msg.Text and msg.HTML were read from, which are hashed
+// normalized instead.
+func hashParts(h hash.Hash, part *store.MIMEPart, msg *mailparse.Message) {
+	if len(part.Parts) > 0 {
+		for i := range part.Parts {
+			hashParts(h, &part.Parts[i], msg)
+		}
+		return
+	}
+	if isPart(msg.TextPart, part) || isPart(msg.HTMLPart, part) {
+		return
+	}
+	h.Write([]byte(part.Path + ":" + part.SHA256 + ":" + strconv.Itoa(part.Size) + "\n"))
+}
+
+// isPart reports whether read is part, found by its path in the tree.
+func isPart(read, part *store.MIMEPart) bool {
+	return read != nil && read.Path == part.Path
+}
+
+// normalize collapses runs of whitespace, which relays may rewrap.
+func normalize(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,222 @@
+package smtp
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/fn-jakubkarp/coresend/internal/metrics"
+)
+
+// Listener tracks SMTP connections so they can be drained at shutdown.
+//
+// After Drain, a connection waiting for its next command fails the read as if
+// it had timed out, which makes go-smtp reply 421 and hang up. This works the
+// same under STARTTLS or implicit TLS, since the reply goes through the TLS
+// layer above. Connections inside DATA keep reading until the message is
+// saved.
+type Listener struct {
+	net.Listener
+
+	mu       sync.Mutex
+	conns    map[*drainConn]struct{}
+	draining bool
+}
+
+func NewListener(l net.Listener) *Listener {
+	return &Listener{Listener: l, conns: make(map[*drainConn]struct{})}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	dc := &drainConn{Conn: c, listener: l}
+	l.mu.Lock()
+	l.conns[dc] = struct{}{}
+	draining := l.draining
+	l.mu.Unlock()
+
+	if draining {
+		dc.drain()
+	}
+	return dc, nil
+}
+
+// Drain makes idle connections hang up with 421 and reports how many were
+// idle and how many are receiving a message.
+func (l *Listener) Drain() (idle, inFlight int) {
+	l.mu.Lock()
+	l.draining = true
+	conns := l.snapshot()
+	l.mu.Unlock()
+
+	for _, c := range conns {
+		if c.drain() {
+			inFlight++
+		} else {
+			idle++
+		}
+	}
+	return idle, inFlight
+}
+
+// Abort closes every remaining connection and returns how many messages were
+// cut off mid-DATA.
+func (l *Listener) Abort() int {
+	l.mu.Lock()
+	conns := l.snapshot()
+	l.mu.Unlock()
+
+	aborted := 0
+	for _, c := range conns {
+		if c.receiving() {
+			aborted++
+		}
+		c.Conn.Close()
+	}
+	return aborted
+}
+
+// snapshot must be called with l.mu held.
+func (l *Listener) snapshot() []*drainConn {
+	conns := make([]*drainConn, 0, len(l.conns))
+	for c := range l.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+func (l *Listener) remove(c *drainConn) {
+	l.mu.Lock()
+	delete(l.conns, c)
+	l.mu.Unlock()
+}
+
+type drainConn struct {
+	net.Conn
+	listener *Listener
+
+	mu        sync.Mutex
+	draining  bool
+	inMessage bool
+	// readDeadline is the last deadline go-smtp asked for, restored when a
+	// message starts after draining began.
+	readDeadline time.Time
+}
+
+func (c *drainConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	refuse := c.draining && !c.inMessage
+	c.mu.Unlock()
+	if refuse {
+		return 0, os.ErrDeadlineExceeded
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *drainConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *drainConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	if c.draining && !c.inMessage {
+		// Keep the expired deadline so a blocked read stays woken
+		return nil
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *drainConn) Close() error {
+	err := c.Conn.Close()
+	c.listener.remove(c)
+	return err
+}
+
+// drain reports whether the connection is receiving a message.
+func (c *drainConn) drain() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.draining = true
+	if !c.inMessage {
+		// Wake a read blocked waiting for the next command
+		c.Conn.SetReadDeadline(time.Now())
+	}
+	return c.inMessage
+}
+
+func (c *drainConn) receiving() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inMessage
+}
+
+func (c *drainConn) beginMessage() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inMessage = true
+	if c.draining {
+		// drain may have expired the deadline between DATA and this call
+		c.Conn.SetReadDeadline(c.readDeadline)
+	}
+}
+
+func (c *drainConn) endMessage() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inMessage = false
+	if c.draining {
+		c.Conn.SetReadDeadline(time.Now())
+	}
+}
+
+// drainConnOf finds the drainConn under any TLS layers, or nil when the
+// connection was not accepted by a Listener.
+func drainConnOf(c net.Conn) *drainConn {
+	for c != nil {
+		switch v := c.(type) {
+		case *drainConn:
+			return v
+		case interface{ NetConn() net.Conn }:
+			c = v.NetConn()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// Shutdown drains l and stops s. Idle sessions are told 421 straight away;
+// messages being received get until ctx is done to finish saving, after which
+// their connections are closed and they are counted as aborted.
+func Shutdown(ctx context.Context, s *gosmtp.Server, l *Listener) error {
+	idle, inFlight := l.Drain()
+	slog.Info("Draining SMTP connections", "addr", l.Addr().String(), "idle", idle, "in_flight", inFlight)
+
+	err := s.Shutdown(ctx)
+	if ctx.Err() == nil {
+		return err
+	}
+
+	if aborted := l.Abort(); aborted > 0 {
+		metrics.SMTPMessagesAbortedTotal.Add(float64(aborted))
+		slog.Warn("Aborted messages still being received at shutdown", "addr", l.Addr().String(), "count", aborted)
+	}
+	return err
+}
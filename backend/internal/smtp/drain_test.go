@@ -0,0 +1,219 @@
+package smtp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/fn-jakubkarp/coresend/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func startDrainableServer(t *testing.T, fakeStore *smtpFakeStore, tlsConfig *tls.Config) (*gosmtp.Server, *Listener) {
+	t.Helper()
+
+	server := gosmtp.NewServer(&Backend{Store: fakeStore, Domains: newTestDomains(t)})
+	server.Domain = "localhost"
+	server.TLSConfig = tlsConfig
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	l := NewListener(ln)
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return server, l
+}
+
+// rawClient speaks SMTP line by line so tests control exactly when bytes
+// reach the server.
+type rawClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRaw(t *testing.T, addr string) *rawClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &rawClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	c.expect("220")
+	return c
+}
+
+func (c *rawClient) write(s string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(s)); err != nil {
+		c.t.Fatalf("write %q error = %v", s, err)
+	}
+}
+
+// reply reads a possibly multi-line reply and returns its last line.
+func (c *rawClient) reply() string {
+	c.t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("read reply error = %v", err)
+		}
+		if len(line) < 4 || line[3] != '-' {
+			return strings.TrimSpace(line)
+		}
+	}
+}
+
+func (c *rawClient) expect(code string) string {
+	c.t.Helper()
+
+	line := c.reply()
+	if !strings.HasPrefix(line, code) {
+		c.t.Fatalf("reply = %q, want %s", line, code)
+	}
+	return line
+}
+
+func (c *rawClient) cmd(line, code string) {
+	c.t.Helper()
+	c.write(line + "\r\n")
+	c.expect(code)
+}
+
+func (c *rawClient) startTLS(config *tls.Config) {
+	c.t.Helper()
+
+	c.cmd("STARTTLS", "220")
+	tlsConn := tls.Client(c.conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		c.t.Fatalf("Handshake() error = %v", err)
+	}
+	c.conn = tlsConn
+	c.r = bufio.NewReader(tlsConn)
+}
+
+func (c *rawClient) startMessage() {
+	c.t.Helper()
+
+	c.cmd("EHLO client.example", "250")
+	c.cmd("MAIL FROM:<sender@example.com>", "250")
+	c.cmd("RCPT TO:<"+smtpValidHexAddress+"@example.com>", "250")
+	c.cmd("DATA", "354")
+	c.write("From: sender@example.com\r\nSubject: Draining\r\n")
+}
+
+func shutdownAsync(s *gosmtp.Server, l *Listener, timeout time.Duration) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		done <- Shutdown(ctx, s, l)
+	}()
+	return done
+}
+
+func waitShutdown(t *testing.T, done <-chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown() did not return")
+		return nil
+	}
+}
+
+func TestShutdown_IdleSessionsGet421(t *testing.T) {
+	t.Parallel()
+
+	serverTLS := selfSignedTLSConfig(t)
+
+	tests := []struct {
+		name     string
+		startTLS bool
+	}{
+		{name: "plaintext"},
+		{name: "after STARTTLS", startTLS: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			server, l := startDrainableServer(t, &smtpFakeStore{}, serverTLS)
+			c := dialRaw(t, l.Addr().String())
+			c.cmd("EHLO client.example", "250")
+			if tc.startTLS {
+				c.startTLS(&tls.Config{InsecureSkipVerify: true})
+				c.cmd("EHLO client.example", "250")
+			}
+
+			if err := waitShutdown(t, shutdownAsync(server, l, 5*time.Second)); err != nil {
+				t.Fatalf("Shutdown() error = %v", err)
+			}
+			c.expect("421")
+		})
+	}
+}
+
+func TestShutdown_WaitsForMessageInFlight(t *testing.T) {
+	t.Parallel()
+
+	fakeStore := &smtpFakeStore{
+		isAddressActiveFn: func(context.Context, string) (bool, error) { return true, nil },
+	}
+	server, l := startDrainableServer(t, fakeStore, nil)
+	c := dialRaw(t, l.Addr().String())
+	c.startMessage()
+
+	done := shutdownAsync(server, l, 5*time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown() returned %v while a message was in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.write("\r\nStill arriving\r\n.\r\n")
+	c.expect("250")
+	c.expect("421")
+
+	if err := waitShutdown(t, done); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if len(fakeStore.saveCalls) != 1 {
+		t.Fatalf("save call count = %d, want 1", len(fakeStore.saveCalls))
+	}
+}
+
+func TestShutdown_AbortsAfterTimeout(t *testing.T) {
+	t.Parallel()
+
+	fakeStore := &smtpFakeStore{
+		isAddressActiveFn: func(context.Context, string) (bool, error) { return true, nil },
+	}
+	server, l := startDrainableServer(t, fakeStore, nil)
+	c := dialRaw(t, l.Addr().String())
+	c.startMessage()
+
+	before := testutil.ToFloat64(metrics.SMTPMessagesAbortedTotal)
+	err := waitShutdown(t, shutdownAsync(server, l, 100*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() error = %v, want deadline exceeded", err)
+	}
+	if got := testutil.ToFloat64(metrics.SMTPMessagesAbortedTotal) - before; got != 1 {
+		t.Fatalf("aborted messages = %v, want 1", got)
+	}
+}
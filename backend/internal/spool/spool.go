@@ -0,0 +1,301 @@
+// Package spool keeps accepted mail on local disk while the store is down.
+//
+// Each message is written to its own file, synced, and renamed into place
+// before the SMTP transaction is acknowledged. A background loop replays the
+// files into the store in arrival order once it recovers, and the directory
+// is rescanned on start so nothing is lost across restarts.
+package spool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/logging"
+	"github.com/fn-jakubkarp/coresend/internal/metrics"
+	"github.com/fn-jakubkarp/coresend/internal/store"
+	"github.com/google/uuid"
+)
+
+// ErrFull is returned by Add when the message would exceed the size limit.
+var ErrFull = errors.New("spool is full")
+
+const (
+	entrySuffix   = ".json"
+	tempSuffix    = ".tmp"
+	corruptSuffix = ".corrupt"
+)
+
+// Entry is one message for one recipient.
+type Entry struct {
+	Address   string        `json:"address"`
+	Domain    string        `json:"domain"`
+	Email     store.Email   `json:"email"`
+	Retention time.Duration `json:"retention"`
+	// Unverified is set when the recipient was accepted without checking it
+	// is active. Replay checks and drops the message if it is not.
+	Unverified bool `json:"unverified,omitempty"`
+	// Raw is the message as received, kept when it may need forwarding, or
+	// RawSHA256 its digest in the forwarder's blob store.
+	Raw       []byte    `json:"raw,omitempty"`
+	RawSHA256 string    `json:"raw_sha256,omitempty"`
+	SpooledAt time.Time `json:"spooled_at"`
+}
+
+type Spool struct {
+	// OnReplay, when set, is called for each message Replay saves.
+	OnReplay func(ctx context.Context, e Entry)
+
+	dir      string
+	maxBytes int64
+	store    store.EmailStore
+
+	mu    sync.Mutex
+	files map[string]int64
+	bytes int64
+}
+
+// Open uses dir as the spool, creating it if needed, and picks up messages
+// left by a previous run.
+func Open(dir string, maxBytes int64, s store.EmailStore) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	sp := &Spool{dir: dir, maxBytes: maxBytes, store: s, files: make(map[string]int64)}
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case strings.HasSuffix(name, tempSuffix):
+			// Never acknowledged, so the sender still has it
+			os.Remove(filepath.Join(dir, name))
+		case strings.HasSuffix(name, entrySuffix):
+			info, err := e.Info()
+			if err != nil {
+				return nil, fmt.Errorf("failed to stat spooled message: %w", err)
+			}
+			sp.files[name] = info.Size()
+			sp.bytes += info.Size()
+		}
+	}
+	sp.updateGauges()
+	return sp, nil
+}
+
+// Len returns the number of spooled messages.
+func (sp *Spool) Len() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return len(sp.files)
+}
+
+// Add durably writes e. The email gets an ID first so a replay that races a
+// late store write overwrites rather than duplicates it.
+func (sp *Spool) Add(e Entry) error {
+	if e.Email.ID == "" {
+		e.Email.ID = uuid.New().String()
+	}
+	if e.SpooledAt.IsZero() {
+		e.SpooledAt = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	size := int64(len(data))
+
+	sp.mu.Lock()
+	if sp.maxBytes > 0 && sp.bytes+size > sp.maxBytes {
+		sp.mu.Unlock()
+		metrics.SpoolOperationsTotal.WithLabelValues("full").Inc()
+		return ErrFull
+	}
+	// Reserve the space so concurrent writers cannot overshoot
+	sp.bytes += size
+	sp.mu.Unlock()
+
+	name := fmt.Sprintf("%020d-%s%s", e.SpooledAt.UnixNano(), logging.NewID(), entrySuffix)
+	if err := writeFileSync(filepath.Join(sp.dir, name), data); err != nil {
+		sp.mu.Lock()
+		sp.bytes -= size
+		sp.mu.Unlock()
+		return err
+	}
+
+	sp.mu.Lock()
+	sp.files[name] = size
+	sp.updateGaugesLocked()
+	sp.mu.Unlock()
+	metrics.SpoolOperationsTotal.WithLabelValues("spooled").Inc()
+	return nil
+}
+
+// Replay saves spooled messages in arrival order until the spool is empty
+// or the store fails. It returns how many were saved.
+func (sp *Spool) Replay(ctx context.Context) (int, error) {
+	replayed := 0
+	for _, name := range sp.pending() {
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		path := filepath.Join(sp.dir, name)
+		e, err := readEntry(path)
+		if err != nil {
+			slog.ErrorContext(ctx, "Set aside unreadable spooled message", "file", name, "error", err)
+			os.Rename(path, path+corruptSuffix)
+			sp.forget(name)
+			metrics.SpoolOperationsTotal.WithLabelValues("corrupt").Inc()
+			continue
+		}
+
+		if e.Unverified {
+			active, err := sp.store.IsAddressActive(ctx, e.Address, e.Domain)
+			if err != nil {
+				return replayed, err
+			}
+			if !active {
+				slog.InfoContext(ctx, "Dropped spooled message for inactive address", logging.KeyAddress, e.Address)
+				sp.remove(name)
+				metrics.SpoolOperationsTotal.WithLabelValues("dropped").Inc()
+				continue
+			}
+		}
+
+		err = sp.store.SaveEmail(ctx, e.Address, e.Email, e.Retention)
+		if errors.Is(err, store.ErrDuplicate) {
+			slog.InfoContext(ctx, "Dropped spooled duplicate", logging.KeyAddress, e.Address)
+			sp.remove(name)
+			metrics.DuplicateEmailsTotal.Inc()
+			continue
+		}
+		if err != nil {
+			return replayed, err
+		}
+		sp.remove(name)
+		metrics.SpoolOperationsTotal.WithLabelValues("replayed").Inc()
+		replayed++
+		if sp.OnReplay != nil {
+			sp.OnReplay(ctx, e)
+		}
+	}
+	return replayed, nil
+}
+
+// Run replays the spool every interval until ctx is cancelled.
+func (sp *Spool) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if sp.Len() > 0 {
+			n, err := sp.Replay(ctx)
+			if n > 0 {
+				slog.InfoContext(ctx, "Replayed spooled messages", "count", n, "remaining", sp.Len())
+			}
+			if err != nil && ctx.Err() == nil {
+				slog.WarnContext(ctx, "Spool replay paused, store unavailable", "remaining", sp.Len(), "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pending lists spooled files oldest first.
+func (sp *Spool) pending() []string {
+	sp.mu.Lock()
+	names := make([]string, 0, len(sp.files))
+	for name := range sp.files {
+		names = append(names, name)
+	}
+	sp.mu.Unlock()
+
+	sort.Strings(names)
+	return names
+}
+
+func (sp *Spool) remove(name string) {
+	if err := os.Remove(filepath.Join(sp.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("Failed to remove replayed spool file", "file", name, "error", err)
+	}
+	sp.forget(name)
+}
+
+func (sp *Spool) forget(name string) {
+	sp.mu.Lock()
+	sp.bytes -= sp.files[name]
+	delete(sp.files, name)
+	sp.updateGaugesLocked()
+	sp.mu.Unlock()
+}
+
+func (sp *Spool) updateGauges() {
+	sp.mu.Lock()
+	sp.updateGaugesLocked()
+	sp.mu.Unlock()
+}
+
+func (sp *Spool) updateGaugesLocked() {
+	metrics.SpoolMessages.Set(float64(len(sp.files)))
+	metrics.SpoolBytes.Set(float64(sp.bytes))
+}
+
+func readEntry(path string) (Entry, error) {
+	var e Entry
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return e, err
+	}
+	err = json.Unmarshal(data, &e)
+	return e, err
+}
+
+// writeFileSync writes data to a temporary file, syncs it and renames it to
+// path, so a crash leaves either the whole message or nothing.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + tempSuffix
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
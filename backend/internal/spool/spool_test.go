@@ -0,0 +1,270 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fn-jakubkarp/coresend/internal/store"
+)
+
+const (
+	testAddress = "0123456789abcdef0123456789abcdef01234567"
+	testDomain  = "coresend.test"
+)
+
+func newTestStore(t *testing.T) (*miniredis.Miniredis, *store.Store) {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	s := store.NewStore(mr.Addr(), "")
+	if err := s.RegisterAddress(context.Background(), testAddress, testDomain, time.Hour); err != nil {
+		t.Fatalf("RegisterAddress() error = %v", err)
+	}
+	return mr, s
+}
+
+func testEntry(subject string) Entry {
+	return Entry{
+		Address:   testAddress,
+		Domain:    testDomain,
+		Email:     store.Email{From: "sender@example.com", To: []string{testAddress}, Subject: subject},
+		Retention: time.Hour,
+	}
+}
+
+func subjects(t *testing.T, s *store.Store) []string {
+	t.Helper()
+
+	emails, err := s.GetEmails(context.Background(), testAddress)
+	if err != nil {
+		t.Fatalf("GetEmails() error = %v", err)
+	}
+	var out []string
+	for _, e := range emails {
+		out = append(out, e.Subject)
+	}
+	return out
+}
+
+func spoolFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestSpool_ReplaysIntoStore(t *testing.T) {
+	t.Parallel()
+
+	_, s := newTestStore(t)
+	dir := t.TempDir()
+	sp, err := Open(dir, 0, s)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	var notified []string
+	sp.OnReplay = func(_ context.Context, e Entry) { notified = append(notified, e.Email.Subject) }
+
+	for _, subject := range []string{"first", "second"} {
+		if err := sp.Add(testEntry(subject)); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if sp.Len() != 2 || len(spoolFiles(t, dir)) != 2 {
+		t.Fatalf("Len() = %d, files = %v, want 2", sp.Len(), spoolFiles(t, dir))
+	}
+
+	n, err := sp.Replay(context.Background())
+	if err != nil || n != 2 {
+		t.Fatalf("Replay() = %d, %v, want 2, nil", n, err)
+	}
+	if sp.Len() != 0 || len(spoolFiles(t, dir)) != 0 {
+		t.Fatalf("after replay Len() = %d, files = %v, want empty", sp.Len(), spoolFiles(t, dir))
+	}
+	if got := subjects(t, s); len(got) != 2 {
+		t.Fatalf("stored subjects = %v, want both messages", got)
+	}
+	if len(notified) != 2 || notified[0] != "first" || notified[1] != "second" {
+		t.Fatalf("OnReplay subjects = %v, want [first second]", notified)
+	}
+}
+
+func TestSpool_ReplayWaitsForStore(t *testing.T) {
+	t.Parallel()
+
+	mr, s := newTestStore(t)
+	sp, err := Open(t.TempDir(), 0, s)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := sp.Add(testEntry("queued")); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	if n, err := sp.Replay(context.Background()); err == nil || n != 0 {
+		t.Fatalf("Replay() with store down = %d, %v, want 0 and an error", n, err)
+	}
+	if sp.Len() != 1 {
+		t.Fatalf("Len() = %d, want the message kept", sp.Len())
+	}
+
+	mr.SetError("")
+	if n, err := sp.Replay(context.Background()); err != nil || n != 1 {
+		t.Fatalf("Replay() after recovery = %d, %v, want 1, nil", n, err)
+	}
+	if got := subjects(t, s); len(got) != 1 || got[0] != "queued" {
+		t.Fatalf("stored subjects = %v, want [queued]", got)
+	}
+}
+
+func TestSpool_Duplicates(t *testing.T) {
+	t.Parallel()
+
+	_, s := newTestStore(t)
+	dir := t.TempDir()
+	sp, err := Open(dir, 0, s)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	var notified int
+	sp.OnReplay = func(context.Context, Entry) { notified++ }
+
+	for range 2 {
+		e := testEntry("retried")
+		e.Email.DedupKey = "mid:retried@example.com"
+		if err := sp.Add(e); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	if n, err := sp.Replay(context.Background()); err != nil || n != 1 {
+		t.Fatalf("Replay() = %d, %v, want 1, nil", n, err)
+	}
+	if sp.Len() != 0 || len(spoolFiles(t, dir)) != 0 {
+		t.Fatalf("after replay Len() = %d, files = %v, want the duplicate removed", sp.Len(), spoolFiles(t, dir))
+	}
+	if got := subjects(t, s); len(got) != 1 || notified != 1 {
+		t.Fatalf("stored subjects = %v, notified %d times, want one message", got, notified)
+	}
+}
+
+func TestSpool_UnverifiedRecipients(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		address   string
+		wantSaved bool
+	}{
+		{name: "active address is saved", address: testAddress, wantSaved: true},
+		{name: "inactive address is dropped", address: "ffffffffffffffffffffffffffffffffffffffff"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, s := newTestStore(t)
+			sp, err := Open(t.TempDir(), 0, s)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			e := testEntry("unverified")
+			e.Address = tc.address
+			e.Unverified = true
+			if err := sp.Add(e); err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+
+			n, err := sp.Replay(context.Background())
+			if err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+			if (n == 1) != tc.wantSaved {
+				t.Fatalf("Replay() saved %d, want saved %v", n, tc.wantSaved)
+			}
+			if sp.Len() != 0 {
+				t.Fatalf("Len() = %d, want 0", sp.Len())
+			}
+		})
+	}
+}
+
+func TestSpool_Full(t *testing.T) {
+	t.Parallel()
+
+	_, s := newTestStore(t)
+	sp, err := Open(t.TempDir(), 600, s)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	if err := sp.Add(testEntry("fits")); err != nil {
+		t.Fatalf("first Add() error = %v", err)
+	}
+	if err := sp.Add(testEntry("overflows")); !errors.Is(err, ErrFull) {
+		t.Fatalf("second Add() error = %v, want ErrFull", err)
+	}
+	if sp.Len() != 1 {
+		t.Fatalf("Len() = %d, want 1", sp.Len())
+	}
+}
+
+func TestOpen_RecoversPreviousRun(t *testing.T) {
+	t.Parallel()
+
+	_, s := newTestStore(t)
+	dir := t.TempDir()
+
+	first, err := Open(dir, 0, s)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := first.Add(testEntry("survivor")); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	// A write interrupted before rename, and a file that is not JSON
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000001-x.json.tmp"), []byte("{"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000002-y.json"), []byte("not json"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	second, err := Open(dir, 0, s)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	if second.Len() != 2 {
+		t.Fatalf("Len() after reopen = %d, want 2", second.Len())
+	}
+
+	n, err := second.Replay(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("Replay() = %d, %v, want 1, nil", n, err)
+	}
+	if got := subjects(t, s); len(got) != 1 || got[0] != "survivor" {
+		t.Fatalf("stored subjects = %v, want [survivor]", got)
+	}
+	if files := spoolFiles(t, dir); len(files) != 1 || files[0] != "00000000000000000002-y.json.corrupt" {
+		t.Fatalf("files = %v, want only the corrupt file set aside", files)
+	}
+}
@@ -0,0 +1,521 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/blob"
+	"github.com/fn-jakubkarp/coresend/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// Content in the blob store is shared by every email that has it, so each
+// digest has a sorted set of the emails referencing it, scored by when to
+// check that they still exist. Emails leave with their folder's keys, which
+// Redis expires without notice, so references are not removed when emails
+// go but found gone by CollectBlobs.
+//
+// Content nothing references is deleted after a grace period, which also
+// covers content written while a message is received but saved later, or
+// never, as when it is rejected. Content held for something other than an
+// email, such as a spooled message, is referenced by a hold that lasts
+// until its score.
+//
+// A digest claimed for deletion is marked until the content is gone, and
+// writes of the same content wait for the mark to clear, so they are not
+// lost to a deletion that was already under way.
+const (
+	// blobGCKey scores each digest by the earliest check of its references.
+	blobGCKey = "blob_gc"
+	// blobOrphansKey scores each digest without references by when it was
+	// last written or lost its last reference.
+	blobOrphansKey = "blob_orphans"
+	// blobHoldPrefix starts the references of holds.
+	blobHoldPrefix = "hold:"
+)
+
+func blobRefsKey(sum string) string {
+	return fmt.Sprintf("blob_refs:%s", sum)
+}
+
+func blobDeletingKey(sum string) string {
+	return fmt.Sprintf("blob_deleting:%s", sum)
+}
+
+const (
+	// blobDeleteTimeout bounds the deletion of one blob, and the mark lasts
+	// twice as long so it outlives the deletion.
+	blobDeleteTimeout = time.Minute
+	// blobDeletePoll is how often a write waits for a deletion to end.
+	blobDeletePoll = 50 * time.Millisecond
+)
+
+// blobRef names the email at id in the hash hKey.
+func blobRef(hKey, id string) string {
+	return hKey + "/" + id
+}
+
+// maxBlobAttempts bounds the retries of a reference update when another
+// changes the same digest.
+const maxBlobAttempts = 5
+
+// blobBatchSize bounds the digests CollectBlobs handles at a time.
+const blobBatchSize = 100
+
+// UseBlobs moves the bodies and inline part data of emails saved from now
+// on to blobs, leaving Redis with the rest.
+func (s *Store) UseBlobs(blobs blob.Store) {
+	s.blobs = blobs
+}
+
+// Blobs returns the blob store for content received before its email is
+// saved, or nil when there is none. What it stores is collected unless an
+// email saved within the grace period, or a hold, references it.
+func (s *Store) Blobs() blob.Store {
+	if s.blobs == nil {
+		return nil
+	}
+	return trackedBlobs{s: s}
+}
+
+type trackedBlobs struct {
+	s *Store
+}
+
+// Put tracks the content before it is written, so it needs the digest
+// first and reads r into a temporary file.
+func (b trackedBlobs) Put(ctx context.Context, r io.Reader) (blob.Ref, error) {
+	f, err := os.CreateTemp("", "coresend-blob-*")
+	if err != nil {
+		return blob.Ref{}, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return blob.Ref{}, err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	// A failure leaves the content stored until an email references it and
+	// is gone; the message is still worth receiving
+	if err := b.s.client.ZAdd(ctx, blobOrphansKey, redis.Z{Score: float64(time.Now().Unix()), Member: sum}).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to track stored blob", "sha256", sum, "error", err)
+	} else if err := b.s.awaitDeletion(ctx, sum); err != nil {
+		return blob.Ref{}, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return blob.Ref{}, err
+	}
+	return b.s.blobs.Put(ctx, f)
+}
+
+// Hold references the content with the given digests until until. Holds
+// are not released; they end at until.
+func (b trackedBlobs) Hold(ctx context.Context, holder string, until time.Time, sums ...string) error {
+	score := float64(until.Unix())
+	pipe := b.s.client.Pipeline()
+	for _, sum := range sums {
+		pipe.ZAdd(ctx, blobRefsKey(sum), redis.Z{Score: score, Member: blobHoldPrefix + holder})
+		pipe.ZAddLT(ctx, blobGCKey, redis.Z{Score: score, Member: sum})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// awaitDeletion returns once sum is not being deleted.
+func (s *Store) awaitDeletion(ctx context.Context, sum string) error {
+	for {
+		n, err := s.client.Exists(ctx, blobDeletingKey(sum)).Result()
+		if err != nil || n == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(blobDeletePoll):
+		}
+	}
+}
+
+func (b trackedBlobs) Open(ctx context.Context, sum string) (io.ReadCloser, error) {
+	return b.s.blobs.Open(ctx, sum)
+}
+
+func (b trackedBlobs) Delete(ctx context.Context, sum string) error {
+	return b.s.blobs.Delete(ctx, sum)
+}
+
+// storeContent moves the body, source and inline part data of email to the
+// blob store.
+func (s *Store) storeContent(ctx context.Context, email *Email) error {
+	blobs := s.Blobs()
+	if email.Body != "" {
+		ref, err := blobs.Put(ctx, strings.NewReader(email.Body))
+		if err != nil {
+			return fmt.Errorf("failed to store body: %w", err)
+		}
+		email.Body, email.BodySHA256 = "", ref.SHA256
+	}
+	if email.Source != nil {
+		ref, err := blobs.Put(ctx, bytes.NewReader(email.Source))
+		if err != nil {
+			return fmt.Errorf("failed to store source: %w", err)
+		}
+		email.Source, email.SourceSHA256 = nil, ref.SHA256
+	}
+	for i, part := range email.Inline {
+		ref, err := blobs.Put(ctx, bytes.NewReader(part.Data))
+		if err != nil {
+			return fmt.Errorf("failed to store inline part: %w", err)
+		}
+		email.Inline[i].SHA256 = ref.SHA256
+	}
+	return nil
+}
+
+// BlobSums returns the digests of the content email references: its body,
+// source, inline parts and the parts of its structure.
+func BlobSums(email Email) []string {
+	seen := map[string]bool{}
+	var sums []string
+	add := func(sum string) {
+		if sum != "" && !seen[sum] {
+			seen[sum] = true
+			sums = append(sums, sum)
+		}
+	}
+	add(email.BodySHA256)
+	add(email.SourceSHA256)
+	for _, part := range email.Inline {
+		add(part.SHA256)
+	}
+	var walk func(part *MIMEPart)
+	walk = func(part *MIMEPart) {
+		add(part.SHA256)
+		for i := range part.Parts {
+			walk(&part.Parts[i])
+		}
+	}
+	if email.Structure != nil {
+		walk(email.Structure)
+	}
+	return sums
+}
+
+// addBlobRefs queues references from the email in hKey to its content, to
+// be checked once it expires.
+func addBlobRefs(ctx context.Context, pipe redis.Pipeliner, hKey string, email Email, retention time.Duration) {
+	until := time.Now().Add(retention)
+	if !email.ExpiresAt.IsZero() && email.ExpiresAt.Before(until) {
+		until = email.ExpiresAt
+	}
+	score := float64(until.Unix())
+	for _, sum := range BlobSums(email) {
+		pipe.ZAdd(ctx, blobRefsKey(sum), redis.Z{Score: score, Member: blobRef(hKey, email.ID)})
+		pipe.ZAddLT(ctx, blobGCKey, redis.Z{Score: score, Member: sum})
+	}
+}
+
+// releaseBlobs removes the references of deleted emails of hKey, so their
+// content is checked by the next CollectBlobs.
+func (s *Store) releaseBlobs(ctx context.Context, hKey string, emails []Email) error {
+	if s.blobs == nil {
+		return nil
+	}
+	now := float64(time.Now().Unix())
+	pipe := s.client.Pipeline()
+	for _, email := range emails {
+		for _, sum := range BlobSums(email) {
+			pipe.ZRem(ctx, blobRefsKey(sum), blobRef(hKey, email.ID))
+			pipe.ZAddLT(ctx, blobGCKey, redis.Z{Score: now, Member: sum})
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// readBlob returns the content with the given digest, or nil when it is
+// missing.
+func (s *Store) readBlob(ctx context.Context, sum string) ([]byte, error) {
+	r, err := s.blobs.Open(ctx, sum)
+	if errors.Is(err, blob.ErrNotFound) {
+		slog.WarnContext(ctx, "Stored content is missing", "sha256", sum)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// loadBody reads back the body of an email saved to the blob store.
+func (s *Store) loadBody(ctx context.Context, email *Email) error {
+	if email.BodySHA256 == "" || s.blobs == nil {
+		return nil
+	}
+	data, err := s.readBlob(ctx, email.BodySHA256)
+	if err != nil {
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+	email.Body = string(data)
+	return nil
+}
+
+// blobReadConcurrency bounds the bodies loadBodies reads at once.
+const blobReadConcurrency = 8
+
+func (s *Store) loadBodies(ctx context.Context, emails []Email) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, blobReadConcurrency)
+	for i := range emails {
+		if emails[i].BodySHA256 == "" {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			if err := s.loadBody(ctx, &emails[i]); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// RunBlobGC collects blobs every interval until ctx is done.
+func (s *Store) RunBlobGC(ctx context.Context, interval, grace time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.CollectBlobs(ctx, time.Now(), grace); err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "Failed to collect blobs", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CollectBlobs drops the references of emails that are gone by now and
+// deletes the content that has had none for grace. It returns how many
+// blobs were deleted.
+func (s *Store) CollectBlobs(ctx context.Context, now time.Time, grace time.Duration) (int, error) {
+	if s.blobs == nil {
+		return 0, nil
+	}
+	start := time.Now()
+	defer func() {
+		metrics.RedisOperationDuration.WithLabelValues("collect_blobs").Observe(time.Since(start).Seconds())
+	}()
+
+	due, err := s.client.ZRangeByScore(ctx, blobGCKey, &redis.ZRangeBy{
+		Min: "-inf", Max: strconv.FormatInt(now.Unix(), 10), Count: blobBatchSize,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+	for _, sum := range due {
+		if err := s.checkBlobRefs(ctx, sum, now); err != nil {
+			return 0, err
+		}
+	}
+
+	cutoff := now.Add(-grace).Unix()
+	orphans, err := s.client.ZRangeByScore(ctx, blobOrphansKey, &redis.ZRangeBy{
+		Min: "-inf", Max: strconv.FormatInt(cutoff, 10), Count: blobBatchSize,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, sum := range orphans {
+		claimed, err := s.claimOrphan(ctx, sum, cutoff)
+		if err != nil {
+			return deleted, err
+		}
+		if !claimed {
+			continue
+		}
+		if err := s.deleteBlob(ctx, sum); err != nil {
+			// Tracked again so the next run retries it
+			s.client.ZAdd(ctx, blobOrphansKey, redis.Z{Score: float64(cutoff), Member: sum})
+			return deleted, fmt.Errorf("failed to delete blob: %w", err)
+		}
+		deleted++
+		metrics.BlobsDeletedTotal.Inc()
+	}
+	return deleted, nil
+}
+
+// deleteBlob deletes claimed content and clears its mark, letting writes of
+// the same content go ahead.
+func (s *Store) deleteBlob(ctx context.Context, sum string) error {
+	deleteCtx, cancel := context.WithTimeout(ctx, blobDeleteTimeout)
+	defer cancel()
+	err := s.blobs.Delete(deleteCtx, sum)
+	if clearErr := s.client.Del(ctx, blobDeletingKey(sum)).Err(); clearErr != nil {
+		// The mark expires on its own; writes wait until then
+		slog.WarnContext(ctx, "Failed to clear blob deletion mark", "sha256", sum, "error", clearErr)
+	}
+	return err
+}
+
+// checkBlobRefs drops the references to sum of emails that are gone and
+// reschedules those of emails that stay. Content left without references
+// becomes an orphan.
+func (s *Store) checkBlobRefs(ctx context.Context, sum string, now time.Time) error {
+	key := blobRefsKey(sum)
+	check := func(tx *redis.Tx) error {
+		due, err := tx.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: "-inf", Max: strconv.FormatInt(now.Unix(), 10)}).Result()
+		if err != nil {
+			return err
+		}
+		later, err := tx.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: "(" + strconv.FormatInt(now.Unix(), 10), Max: "+inf", Count: 1}).Result()
+		if err != nil {
+			return err
+		}
+		var next float64
+		if len(later) > 0 {
+			next = later[0].Score
+		}
+
+		var gone []any
+		live := map[string]float64{}
+		for _, ref := range due {
+			until, ok, err := s.refUntil(ctx, tx, ref, now)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				gone = append(gone, ref)
+				continue
+			}
+			score := float64(until.Unix())
+			live[ref] = score
+			if next == 0 || score < next {
+				next = score
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if len(gone) > 0 {
+				pipe.ZRem(ctx, key, gone...)
+			}
+			for ref, score := range live {
+				pipe.ZAdd(ctx, key, redis.Z{Score: score, Member: ref})
+			}
+			if next == 0 {
+				pipe.ZRem(ctx, blobGCKey, sum)
+				pipe.ZAdd(ctx, blobOrphansKey, redis.Z{Score: float64(now.Unix()), Member: sum})
+			} else {
+				pipe.ZAdd(ctx, blobGCKey, redis.Z{Score: next, Member: sum})
+			}
+			return nil
+		})
+		return err
+	}
+
+	for range maxBlobAttempts {
+		err := s.client.Watch(ctx, check, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return redis.TxFailedErr
+}
+
+// refUntil reports whether the email named by ref is still stored, and
+// until when at least. Holds are checked once they end, so they are gone.
+func (s *Store) refUntil(ctx context.Context, tx *redis.Tx, ref string, now time.Time) (time.Time, bool, error) {
+	if strings.HasPrefix(ref, blobHoldPrefix) {
+		return time.Time{}, false, nil
+	}
+	hKey, id, _ := strings.Cut(ref, "/")
+	data, err := tx.HGet(ctx, hKey, id).Result()
+	if err == redis.Nil {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, err
+	}
+	var email struct {
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := s.codec.decode(ctx, []byte(data), &email); errors.Is(err, errDictionaryGone) {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, err
+	}
+	if !email.ExpiresAt.IsZero() && !now.Before(email.ExpiresAt) {
+		return time.Time{}, false, nil
+	}
+
+	// The folder's expiry moves with every email saved to it
+	ttl, err := tx.PTTL(ctx, hKey).Result()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if ttl <= 0 {
+		ttl = DefaultRetention
+	}
+	until := now.Add(ttl)
+	if !email.ExpiresAt.IsZero() && email.ExpiresAt.Before(until) {
+		until = email.ExpiresAt
+	}
+	return until, true, nil
+}
+
+// claimOrphanScript removes a digest from the orphans if it has not been
+// written since the cutoff, and returns 1 and marks it as being deleted if
+// it has no references either, so its content can be deleted.
+//
+// KEYS[1] is the orphans sorted set, KEYS[2] the references of the digest
+// ARGV[1] and KEYS[3] its deletion mark; ARGV[2] is the cutoff in Unix
+// seconds and ARGV[3] how long the mark lasts in milliseconds.
+var claimOrphanScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score or tonumber(score) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+if redis.call('ZCARD', KEYS[2]) > 0 then
+	return 0
+end
+redis.call('SET', KEYS[3], '1', 'PX', ARGV[3])
+return 1
+`)
+
+// claimOrphan reports whether sum still has no references and has not been
+// written since cutoff, removing it from the orphans and marking it as
+// being deleted if so.
+func (s *Store) claimOrphan(ctx context.Context, sum string, cutoff int64) (bool, error) {
+	keys := []string{blobOrphansKey, blobRefsKey(sum), blobDeletingKey(sum)}
+	claimed, err := claimOrphanScript.Run(ctx, s.client, keys, sum, cutoff, (2 * blobDeleteTimeout).Milliseconds()).Int()
+	return claimed == 1, err
+}
@@ -0,0 +1,266 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/blob"
+)
+
+func newTestBlobStore(t *testing.T) (*Store, string) {
+	t.Helper()
+
+	s, _ := newTestStore(t)
+	dir := t.TempDir()
+	blobs, err := blob.NewFS(dir)
+	if err != nil {
+		t.Fatalf("NewFS() error = %v", err)
+	}
+	s.UseBlobs(blobs)
+	return s, dir
+}
+
+func storedBlobs(t *testing.T, dir string) int {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestSaveEmail_Blobs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, dir := newTestBlobStore(t)
+
+	body := strings.Repeat("<p>The same newsletter</p>", 100)
+	logo := []byte("\x89PNG")
+	source := []byte("Subject: Newsletter\r\n\r\n" + body)
+	for _, address := range []string{"inbox-a", "inbox-b"} {
+		email := Email{
+			ID:      "email-" + address,
+			Subject: "Newsletter",
+			Body:    body,
+			Inline:  []InlinePart{{ContentID: "logo", ContentType: "image/png", Size: len(logo), Data: logo}},
+			Source:  source,
+		}
+		if err := s.SaveEmail(ctx, address, email, time.Hour); err != nil {
+			t.Fatalf("SaveEmail() error = %v", err)
+		}
+		if email.Inline[0].Data == nil {
+			t.Fatal("SaveEmail() cleared the caller's inline data")
+		}
+	}
+
+	// Both inboxes share one copy of each, and Redis keeps neither
+	if n := storedBlobs(t, dir); n != 3 {
+		t.Fatalf("stored blobs = %d, want 3", n)
+	}
+	raw, err := s.client.HGet(ctx, "emails:inbox-a", "email-inbox-a").Result()
+	if err != nil {
+		t.Fatalf("HGet() error = %v", err)
+	}
+	var saved Email
+	if err := json.Unmarshal([]byte(raw), &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Body != "" || saved.BodySHA256 == "" || saved.Inline[0].SHA256 == "" || saved.Source != nil || saved.SourceSHA256 == "" {
+		t.Fatalf("saved email = %+v, want content in the blob store", saved)
+	}
+	if n, _ := s.client.HLen(ctx, inlineKey("inbox-a")).Result(); n != 0 {
+		t.Fatalf("inline hash has %d fields, want 0", n)
+	}
+	if n, _ := s.client.HLen(ctx, sourceKey("inbox-a")).Result(); n != 0 {
+		t.Fatalf("source hash has %d fields, want 0", n)
+	}
+
+	got, err := s.GetEmail(ctx, "inbox-a", "email-inbox-a")
+	if err != nil || got == nil || got.Body != body {
+		t.Fatalf("GetEmail() = %v, %v, want the body read back", got, err)
+	}
+	emails, err := s.GetEmails(ctx, "inbox-b")
+	if err != nil || len(emails) != 1 || emails[0].Body != body {
+		t.Fatalf("GetEmails() = %v, %v, want the body read back", emails, err)
+	}
+	part, err := s.GetInlinePart(ctx, "inbox-b", "email-inbox-b", "logo")
+	if err != nil || part == nil || string(part.Data) != string(logo) {
+		t.Fatalf("GetInlinePart() = %+v, %v, want the logo", part, err)
+	}
+	if got, err := s.GetSource(ctx, "inbox-b", "email-inbox-b"); err != nil || string(got) != string(source) {
+		t.Fatalf("GetSource() = %q, %v, want the source read back", got, err)
+	}
+}
+
+func TestCollectBlobs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	grace := time.Hour
+
+	t.Run("deleted emails release their content", func(t *testing.T) {
+		t.Parallel()
+
+		s, dir := newTestBlobStore(t)
+		for _, address := range []string{"inbox-a", "inbox-b"} {
+			if err := s.SaveEmail(ctx, address, Email{ID: "email", Body: "shared body"}, 24*time.Hour); err != nil {
+				t.Fatalf("SaveEmail() error = %v", err)
+			}
+		}
+
+		if err := s.DeleteEmail(ctx, "inbox-a", "email"); err != nil {
+			t.Fatalf("DeleteEmail() error = %v", err)
+		}
+		now := time.Now()
+		if n, err := s.CollectBlobs(ctx, now.Add(2*grace), grace); err != nil || n != 0 {
+			t.Fatalf("CollectBlobs() = %d, %v, want 0 while inbox-b has the body", n, err)
+		}
+
+		if _, err := s.ClearInbox(ctx, "inbox-b"); err != nil {
+			t.Fatalf("ClearInbox() error = %v", err)
+		}
+		if n, err := s.CollectBlobs(ctx, now, grace); err != nil || n != 0 {
+			t.Fatalf("CollectBlobs() = %d, %v, want 0 within the grace period", n, err)
+		}
+		if n, err := s.CollectBlobs(ctx, now.Add(2*grace), grace); err != nil || n != 1 {
+			t.Fatalf("CollectBlobs() = %d, %v, want 1", n, err)
+		}
+		if n := storedBlobs(t, dir); n != 0 {
+			t.Fatalf("stored blobs = %d, want 0", n)
+		}
+	})
+
+	t.Run("expired emails release their content", func(t *testing.T) {
+		t.Parallel()
+
+		s, dir := newTestBlobStore(t)
+		if err := s.SaveEmail(ctx, "inbox", Email{ID: "kept", Body: "kept body"}, 24*time.Hour); err != nil {
+			t.Fatalf("SaveEmail() error = %v", err)
+		}
+		expiring := Email{ID: "expiring", Body: "expiring body", ExpiresAt: time.Now().Add(time.Minute)}
+		if err := s.SaveEmail(ctx, "inbox", expiring, 24*time.Hour); err != nil {
+			t.Fatalf("SaveEmail() error = %v", err)
+		}
+
+		// The inbox is still there, so only the expiring email is gone
+		now := time.Now().Add(2 * time.Minute)
+		if n, err := s.CollectBlobs(ctx, now, grace); err != nil || n != 0 {
+			t.Fatalf("CollectBlobs() = %d, %v, want 0 within the grace period", n, err)
+		}
+		if n, err := s.CollectBlobs(ctx, now.Add(2*grace), grace); err != nil || n != 1 {
+			t.Fatalf("CollectBlobs() = %d, %v, want 1", n, err)
+		}
+		if n := storedBlobs(t, dir); n != 1 {
+			t.Fatalf("stored blobs = %d, want the kept body", n)
+		}
+		got, err := s.GetEmail(ctx, "inbox", "kept")
+		if err != nil || got == nil || got.Body != "kept body" {
+			t.Fatalf("GetEmail() = %v, %v, want the kept body", got, err)
+		}
+	})
+
+	t.Run("content of unsaved messages is collected", func(t *testing.T) {
+		t.Parallel()
+
+		s, dir := newTestBlobStore(t)
+		if _, err := s.Blobs().Put(ctx, strings.NewReader("rejected attachment")); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		if n, err := s.CollectBlobs(ctx, time.Now().Add(2*grace), grace); err != nil || n != 1 {
+			t.Fatalf("CollectBlobs() = %d, %v, want 1", n, err)
+		}
+		if n := storedBlobs(t, dir); n != 0 {
+			t.Fatalf("stored blobs = %d, want 0", n)
+		}
+	})
+
+	t.Run("content written again is kept for its new email", func(t *testing.T) {
+		t.Parallel()
+
+		s, dir := newTestBlobStore(t)
+		if _, err := s.Blobs().Put(ctx, strings.NewReader("attachment")); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		ref, err := s.Blobs().Put(ctx, strings.NewReader("attachment"))
+		if err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		email := Email{ID: "email", Structure: &MIMEPart{ContentType: "application/pdf", SHA256: ref.SHA256}}
+		if err := s.SaveEmail(ctx, "inbox", email, 24*time.Hour); err != nil {
+			t.Fatalf("SaveEmail() error = %v", err)
+		}
+		if n, err := s.CollectBlobs(ctx, time.Now().Add(2*grace), grace); err != nil || n != 0 {
+			t.Fatalf("CollectBlobs() = %d, %v, want 0 while the email exists", n, err)
+		}
+		if n := storedBlobs(t, dir); n != 1 {
+			t.Fatalf("stored blobs = %d, want 1", n)
+		}
+	})
+
+	t.Run("held content is kept until the hold ends", func(t *testing.T) {
+		t.Parallel()
+
+		s, dir := newTestBlobStore(t)
+		ref, err := s.Blobs().Put(ctx, strings.NewReader("spooled attachment"))
+		if err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		now := time.Now()
+		if err := blob.Hold(ctx, s.Blobs(), "spool/inbox/email", now.Add(3*grace), ref.SHA256); err != nil {
+			t.Fatalf("Hold() error = %v", err)
+		}
+		if n, err := s.CollectBlobs(ctx, now.Add(2*grace), grace); err != nil || n != 0 {
+			t.Fatalf("CollectBlobs() = %d, %v, want 0 while held", n, err)
+		}
+		if n, err := s.CollectBlobs(ctx, now.Add(4*grace), grace); err != nil || n != 0 {
+			t.Fatalf("CollectBlobs() = %d, %v, want 0 within the grace period", n, err)
+		}
+		if n, err := s.CollectBlobs(ctx, now.Add(6*grace), grace); err != nil || n != 1 {
+			t.Fatalf("CollectBlobs() = %d, %v, want 1", n, err)
+		}
+		if n := storedBlobs(t, dir); n != 0 {
+			t.Fatalf("stored blobs = %d, want 0", n)
+		}
+	})
+
+	t.Run("content written while it is deleted is written again", func(t *testing.T) {
+		t.Parallel()
+
+		s, dir := newTestBlobStore(t)
+		ref, err := s.Blobs().Put(ctx, strings.NewReader("attachment"))
+		if err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		claimed, err := s.claimOrphan(ctx, ref.SHA256, time.Now().Add(2*grace).Unix())
+		if err != nil || !claimed {
+			t.Fatalf("claimOrphan() = %v, %v, want true", claimed, err)
+		}
+
+		done := make(chan error)
+		go func() {
+			_, err := s.Blobs().Put(ctx, strings.NewReader("attachment"))
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("Put() = %v during the deletion, want it to wait", err)
+		case <-time.After(5 * blobDeletePoll):
+		}
+
+		if err := s.deleteBlob(ctx, ref.SHA256); err != nil {
+			t.Fatalf("deleteBlob() error = %v", err)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		if n := storedBlobs(t, dir); n != 1 {
+			t.Fatalf("stored blobs = %d, want the content written again", n)
+		}
+	})
+}
@@ -0,0 +1,421 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/metrics"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"github.com/redis/go-redis/v9"
+)
+
+// Emails are stored as records: JSON, as they always were, or with
+// compression enabled, recordZstd followed by a zstd frame of the JSON.
+// Records are told apart by their first byte, which is '{' for JSON, so
+// both kinds are read whether compression is enabled or not.
+//
+// Mail to many inboxes shares templates, which compress well against each
+// other but not within one email, so frames use a dictionary trained on
+// recent records. The frame names its dictionary, which is kept in Redis
+// and loaded when a record needs it, so records stay readable after
+// retraining and by other instances. Instances record hourly that they
+// still compress with their dictionary; once none has for longer than any
+// domain keeps an email, it is deleted, and records still using it read as
+// expired.
+const recordZstd = 0x01
+
+const (
+	// dictsKey is the hash of dictionaries by ID and dictCurrentKey the ID
+	// of the one new records use. dictUsedKey scores the IDs by when they
+	// were last recorded as used, in Unix seconds.
+	dictsKey       = "zstd_dicts"
+	dictCurrentKey = "zstd_dict_current"
+	dictUsedKey    = "zstd_dict_used"
+)
+
+// Dictionary training keeps the latest dictSamples records, up to
+// dictSampleSize bytes of each, and trains again once dictRetrainInterval
+// has passed. A dictionary in use is recorded as used every
+// dictMarkInterval, and is no longer compressed with once dictUseLimit
+// passes without that succeeding.
+const (
+	dictSamples         = 512
+	dictSampleSize      = 64 << 10
+	dictMaxSize         = 32 << 10
+	dictRetrainInterval = 24 * time.Hour
+	dictMarkInterval    = time.Hour
+	dictUseLimit        = 2 * dictMarkInterval
+)
+
+// errDictionaryGone is returned for records compressed with a dictionary
+// that has been deleted.
+var errDictionaryGone = errors.New("compression dictionary deleted")
+
+// codec encodes and decodes email records.
+type codec struct {
+	client *redis.Client
+
+	mu sync.RWMutex
+	// encoder is nil while compression is disabled. It compresses with
+	// dictionary dictID, or without one when that is 0, like plain.
+	encoder *zstd.Encoder
+	plain   *zstd.Encoder
+	dictID  uint32
+	// markedAt is when dictID was last recorded as used, and marking is
+	// set while that is being recorded.
+	markedAt time.Time
+	marking  bool
+	// keep is how long dictionaries are kept after their last use.
+	keep time.Duration
+
+	// samples is a ring of recent records, next its oldest.
+	samples   [][]byte
+	next      int
+	trainedAt time.Time
+	training  bool
+
+	// decoders are by dictionary ID, 0 for none, and loaded when a record
+	// needs them. dmu is held for reading while one decodes, so they are
+	// closed only once no read is using them.
+	dmu      sync.RWMutex
+	decoders map[uint32]*zstd.Decoder
+}
+
+// UseCompression compresses the emails saved from now on, with the
+// dictionary other instances trained if there is one. keep is the longest
+// retention of any domain: dictionaries unused for that long are deleted.
+func (s *Store) UseCompression(ctx context.Context, keep time.Duration) error {
+	return s.codec.enable(ctx, keep)
+}
+
+func (c *codec) enable(ctx context.Context, keep time.Duration) error {
+	if keep <= 0 {
+		keep = DefaultRetention
+	}
+	plain, err := newEncoder(nil)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.keep, c.encoder, c.plain = keep, plain, plain
+	c.mu.Unlock()
+	if err := c.prune(ctx); err != nil {
+		return err
+	}
+
+	field, err := c.client.Get(ctx, dictCurrentKey).Result()
+	if err == redis.Nil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	id, err := strconv.ParseUint(field, 10, 32)
+	if err != nil {
+		return nil
+	}
+	at := time.Now()
+	if ok, err := c.mark(ctx, uint32(id), at); err != nil || !ok {
+		return err
+	}
+	current, err := c.client.HGet(ctx, dictsKey, field).Bytes()
+	if err != nil {
+		return err
+	}
+	if err := c.use(uint32(id), current, at); err != nil {
+		return err
+	}
+	// Another instance trained it; this one trains its own a day on
+	c.mu.Lock()
+	c.trainedAt = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+func newEncoder(dictionary []byte) (*zstd.Encoder, error) {
+	opts := []zstd.EOption{zstd.WithEncoderLevel(zstd.SpeedDefault)}
+	if dictionary != nil {
+		opts = append(opts, zstd.WithEncoderDict(dictionary))
+	}
+	return zstd.NewWriter(nil, opts...)
+}
+
+// use compresses new records with dictionary id, recorded as used at.
+func (c *codec) use(id uint32, dictionary []byte, at time.Time) error {
+	encoder, err := newEncoder(dictionary)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.encoder, c.dictID, c.markedAt = encoder, id, at
+	return nil
+}
+
+// markScript records dictionary ARGV[1] as used at ARGV[2] if it still
+// exists, and returns whether it does. KEYS[1] is dictsKey and KEYS[2]
+// dictUsedKey.
+var markScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZADD', KEYS[2], 'GT', ARGV[2], ARGV[1])
+return 1
+`)
+
+// mark records dictionary id as used at, and reports false if it has been
+// deleted.
+func (c *codec) mark(ctx context.Context, id uint32, at time.Time) (bool, error) {
+	n, err := markScript.Run(ctx, c.client, []string{dictsKey, dictUsedKey}, id, at.Unix()).Int()
+	return n == 1, err
+}
+
+// markUsed records in the background that the dictionary is still used.
+func (c *codec) markUsed() {
+	c.mu.Lock()
+	id := c.dictID
+	due := id != 0 && !c.marking && time.Since(c.markedAt) >= dictMarkInterval
+	if due {
+		c.marking = true
+	}
+	c.mu.Unlock()
+	if !due {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		at := time.Now()
+		ok, err := c.mark(ctx, id, at)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to record compression dictionary use", "id", id, "error", err)
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.marking = false
+		if err != nil || c.dictID != id {
+			return
+		}
+		if ok {
+			c.markedAt = at
+		} else {
+			// Deleted by another instance while this one could not record it
+			c.encoder, c.dictID = c.plain, 0
+		}
+	}()
+}
+
+// pruneScript deletes the dictionaries last used before ARGV[1] and returns
+// their IDs. KEYS[1] is dictsKey and KEYS[2] dictUsedKey.
+var pruneScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', '(' .. ARGV[1])
+for _, id in ipairs(ids) do
+	redis.call('HDEL', KEYS[1], id)
+	redis.call('ZREM', KEYS[2], id)
+end
+return ids
+`)
+
+// prune deletes the dictionaries no instance has used for keep, so every
+// record compressed with them has expired, and closes the decoders of
+// dictionaries deleted here or by other instances.
+func (c *codec) prune(ctx context.Context) error {
+	c.mu.RLock()
+	keep := c.keep
+	c.mu.RUnlock()
+	// A dictionary is used for up to dictUseLimit after it was recorded
+	cutoff := time.Now().Add(-keep - dictUseLimit).Unix()
+	deleted, err := pruneScript.Run(ctx, c.client, []string{dictsKey, dictUsedKey}, cutoff).StringSlice()
+	if err != nil {
+		return err
+	}
+	if len(deleted) > 0 {
+		slog.InfoContext(ctx, "Deleted compression dictionaries", "ids", deleted)
+	}
+
+	pipe := c.client.Pipeline()
+	exists := make(map[uint32]*redis.BoolCmd)
+	c.dmu.RLock()
+	for id := range c.decoders {
+		if id != 0 {
+			exists[id] = pipe.HExists(ctx, dictsKey, strconv.FormatUint(uint64(id), 10))
+		}
+	}
+	c.dmu.RUnlock()
+	if len(exists) == 0 {
+		return nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+	for id, cmd := range exists {
+		if decoder := c.decoders[id]; decoder != nil && !cmd.Val() {
+			decoder.Close()
+			delete(c.decoders, id)
+		}
+	}
+	return nil
+}
+
+// encode returns the record of v.
+func (c *codec) encode(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	encoder, plain, id, markedAt := c.encoder, c.plain, c.dictID, c.markedAt
+	c.mu.RUnlock()
+	if encoder == nil {
+		return data, nil
+	}
+	c.sample(data)
+	if id != 0 && time.Since(markedAt) >= dictMarkInterval {
+		c.markUsed()
+		if time.Since(markedAt) >= dictUseLimit {
+			// The dictionary may be deleted before the record expires
+			encoder = plain
+		}
+	}
+
+	record := encoder.EncodeAll(data, []byte{recordZstd})
+	if len(record) >= len(data) {
+		// Small records can grow
+		record = data
+	}
+	metrics.StoreRecordBytesTotal.WithLabelValues("uncompressed").Add(float64(len(data)))
+	metrics.StoreRecordBytesTotal.WithLabelValues("stored").Add(float64(len(record)))
+	metrics.StoreBytesSavedTotal.Add(float64(len(data) - len(record)))
+	return record, nil
+}
+
+// decode reads a record into v.
+func (c *codec) decode(ctx context.Context, record []byte, v any) error {
+	if len(record) == 0 || record[0] != recordZstd {
+		return json.Unmarshal(record, v)
+	}
+	data, err := c.decompress(ctx, record[1:])
+	if err != nil {
+		return fmt.Errorf("failed to decompress email: %w", err)
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (c *codec) decompress(ctx context.Context, frame []byte) ([]byte, error) {
+	var header zstd.Header
+	if err := header.Decode(frame); err != nil {
+		return nil, err
+	}
+	id := header.DictionaryID
+
+	c.dmu.RLock()
+	if decoder := c.decoders[id]; decoder != nil {
+		defer c.dmu.RUnlock()
+		return decoder.DecodeAll(frame, nil)
+	}
+	c.dmu.RUnlock()
+
+	decoder, err := c.loadDecoder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.dmu.Lock()
+	if c.decoders == nil {
+		c.decoders = make(map[uint32]*zstd.Decoder)
+	}
+	if c.decoders[id] != nil {
+		// Loaded by another read meanwhile
+		decoder.Close()
+	} else {
+		c.decoders[id] = decoder
+	}
+	c.dmu.Unlock()
+	return c.decompress(ctx, frame)
+}
+
+// loadDecoder reads dictionary id from Redis and returns a decoder for it.
+func (c *codec) loadDecoder(ctx context.Context, id uint32) (*zstd.Decoder, error) {
+	opts := []zstd.DOption{zstd.WithDecoderConcurrency(0)}
+	if id != 0 {
+		d, err := c.client.HGet(ctx, dictsKey, strconv.FormatUint(uint64(id), 10)).Bytes()
+		if err == redis.Nil {
+			return nil, errDictionaryGone
+		} else if err != nil {
+			return nil, err
+		}
+		opts = append(opts, zstd.WithDecoderDicts(d))
+	}
+	return zstd.NewReader(nil, opts...)
+}
+
+// sample keeps data for training and starts training once there are
+// enough samples and the dictionary is due.
+func (c *codec) sample(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data = data[:min(len(data), dictSampleSize)]
+	if len(c.samples) < dictSamples {
+		c.samples = append(c.samples, data)
+	} else {
+		c.samples[c.next] = data
+		c.next = (c.next + 1) % dictSamples
+	}
+	if c.training || len(c.samples) < dictSamples || !c.trainedAt.IsZero() && time.Since(c.trainedAt) < dictRetrainInterval {
+		return
+	}
+
+	samples := c.samples
+	c.samples, c.next, c.training = nil, 0, true
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := c.train(ctx, samples); err != nil {
+			slog.WarnContext(ctx, "Failed to train compression dictionary", "error", err)
+		}
+	}()
+}
+
+// train builds a dictionary from samples, saves it for other instances and
+// uses it for new records, then deletes the dictionaries no longer used.
+func (c *codec) train(ctx context.Context, samples [][]byte) error {
+	defer func() {
+		c.mu.Lock()
+		c.training = false
+		c.trainedAt = time.Now()
+		c.mu.Unlock()
+	}()
+
+	d, err := dict.BuildZstdDict(samples, dict.Options{MaxDictSize: dictMaxSize, HashBytes: 6})
+	if err != nil {
+		return err
+	}
+	inspected, err := zstd.InspectDictionary(d)
+	if err != nil {
+		return err
+	}
+	id := strconv.FormatUint(uint64(inspected.ID()), 10)
+
+	at := time.Now()
+	pipe := c.client.TxPipeline()
+	pipe.HSet(ctx, dictsKey, id, d)
+	pipe.ZAdd(ctx, dictUsedKey, redis.Z{Score: float64(at.Unix()), Member: id})
+	pipe.Set(ctx, dictCurrentKey, id, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if err := c.use(inspected.ID(), d, at); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Trained compression dictionary", "id", id, "size", len(d), "samples", len(samples))
+	return c.prune(ctx)
+}
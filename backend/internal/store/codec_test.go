@@ -0,0 +1,196 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// template is a newsletter as received by many inboxes, with the parts that
+// differ between them.
+func template(i int) Email {
+	return Email{
+		ID:      fmt.Sprintf("email-%d", i),
+		From:    "news@shop.example",
+		To:      []string{fmt.Sprintf("customer-%d@coresend.test", i)},
+		Subject: "Your weekly deals",
+		Body: fmt.Sprintf("<html><body><p>Hello customer %d,</p>", i) +
+			strings.Repeat(`<table class="deal"><tr><td><img src="https://shop.example/img/deal.png"></td><td>Deal of the week</td></tr></table>`, 5) +
+			fmt.Sprintf(`<a href="https://shop.example/unsubscribe?u=%d">Unsubscribe</a></body></html>`, i),
+		ReceivedAt:  time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		ContentType: "text/html",
+	}
+}
+
+func TestCodec_Compression(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, mr := newTestStore(t)
+
+	// Saved before compression was enabled
+	legacy := template(0)
+	if err := s.SaveEmail(ctx, "inbox", legacy, time.Hour); err != nil {
+		t.Fatalf("SaveEmail() error = %v", err)
+	}
+	if err := s.UseCompression(ctx, time.Hour); err != nil {
+		t.Fatalf("UseCompression() error = %v", err)
+	}
+
+	email := template(1)
+	if err := s.SaveEmail(ctx, "inbox", email, time.Hour); err != nil {
+		t.Fatalf("SaveEmail() error = %v", err)
+	}
+	record := mr.HGet("emails:inbox", email.ID)
+	if record[0] != recordZstd || len(record) >= len(email.Body) {
+		t.Fatalf("record = %d bytes starting with %q, want a smaller zstd record", len(record), record[0])
+	}
+
+	// A short email stays JSON rather than growing
+	short := Email{ID: "short", Subject: "hi"}
+	if err := s.SaveEmail(ctx, "inbox", short, time.Hour); err != nil {
+		t.Fatalf("SaveEmail() error = %v", err)
+	}
+	if record := mr.HGet("emails:inbox", short.ID); record[0] != '{' {
+		t.Fatalf("record = %q, want JSON", record)
+	}
+
+	for _, want := range []Email{legacy, email} {
+		got, err := s.GetEmail(ctx, "inbox", want.ID)
+		if err != nil || got == nil {
+			t.Fatalf("GetEmail(%s) = %v, %v", want.ID, got, err)
+		}
+		if !reflect.DeepEqual(*got, want) {
+			t.Fatalf("GetEmail(%s) = %+v\nwant %+v", want.ID, *got, want)
+		}
+	}
+	emails, err := s.GetEmails(ctx, "inbox")
+	if err != nil || len(emails) != 3 {
+		t.Fatalf("GetEmails() = %d emails, %v, want 3", len(emails), err)
+	}
+}
+
+func TestCodec_Dictionary(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, mr := newTestStore(t)
+	if err := s.UseCompression(ctx, time.Hour); err != nil {
+		t.Fatalf("UseCompression() error = %v", err)
+	}
+
+	// Started before the dictionary exists, like another instance
+	other := NewStore(mr.Addr(), "")
+	t.Cleanup(func() { _ = other.client.Close() })
+	if err := other.UseCompression(ctx, time.Hour); err != nil {
+		t.Fatalf("UseCompression() error = %v", err)
+	}
+
+	without, err := s.codec.encode(template(1))
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+
+	train(t, s)
+
+	email := template(1)
+	if err := s.SaveEmail(ctx, "inbox", email, time.Hour); err != nil {
+		t.Fatalf("SaveEmail() error = %v", err)
+	}
+	with := mr.HGet("emails:inbox", email.ID)
+	if len(with) >= len(without) {
+		t.Fatalf("record with dictionary = %d bytes, want less than %d without", len(with), len(without))
+	}
+
+	got, err := other.GetEmail(ctx, "inbox", email.ID)
+	if err != nil || got == nil || !reflect.DeepEqual(*got, email) {
+		t.Fatalf("GetEmail() from another instance = %+v, %v, want the email", got, err)
+	}
+	// Only the dictionary of the record was loaded
+	if ids := slices.Collect(maps.Keys(other.codec.decoders)); !reflect.DeepEqual(ids, []uint32{s.codec.dictID}) {
+		t.Fatalf("decoders = %v, want only dictionary %d", ids, s.codec.dictID)
+	}
+}
+
+func TestCodec_DeletesUnusedDictionaries(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, mr := newTestStore(t)
+	if err := s.UseCompression(ctx, time.Hour); err != nil {
+		t.Fatalf("UseCompression() error = %v", err)
+	}
+	other := NewStore(mr.Addr(), "")
+	t.Cleanup(func() { _ = other.client.Close() })
+	if err := other.UseCompression(ctx, time.Hour); err != nil {
+		t.Fatalf("UseCompression() error = %v", err)
+	}
+
+	train(t, s)
+	old := strconv.FormatUint(uint64(s.codec.dictID), 10)
+	email := template(1)
+	if err := s.SaveEmail(ctx, "inbox", email, time.Hour); err != nil {
+		t.Fatalf("SaveEmail() error = %v", err)
+	}
+	if got, err := other.GetEmail(ctx, "inbox", email.ID); err != nil || got == nil {
+		t.Fatalf("GetEmail() = %v, %v, want the email", got, err)
+	}
+
+	// Last used longer ago than the retention, then replaced
+	if _, err := mr.ZAdd(dictUsedKey, float64(time.Now().Add(-4*time.Hour).Unix()), old); err != nil {
+		t.Fatal(err)
+	}
+	mr.HSet(dictsKey, "1", "newer")
+	if _, err := mr.ZAdd(dictUsedKey, float64(time.Now().Unix()), "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.codec.prune(ctx); err != nil {
+		t.Fatalf("prune() error = %v", err)
+	}
+	if fields, _ := mr.HKeys(dictsKey); !reflect.DeepEqual(fields, []string{"1"}) {
+		t.Fatalf("dictionaries = %v, want only the one still used", fields)
+	}
+	if used, _ := mr.ZMembers(dictUsedKey); !reflect.DeepEqual(used, []string{"1"}) {
+		t.Fatalf("used dictionaries = %v, want only the one still used", used)
+	}
+
+	// The other instance closes its decoder, and the record reads as expired
+	if err := other.codec.prune(ctx); err != nil {
+		t.Fatalf("prune() error = %v", err)
+	}
+	if len(other.codec.decoders) != 0 {
+		t.Fatalf("decoders = %v, want none", other.codec.decoders)
+	}
+	if got, err := other.GetEmail(ctx, "inbox", email.ID); err != nil || got != nil {
+		t.Fatalf("GetEmail() = %v, %v, want nil", got, err)
+	}
+	emails, err := other.GetEmails(ctx, "inbox")
+	if err != nil || len(emails) != 0 {
+		t.Fatalf("GetEmails() = %v, %v, want none", emails, err)
+	}
+	if mr.Exists("emails:inbox") {
+		t.Fatal("record kept, want it removed")
+	}
+}
+
+// train trains a dictionary for s on newsletters.
+func train(t *testing.T, s *Store) {
+	t.Helper()
+	var samples [][]byte
+	for i := range 64 {
+		record, err := (&codec{}).encode(template(100 + i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, record)
+	}
+	if err := s.codec.train(context.Background(), samples); err != nil {
+		t.Fatalf("train() error = %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// FilterRule decides what happens to mail an address receives. Every
+// condition that is set must match; a rule needs at least one.
+type FilterRule struct {
+	ID string `json:"id"`
+	// FromGlob matches the envelope sender, ignoring case; * and ? are
+	// wildcards.
+	FromGlob string `json:"from_glob,omitempty"`
+	// SubjectRegex matches anywhere in the subject.
+	SubjectRegex string `json:"subject_regex,omitempty"`
+	// Header names a header that must be present and, when HeaderContains
+	// is set, contain it, ignoring case.
+	Header         string `json:"header,omitempty"`
+	HeaderContains string `json:"header_contains,omitempty"`
+	// MinSize matches messages of at least this many bytes.
+	MinSize int64 `json:"min_size,omitempty"`
+	// Action is drop, reject, label or expire. Label and ExpireMinutes go
+	// with the last two.
+	Action        string    `json:"action"`
+	Label         string    `json:"label,omitempty"`
+	ExpireMinutes int       `json:"expire_minutes,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ErrNotRegistered is returned for data kept with an address registration
+// when the address is not registered.
+var ErrNotRegistered = errors.New("address is not registered")
+
+// ErrLimitReached is returned when an address already has as many of
+// something as it may.
+var ErrLimitReached = errors.New("limit reached")
+
+// FilterStore keeps the filtering rules of addresses with their
+// registrations.
+type FilterStore interface {
+	// AddFilterRule adds a rule unless the address has max rules already.
+	AddFilterRule(ctx context.Context, addressBox string, rule FilterRule, max int) error
+	// ListFilterRules returns the rules of an address, oldest first.
+	ListFilterRules(ctx context.Context, addressBox string) ([]FilterRule, error)
+	DeleteFilterRule(ctx context.Context, addressBox string, ruleID string) (bool, error)
+}
+
+// filtersKey is the hash of an address's filtering rules by ID.
+func filtersKey(addressBox string) string {
+	return fmt.Sprintf("filters:%s", addressBox)
+}
+
+// addFilterRuleScript adds a rule to the registered address's rules, which
+// expire with its registration, unless it has ARGV[3] already. It returns 1
+// when added, 0 at the limit and -1 when the address is not registered.
+//
+// KEYS[1] is the rules hash and KEYS[2] the registration; ARGV[1] is the
+// rule's ID and ARGV[2] the rule.
+var addFilterRuleScript = redis.NewScript(`
+local ttl = redis.call('PTTL', KEYS[2])
+if ttl == -2 then
+	return -1
+end
+if redis.call('HLEN', KEYS[1]) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+if ttl > 0 then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+else
+	redis.call('PERSIST', KEYS[1])
+end
+return 1
+`)
+
+// AddFilterRule adds a rule to the address's rules, which are kept with its
+// registration, and fails with ErrNotRegistered if there is none.
+func (s *Store) AddFilterRule(ctx context.Context, addressBox string, rule FilterRule, max int) error {
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	keys := []string{filtersKey(addressBox), registrationKey(addressBox)}
+	added, err := addFilterRuleScript.Run(ctx, s.client, keys, rule.ID, data, max).Int()
+	switch {
+	case err != nil:
+		return err
+	case added < 0:
+		return ErrNotRegistered
+	case added == 0:
+		return ErrLimitReached
+	}
+	return nil
+}
+
+func (s *Store) ListFilterRules(ctx context.Context, addressBox string) ([]FilterRule, error) {
+	raw, err := s.client.HGetAll(ctx, filtersKey(addressBox)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]FilterRule, 0, len(raw))
+	for id, data := range raw {
+		var rule FilterRule
+		if err := json.Unmarshal([]byte(data), &rule); err != nil {
+			slog.WarnContext(ctx, "Skipping unmarshalable filter rule", "id", id, "error", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	slices.SortFunc(rules, func(a, b FilterRule) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return rules, nil
+}
+
+func (s *Store) DeleteFilterRule(ctx context.Context, addressBox string, ruleID string) (bool, error) {
+	n, err := s.client.HDel(ctx, filtersKey(addressBox), ruleID).Result()
+	return n > 0, err
+}
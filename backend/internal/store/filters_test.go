@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFilterRules_AddListDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, mr := newTestStore(t)
+	addr := "filters"
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := s.RegisterAddress(ctx, addr, "coresend.io", time.Hour); err != nil {
+		t.Fatalf("RegisterAddress() error = %v", err)
+	}
+	for i, id := range []string{"b", "a"} {
+		rule := FilterRule{ID: id, FromGlob: "*@" + id + ".example.com", Action: "drop", CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := s.AddFilterRule(ctx, addr, rule, 2); err != nil {
+			t.Fatalf("AddFilterRule(%s) error = %v", id, err)
+		}
+	}
+	assertTTLWithin(t, mr.TTL("filters:"+addr), time.Hour)
+	if err := s.AddFilterRule(ctx, addr, FilterRule{ID: "c", FromGlob: "*", Action: "drop"}, 2); !errors.Is(err, ErrLimitReached) {
+		t.Fatalf("AddFilterRule(c) error = %v, want ErrLimitReached", err)
+	}
+
+	rules, err := s.ListFilterRules(ctx, addr)
+	if err != nil {
+		t.Fatalf("ListFilterRules() error = %v", err)
+	}
+	if len(rules) != 2 || rules[0].ID != "b" || rules[1].ID != "a" || rules[0].FromGlob != "*@b.example.com" {
+		t.Fatalf("ListFilterRules() = %+v, want oldest first", rules)
+	}
+
+	if ok, err := s.DeleteFilterRule(ctx, addr, "b"); err != nil || !ok {
+		t.Fatalf("DeleteFilterRule(b) = %v, %v, want true, nil", ok, err)
+	}
+	if ok, err := s.DeleteFilterRule(ctx, addr, "b"); err != nil || ok {
+		t.Fatalf("DeleteFilterRule(b) again = %v, %v, want false, nil", ok, err)
+	}
+	if rules, _ := s.ListFilterRules(ctx, addr); len(rules) != 1 || rules[0].ID != "a" {
+		t.Fatalf("ListFilterRules() after delete = %+v, want a", rules)
+	}
+}
+
+func TestFilterRules_Registration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, mr := newTestStore(t)
+	addr := "filters"
+	rule := FilterRule{ID: "a", FromGlob: "*", Action: "drop"}
+
+	if err := s.AddFilterRule(ctx, addr, rule, 1); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("AddFilterRule() error = %v, want ErrNotRegistered", err)
+	}
+
+	if err := s.RegisterAddress(ctx, addr, "coresend.io", time.Hour); err != nil {
+		t.Fatalf("RegisterAddress() error = %v", err)
+	}
+	if err := s.AddFilterRule(ctx, addr, rule, 1); err != nil {
+		t.Fatalf("AddFilterRule() error = %v", err)
+	}
+	assertTTLWithin(t, mr.TTL("filters:"+addr), time.Hour)
+
+	// Registering again keeps the rules as long as the registration
+	if err := s.RegisterAddress(ctx, addr, "coresend.io", 24*time.Hour); err != nil {
+		t.Fatalf("RegisterAddress() error = %v", err)
+	}
+	if ttl := mr.TTL("filters:" + addr); ttl <= time.Hour || ttl > 24*time.Hour {
+		t.Fatalf("rules ttl = %s, want the new registration's", ttl)
+	}
+
+	mr.FastForward(25 * time.Hour)
+	if rules, err := s.ListFilterRules(ctx, addr); err != nil || len(rules) != 0 {
+		t.Fatalf("ListFilterRules() = %+v, %v, want none after the registration ended", rules, err)
+	}
+}
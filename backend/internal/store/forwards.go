@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ForwardRule relays mail received by an address to another mailbox.
+type ForwardRule struct {
+	ID          string `json:"id"`
+	Destination string `json:"destination"`
+	// FromContains and SubjectContains, when set, limit the rule to mail
+	// whose sender or subject contains them, ignoring case.
+	FromContains    string    `json:"from_contains,omitempty"`
+	SubjectContains string    `json:"subject_contains,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ForwardJob is one pending relay of a raw message.
+type ForwardJob struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	// RuleID is empty for bounces returned to an SRS-rewritten sender and
+	// for mail sent from the address.
+	RuleID string `json:"rule_id,omitempty"`
+	// Sender is the envelope sender to relay with, already rewritten.
+	Sender      string `json:"sender"`
+	Destination string `json:"destination"`
+	EmailID     string `json:"email_id,omitempty"`
+	// Raw is the message to relay, unless RawSHA256 names it in the blob
+	// store; forwarding headers are then added as it is relayed.
+	Raw       []byte `json:"raw"`
+	RawSHA256 string `json:"raw_sha256,omitempty"`
+	// Attempt counts the relays tried so far.
+	Attempt     int       `json:"attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	TraceParent string    `json:"trace_parent,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ForwardStore keeps forwarding rules and the queue of messages to relay.
+type ForwardStore interface {
+	AddForwardRule(ctx context.Context, addressBox string, rule ForwardRule, ttl time.Duration) error
+	ListForwardRules(ctx context.Context, addressBox string) ([]ForwardRule, error)
+	DeleteForwardRule(ctx context.Context, addressBox string, ruleID string) (bool, error)
+	// EnqueueForwardJob schedules job to run at the given time, replacing
+	// any earlier copy with the same ID.
+	EnqueueForwardJob(ctx context.Context, job ForwardJob, at time.Time) error
+	// ClaimForwardJobs returns up to max jobs due by now and hides them from
+	// other claimers for lease, like ClaimWebhookJobs.
+	ClaimForwardJobs(ctx context.Context, now time.Time, lease time.Duration, max int) ([]ForwardJob, error)
+	CompleteForwardJob(ctx context.Context, jobID string) error
+}
+
+const (
+	forwardQueueKey = "forward:queue"
+	forwardJobsKey  = "forward:jobs"
+)
+
+func (s *Store) AddForwardRule(ctx context.Context, addressBox string, rule ForwardRule, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = DefaultRetention
+	}
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("forwards:%s", addressBox)
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, key, rule.ID, data)
+	pipe.Expire(ctx, key, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *Store) ListForwardRules(ctx context.Context, addressBox string) ([]ForwardRule, error) {
+	raw, err := s.client.HGetAll(ctx, fmt.Sprintf("forwards:%s", addressBox)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]ForwardRule, 0, len(raw))
+	for id, data := range raw {
+		var rule ForwardRule
+		if err := json.Unmarshal([]byte(data), &rule); err != nil {
+			slog.WarnContext(ctx, "Skipping unmarshalable forward rule", "id", id, "error", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	slices.SortFunc(rules, func(a, b ForwardRule) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return rules, nil
+}
+
+func (s *Store) DeleteForwardRule(ctx context.Context, addressBox string, ruleID string) (bool, error) {
+	n, err := s.client.HDel(ctx, fmt.Sprintf("forwards:%s", addressBox), ruleID).Result()
+	return n > 0, err
+}
+
+func (s *Store) EnqueueForwardJob(ctx context.Context, job ForwardJob, at time.Time) error {
+	return s.enqueueJob(ctx, forwardQueueKey, forwardJobsKey, job.ID, job, at)
+}
+
+func (s *Store) ClaimForwardJobs(ctx context.Context, now time.Time, lease time.Duration, max int) ([]ForwardJob, error) {
+	return claimJobs[ForwardJob](ctx, s, "claim_forward_jobs", forwardQueueKey, forwardJobsKey, now, lease, max)
+}
+
+func (s *Store) CompleteForwardJob(ctx context.Context, jobID string) error {
+	return s.completeJob(ctx, forwardQueueKey, forwardJobsKey, jobID)
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestForwardRules_AddListDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, mr := newTestStore(t)
+	addr := "forwards"
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, id := range []string{"b", "a"} {
+		rule := ForwardRule{ID: id, Destination: id + "@example.com", SubjectContains: "code", CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := s.AddForwardRule(ctx, addr, rule, time.Hour); err != nil {
+			t.Fatalf("AddForwardRule(%s) error = %v", id, err)
+		}
+	}
+	assertTTLWithin(t, mr.TTL("forwards:"+addr), time.Hour)
+
+	rules, err := s.ListForwardRules(ctx, addr)
+	if err != nil {
+		t.Fatalf("ListForwardRules() error = %v", err)
+	}
+	if len(rules) != 2 || rules[0].ID != "b" || rules[1].ID != "a" || rules[0].SubjectContains != "code" {
+		t.Fatalf("ListForwardRules() = %+v, want oldest first", rules)
+	}
+
+	if ok, err := s.DeleteForwardRule(ctx, addr, "b"); err != nil || !ok {
+		t.Fatalf("DeleteForwardRule(b) = %v, %v, want true, nil", ok, err)
+	}
+	if ok, err := s.DeleteForwardRule(ctx, addr, "b"); err != nil || ok {
+		t.Fatalf("DeleteForwardRule(b) again = %v, %v, want false, nil", ok, err)
+	}
+	if rules, _ := s.ListForwardRules(ctx, addr); len(rules) != 1 || rules[0].ID != "a" {
+		t.Fatalf("ListForwardRules() after delete = %+v, want a", rules)
+	}
+}
+
+func TestForwardJobs_ClaimAndComplete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, mr := newTestStore(t)
+	now := time.Now()
+	raw := []byte("Subject: hi\r\n\r\nbinary \x00\xff body\r\n")
+
+	job := ForwardJob{ID: "job", Address: "forwards", RuleID: "rule", Destination: "a@example.com", Raw: raw}
+	if err := s.EnqueueForwardJob(ctx, job, now.Add(time.Minute)); err != nil {
+		t.Fatalf("EnqueueForwardJob() error = %v", err)
+	}
+
+	if jobs, _ := s.ClaimForwardJobs(ctx, now, time.Minute, 10); len(jobs) != 0 {
+		t.Fatalf("claim before due = %+v, want none", jobs)
+	}
+	jobs, err := s.ClaimForwardJobs(ctx, now.Add(time.Minute), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimForwardJobs() error = %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].RuleID != "rule" || !bytes.Equal(jobs[0].Raw, raw) {
+		t.Fatalf("ClaimForwardJobs() = %+v, want the job with its raw message", jobs)
+	}
+
+	if err := s.CompleteForwardJob(ctx, "job"); err != nil {
+		t.Fatalf("CompleteForwardJob() error = %v", err)
+	}
+	if mr.HGet(forwardJobsKey, "job") != "" {
+		t.Fatal("completed job data should be removed")
+	}
+	if jobs, _ := s.ClaimForwardJobs(ctx, now.Add(time.Hour), time.Minute, 10); len(jobs) != 0 {
+		t.Fatalf("claim after complete = %+v, want none", jobs)
+	}
+}
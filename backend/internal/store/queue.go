@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// claimScript pushes due jobs past the lease in one step, so two workers
+// never claim the same job. Queue entries whose job is gone are dropped.
+//
+// KEYS[1] is the queue sorted set scored by due time in milliseconds and
+// KEYS[2] the hash of job data by ID.
+var claimScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+local jobs = {}
+for _, id in ipairs(ids) do
+	local data = redis.call('HGET', KEYS[2], id)
+	if data then
+		redis.call('ZADD', KEYS[1], ARGV[2], id)
+		table.insert(jobs, data)
+	else
+		redis.call('ZREM', KEYS[1], id)
+	end
+end
+return jobs
+`)
+
+// claimJobs returns up to max jobs from a queue that are due by now and
+// hides them from other claimers for lease.
+func claimJobs[T any](ctx context.Context, s *Store, op, queueKey, jobsKey string, now time.Time, lease time.Duration, max int) ([]T, error) {
+	start := time.Now()
+	defer func() {
+		metrics.RedisOperationDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	}()
+
+	raw, err := claimScript.Run(ctx, s.client,
+		[]string{queueKey, jobsKey},
+		now.UnixMilli(), now.Add(lease).UnixMilli(), max,
+	).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]T, 0, len(raw))
+	for i, item := range raw {
+		data, ok := item.(string)
+		if !ok {
+			slog.WarnContext(ctx, "Skipping queued job without data", "op", op, "index", i)
+			continue
+		}
+		var job T
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			slog.WarnContext(ctx, "Skipping unmarshalable queued job", "op", op, "index", i, "error", err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// enqueueJob stores job under id and schedules it at the given time,
+// replacing any earlier copy.
+func (s *Store) enqueueJob(ctx context.Context, queueKey, jobsKey, id string, job any, at time.Time) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, jobsKey, id, data)
+	pipe.ZAdd(ctx, queueKey, redis.Z{Score: float64(at.UnixMilli()), Member: id})
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// completeJob removes a job from its queue.
+func (s *Store) completeJob(ctx context.Context, queueKey, jobsKey, id string) error {
+	pipe := s.client.TxPipeline()
+	pipe.ZRem(ctx, queueKey, id)
+	pipe.HDel(ctx, jobsKey, id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -2,40 +2,194 @@ package store
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"time"
 
+	"github.com/fn-jakubkarp/coresend/internal/blob"
 	"github.com/fn-jakubkarp/coresend/internal/metrics"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
 type Email struct {
-	ID         string    `json:"id"`
-	From       string    `json:"from"`
+	ID string `json:"id"`
+	// From is the envelope sender.
+	From string `json:"from"`
+	// To and Cc are the recipients in the message header. Emails received
+	// before envelopes were tracked have the local parts of all envelope
+	// recipients in To instead.
 	To         []string  `json:"to"`
+	Cc         []string  `json:"cc,omitempty"`
 	Subject    string    `json:"subject"`
 	Body       string    `json:"body"`
 	ReceivedAt time.Time `json:"received_at"`
+	TLS        *TLSInfo  `json:"tls,omitempty"`
+	// BodySHA256 is set when Body is kept in the blob store. Body is read
+	// back from there, so emails returned by the store still carry it.
+	BodySHA256 string `json:"body_sha256,omitempty"`
+	// ContentType is text/html or text/plain for Body. It is empty for
+	// emails stored before this was tracked.
+	ContentType string `json:"content_type,omitempty"`
+	// Charset is the charset Body was declared in, lower-cased, before it
+	// was decoded to UTF-8. It is empty when none was declared.
+	Charset string `json:"charset,omitempty"`
+	// TraceParent links the email to the trace of the SMTP delivery.
+	TraceParent string `json:"trace_parent,omitempty"`
+	// MessageID and References come from the message header, without angle
+	// brackets, so replies can thread. ReplyTo is the Reply-To address, or
+	// the header From when there is none.
+	MessageID  string   `json:"message_id,omitempty"`
+	References []string `json:"references,omitempty"`
+	ReplyTo    string   `json:"reply_to,omitempty"`
+	// Labels are added by the address's filtering rules.
+	Labels []string `json:"labels,omitempty"`
+	// ExpiresAt, when set, removes the email before the rest of the inbox
+	// expires.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// Extracted holds the codes and links found in the message at ingest.
+	Extracted *Extracted `json:"extracted,omitempty"`
+	// Inline are the embedded images HTML bodies reference by Content-ID.
+	Inline []InlinePart `json:"inline,omitempty"`
+	// Structure is the MIME tree of the message. It is nil for emails stored
+	// before this was tracked.
+	Structure *MIMEPart `json:"structure,omitempty"`
+	// DedupKey, when set, identifies the message for SaveEmail to skip
+	// copies of it while the first is in the inbox.
+	DedupKey string `json:"dedup_key,omitempty"`
+	// Duplicates counts the copies SaveEmail skipped.
+	Duplicates int `json:"duplicates,omitempty"`
+	// Envelope is how the copy in this inbox was delivered. It is nil for
+	// emails received before this was tracked and for sent emails.
+	Envelope *Envelope `json:"envelope,omitempty"`
+	// Source is the message as received, when it is kept. It is saved apart
+	// from the email, so emails read back from the store do not carry it;
+	// GetSource returns it. SourceSHA256 is set when it is kept in the blob
+	// store.
+	Source       []byte `json:"source,omitempty"`
+	SourceSHA256 string `json:"source_sha256,omitempty"`
 }
 
+// Envelope is the SMTP transaction that delivered an email to one inbox.
+// It names only that inbox's recipient, so the other envelope recipients,
+// such as Bcc recipients, stay hidden.
+type Envelope struct {
+	MailFrom string `json:"mail_from"`
+	// Recipient is the RCPT TO address the email arrived on.
+	Recipient string `json:"recipient"`
+	ClientIP  string `json:"client_ip,omitempty"`
+	// Helo is the name the client gave in HELO or EHLO.
+	Helo string `json:"helo,omitempty"`
+}
+
+// MIMEPart describes a part of a message's MIME tree.
+type MIMEPart struct {
+	// Path numbers the part among its parent's parts from 1, joined with
+	// dots, such as "2.1". The root is "", and a message/rfc822 part has the
+	// attached message as its only part.
+	Path        string `json:"path"`
+	ContentType string `json:"content_type"`
+	Charset     string `json:"charset,omitempty"`
+	// Encoding is the Content-Transfer-Encoding, lower-cased.
+	Encoding    string `json:"encoding,omitempty"`
+	Disposition string `json:"disposition,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
+	// SHA256 is the hex digest of the decoded content of leaf parts. Attached
+	// messages that are described by their Parts have none.
+	SHA256 string `json:"sha256,omitempty"`
+	// Size is the decoded size of the content in bytes; for multiparts, the
+	// sum of their parts.
+	Size  int        `json:"size"`
+	Parts []MIMEPart `json:"parts,omitempty"`
+}
+
+// InlinePart is an image embedded in a message, referenced from its HTML
+// body by a cid: URL.
+type InlinePart struct {
+	// ContentID is the part's Content-ID without angle brackets.
+	ContentID   string `json:"content_id"`
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename,omitempty"`
+	Size        int    `json:"size"`
+	// SHA256 is set when Data is kept in the blob store.
+	SHA256 string `json:"sha256,omitempty"`
+	// Data is saved apart from the email, so emails read back from the store
+	// do not carry it; GetInlinePart returns it.
+	Data []byte `json:"data,omitempty"`
+}
+
+// Extracted is what an email offers to test scripts.
+type Extracted struct {
+	// Codes are one-time code candidates, most likely first.
+	Codes []Code `json:"codes,omitempty"`
+	// Links are verification, password reset and login links.
+	Links []Link `json:"links,omitempty"`
+	// Unsubscribe are the List-Unsubscribe targets.
+	Unsubscribe []string `json:"unsubscribe,omitempty"`
+}
+
+// Code is a one-time code candidate with a confidence between 0 and 1.
+type Code struct {
+	Value      string  `json:"value"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Link is a URL with its kind: verify, reset or login.
+type Link struct {
+	URL  string `json:"url"`
+	Kind string `json:"kind"`
+}
+
+// Expired reports whether the email is past its ExpiresAt.
+func (e Email) Expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// TLSInfo records how the SMTP connection that delivered an email was secured.
+// It is nil for emails stored before this was tracked.
+type TLSInfo struct {
+	Encrypted   bool   `json:"encrypted"`
+	Version     string `json:"version,omitempty"`
+	CipherSuite string `json:"cipher_suite,omitempty"`
+}
+
+// DefaultRetention applies when SaveEmail is called without a retention.
+const DefaultRetention = 24 * time.Hour
+
+// ErrDuplicate is returned by SaveEmail for an email with the DedupKey of
+// one already in the inbox. The duplicate is counted on that email instead
+// of being saved.
+var ErrDuplicate = errors.New("duplicate of an email in the inbox")
+
 type EmailStore interface {
-	SaveEmail(ctx context.Context, addressBox string, email Email) error
+	SaveEmail(ctx context.Context, addressBox string, email Email, retention time.Duration) error
 	GetEmails(ctx context.Context, addressBox string) ([]Email, error)
 	GetEmail(ctx context.Context, addressBox string, emailID string) (*Email, error)
 	DeleteEmail(ctx context.Context, addressBox string, emailID string) error
 	ClearInbox(ctx context.Context, addressBox string) (int64, error)
 	CheckRateLimit(ctx context.Context, key string, limit int, window time.Duration) (bool, int, error)
-	RegisterAddress(ctx context.Context, addressBox string, duration time.Duration) error
-	IsAddressActive(ctx context.Context, addressBox string) (bool, error)
+	RegisterAddress(ctx context.Context, addressBox string, domain string, duration time.Duration) error
+	IsAddressActive(ctx context.Context, addressBox string, domain string) (bool, error)
+	AddressDomain(ctx context.Context, addressBox string) (string, error)
+	// GetInlinePart returns the inline part of an email with its data, or
+	// nil when there is none.
+	GetInlinePart(ctx context.Context, addressBox string, emailID string, contentID string) (*InlinePart, error)
+	// GetSource returns the message an email was received as, or nil when
+	// it was not kept.
+	GetSource(ctx context.Context, addressBox string, emailID string) ([]byte, error)
 	Ping(ctx context.Context) error
 	CheckAndStoreNonce(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
 }
 
 type Store struct {
 	client *redis.Client
+	// codec encodes the emails in folders, compressed once enabled.
+	codec *codec
+	// blobs, when set, keeps bodies and inline part data.
+	blobs blob.Store
 }
 
 func NewStore(addr, password string) *Store {
@@ -44,57 +198,222 @@ func NewStore(addr, password string) *Store {
 		Password: password,
 		DB:       0,
 	})
-	return &Store{client: rdb}
+	return &Store{client: rdb, codec: &codec{client: rdb}}
 }
 
 func (s *Store) Ping(ctx context.Context) error {
 	return s.client.Ping(ctx).Err()
 }
 
-func (s *Store) SaveEmail(ctx context.Context, addressBox string, email Email) error {
+func (s *Store) SaveEmail(ctx context.Context, addressBox string, email Email, retention time.Duration) error {
+	if email.ID == "" {
+		email.ID = uuid.New().String()
+	}
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	if email.DedupKey != "" {
+		duplicate, err := s.countDuplicate(ctx, addressBox, email, retention)
+		if err != nil {
+			return err
+		}
+		if duplicate {
+			return ErrDuplicate
+		}
+	}
+	return s.saveToFolder(ctx, "save_email", fmt.Sprintf("inbox:%s", addressBox), fmt.Sprintf("emails:%s", addressBox), inlineKey(addressBox), sourceKey(addressBox), email, retention)
+}
+
+// dedupKey is the hash of the IDs of an inbox's emails by DedupKey. It
+// expires with the inbox; entries of deleted emails are replaced.
+func dedupKey(addressBox string) string {
+	return fmt.Sprintf("dedup:%s", addressBox)
+}
+
+// maxDedupAttempts bounds the retries of countDuplicate when the inbox
+// changes while it runs.
+const maxDedupAttempts = 5
+
+// countDuplicate reports whether an email with the DedupKey of email is in
+// the inbox, and counts email on it if so. Otherwise email is recorded as
+// the one to count further copies on.
+func (s *Store) countDuplicate(ctx context.Context, addressBox string, email Email, retention time.Duration) (bool, error) {
 	start := time.Now()
 	defer func() {
-		metrics.RedisOperationDuration.WithLabelValues("save_email").Observe(time.Since(start).Seconds())
+		metrics.RedisOperationDuration.WithLabelValues("count_duplicate").Observe(time.Since(start).Seconds())
+	}()
+
+	dKey := dedupKey(addressBox)
+	zKey := fmt.Sprintf("inbox:%s", addressBox)
+	hKey := fmt.Sprintf("emails:%s", addressBox)
+	var duplicate bool
+	count := func(tx *redis.Tx) error {
+		duplicate = false
+		original, err := s.dedupOriginal(ctx, tx, dKey, zKey, hKey, email.DedupKey)
+		if err != nil {
+			return err
+		}
+		if original == nil {
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, dKey, email.DedupKey, email.ID)
+				pipe.Expire(ctx, dKey, retention)
+				return nil
+			})
+			return err
+		}
+
+		original.Duplicates++
+		data, err := s.codec.encode(original)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, hKey, original.ID, data)
+			return nil
+		})
+		duplicate = err == nil
+		return err
+	}
+
+	for range maxDedupAttempts {
+		err := s.client.Watch(ctx, count, dKey, zKey, hKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return duplicate, err
+		}
+	}
+	return false, redis.TxFailedErr
+}
+
+// dedupOriginal returns the email recorded under key, or nil when there is
+// none or it is no longer in the inbox.
+func (s *Store) dedupOriginal(ctx context.Context, tx *redis.Tx, dKey, zKey, hKey, key string) (*Email, error) {
+	id, err := tx.HGet(ctx, dKey, key).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	// Emails past the newest 100 leave the sorted set before the hash
+	if err := tx.ZScore(ctx, zKey, id).Err(); err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	data, err := tx.HGet(ctx, hKey, id).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var original Email
+	if err := s.codec.decode(ctx, []byte(data), &original); errors.Is(err, errDictionaryGone) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if original.Expired(time.Now()) {
+		return nil, nil
+	}
+	return &original, nil
+}
+
+// inlineKey is the hash of the inline part data of an inbox, keyed by
+// inlineField.
+func inlineKey(addressBox string) string {
+	return fmt.Sprintf("inline:%s", addressBox)
+}
+
+func inlineField(emailID, contentID string) string {
+	return emailID + "/" + contentID
+}
+
+// sourceKey is the hash of the kept message sources of an inbox, keyed by
+// email ID.
+func sourceKey(addressBox string) string {
+	return fmt.Sprintf("source:%s", addressBox)
+}
+
+// saveToFolder adds email to the folder kept in the sorted set zKey and the
+// hash hKey, keeping the 100 newest emails. The data of inline parts goes to
+// the hash pKey and the source to the hash sKey, or with the body to the
+// blob store when there is one.
+func (s *Store) saveToFolder(ctx context.Context, op, zKey, hKey, pKey, sKey string, email Email, retention time.Duration) error {
+	start := time.Now()
+	defer func() {
+		metrics.RedisOperationDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
 	}()
 
 	if email.ID == "" {
 		email.ID = uuid.New().String()
 	}
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	// Copied, as the caller's email keeps its data
+	email.Inline = slices.Clone(email.Inline)
+	if s.blobs != nil {
+		if err := s.storeContent(ctx, &email); err != nil {
+			return err
+		}
+	}
+	parts := make(map[string]any, len(email.Inline))
+	for i, part := range email.Inline {
+		if part.SHA256 == "" {
+			parts[inlineField(email.ID, part.ContentID)] = part.Data
+		}
+		email.Inline[i].Data = nil
+	}
+	source := email.Source
+	email.Source = nil
 
-	data, err := json.Marshal(email)
+	data, err := s.codec.encode(email)
 	if err != nil {
 		return err
 	}
 
-	zKey := fmt.Sprintf("inbox:%s", addressBox)
-	hKey := fmt.Sprintf("emails:%s", addressBox)
-
 	now := float64(time.Now().Unix())
 
 	pipe := s.client.Pipeline()
 
+	if len(parts) > 0 {
+		pipe.HSet(ctx, pKey, parts)
+		pipe.Expire(ctx, pKey, retention)
+	}
+	if source != nil {
+		pipe.HSet(ctx, sKey, email.ID, source)
+		pipe.Expire(ctx, sKey, retention)
+	}
+
 	pipe.ZAdd(ctx, zKey, redis.Z{Score: now, Member: email.ID})
 
 	pipe.HSet(ctx, hKey, email.ID, data)
 
 	pipe.ZRemRangeByRank(ctx, zKey, 0, -101) // Keep 100 latest emails
 
-	pipe.Expire(ctx, zKey, 24*time.Hour)
-	pipe.Expire(ctx, hKey, 24*time.Hour)
+	pipe.Expire(ctx, zKey, retention)
+	pipe.Expire(ctx, hKey, retention)
+
+	if s.blobs != nil {
+		addBlobRefs(ctx, pipe, hKey, email, retention)
+	}
 
 	_, err = pipe.Exec(ctx)
 	return err
 }
 
 func (s *Store) GetEmails(ctx context.Context, addressBox string) ([]Email, error) {
+	return s.listFolder(ctx, "get_inbox", fmt.Sprintf("inbox:%s", addressBox), fmt.Sprintf("emails:%s", addressBox))
+}
+
+// listFolder returns the emails of a folder saved by saveToFolder, newest
+// first.
+func (s *Store) listFolder(ctx context.Context, op, zKey, hKey string) ([]Email, error) {
 	start := time.Now()
 	defer func() {
-		metrics.RedisOperationDuration.WithLabelValues("get_inbox").Observe(time.Since(start).Seconds())
+		metrics.RedisOperationDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
 	}()
 
-	zKey := fmt.Sprintf("inbox:%s", addressBox)
-	hKey := fmt.Sprintf("emails:%s", addressBox)
-
 	// Fetches newest IDs from the Sorted Set
 	ids, err := s.client.ZRevRange(ctx, zKey, 0, -1).Result()
 	if err != nil {
@@ -111,27 +430,50 @@ func (s *Store) GetEmails(ctx context.Context, addressBox string) ([]Email, erro
 		return nil, err
 	}
 
+	now := time.Now()
 	emails := make([]Email, 0, len(rawData))
+	var expired []string
 	for i, item := range rawData {
 		if item == nil {
-			slog.Warn("Skipping nil email data", "index", i, "id", ids[i])
+			slog.WarnContext(ctx, "Skipping nil email data", "index", i, "id", ids[i])
 			continue
 		}
 
 		strData, ok := item.(string)
 		if !ok {
-			slog.Warn("Skipping email with invalid type", "index", i, "id", ids[i])
+			slog.WarnContext(ctx, "Skipping email with invalid type", "index", i, "id", ids[i])
 			continue
 		}
 
 		var email Email
-		if err := json.Unmarshal([]byte(strData), &email); err != nil {
-			slog.Warn("Skipping unmarshalable email", "index", i, "id", ids[i], "error", err)
+		if err := s.codec.decode(ctx, []byte(strData), &email); errors.Is(err, errDictionaryGone) {
+			expired = append(expired, ids[i])
+			continue
+		} else if err != nil {
+			slog.WarnContext(ctx, "Skipping unmarshalable email", "index", i, "id", ids[i], "error", err)
+			continue
+		}
+		if email.Expired(now) {
+			expired = append(expired, email.ID)
 			continue
 		}
 		emails = append(emails, email)
 	}
 
+	if len(expired) > 0 {
+		// Expired emails are already hidden, so a failed removal is retried
+		// by the next listing
+		pipe := s.client.Pipeline()
+		pipe.ZRem(ctx, zKey, expired)
+		pipe.HDel(ctx, hKey, expired...)
+		if _, err := pipe.Exec(ctx); err != nil {
+			slog.WarnContext(ctx, "Failed to remove expired emails", "count", len(expired), "error", err)
+		}
+	}
+
+	if err := s.loadBodies(ctx, emails); err != nil {
+		return nil, err
+	}
 	return emails, nil
 }
 
@@ -146,7 +488,15 @@ func (s *Store) GetEmail(ctx context.Context, addressBox string, emailID string)
 	}
 
 	var email Email
-	if err := json.Unmarshal([]byte(data), &email); err != nil {
+	if err := s.codec.decode(ctx, []byte(data), &email); errors.Is(err, errDictionaryGone) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if email.Expired(time.Now()) {
+		return nil, nil
+	}
+	if err := s.loadBody(ctx, &email); err != nil {
 		return nil, err
 	}
 
@@ -157,20 +507,143 @@ func (s *Store) DeleteEmail(ctx context.Context, addressBox string, emailID stri
 	zKey := fmt.Sprintf("inbox:%s", addressBox)
 	hKey := fmt.Sprintf("emails:%s", addressBox)
 
+	deleted, err := s.folderEmails(ctx, hKey, emailID)
+	if err != nil {
+		return err
+	}
+
 	pipe := s.client.Pipeline()
 
 	pipe.ZRem(ctx, zKey, emailID)
 	pipe.HDel(ctx, hKey, emailID)
+	pipe.HDel(ctx, sourceKey(addressBox), emailID)
 
-	_, err := pipe.Exec(ctx)
-	return err
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.releaseBlobs(ctx, hKey, deleted); err != nil {
+		return err
+	}
+	return s.deleteInlineParts(ctx, addressBox, emailID)
+}
+
+// folderEmails returns the emails of the hash hKey with the given IDs, or
+// all of them when none are given, as far as they are needed to release
+// their blobs.
+func (s *Store) folderEmails(ctx context.Context, hKey string, ids ...string) ([]Email, error) {
+	if s.blobs == nil {
+		return nil, nil
+	}
+	var (
+		values []string
+		err    error
+	)
+	if len(ids) == 0 {
+		values, err = s.client.HVals(ctx, hKey).Result()
+	} else {
+		var items []any
+		items, err = s.client.HMGet(ctx, hKey, ids...).Result()
+		for _, item := range items {
+			if data, ok := item.(string); ok {
+				values = append(values, data)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	emails := make([]Email, 0, len(values))
+	for _, data := range values {
+		var email Email
+		if err := s.codec.decode(ctx, []byte(data), &email); err != nil {
+			continue
+		}
+		emails = append(emails, email)
+	}
+	return emails, nil
+}
+
+// deleteInlineParts removes the inline part data of an email.
+func (s *Store) deleteInlineParts(ctx context.Context, addressBox, emailID string) error {
+	pKey := inlineKey(addressBox)
+	var fields []string
+	iter := s.client.HScan(ctx, pKey, 0, inlineField(emailID, "*"), 100).Iterator()
+	for i := 0; iter.Next(ctx); i++ {
+		// The scan alternates field names and values
+		if i%2 == 0 {
+			fields = append(fields, iter.Val())
+		}
+	}
+	if err := iter.Err(); err != nil || len(fields) == 0 {
+		return err
+	}
+	return s.client.HDel(ctx, pKey, fields...).Err()
 }
 
 func (s *Store) ClearInbox(ctx context.Context, addressBox string) (int64, error) {
 	zKey := fmt.Sprintf("inbox:%s", addressBox)
 	hKey := fmt.Sprintf("emails:%s", addressBox)
 
-	return s.client.Del(ctx, zKey, hKey).Result()
+	emails, err := s.folderEmails(ctx, hKey)
+	if err != nil {
+		return 0, err
+	}
+
+	pipe := s.client.Pipeline()
+	deleted := pipe.Del(ctx, zKey, hKey)
+	pipe.Del(ctx, inlineKey(addressBox), sourceKey(addressBox), dedupKey(addressBox))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	if err := s.releaseBlobs(ctx, hKey, emails); err != nil {
+		return 0, err
+	}
+	return deleted.Val(), nil
+}
+
+func (s *Store) GetInlinePart(ctx context.Context, addressBox string, emailID string, contentID string) (*InlinePart, error) {
+	email, err := s.GetEmail(ctx, addressBox, emailID)
+	if err != nil || email == nil {
+		return nil, err
+	}
+	for _, part := range email.Inline {
+		if part.ContentID != contentID {
+			continue
+		}
+		if part.SHA256 != "" && s.blobs != nil {
+			data, err := s.readBlob(ctx, part.SHA256)
+			if data == nil || err != nil {
+				return nil, err
+			}
+			part.Data = data
+			return &part, nil
+		}
+		data, err := s.client.HGet(ctx, inlineKey(addressBox), inlineField(emailID, contentID)).Bytes()
+		if err == redis.Nil {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		part.Data = data
+		return &part, nil
+	}
+	return nil, nil
+}
+
+func (s *Store) GetSource(ctx context.Context, addressBox string, emailID string) ([]byte, error) {
+	email, err := s.GetEmail(ctx, addressBox, emailID)
+	if err != nil || email == nil {
+		return nil, err
+	}
+	if email.SourceSHA256 != "" && s.blobs != nil {
+		return s.readBlob(ctx, email.SourceSHA256)
+	}
+	data, err := s.client.HGet(ctx, sourceKey(addressBox), emailID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return data, err
 }
 
 func (s *Store) CheckRateLimit(ctx context.Context, key string, limit int, window time.Duration) (bool, int, error) {
@@ -204,20 +677,59 @@ func (s *Store) CheckRateLimit(ctx context.Context, key string, limit int, windo
 	return count <= int64(limit), remaining, nil
 }
 
-func (s *Store) RegisterAddress(ctx context.Context, addressBox string, duration time.Duration) error {
-	key := fmt.Sprintf("active_address:%s", addressBox)
-	return s.client.Set(ctx, key, "1", duration).Err()
+// legacyRegistration is the value written by registrations that predate
+// multi-domain support; it matches any configured domain.
+const legacyRegistration = "1"
+
+// registrationKey holds the domain an address is registered on.
+func registrationKey(addressBox string) string {
+	return fmt.Sprintf("active_address:%s", addressBox)
 }
 
-func (s *Store) IsAddressActive(ctx context.Context, addressBox string) (bool, error) {
-	key := fmt.Sprintf("active_address:%s", addressBox)
+// RegisterAddress registers an address for duration, and keeps its
+// filtering rules as long.
+func (s *Store) RegisterAddress(ctx context.Context, addressBox string, domain string, duration time.Duration) error {
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, registrationKey(addressBox), domain, duration)
+	if duration > 0 {
+		pipe.Expire(ctx, filtersKey(addressBox), duration)
+	} else {
+		pipe.Persist(ctx, filtersKey(addressBox))
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
 
-	exists, err := s.client.Exists(ctx, key).Result()
-	if err != nil {
+// IsAddressActive reports whether the address is registered on the given domain.
+func (s *Store) IsAddressActive(ctx context.Context, addressBox string, domain string) (bool, error) {
+	key := registrationKey(addressBox)
+
+	registered, err := s.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
 		return false, err
 	}
 
-	return exists > 0, nil
+	return registered == domain || registered == legacyRegistration, nil
+}
+
+// AddressDomain returns the domain an address was registered on, or an empty
+// string if it is not registered or the registration predates domains.
+func (s *Store) AddressDomain(ctx context.Context, addressBox string) (string, error) {
+	key := registrationKey(addressBox)
+
+	registered, err := s.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if registered == legacyRegistration {
+		return "", nil
+	}
+	return registered, nil
 }
 
 func (s *Store) CheckAndStoreNonce(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -84,7 +85,7 @@ func TestSaveEmail_AutoIDAndTTL(t *testing.T) {
 		ReceivedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
 	}
 
-	if err := s.SaveEmail(ctx, address, email); err != nil {
+	if err := s.SaveEmail(ctx, address, email, 0); err != nil {
 		t.Fatalf("SaveEmail() error: %v", err)
 	}
 
@@ -126,6 +127,25 @@ func TestSaveEmail_AutoIDAndTTL(t *testing.T) {
 	assertTTLWithin(t, ttlH, 24*time.Hour)
 }
 
+func TestSaveEmail_CustomRetention(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+
+	if err := s.SaveEmail(ctx, "short", Email{Subject: "Hi"}, time.Hour); err != nil {
+		t.Fatalf("SaveEmail() error: %v", err)
+	}
+
+	for _, key := range []string{"inbox:short", "emails:short"} {
+		ttl, err := s.client.TTL(ctx, key).Result()
+		if err != nil {
+			t.Fatalf("TTL(%s) error: %v", key, err)
+		}
+		assertTTLWithin(t, ttl, time.Hour)
+	}
+}
+
 func TestSaveEmail_Enforces100Newest(t *testing.T) {
 	t.Parallel()
 
@@ -141,7 +161,7 @@ func TestSaveEmail_Enforces100Newest(t *testing.T) {
 			Subject:    fmt.Sprintf("subject-%03d", i),
 			Body:       "body",
 			ReceivedAt: time.Now().UTC(),
-		})
+		}, 0)
 		if err != nil {
 			t.Fatalf("SaveEmail(%d) error: %v", i, err)
 		}
@@ -227,6 +247,38 @@ func TestGetEmails(t *testing.T) {
 			t.Fatalf("unexpected email order: [%s %s %s]", emails[0].ID, emails[1].ID, emails[2].ID)
 		}
 	})
+
+	t.Run("hides and removes expired emails", func(t *testing.T) {
+		t.Parallel()
+
+		s, mr := newTestStore(t)
+		ctx := context.Background()
+		address := "expiring"
+
+		emails := []Email{
+			{ID: "kept", ExpiresAt: time.Now().Add(time.Hour)},
+			{ID: "expired", ExpiresAt: time.Now().Add(-time.Minute)},
+		}
+		for _, email := range emails {
+			if err := s.SaveEmail(ctx, address, email, time.Hour); err != nil {
+				t.Fatalf("SaveEmail(%s) error: %v", email.ID, err)
+			}
+		}
+
+		got, err := s.GetEmails(ctx, address)
+		if err != nil {
+			t.Fatalf("GetEmails() error: %v", err)
+		}
+		if len(got) != 1 || got[0].ID != "kept" {
+			t.Fatalf("GetEmails() = %+v, want only kept", got)
+		}
+		if mr.HGet("emails:"+address, "expired") != "" {
+			t.Fatal("expired email still stored after listing")
+		}
+		if email, err := s.GetEmail(ctx, address, "kept"); err != nil || email == nil {
+			t.Fatalf("GetEmail(kept) = %v, %v", email, err)
+		}
+	})
 }
 
 func TestGetEmail(t *testing.T) {
@@ -357,6 +409,166 @@ func TestClearInbox(t *testing.T) {
 	}
 }
 
+func TestInlineParts(t *testing.T) {
+	t.Parallel()
+
+	s, mr := newTestStore(t)
+	ctx := context.Background()
+	address := "inline"
+	email := Email{
+		ID:     "id-1",
+		Body:   `<img src="cid:logo@example.com">`,
+		Inline: []InlinePart{{ContentID: "logo@example.com", ContentType: "image/png", Size: 3, Data: []byte("png")}},
+	}
+	if err := s.SaveEmail(ctx, address, email, time.Hour); err != nil {
+		t.Fatalf("SaveEmail() error: %v", err)
+	}
+	if err := s.SaveEmail(ctx, address, Email{ID: "id-2", Inline: []InlinePart{{ContentID: "a", Data: []byte("a")}}}, time.Hour); err != nil {
+		t.Fatalf("SaveEmail() error: %v", err)
+	}
+
+	stored, err := s.GetEmail(ctx, address, "id-1")
+	if err != nil || stored == nil {
+		t.Fatalf("GetEmail() = %v, %v", stored, err)
+	}
+	if len(stored.Inline) != 1 || stored.Inline[0].Data != nil || stored.Inline[0].ContentType != "image/png" {
+		t.Fatalf("stored inline parts = %+v, want metadata only", stored.Inline)
+	}
+	if ttl := mr.TTL("inline:" + address); ttl != time.Hour {
+		t.Fatalf("inline TTL = %v, want %v", ttl, time.Hour)
+	}
+
+	part, err := s.GetInlinePart(ctx, address, "id-1", "logo@example.com")
+	if err != nil || part == nil || string(part.Data) != "png" || part.ContentType != "image/png" {
+		t.Fatalf("GetInlinePart() = %+v, %v", part, err)
+	}
+	for _, tc := range []struct{ emailID, contentID string }{
+		{"id-1", "missing"},
+		{"id-1", "a"},
+		{"missing", "logo@example.com"},
+	} {
+		if part, err := s.GetInlinePart(ctx, address, tc.emailID, tc.contentID); err != nil || part != nil {
+			t.Fatalf("GetInlinePart(%q, %q) = %+v, %v, want nil", tc.emailID, tc.contentID, part, err)
+		}
+	}
+
+	if err := s.DeleteEmail(ctx, address, "id-1"); err != nil {
+		t.Fatalf("DeleteEmail() error: %v", err)
+	}
+	if fields, _ := s.client.HKeys(ctx, "inline:"+address).Result(); len(fields) != 1 || fields[0] != "id-2/a" {
+		t.Fatalf("inline fields after delete = %v, want [id-2/a]", fields)
+	}
+
+	if deleted, err := s.ClearInbox(ctx, address); err != nil || deleted != 2 {
+		t.Fatalf("ClearInbox() = %d, %v", deleted, err)
+	}
+	if mr.Exists("inline:" + address) {
+		t.Fatal("inline key still exists")
+	}
+}
+
+func TestGetSource(t *testing.T) {
+	t.Parallel()
+
+	s, mr := newTestStore(t)
+	ctx := context.Background()
+	address := "source"
+	source := []byte("From: a@example.com\r\nSubject: Hi\r\n\r\nHello\r\n")
+	if err := s.SaveEmail(ctx, address, Email{ID: "id-1", Subject: "Hi", Source: source}, time.Hour); err != nil {
+		t.Fatalf("SaveEmail() error: %v", err)
+	}
+	if err := s.SaveEmail(ctx, address, Email{ID: "id-2", Subject: "Not kept"}, time.Hour); err != nil {
+		t.Fatalf("SaveEmail() error: %v", err)
+	}
+
+	stored, err := s.GetEmail(ctx, address, "id-1")
+	if err != nil || stored == nil || stored.Source != nil {
+		t.Fatalf("GetEmail() = %+v, %v, want the email without its source", stored, err)
+	}
+	if ttl := mr.TTL("source:" + address); ttl != time.Hour {
+		t.Fatalf("source TTL = %v, want %v", ttl, time.Hour)
+	}
+	got, err := s.GetSource(ctx, address, "id-1")
+	if err != nil || string(got) != string(source) {
+		t.Fatalf("GetSource() = %q, %v, want %q", got, err, source)
+	}
+	for _, id := range []string{"id-2", "missing"} {
+		if got, err := s.GetSource(ctx, address, id); err != nil || got != nil {
+			t.Fatalf("GetSource(%q) = %q, %v, want nil", id, got, err)
+		}
+	}
+
+	if err := s.DeleteEmail(ctx, address, "id-1"); err != nil {
+		t.Fatalf("DeleteEmail() error: %v", err)
+	}
+	if mr.Exists("source:" + address) {
+		t.Fatal("source kept after its email was deleted")
+	}
+}
+
+func TestSaveEmail_Dedup(t *testing.T) {
+	t.Parallel()
+
+	s, mr := newTestStore(t)
+	ctx := context.Background()
+	address := "dedup"
+
+	save := func(id, key string) error {
+		return s.SaveEmail(ctx, address, Email{ID: id, DedupKey: key}, time.Hour)
+	}
+
+	if err := save("id-1", "mid:a@example.com"); err != nil {
+		t.Fatalf("SaveEmail() error: %v", err)
+	}
+	for _, id := range []string{"id-2", "id-3"} {
+		if err := save(id, "mid:a@example.com"); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("SaveEmail(%s) error = %v, want ErrDuplicate", id, err)
+		}
+	}
+	if err := save("id-4", "mid:b@example.com"); err != nil {
+		t.Fatalf("SaveEmail() error: %v", err)
+	}
+	if err := save("id-5", ""); err != nil {
+		t.Fatalf("SaveEmail() without a key error: %v", err)
+	}
+
+	emails, err := s.GetEmails(ctx, address)
+	if err != nil {
+		t.Fatalf("GetEmails() error: %v", err)
+	}
+	if len(emails) != 3 {
+		t.Fatalf("GetEmails() returned %d emails, want 3", len(emails))
+	}
+	original, err := s.GetEmail(ctx, address, "id-1")
+	if err != nil || original == nil {
+		t.Fatalf("GetEmail() = %v, %v", original, err)
+	}
+	if original.Duplicates != 2 {
+		t.Fatalf("Duplicates = %d, want 2", original.Duplicates)
+	}
+	if ttl := mr.TTL("dedup:" + address); ttl != time.Hour {
+		t.Fatalf("dedup TTL = %v, want %v", ttl, time.Hour)
+	}
+
+	// Once the original is gone the next copy is saved
+	if err := s.DeleteEmail(ctx, address, "id-1"); err != nil {
+		t.Fatalf("DeleteEmail() error: %v", err)
+	}
+	if err := save("id-6", "mid:a@example.com"); err != nil {
+		t.Fatalf("SaveEmail() after delete error: %v", err)
+	}
+	if err := save("id-7", "mid:a@example.com"); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("SaveEmail() error = %v, want ErrDuplicate", err)
+	}
+
+	if _, err := s.ClearInbox(ctx, address); err != nil {
+		t.Fatalf("ClearInbox() error: %v", err)
+	}
+	if mr.Exists("dedup:" + address) {
+		t.Fatal("dedup key still exists")
+	}
+}
+
 func TestCheckRateLimit(t *testing.T) {
 	t.Parallel()
 
@@ -414,7 +626,7 @@ func TestRegisterAddressAndIsAddressActive(t *testing.T) {
 	address := "active-box"
 	ttlWindow := 2 * time.Minute
 
-	active, err := s.IsAddressActive(ctx, address)
+	active, err := s.IsAddressActive(ctx, address, "coresend.io")
 	if err != nil {
 		t.Fatalf("IsAddressActive() pre-check error: %v", err)
 	}
@@ -422,11 +634,11 @@ func TestRegisterAddressAndIsAddressActive(t *testing.T) {
 		t.Fatalf("address should be inactive before registration")
 	}
 
-	if err := s.RegisterAddress(ctx, address, ttlWindow); err != nil {
+	if err := s.RegisterAddress(ctx, address, "coresend.io", ttlWindow); err != nil {
 		t.Fatalf("RegisterAddress() error: %v", err)
 	}
 
-	active, err = s.IsAddressActive(ctx, address)
+	active, err = s.IsAddressActive(ctx, address, "coresend.io")
 	if err != nil {
 		t.Fatalf("IsAddressActive() post-register error: %v", err)
 	}
@@ -441,7 +653,7 @@ func TestRegisterAddressAndIsAddressActive(t *testing.T) {
 	assertTTLWithin(t, ttl, ttlWindow)
 
 	mr.FastForward(3 * time.Minute)
-	active, err = s.IsAddressActive(ctx, address)
+	active, err = s.IsAddressActive(ctx, address, "coresend.io")
 	if err != nil {
 		t.Fatalf("IsAddressActive() after expiry error: %v", err)
 	}
@@ -450,6 +662,50 @@ func TestRegisterAddressAndIsAddressActive(t *testing.T) {
 	}
 }
 
+func TestIsAddressActive_DomainMatching(t *testing.T) {
+	t.Parallel()
+
+	s, mr := newTestStore(t)
+	ctx := context.Background()
+
+	if err := s.RegisterAddress(ctx, "box", "coresend.io", time.Minute); err != nil {
+		t.Fatalf("RegisterAddress() error: %v", err)
+	}
+	if err := mr.Set("active_address:legacy", legacyRegistration); err != nil {
+		t.Fatalf("failed to seed legacy registration: %v", err)
+	}
+
+	tests := []struct {
+		address    string
+		domain     string
+		wantActive bool
+		wantDomain string
+	}{
+		{address: "box", domain: "coresend.io", wantActive: true, wantDomain: "coresend.io"},
+		{address: "box", domain: "other.example", wantActive: false, wantDomain: "coresend.io"},
+		{address: "legacy", domain: "other.example", wantActive: true, wantDomain: ""},
+		{address: "missing", domain: "coresend.io", wantActive: false, wantDomain: ""},
+	}
+
+	for _, tc := range tests {
+		active, err := s.IsAddressActive(ctx, tc.address, tc.domain)
+		if err != nil {
+			t.Fatalf("IsAddressActive(%q, %q) error: %v", tc.address, tc.domain, err)
+		}
+		if active != tc.wantActive {
+			t.Fatalf("IsAddressActive(%q, %q) = %v, want %v", tc.address, tc.domain, active, tc.wantActive)
+		}
+
+		domain, err := s.AddressDomain(ctx, tc.address)
+		if err != nil {
+			t.Fatalf("AddressDomain(%q) error: %v", tc.address, err)
+		}
+		if domain != tc.wantDomain {
+			t.Fatalf("AddressDomain(%q) = %q, want %q", tc.address, domain, tc.wantDomain)
+		}
+	}
+}
+
 func TestCheckAndStoreNonce(t *testing.T) {
 	t.Parallel()
 
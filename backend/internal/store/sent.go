@@ -0,0 +1,22 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SentStore keeps copies of mail sent from an address, separate from its
+// inbox.
+type SentStore interface {
+	SaveSent(ctx context.Context, addressBox string, email Email, retention time.Duration) error
+	GetSent(ctx context.Context, addressBox string) ([]Email, error)
+}
+
+func (s *Store) SaveSent(ctx context.Context, addressBox string, email Email, retention time.Duration) error {
+	return s.saveToFolder(ctx, "save_sent", fmt.Sprintf("sent:%s", addressBox), fmt.Sprintf("sent_emails:%s", addressBox), fmt.Sprintf("sent_inline:%s", addressBox), fmt.Sprintf("sent_source:%s", addressBox), email, retention)
+}
+
+func (s *Store) GetSent(ctx context.Context, addressBox string) ([]Email, error) {
+	return s.listFolder(ctx, "get_sent", fmt.Sprintf("sent:%s", addressBox), fmt.Sprintf("sent_emails:%s", addressBox))
+}
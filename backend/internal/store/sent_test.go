@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSent_KeptApartFromInbox(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, mr := newTestStore(t)
+	addr := "sender"
+
+	sent := Email{ID: "sent-1", From: addr + "@coresend.test", To: []string{"tester@example.com"}, Subject: "Hello", MessageID: "1@coresend.test"}
+	if err := s.SaveSent(ctx, addr, sent, time.Hour); err != nil {
+		t.Fatalf("SaveSent() error = %v", err)
+	}
+	assertTTLWithin(t, mr.TTL("sent:"+addr), time.Hour)
+	assertTTLWithin(t, mr.TTL("sent_emails:"+addr), time.Hour)
+
+	got, err := s.GetSent(ctx, addr)
+	if err != nil {
+		t.Fatalf("GetSent() error = %v", err)
+	}
+	if len(got) != 1 || got[0].ID != "sent-1" || got[0].MessageID != "1@coresend.test" {
+		t.Fatalf("GetSent() = %+v, want sent-1", got)
+	}
+
+	if inbox, _ := s.GetEmails(ctx, addr); len(inbox) != 0 {
+		t.Fatalf("GetEmails() = %+v, want sent mail kept out of the inbox", inbox)
+	}
+}
@@ -0,0 +1,413 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedStore wraps an EmailStore with one client span per call.
+type tracedStore struct {
+	next   EmailStore
+	tracer trace.Tracer
+}
+
+// WithTracing returns an EmailStore that records a span around every call.
+// Addresses are not recorded, only email IDs and counts.
+func WithTracing(s EmailStore) EmailStore {
+	return &tracedStore{next: s, tracer: tracing.Tracer()}
+}
+
+func (t *tracedStore) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return startSpan(ctx, t.tracer, op, attrs...)
+}
+
+func startSpan(ctx context.Context, tracer trace.Tracer, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs,
+		attribute.String("db.system.name", "redis"),
+		attribute.String("db.operation.name", op),
+	)
+	return tracer.Start(ctx, "store."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func (t *tracedStore) SaveEmail(ctx context.Context, addressBox string, email Email, retention time.Duration) error {
+	ctx, span := t.start(ctx, "save_email", attribute.Int64("coresend.retention_seconds", int64(retention.Seconds())))
+	defer span.End()
+
+	err := t.next.SaveEmail(ctx, addressBox, email, retention)
+	if errors.Is(err, ErrDuplicate) {
+		span.SetAttributes(attribute.Bool("coresend.duplicate", true))
+		return err
+	}
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedStore) GetEmails(ctx context.Context, addressBox string) ([]Email, error) {
+	ctx, span := t.start(ctx, "get_emails")
+	defer span.End()
+
+	emails, err := t.next.GetEmails(ctx, addressBox)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int("coresend.email_count", len(emails)))
+	return emails, err
+}
+
+func (t *tracedStore) GetEmail(ctx context.Context, addressBox string, emailID string) (*Email, error) {
+	ctx, span := t.start(ctx, "get_email", attribute.String("coresend.email_id", emailID))
+	defer span.End()
+
+	email, err := t.next.GetEmail(ctx, addressBox, emailID)
+	tracing.RecordError(span, err)
+	return email, err
+}
+
+func (t *tracedStore) DeleteEmail(ctx context.Context, addressBox string, emailID string) error {
+	ctx, span := t.start(ctx, "delete_email", attribute.String("coresend.email_id", emailID))
+	defer span.End()
+
+	err := t.next.DeleteEmail(ctx, addressBox, emailID)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedStore) ClearInbox(ctx context.Context, addressBox string) (int64, error) {
+	ctx, span := t.start(ctx, "clear_inbox")
+	defer span.End()
+
+	n, err := t.next.ClearInbox(ctx, addressBox)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int64("coresend.email_count", n))
+	return n, err
+}
+
+func (t *tracedStore) CheckRateLimit(ctx context.Context, key string, limit int, window time.Duration) (bool, int, error) {
+	ctx, span := t.start(ctx, "check_rate_limit")
+	defer span.End()
+
+	allowed, count, err := t.next.CheckRateLimit(ctx, key, limit, window)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Bool("coresend.allowed", allowed))
+	return allowed, count, err
+}
+
+func (t *tracedStore) RegisterAddress(ctx context.Context, addressBox string, domain string, duration time.Duration) error {
+	ctx, span := t.start(ctx, "register_address", attribute.String("coresend.domain", domain))
+	defer span.End()
+
+	err := t.next.RegisterAddress(ctx, addressBox, domain, duration)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedStore) IsAddressActive(ctx context.Context, addressBox string, domain string) (bool, error) {
+	ctx, span := t.start(ctx, "is_address_active", attribute.String("coresend.domain", domain))
+	defer span.End()
+
+	active, err := t.next.IsAddressActive(ctx, addressBox, domain)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Bool("coresend.active", active))
+	return active, err
+}
+
+func (t *tracedStore) AddressDomain(ctx context.Context, addressBox string) (string, error) {
+	ctx, span := t.start(ctx, "address_domain")
+	defer span.End()
+
+	domain, err := t.next.AddressDomain(ctx, addressBox)
+	tracing.RecordError(span, err)
+	return domain, err
+}
+
+func (t *tracedStore) Ping(ctx context.Context) error {
+	ctx, span := t.start(ctx, "ping")
+	defer span.End()
+
+	err := t.next.Ping(ctx)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedStore) CheckAndStoreNonce(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	ctx, span := t.start(ctx, "check_and_store_nonce")
+	defer span.End()
+
+	unique, err := t.next.CheckAndStoreNonce(ctx, nonce, ttl)
+	tracing.RecordError(span, err)
+	return unique, err
+}
+
+func (t *tracedStore) GetInlinePart(ctx context.Context, addressBox string, emailID string, contentID string) (*InlinePart, error) {
+	ctx, span := t.start(ctx, "get_inline_part", attribute.String("coresend.email_id", emailID))
+	defer span.End()
+
+	part, err := t.next.GetInlinePart(ctx, addressBox, emailID, contentID)
+	tracing.RecordError(span, err)
+	return part, err
+}
+
+func (t *tracedStore) GetSource(ctx context.Context, addressBox string, emailID string) ([]byte, error) {
+	ctx, span := t.start(ctx, "get_source", attribute.String("coresend.email_id", emailID))
+	defer span.End()
+
+	source, err := t.next.GetSource(ctx, addressBox, emailID)
+	tracing.RecordError(span, err)
+	return source, err
+}
+
+// tracedWebhookStore wraps a WebhookStore with one client span per call.
+type tracedWebhookStore struct {
+	next   WebhookStore
+	tracer trace.Tracer
+}
+
+// WithWebhookTracing returns a WebhookStore that records a span around every
+// call. Like WithTracing it records IDs and counts, never addresses or URLs.
+func WithWebhookTracing(s WebhookStore) WebhookStore {
+	return &tracedWebhookStore{next: s, tracer: tracing.Tracer()}
+}
+
+func (t *tracedWebhookStore) AddWebhook(ctx context.Context, addressBox string, hook Webhook, max int, ttl time.Duration) error {
+	ctx, span := startSpan(ctx, t.tracer, "add_webhook", attribute.String("coresend.webhook_id", hook.ID))
+	defer span.End()
+
+	err := t.next.AddWebhook(ctx, addressBox, hook, max, ttl)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedWebhookStore) ListWebhooks(ctx context.Context, addressBox string) ([]Webhook, error) {
+	ctx, span := startSpan(ctx, t.tracer, "list_webhooks")
+	defer span.End()
+
+	hooks, err := t.next.ListWebhooks(ctx, addressBox)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int("coresend.webhook_count", len(hooks)))
+	return hooks, err
+}
+
+func (t *tracedWebhookStore) DeleteWebhook(ctx context.Context, addressBox string, webhookID string) (bool, error) {
+	ctx, span := startSpan(ctx, t.tracer, "delete_webhook", attribute.String("coresend.webhook_id", webhookID))
+	defer span.End()
+
+	deleted, err := t.next.DeleteWebhook(ctx, addressBox, webhookID)
+	tracing.RecordError(span, err)
+	return deleted, err
+}
+
+func (t *tracedWebhookStore) EnqueueWebhookJob(ctx context.Context, job WebhookJob, at time.Time) error {
+	ctx, span := startSpan(ctx, t.tracer, "enqueue_webhook_job",
+		attribute.String("coresend.webhook_job_id", job.ID),
+		attribute.Int("coresend.attempt", job.Attempt),
+	)
+	defer span.End()
+
+	err := t.next.EnqueueWebhookJob(ctx, job, at)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedWebhookStore) ClaimWebhookJobs(ctx context.Context, now time.Time, lease time.Duration, max int) ([]WebhookJob, error) {
+	ctx, span := startSpan(ctx, t.tracer, "claim_webhook_jobs")
+	defer span.End()
+
+	jobs, err := t.next.ClaimWebhookJobs(ctx, now, lease, max)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int("coresend.webhook_job_count", len(jobs)))
+	return jobs, err
+}
+
+func (t *tracedWebhookStore) CompleteWebhookJob(ctx context.Context, jobID string) error {
+	ctx, span := startSpan(ctx, t.tracer, "complete_webhook_job", attribute.String("coresend.webhook_job_id", jobID))
+	defer span.End()
+
+	err := t.next.CompleteWebhookJob(ctx, jobID)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedWebhookStore) DeadLetterWebhookJob(ctx context.Context, job WebhookJob) error {
+	ctx, span := startSpan(ctx, t.tracer, "dead_letter_webhook_job", attribute.String("coresend.webhook_job_id", job.ID))
+	defer span.End()
+
+	err := t.next.DeadLetterWebhookJob(ctx, job)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedWebhookStore) DeadWebhookJobs(ctx context.Context, addressBox string) ([]WebhookJob, error) {
+	ctx, span := startSpan(ctx, t.tracer, "dead_webhook_jobs")
+	defer span.End()
+
+	jobs, err := t.next.DeadWebhookJobs(ctx, addressBox)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int("coresend.webhook_job_count", len(jobs)))
+	return jobs, err
+}
+
+func (t *tracedWebhookStore) LogWebhookDelivery(ctx context.Context, addressBox string, d WebhookDelivery, ttl time.Duration) error {
+	ctx, span := startSpan(ctx, t.tracer, "log_webhook_delivery", attribute.String("coresend.webhook_job_id", d.JobID))
+	defer span.End()
+
+	err := t.next.LogWebhookDelivery(ctx, addressBox, d, ttl)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedWebhookStore) WebhookDeliveries(ctx context.Context, addressBox string) ([]WebhookDelivery, error) {
+	ctx, span := startSpan(ctx, t.tracer, "webhook_deliveries")
+	defer span.End()
+
+	deliveries, err := t.next.WebhookDeliveries(ctx, addressBox)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int("coresend.delivery_count", len(deliveries)))
+	return deliveries, err
+}
+
+// tracedForwardStore wraps a ForwardStore with one client span per call.
+type tracedForwardStore struct {
+	next   ForwardStore
+	tracer trace.Tracer
+}
+
+// WithForwardTracing returns a ForwardStore that records a span around every
+// call. Destinations and message contents are never recorded.
+func WithForwardTracing(s ForwardStore) ForwardStore {
+	return &tracedForwardStore{next: s, tracer: tracing.Tracer()}
+}
+
+func (t *tracedForwardStore) AddForwardRule(ctx context.Context, addressBox string, rule ForwardRule, ttl time.Duration) error {
+	ctx, span := startSpan(ctx, t.tracer, "add_forward_rule", attribute.String("coresend.forward_rule_id", rule.ID))
+	defer span.End()
+
+	err := t.next.AddForwardRule(ctx, addressBox, rule, ttl)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedForwardStore) ListForwardRules(ctx context.Context, addressBox string) ([]ForwardRule, error) {
+	ctx, span := startSpan(ctx, t.tracer, "list_forward_rules")
+	defer span.End()
+
+	rules, err := t.next.ListForwardRules(ctx, addressBox)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int("coresend.forward_rule_count", len(rules)))
+	return rules, err
+}
+
+func (t *tracedForwardStore) DeleteForwardRule(ctx context.Context, addressBox string, ruleID string) (bool, error) {
+	ctx, span := startSpan(ctx, t.tracer, "delete_forward_rule", attribute.String("coresend.forward_rule_id", ruleID))
+	defer span.End()
+
+	deleted, err := t.next.DeleteForwardRule(ctx, addressBox, ruleID)
+	tracing.RecordError(span, err)
+	return deleted, err
+}
+
+func (t *tracedForwardStore) EnqueueForwardJob(ctx context.Context, job ForwardJob, at time.Time) error {
+	ctx, span := startSpan(ctx, t.tracer, "enqueue_forward_job",
+		attribute.String("coresend.forward_job_id", job.ID),
+		attribute.Int("coresend.attempt", job.Attempt),
+	)
+	defer span.End()
+
+	err := t.next.EnqueueForwardJob(ctx, job, at)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedForwardStore) ClaimForwardJobs(ctx context.Context, now time.Time, lease time.Duration, max int) ([]ForwardJob, error) {
+	ctx, span := startSpan(ctx, t.tracer, "claim_forward_jobs")
+	defer span.End()
+
+	jobs, err := t.next.ClaimForwardJobs(ctx, now, lease, max)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int("coresend.forward_job_count", len(jobs)))
+	return jobs, err
+}
+
+func (t *tracedForwardStore) CompleteForwardJob(ctx context.Context, jobID string) error {
+	ctx, span := startSpan(ctx, t.tracer, "complete_forward_job", attribute.String("coresend.forward_job_id", jobID))
+	defer span.End()
+
+	err := t.next.CompleteForwardJob(ctx, jobID)
+	tracing.RecordError(span, err)
+	return err
+}
+
+// tracedSentStore wraps a SentStore with one client span per call.
+type tracedSentStore struct {
+	next   SentStore
+	tracer trace.Tracer
+}
+
+// WithSentTracing returns a SentStore that records a span around every call.
+func WithSentTracing(s SentStore) SentStore {
+	return &tracedSentStore{next: s, tracer: tracing.Tracer()}
+}
+
+func (t *tracedSentStore) SaveSent(ctx context.Context, addressBox string, email Email, retention time.Duration) error {
+	ctx, span := startSpan(ctx, t.tracer, "save_sent", attribute.String("coresend.email_id", email.ID))
+	defer span.End()
+
+	err := t.next.SaveSent(ctx, addressBox, email, retention)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedSentStore) GetSent(ctx context.Context, addressBox string) ([]Email, error) {
+	ctx, span := startSpan(ctx, t.tracer, "get_sent")
+	defer span.End()
+
+	emails, err := t.next.GetSent(ctx, addressBox)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int("coresend.email_count", len(emails)))
+	return emails, err
+}
+
+// tracedFilterStore wraps a FilterStore with one client span per call.
+type tracedFilterStore struct {
+	next   FilterStore
+	tracer trace.Tracer
+}
+
+// WithFilterTracing returns a FilterStore that records a span around every
+// call. Rule patterns are never recorded.
+func WithFilterTracing(s FilterStore) FilterStore {
+	return &tracedFilterStore{next: s, tracer: tracing.Tracer()}
+}
+
+func (t *tracedFilterStore) AddFilterRule(ctx context.Context, addressBox string, rule FilterRule, max int) error {
+	ctx, span := startSpan(ctx, t.tracer, "add_filter_rule", attribute.String("coresend.filter_rule_id", rule.ID))
+	defer span.End()
+
+	err := t.next.AddFilterRule(ctx, addressBox, rule, max)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedFilterStore) ListFilterRules(ctx context.Context, addressBox string) ([]FilterRule, error) {
+	ctx, span := startSpan(ctx, t.tracer, "list_filter_rules")
+	defer span.End()
+
+	rules, err := t.next.ListFilterRules(ctx, addressBox)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int("coresend.filter_rule_count", len(rules)))
+	return rules, err
+}
+
+func (t *tracedFilterStore) DeleteFilterRule(ctx context.Context, addressBox string, ruleID string) (bool, error) {
+	ctx, span := startSpan(ctx, t.tracer, "delete_filter_rule", attribute.String("coresend.filter_rule_id", ruleID))
+	defer span.End()
+
+	deleted, err := t.next.DeleteFilterRule(ctx, addressBox, ruleID)
+	tracing.RecordError(span, err)
+	return deleted, err
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTracedTestStore(t *testing.T) (EmailStore, *tracetest.SpanRecorder, func()) {
+	t.Helper()
+
+	s, mr := newTestStore(t)
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+
+	return &tracedStore{next: s, tracer: provider.Tracer("test")}, recorder, mr.Close
+}
+
+func TestTracedStore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("records a span per call without the address", func(t *testing.T) {
+		t.Parallel()
+
+		traced, recorder, _ := newTracedTestStore(t)
+		ctx := context.Background()
+
+		if err := traced.SaveEmail(ctx, "box", Email{ID: "email-1"}, time.Hour); err != nil {
+			t.Fatalf("SaveEmail() error = %v", err)
+		}
+		if _, err := traced.GetEmail(ctx, "box", "email-1"); err != nil {
+			t.Fatalf("GetEmail() error = %v", err)
+		}
+
+		spans := recorder.Ended()
+		if len(spans) != 2 {
+			t.Fatalf("span count = %d, want 2", len(spans))
+		}
+		wantNames := []string{"store.save_email", "store.get_email"}
+		for i, span := range spans {
+			if span.Name() != wantNames[i] {
+				t.Fatalf("span[%d] name = %q, want %q", i, span.Name(), wantNames[i])
+			}
+			if span.Status().Code == codes.Error {
+				t.Fatalf("span[%d] status = error, want unset", i)
+			}
+			for _, attr := range span.Attributes() {
+				if attr.Value.AsString() == "box" {
+					t.Fatalf("span[%d] records the address in %s", i, attr.Key)
+				}
+			}
+		}
+	})
+
+	t.Run("marks failed calls", func(t *testing.T) {
+		t.Parallel()
+
+		traced, recorder, closeRedis := newTracedTestStore(t)
+		closeRedis()
+
+		if err := traced.Ping(context.Background()); err == nil {
+			t.Fatalf("Ping() expected error when redis is closed")
+		}
+
+		spans := recorder.Ended()
+		if len(spans) != 1 {
+			t.Fatalf("span count = %d, want 1", len(spans))
+		}
+		if spans[0].Status().Code != codes.Error {
+			t.Fatalf("span status = %v, want error", spans[0].Status().Code)
+		}
+	})
+}
@@ -0,0 +1,243 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// Webhook is a URL notified when an address receives an email.
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret keys the HMAC signature of each delivery.
+	Secret string `json:"secret"`
+	// IncludeBody adds the email body to the payload.
+	IncludeBody bool      `json:"include_body"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WebhookJob is one pending delivery of a payload to a webhook.
+type WebhookJob struct {
+	ID        string          `json:"id"`
+	Address   string          `json:"address"`
+	WebhookID string          `json:"webhook_id"`
+	URL       string          `json:"url"`
+	Secret    string          `json:"secret"`
+	EmailID   string          `json:"email_id"`
+	Payload   json.RawMessage `json:"payload"`
+	// Attempt counts the deliveries tried so far.
+	Attempt   int           `json:"attempt"`
+	LastError string        `json:"last_error,omitempty"`
+	Retention time.Duration `json:"retention"`
+	// TraceParent links deliveries to the trace of the SMTP delivery.
+	TraceParent string    `json:"trace_parent,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WebhookDelivery records one delivery attempt for the delivery log.
+type WebhookDelivery struct {
+	JobID      string    `json:"job_id"`
+	WebhookID  string    `json:"webhook_id"`
+	EmailID    string    `json:"email_id"`
+	Attempt    int       `json:"attempt"`
+	Status     string    `json:"status"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Duration   int64     `json:"duration_ms"`
+	At         time.Time `json:"at"`
+}
+
+// WebhookStore keeps webhook registrations, the delivery queue and the
+// per-address delivery log and dead letters.
+type WebhookStore interface {
+	// AddWebhook adds hook unless the address has max webhooks already, in
+	// which case it returns ErrLimitReached.
+	AddWebhook(ctx context.Context, addressBox string, hook Webhook, max int, ttl time.Duration) error
+	ListWebhooks(ctx context.Context, addressBox string) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, addressBox string, webhookID string) (bool, error)
+	// EnqueueWebhookJob schedules job to run at the given time, replacing
+	// any earlier copy with the same ID.
+	EnqueueWebhookJob(ctx context.Context, job WebhookJob, at time.Time) error
+	// ClaimWebhookJobs returns up to max jobs due by now and hides them from
+	// other claimers for lease. A job that is neither completed nor
+	// rescheduled within the lease is claimed again.
+	ClaimWebhookJobs(ctx context.Context, now time.Time, lease time.Duration, max int) ([]WebhookJob, error)
+	CompleteWebhookJob(ctx context.Context, jobID string) error
+	// DeadLetterWebhookJob removes job from the queue and keeps it in the
+	// address's dead letters.
+	DeadLetterWebhookJob(ctx context.Context, job WebhookJob) error
+	DeadWebhookJobs(ctx context.Context, addressBox string) ([]WebhookJob, error)
+	LogWebhookDelivery(ctx context.Context, addressBox string, d WebhookDelivery, ttl time.Duration) error
+	WebhookDeliveries(ctx context.Context, addressBox string) ([]WebhookDelivery, error)
+}
+
+const (
+	webhookQueueKey = "webhook:queue"
+	webhookJobsKey  = "webhook:jobs"
+
+	// maxWebhookDeliveries and maxDeadWebhookJobs cap the per-address lists.
+	maxWebhookDeliveries = 100
+	maxDeadWebhookJobs   = 100
+)
+
+// addWebhookScript adds a webhook to KEYS[1] and keeps the hash for another
+// ARGV[4] milliseconds, unless it has ARGV[3] webhooks already. It returns 1
+// when added and 0 at the limit.
+//
+// ARGV[1] is the webhook's ID and ARGV[2] the webhook.
+var addWebhookScript = redis.NewScript(`
+if redis.call('HLEN', KEYS[1]) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return 1
+`)
+
+func (s *Store) AddWebhook(ctx context.Context, addressBox string, hook Webhook, max int, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = DefaultRetention
+	}
+	data, err := json.Marshal(hook)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{fmt.Sprintf("webhooks:%s", addressBox)}
+	added, err := addWebhookScript.Run(ctx, s.client, keys, hook.ID, data, max, ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return ErrLimitReached
+	}
+	return nil
+}
+
+func (s *Store) ListWebhooks(ctx context.Context, addressBox string) ([]Webhook, error) {
+	start := time.Now()
+	defer func() {
+		metrics.RedisOperationDuration.WithLabelValues("list_webhooks").Observe(time.Since(start).Seconds())
+	}()
+
+	raw, err := s.client.HGetAll(ctx, fmt.Sprintf("webhooks:%s", addressBox)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := make([]Webhook, 0, len(raw))
+	for id, data := range raw {
+		var hook Webhook
+		if err := json.Unmarshal([]byte(data), &hook); err != nil {
+			slog.WarnContext(ctx, "Skipping unmarshalable webhook", "id", id, "error", err)
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+	sortByCreated(hooks)
+	return hooks, nil
+}
+
+func (s *Store) DeleteWebhook(ctx context.Context, addressBox string, webhookID string) (bool, error) {
+	n, err := s.client.HDel(ctx, fmt.Sprintf("webhooks:%s", addressBox), webhookID).Result()
+	return n > 0, err
+}
+
+func (s *Store) EnqueueWebhookJob(ctx context.Context, job WebhookJob, at time.Time) error {
+	return s.enqueueJob(ctx, webhookQueueKey, webhookJobsKey, job.ID, job, at)
+}
+
+func (s *Store) ClaimWebhookJobs(ctx context.Context, now time.Time, lease time.Duration, max int) ([]WebhookJob, error) {
+	return claimJobs[WebhookJob](ctx, s, "claim_webhook_jobs", webhookQueueKey, webhookJobsKey, now, lease, max)
+}
+
+func (s *Store) CompleteWebhookJob(ctx context.Context, jobID string) error {
+	return s.completeJob(ctx, webhookQueueKey, webhookJobsKey, jobID)
+}
+
+func (s *Store) DeadLetterWebhookJob(ctx context.Context, job WebhookJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	retention := job.Retention
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	key := fmt.Sprintf("webhook_dead:%s", job.Address)
+	pipe := s.client.TxPipeline()
+	pipe.ZRem(ctx, webhookQueueKey, job.ID)
+	pipe.HDel(ctx, webhookJobsKey, job.ID)
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, maxDeadWebhookJobs-1)
+	pipe.Expire(ctx, key, retention)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *Store) DeadWebhookJobs(ctx context.Context, addressBox string) ([]WebhookJob, error) {
+	raw, err := s.client.LRange(ctx, fmt.Sprintf("webhook_dead:%s", addressBox), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeList[WebhookJob](ctx, raw), nil
+}
+
+func (s *Store) LogWebhookDelivery(ctx context.Context, addressBox string, d WebhookDelivery, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = DefaultRetention
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("webhook_deliveries:%s", addressBox)
+	pipe := s.client.TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, maxWebhookDeliveries-1)
+	pipe.Expire(ctx, key, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *Store) WebhookDeliveries(ctx context.Context, addressBox string) ([]WebhookDelivery, error) {
+	raw, err := s.client.LRange(ctx, fmt.Sprintf("webhook_deliveries:%s", addressBox), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeList[WebhookDelivery](ctx, raw), nil
+}
+
+// decodeList unmarshals each JSON item, skipping ones that do not decode.
+func decodeList[T any](ctx context.Context, raw []string) []T {
+	out := make([]T, 0, len(raw))
+	for i, data := range raw {
+		var v T
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			slog.WarnContext(ctx, "Skipping unmarshalable list item", "index", i, "error", err)
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// sortByCreated orders webhooks oldest first; hash iteration order is random.
+func sortByCreated(hooks []Webhook) {
+	slices.SortFunc(hooks, func(a, b Webhook) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWebhooks_AddListDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, mr := newTestStore(t)
+	addr := "hooks"
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, id := range []string{"b", "a", "c"} {
+		hook := Webhook{ID: id, URL: "https://example.com/" + id, Secret: "s", CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := s.AddWebhook(ctx, addr, hook, 3, time.Hour); err != nil {
+			t.Fatalf("AddWebhook(%s) error = %v", id, err)
+		}
+	}
+	assertTTLWithin(t, mr.TTL("webhooks:"+addr), time.Hour)
+	if err := s.AddWebhook(ctx, addr, Webhook{ID: "d", URL: "https://example.com/d"}, 3, time.Hour); !errors.Is(err, ErrLimitReached) {
+		t.Fatalf("AddWebhook(d) error = %v, want ErrLimitReached", err)
+	}
+
+	hooks, err := s.ListWebhooks(ctx, addr)
+	if err != nil {
+		t.Fatalf("ListWebhooks() error = %v", err)
+	}
+	if len(hooks) != 3 || hooks[0].ID != "b" || hooks[1].ID != "a" || hooks[2].ID != "c" {
+		t.Fatalf("ListWebhooks() = %+v, want oldest first", hooks)
+	}
+
+	if ok, err := s.DeleteWebhook(ctx, addr, "a"); err != nil || !ok {
+		t.Fatalf("DeleteWebhook(a) = %v, %v, want true, nil", ok, err)
+	}
+	if ok, err := s.DeleteWebhook(ctx, addr, "a"); err != nil || ok {
+		t.Fatalf("DeleteWebhook(a) again = %v, %v, want false, nil", ok, err)
+	}
+	if hooks, _ := s.ListWebhooks(ctx, addr); len(hooks) != 2 {
+		t.Fatalf("ListWebhooks() after delete = %+v, want 2", hooks)
+	}
+}
+
+func TestClaimWebhookJobs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, mr := newTestStore(t)
+	now := time.Now()
+
+	for i := range 3 {
+		job := WebhookJob{ID: fmt.Sprintf("job-%d", i), Address: "hooks", URL: "https://example.com"}
+		if err := s.EnqueueWebhookJob(ctx, job, now.Add(time.Duration(i-1)*time.Minute)); err != nil {
+			t.Fatalf("EnqueueWebhookJob() error = %v", err)
+		}
+	}
+	// A queue entry whose job data is gone is dropped on claim.
+	mr.ZAdd(webhookQueueKey, float64(now.Add(-time.Hour).UnixMilli()), "orphan")
+
+	jobs, err := s.ClaimWebhookJobs(ctx, now, time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimWebhookJobs() error = %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != "job-0" || jobs[1].ID != "job-1" {
+		t.Fatalf("ClaimWebhookJobs() = %+v, want job-0 and job-1", jobs)
+	}
+	if members, _ := mr.ZMembers(webhookQueueKey); len(members) != 3 {
+		t.Fatalf("queue = %v, want the orphan removed", members)
+	}
+
+	if jobs, _ := s.ClaimWebhookJobs(ctx, now, time.Minute, 10); len(jobs) != 0 {
+		t.Fatalf("second claim = %+v, want leased jobs hidden", jobs)
+	}
+
+	// After the lease, unfinished jobs are due again together with job-2.
+	jobs, err = s.ClaimWebhookJobs(ctx, now.Add(2*time.Minute), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimWebhookJobs() error = %v", err)
+	}
+	if len(jobs) != 3 {
+		t.Fatalf("claim after lease = %+v, want 3", jobs)
+	}
+
+	if err := s.CompleteWebhookJob(ctx, "job-0"); err != nil {
+		t.Fatalf("CompleteWebhookJob() error = %v", err)
+	}
+	if mr.HGet(webhookJobsKey, "job-0") != "" {
+		t.Fatal("completed job data should be removed")
+	}
+	if jobs, _ := s.ClaimWebhookJobs(ctx, now.Add(time.Hour), time.Minute, 1); len(jobs) != 1 || jobs[0].ID == "job-0" {
+		t.Fatalf("claim with max 1 = %+v, want one job other than job-0", jobs)
+	}
+}
+
+func TestDeadLetterWebhookJob(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, mr := newTestStore(t)
+	job := WebhookJob{ID: "job", Address: "hooks", Attempt: 8, LastError: "timeout", Retention: time.Hour}
+
+	if err := s.EnqueueWebhookJob(ctx, job, time.Now()); err != nil {
+		t.Fatalf("EnqueueWebhookJob() error = %v", err)
+	}
+	if err := s.DeadLetterWebhookJob(ctx, job); err != nil {
+		t.Fatalf("DeadLetterWebhookJob() error = %v", err)
+	}
+
+	if jobs, _ := s.ClaimWebhookJobs(ctx, time.Now().Add(time.Hour), time.Minute, 10); len(jobs) != 0 {
+		t.Fatalf("claim after dead letter = %+v, want none", jobs)
+	}
+	dead, err := s.DeadWebhookJobs(ctx, "hooks")
+	if err != nil {
+		t.Fatalf("DeadWebhookJobs() error = %v", err)
+	}
+	if len(dead) != 1 || dead[0].ID != "job" || dead[0].LastError != "timeout" {
+		t.Fatalf("DeadWebhookJobs() = %+v", dead)
+	}
+	assertTTLWithin(t, mr.TTL("webhook_dead:hooks"), time.Hour)
+}
+
+func TestLogWebhookDelivery_KeepsNewest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, mr := newTestStore(t)
+
+	for i := range maxWebhookDeliveries + 5 {
+		if err := s.LogWebhookDelivery(ctx, "hooks", WebhookDelivery{JobID: "job", Attempt: i + 1}, time.Hour); err != nil {
+			t.Fatalf("LogWebhookDelivery() error = %v", err)
+		}
+	}
+
+	deliveries, err := s.WebhookDeliveries(ctx, "hooks")
+	if err != nil {
+		t.Fatalf("WebhookDeliveries() error = %v", err)
+	}
+	if len(deliveries) != maxWebhookDeliveries {
+		t.Fatalf("len(deliveries) = %d, want %d", len(deliveries), maxWebhookDeliveries)
+	}
+	if deliveries[0].Attempt != maxWebhookDeliveries+5 {
+		t.Fatalf("deliveries[0].Attempt = %d, want newest first", deliveries[0].Attempt)
+	}
+	assertTTLWithin(t, mr.TTL("webhook_deliveries:hooks"), time.Hour)
+}
@@ -0,0 +1,129 @@
+// Package tracing sets up OpenTelemetry trace export and propagation.
+//
+// The exporter is chosen by OTEL_TRACES_EXPORTER. The OTLP endpoint, headers,
+// sampler and service name follow the standard OTEL_* environment variables.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+
+	instrumentationName = "github.com/fn-jakubkarp/coresend"
+	defaultServiceName  = "coresend"
+)
+
+// ParseExporter validates an OTEL_TRACES_EXPORTER value. Empty selects none;
+// "console" is accepted as an alias for stdout.
+func ParseExporter(name string) (string, error) {
+	switch name = strings.ToLower(strings.TrimSpace(name)); name {
+	case "", ExporterNone:
+		return ExporterNone, nil
+	case ExporterOTLP:
+		return ExporterOTLP, nil
+	case ExporterStdout, "console":
+		return ExporterStdout, nil
+	}
+	return "", fmt.Errorf("unknown trace exporter %q, want otlp, stdout or none", name)
+}
+
+// Setup installs the global tracer provider and W3C trace context
+// propagation. The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(Propagator())
+
+	var (
+		spanExporter sdktrace.SpanExporter
+		err          error
+	)
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s exporter: %w", exporter, err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(defaultServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Propagator returns the W3C trace context and baggage propagator used for
+// incoming HTTP requests.
+func Propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	)
+}
+
+// Tracer returns the tracer used across the service.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Inject serializes the span context in ctx as a W3C traceparent value, or
+// returns an empty string when ctx carries no valid span.
+func Inject(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// AddLink links the span in ctx to the span described by a traceparent
+// value, such as the delivery of an email that is being read.
+func AddLink(ctx context.Context, traceparent string) {
+	if traceparent == "" {
+		return
+	}
+	carrier := propagation.MapCarrier{"traceparent": traceparent}
+	sc := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), carrier))
+	if sc.IsValid() {
+		trace.SpanFromContext(ctx).AddLink(trace.Link{SpanContext: sc})
+	}
+}
+
+// RecordError marks span as failed when err is non-nil.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestParseExporter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "", want: ExporterNone},
+		{input: "none", want: ExporterNone},
+		{input: "OTLP", want: ExporterOTLP},
+		{input: "stdout", want: ExporterStdout},
+		{input: "console", want: ExporterStdout},
+		{input: "zipkin", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseExporter(tc.input)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("ParseExporter(%q) expected error", tc.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseExporter(%q) error = %v", tc.input, err)
+			}
+			if got != tc.want {
+				t.Fatalf("ParseExporter(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInjectAndAddLink(t *testing.T) {
+	t.Parallel()
+
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	if got := Inject(context.Background()); got != "" {
+		t.Fatalf("Inject() without span = %q, want empty", got)
+	}
+
+	deliveryCtx, delivery := tracer.Start(context.Background(), "delivery")
+	traceparent := Inject(deliveryCtx)
+	delivery.End()
+	if traceparent == "" {
+		t.Fatalf("Inject() returned empty traceparent")
+	}
+
+	readCtx, read := tracer.Start(context.Background(), "read")
+	AddLink(readCtx, traceparent)
+	AddLink(readCtx, "")
+	AddLink(readCtx, "not-a-traceparent")
+	read.End()
+
+	spans := recorder.Ended()
+	links := spans[1].Links()
+	if len(links) != 1 {
+		t.Fatalf("link count = %d, want 1", len(links))
+	}
+	if links[0].SpanContext.SpanID() != spans[0].SpanContext().SpanID() {
+		t.Fatalf("linked span = %s, want %s", links[0].SpanContext.SpanID(), spans[0].SpanContext().SpanID())
+	}
+}
@@ -0,0 +1,447 @@
+// Package webhook notifies URLs registered on an address when it receives an
+// email.
+//
+// Deliveries are queued in the store, so they survive restarts and are shared
+// between instances. Each one is a JSON POST signed with the webhook's secret.
+// Failed deliveries are retried with exponential backoff; after the last
+// attempt the job is moved to the address's dead letters. Every attempt is
+// recorded in the address's delivery log.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fn-jakubkarp/coresend/internal/buildinfo"
+	"github.com/fn-jakubkarp/coresend/internal/logging"
+	"github.com/fn-jakubkarp/coresend/internal/metrics"
+	"github.com/fn-jakubkarp/coresend/internal/netguard"
+	"github.com/fn-jakubkarp/coresend/internal/store"
+	"github.com/fn-jakubkarp/coresend/internal/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EventEmailReceived is the only event sent so far.
+const EventEmailReceived = "email.received"
+
+// Headers set on every delivery.
+const (
+	HeaderEvent     = "X-Coresend-Event"
+	HeaderDelivery  = "X-Coresend-Delivery"
+	HeaderTimestamp = "X-Coresend-Timestamp"
+	HeaderSignature = "X-Coresend-Signature"
+)
+
+// Defaults applied when the matching Dispatcher field is zero.
+const (
+	DefaultMaxAttempts   = 8
+	DefaultBackoff       = 10 * time.Second
+	DefaultMaxBackoff    = time.Hour
+	DefaultTimeout       = 10 * time.Second
+	DefaultMaxPerAddress = 5
+)
+
+// Delivery statuses recorded in the delivery log.
+const (
+	StatusDelivered = "delivered"
+	StatusRetrying  = "retrying"
+	StatusDead      = "dead"
+)
+
+const (
+	// batchSize bounds the jobs claimed, and delivered concurrently, per poll.
+	batchSize = 32
+	// leaseMargin is added to the request timeout to cover the store calls
+	// around a delivery.
+	leaseMargin = 30 * time.Second
+	// maxResponseBytes is how much of a receiver's response is read.
+	maxResponseBytes = 64 << 10
+)
+
+var (
+	ErrInvalidURL = errors.New("webhook URL must be an absolute http or https URL")
+	ErrPrivateURL = errors.New("webhook URL must not point to a private network")
+	ErrTooMany    = errors.New("too many webhooks for this address")
+)
+
+// Payload is the JSON body of an email.received delivery.
+type Payload struct {
+	Event   string       `json:"event"`
+	Address string       `json:"address"`
+	Email   EmailPayload `json:"email"`
+}
+
+type EmailPayload struct {
+	ID         string          `json:"id"`
+	From       string          `json:"from"`
+	To         []string        `json:"to"`
+	Cc         []string        `json:"cc,omitempty"`
+	Subject    string          `json:"subject"`
+	Body       string          `json:"body,omitempty"`
+	ReceivedAt time.Time       `json:"received_at"`
+	TLS        *store.TLSInfo  `json:"tls,omitempty"`
+	Envelope   *store.Envelope `json:"envelope,omitempty"`
+}
+
+type Dispatcher struct {
+	Store store.WebhookStore
+	// Client sends deliveries. When nil, one from netguard.NewClient is used.
+	Client *http.Client
+	// MaxAttempts is how many deliveries are tried before a job is dead.
+	MaxAttempts int
+	// Backoff is the wait after the first failure; it doubles with every
+	// further failure up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds each delivery request.
+	Timeout       time.Duration
+	MaxPerAddress int
+	// AllowPrivate permits URLs on loopback and private networks.
+	AllowPrivate bool
+
+	clientOnce sync.Once
+	client     *http.Client
+}
+
+// Register validates rawURL and adds a webhook with a new secret to address.
+// Each registration keeps all of the address's webhooks for another ttl.
+func (d *Dispatcher) Register(ctx context.Context, address, rawURL string, includeBody bool, ttl time.Duration) (store.Webhook, error) {
+	if err := netguard.ValidateURL(rawURL, d.AllowPrivate); errors.Is(err, netguard.ErrPrivateURL) {
+		return store.Webhook{}, ErrPrivateURL
+	} else if err != nil {
+		return store.Webhook{}, ErrInvalidURL
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return store.Webhook{}, err
+	}
+	hook := store.Webhook{
+		ID:          uuid.New().String(),
+		URL:         rawURL,
+		Secret:      secret,
+		IncludeBody: includeBody,
+		CreatedAt:   time.Now().UTC(),
+	}
+	err = d.Store.AddWebhook(ctx, address, hook, d.maxPerAddress(), ttl)
+	if errors.Is(err, store.ErrLimitReached) {
+		return store.Webhook{}, ErrTooMany
+	} else if err != nil {
+		return store.Webhook{}, err
+	}
+	return hook, nil
+}
+
+// Enqueue queues a delivery of email to every webhook on address and returns
+// how many were queued. The delivery log and dead letters are kept for
+// retention.
+func (d *Dispatcher) Enqueue(ctx context.Context, address string, retention time.Duration, email store.Email) (int, error) {
+	hooks, err := d.Store.ListWebhooks(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for i, hook := range hooks {
+		payload := Payload{
+			Event:   EventEmailReceived,
+			Address: address,
+			Email: EmailPayload{
+				ID:         email.ID,
+				From:       email.From,
+				To:         email.To,
+				Cc:         email.Cc,
+				Subject:    email.Subject,
+				ReceivedAt: email.ReceivedAt.UTC(),
+				TLS:        email.TLS,
+				Envelope:   email.Envelope,
+			},
+		}
+		if hook.IncludeBody {
+			payload.Email.Body = email.Body
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return i, err
+		}
+
+		job := store.WebhookJob{
+			ID:          uuid.New().String(),
+			Address:     address,
+			WebhookID:   hook.ID,
+			URL:         hook.URL,
+			Secret:      hook.Secret,
+			EmailID:     email.ID,
+			Payload:     data,
+			Retention:   retention,
+			TraceParent: email.TraceParent,
+			CreatedAt:   now.UTC(),
+		}
+		if err := d.Store.EnqueueWebhookJob(ctx, job, now); err != nil {
+			return i, err
+		}
+	}
+	return len(hooks), nil
+}
+
+// Run delivers due jobs every interval until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.ProcessDue(ctx); err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "Failed to claim webhook deliveries", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue claims the jobs that are due, delivers them concurrently and
+// returns how many were attempted.
+func (d *Dispatcher) ProcessDue(ctx context.Context) (int, error) {
+	jobs, err := d.Store.ClaimWebhookJobs(ctx, time.Now(), d.timeout()+leaseMargin, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Go(func() { d.deliver(ctx, job) })
+	}
+	wg.Wait()
+	return len(jobs), nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, job store.WebhookJob) {
+	job.Attempt++
+	ctx, span := tracing.Tracer().Start(ctx, "webhook.deliver",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("coresend.webhook_job_id", job.ID),
+			attribute.String("coresend.webhook_id", job.WebhookID),
+			attribute.Int("coresend.attempt", job.Attempt),
+		),
+	)
+	defer span.End()
+	tracing.AddLink(ctx, job.TraceParent)
+
+	active, err := d.isRegistered(ctx, job)
+	if err != nil {
+		// Leave the job to be claimed again once the lease runs out
+		tracing.RecordError(span, err)
+		slog.WarnContext(ctx, "Failed to look up webhook", "webhook_id", job.WebhookID, "error", err)
+		return
+	}
+	if !active {
+		slog.InfoContext(ctx, "Dropped delivery for removed webhook", "webhook_id", job.WebhookID, "job_id", job.ID)
+		if err := d.Store.CompleteWebhookJob(ctx, job.ID); err != nil {
+			slog.WarnContext(ctx, "Failed to drop webhook job", "job_id", job.ID, "error", err)
+		}
+		return
+	}
+
+	start := time.Now()
+	statusCode, sendErr := d.send(ctx, job)
+	elapsed := time.Since(start)
+	if sendErr != nil && ctx.Err() != nil {
+		// Shutting down; the job is claimed again once its lease runs out
+		return
+	}
+	metrics.WebhookDeliveryDuration.Observe(elapsed.Seconds())
+	span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+
+	entry := store.WebhookDelivery{
+		JobID:      job.ID,
+		WebhookID:  job.WebhookID,
+		EmailID:    job.EmailID,
+		Attempt:    job.Attempt,
+		StatusCode: statusCode,
+		Duration:   elapsed.Milliseconds(),
+		At:         start.UTC(),
+	}
+
+	switch {
+	case sendErr == nil:
+		entry.Status = StatusDelivered
+		err = d.Store.CompleteWebhookJob(ctx, job.ID)
+		slog.InfoContext(ctx, "Delivered webhook", "webhook_id", job.WebhookID, "attempt", job.Attempt, "status", statusCode)
+
+	case job.Attempt >= d.maxAttempts():
+		tracing.RecordError(span, sendErr)
+		entry.Status = StatusDead
+		entry.Error = sendErr.Error()
+		job.LastError = sendErr.Error()
+		err = d.Store.DeadLetterWebhookJob(ctx, job)
+		slog.WarnContext(ctx, "Gave up on webhook delivery", "webhook_id", job.WebhookID, "attempts", job.Attempt, "error", sendErr)
+
+	default:
+		tracing.RecordError(span, sendErr)
+		entry.Status = StatusRetrying
+		entry.Error = sendErr.Error()
+		job.LastError = sendErr.Error()
+		wait := d.backoff(job.Attempt)
+		err = d.Store.EnqueueWebhookJob(ctx, job, time.Now().Add(wait))
+		slog.InfoContext(ctx, "Webhook delivery failed, retrying", "webhook_id", job.WebhookID, "attempt", job.Attempt, "retry_in", wait, "error", sendErr)
+	}
+	metrics.WebhookDeliveriesTotal.WithLabelValues(entry.Status).Inc()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to update webhook job", "job_id", job.ID, "status", entry.Status, "error", err)
+	}
+
+	if err := d.Store.LogWebhookDelivery(ctx, job.Address, entry, job.Retention); err != nil {
+		slog.WarnContext(ctx, "Failed to record webhook delivery", logging.KeyAddress, job.Address, "error", err)
+	}
+}
+
+// isRegistered reports whether the job's webhook still exists, so removing a
+// webhook also stops its pending retries.
+func (d *Dispatcher) isRegistered(ctx context.Context, job store.WebhookJob) (bool, error) {
+	hooks, err := d.Store.ListWebhooks(ctx, job.Address)
+	if err != nil {
+		return false, err
+	}
+	for _, hook := range hooks {
+		if hook.ID == job.WebhookID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// send posts the job's payload and returns the response status. Any status
+// other than 2xx is an error.
+func (d *Dispatcher) send(ctx context.Context, job store.WebhookJob) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.URL, bytes.NewReader(job.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "coresend-webhook/"+buildinfo.Get().Version)
+	req.Header.Set(HeaderEvent, EventEmailReceived)
+	req.Header.Set(HeaderDelivery, job.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(job.Secret, timestamp, job.Payload))
+	if tp := tracing.Inject(ctx); tp != "" {
+		req.Header.Set("traceparent", tp)
+	}
+
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the wait after the given failed attempt.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	wait := d.baseBackoff()
+	for i := 1; i < attempt && wait < d.maxBackoff(); i++ {
+		wait *= 2
+	}
+	return min(wait, d.maxBackoff())
+}
+
+func (d *Dispatcher) httpClient() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	d.clientOnce.Do(func() { d.client = netguard.NewClient(d.timeout(), d.AllowPrivate) })
+	return d.client
+}
+
+func (d *Dispatcher) maxAttempts() int {
+	if d.MaxAttempts > 0 {
+		return d.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+func (d *Dispatcher) baseBackoff() time.Duration {
+	if d.Backoff > 0 {
+		return d.Backoff
+	}
+	return DefaultBackoff
+}
+
+func (d *Dispatcher) maxBackoff() time.Duration {
+	if d.MaxBackoff > 0 {
+		return d.MaxBackoff
+	}
+	return DefaultMaxBackoff
+}
+
+func (d *Dispatcher) timeout() time.Duration {
+	if d.Timeout > 0 {
+		return d.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (d *Dispatcher) maxPerAddress() int {
+	if d.MaxPerAddress > 0 {
+		return d.MaxPerAddress
+	}
+	return DefaultMaxPerAddress
+}
+
+// Sign returns the X-Coresend-Signature value for a delivery: the hex
+// HMAC-SHA256 of the timestamp, a dot and the body, keyed by the secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery's signature and that its timestamp is within
+// maxAge of now. Receivers written in Go can use it directly.
+func Verify(secret, timestamp, signature string, body []byte, maxAge time.Duration) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > maxAge || age < -maxAge {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body)))
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,338 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fn-jakubkarp/coresend/internal/store"
+)
+
+const testAddress = "0123456789abcdef0123456789abcdef01234567"
+
+// receiver records the deliveries it gets and answers with status.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	t.Helper()
+
+	rcv := &receiver{status: status}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		rcv.requests = append(rcv.requests, receivedRequest{header: r.Header.Clone(), body: body})
+		status := rcv.status
+		rcv.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+func (rcv *receiver) received() []receivedRequest {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]receivedRequest(nil), rcv.requests...)
+}
+
+func newTestDispatcher(t *testing.T) *Dispatcher {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	return &Dispatcher{
+		Store:        store.NewStore(mr.Addr(), ""),
+		Backoff:      time.Millisecond,
+		MaxBackoff:   time.Millisecond,
+		Timeout:      time.Second,
+		AllowPrivate: true,
+	}
+}
+
+func testEmail() store.Email {
+	return store.Email{
+		ID:         "email-1",
+		From:       "ci@example.com",
+		To:         []string{testAddress},
+		Subject:    "Your code is 123456",
+		Body:       "Use 123456 to sign in",
+		ReceivedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestDispatcher_DeliversSignedPayload(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		includeBody bool
+		wantBody    string
+	}{
+		{name: "metadata only"},
+		{name: "with body", includeBody: true, wantBody: "Use 123456 to sign in"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			d := newTestDispatcher(t)
+			rcv := newReceiver(t, http.StatusNoContent)
+
+			hook, err := d.Register(ctx, testAddress, rcv.URL+"/hook", tc.includeBody, time.Hour)
+			if err != nil {
+				t.Fatalf("Register() error = %v", err)
+			}
+			if n, err := d.Enqueue(ctx, testAddress, time.Hour, testEmail()); err != nil || n != 1 {
+				t.Fatalf("Enqueue() = %d, %v, want 1, nil", n, err)
+			}
+			if n, err := d.ProcessDue(ctx); err != nil || n != 1 {
+				t.Fatalf("ProcessDue() = %d, %v, want 1, nil", n, err)
+			}
+
+			reqs := rcv.received()
+			if len(reqs) != 1 {
+				t.Fatalf("received %d requests, want 1", len(reqs))
+			}
+			req := reqs[0]
+			if got := req.header.Get(HeaderEvent); got != EventEmailReceived {
+				t.Fatalf("%s = %q, want %q", HeaderEvent, got, EventEmailReceived)
+			}
+			if req.header.Get(HeaderDelivery) == "" {
+				t.Fatalf("%s header missing", HeaderDelivery)
+			}
+			if !Verify(hook.Secret, req.header.Get(HeaderTimestamp), req.header.Get(HeaderSignature), req.body, time.Minute) {
+				t.Fatalf("signature %q does not verify", req.header.Get(HeaderSignature))
+			}
+
+			var payload Payload
+			if err := json.Unmarshal(req.body, &payload); err != nil {
+				t.Fatalf("payload is not JSON: %v", err)
+			}
+			if payload.Address != testAddress || payload.Email.ID != "email-1" || payload.Email.Subject != "Your code is 123456" {
+				t.Fatalf("payload = %+v", payload)
+			}
+			if payload.Email.Body != tc.wantBody {
+				t.Fatalf("payload body = %q, want %q", payload.Email.Body, tc.wantBody)
+			}
+
+			deliveries, err := d.Store.WebhookDeliveries(ctx, testAddress)
+			if err != nil {
+				t.Fatalf("WebhookDeliveries() error = %v", err)
+			}
+			if len(deliveries) != 1 || deliveries[0].Status != StatusDelivered || deliveries[0].StatusCode != http.StatusNoContent {
+				t.Fatalf("deliveries = %+v, want one delivered", deliveries)
+			}
+			if n, _ := d.ProcessDue(ctx); n != 0 {
+				t.Fatalf("ProcessDue() after delivery = %d, want the queue empty", n)
+			}
+		})
+	}
+}
+
+func TestDispatcher_RetriesThenDeadLetters(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	d := newTestDispatcher(t)
+	d.MaxAttempts = 3
+	rcv := newReceiver(t, http.StatusServiceUnavailable)
+
+	if _, err := d.Register(ctx, testAddress, rcv.URL, false, time.Hour); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if _, err := d.Enqueue(ctx, testAddress, time.Hour, testEmail()); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		time.Sleep(5 * time.Millisecond)
+		if n, err := d.ProcessDue(ctx); err != nil || n != 1 {
+			t.Fatalf("attempt %d: ProcessDue() = %d, %v, want 1, nil", attempt, n, err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	if n, _ := d.ProcessDue(ctx); n != 0 {
+		t.Fatalf("ProcessDue() after the last attempt = %d, want 0", n)
+	}
+
+	reqs := rcv.received()
+	if len(reqs) != 3 {
+		t.Fatalf("received %d requests, want 3", len(reqs))
+	}
+	if reqs[0].header.Get(HeaderDelivery) != reqs[2].header.Get(HeaderDelivery) {
+		t.Fatal("retries should keep the delivery ID")
+	}
+
+	deliveries, err := d.Store.WebhookDeliveries(ctx, testAddress)
+	if err != nil {
+		t.Fatalf("WebhookDeliveries() error = %v", err)
+	}
+	wantStatuses := []string{StatusDead, StatusRetrying, StatusRetrying}
+	if len(deliveries) != len(wantStatuses) {
+		t.Fatalf("deliveries = %+v, want %d", deliveries, len(wantStatuses))
+	}
+	for i, want := range wantStatuses {
+		if deliveries[i].Status != want || deliveries[i].Attempt != 3-i {
+			t.Fatalf("deliveries[%d] = %+v, want %s attempt %d", i, deliveries[i], want, 3-i)
+		}
+	}
+
+	dead, err := d.Store.DeadWebhookJobs(ctx, testAddress)
+	if err != nil {
+		t.Fatalf("DeadWebhookJobs() error = %v", err)
+	}
+	if len(dead) != 1 || dead[0].Attempt != 3 || dead[0].LastError != "receiver answered 503 Service Unavailable" {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+}
+
+func TestDispatcher_DropsJobsOfRemovedWebhook(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	d := newTestDispatcher(t)
+	rcv := newReceiver(t, http.StatusOK)
+
+	hook, err := d.Register(ctx, testAddress, rcv.URL, false, time.Hour)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if _, err := d.Enqueue(ctx, testAddress, time.Hour, testEmail()); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if _, err := d.Store.DeleteWebhook(ctx, testAddress, hook.ID); err != nil {
+		t.Fatalf("DeleteWebhook() error = %v", err)
+	}
+
+	if _, err := d.ProcessDue(ctx); err != nil {
+		t.Fatalf("ProcessDue() error = %v", err)
+	}
+	if got := len(rcv.received()); got != 0 {
+		t.Fatalf("received %d requests, want 0", got)
+	}
+	if n, _ := d.ProcessDue(ctx); n != 0 {
+		t.Fatalf("ProcessDue() = %d, want the job dropped", n)
+	}
+}
+
+func TestDispatcher_Register(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	d := newTestDispatcher(t)
+	d.AllowPrivate = false
+	d.MaxPerAddress = 2
+
+	if _, err := d.Register(ctx, testAddress, "http://127.0.0.1:8080/hook", false, time.Hour); !errors.Is(err, ErrPrivateURL) {
+		t.Fatalf("Register(loopback) error = %v, want ErrPrivateURL", err)
+	}
+	for i := range 2 {
+		hook, err := d.Register(ctx, testAddress, "https://ci.example.com/hook", false, time.Hour)
+		if err != nil {
+			t.Fatalf("Register() #%d error = %v", i, err)
+		}
+		if len(hook.Secret) < 32 {
+			t.Fatalf("secret = %q, want a long random secret", hook.Secret)
+		}
+	}
+	if _, err := d.Register(ctx, testAddress, "https://ci.example.com/hook", false, time.Hour); !errors.Is(err, ErrTooMany) {
+		t.Fatalf("Register() over the limit error = %v, want ErrTooMany", err)
+	}
+
+	// Registrations racing for the last places do not exceed the limit
+	const other = "fedcba9876543210fedcba9876543210fedcba98"
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Register(ctx, other, "https://ci.example.com/hook", false, time.Hour)
+		}()
+	}
+	wg.Wait()
+	if hooks, err := d.Store.ListWebhooks(ctx, other); err != nil || len(hooks) != 2 {
+		t.Fatalf("ListWebhooks() = %d hooks, %v, want 2", len(hooks), err)
+	}
+}
+
+func TestDispatcher_Backoff(t *testing.T) {
+	t.Parallel()
+
+	d := &Dispatcher{Backoff: 10 * time.Second, MaxBackoff: time.Minute}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 10 * time.Second},
+		{attempt: 2, want: 20 * time.Second},
+		{attempt: 3, want: 40 * time.Second},
+		{attempt: 4, want: time.Minute},
+		{attempt: 30, want: time.Minute},
+	}
+
+	for _, tc := range tests {
+		if got := d.backoff(tc.attempt); got != tc.want {
+			t.Fatalf("backoff(%d) = %v, want %v", tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	body := []byte(`{"event":"email.received"}`)
+	now := time.Now().Unix()
+	fresh := strconv.FormatInt(now, 10)
+	stale := strconv.FormatInt(now-600, 10)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		signature string
+		body      []byte
+		want      bool
+	}{
+		{name: "valid", secret: "s", timestamp: fresh, signature: Sign("s", fresh, body), body: body, want: true},
+		{name: "wrong secret", secret: "other", timestamp: fresh, signature: Sign("s", fresh, body), body: body},
+		{name: "tampered body", secret: "s", timestamp: fresh, signature: Sign("s", fresh, body), body: []byte(`{}`)},
+		{name: "replayed timestamp", secret: "s", timestamp: stale, signature: Sign("s", stale, body), body: body},
+		{name: "malformed timestamp", secret: "s", timestamp: "soon", signature: Sign("s", "soon", body), body: body},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := Verify(tc.secret, tc.timestamp, tc.signature, tc.body, 5*time.Minute); got != tc.want {
+				t.Fatalf("Verify() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}